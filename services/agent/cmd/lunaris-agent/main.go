@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/lunaris/agent/internal/agent"
@@ -16,8 +18,14 @@ import (
 
 func main() {
 	// Parse command line flags
-	apiURL := flag.String("api", "", "API server URL (overrides config)")
+	apiURL := flag.String("api", "", "API server URL, or comma-separated URLs in failover order (overrides config)")
 	showVersion := flag.Bool("version", false, "Show version and exit")
+
+	// Directory overrides; the LUNARIS_*_DIR environment variables work too
+	configDir := flag.String("config-dir", "", "Config directory (overrides "+config.EnvConfigDir+")")
+	stateDir := flag.String("state-dir", "", "State directory (overrides "+config.EnvStateDir+")")
+	logDir := flag.String("log-dir", "", "Log directory (overrides "+config.EnvLogDir+")")
+	cacheDir := flag.String("cache-dir", "", "Cache directory (overrides "+config.EnvCacheDir+")")
 	
 	// Service management flags
 	installService := flag.Bool("install", false, "Install as Windows service")
@@ -39,7 +47,16 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to get executable path: %v", err)
 		}
-		if err := service.InstallService(exePath); err != nil {
+		args, err := serviceArgs(config.Dirs{
+			Config: *configDir,
+			State:  *stateDir,
+			Log:    *logDir,
+			Cache:  *cacheDir,
+		})
+		if err != nil {
+			log.Fatalf("Failed to install service: %v", err)
+		}
+		if err := service.InstallService(exePath, args...); err != nil {
 			log.Fatalf("Failed to install service: %v", err)
 		}
 		return
@@ -66,6 +83,13 @@ func main() {
 		return
 	}
 
+	config.SetDirs(config.Dirs{
+		Config: *configDir,
+		State:  *stateDir,
+		Log:    *logDir,
+		Cache:  *cacheDir,
+	})
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -74,7 +98,8 @@ func main() {
 
 	// Override API URL if provided
 	if *apiURL != "" {
-		cfg.APIURL = *apiURL
+		cfg.APIURLs = strings.Split(*apiURL, ",")
+		cfg.APIURL = cfg.APIURLs[0]
 	}
 
 	// Check if running as Windows service
@@ -122,3 +147,25 @@ func runConsole(cfg *config.Config) {
 
 	log.Println("Agent stopped")
 }
+
+// serviceArgs returns the service command line flags for the directory overrides.
+// Paths are made absolute since the service doesn't start in the current directory.
+func serviceArgs(d config.Dirs) ([]string, error) {
+	var args []string
+	for _, dir := range []struct{ flag, path string }{
+		{"-config-dir", d.Config},
+		{"-state-dir", d.State},
+		{"-log-dir", d.Log},
+		{"-cache-dir", d.Cache},
+	} {
+		if dir.path == "" {
+			continue
+		}
+		path, err := filepath.Abs(dir.path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir.flag, err)
+		}
+		args = append(args, dir.flag, path)
+	}
+	return args, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lunaris/agent/internal/config"
+)
+
+func TestServiceArgs(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := filepath.Join(wd, "state")
+	logDir := filepath.Join(t.TempDir(), "log")
+
+	args, err := serviceArgs(config.Dirs{State: "state", Log: logDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-state-dir", abs, "-log-dir", logDir}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("serviceArgs() = %q, want %q", args, want)
+	}
+
+	if args, _ := serviceArgs(config.Dirs{}); len(args) != 0 {
+		t.Errorf("serviceArgs() without overrides = %q, want none", args)
+	}
+}
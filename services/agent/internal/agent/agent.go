@@ -2,21 +2,35 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/cmdsig"
+	"github.com/lunaris/agent/internal/commands"
 	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/credstore"
+	"github.com/lunaris/agent/internal/identity"
+	"github.com/lunaris/agent/internal/journal"
 	"github.com/lunaris/agent/internal/metrics"
-	"github.com/lunaris/agent/internal/winget"
+	"github.com/lunaris/agent/internal/outbox"
+	"github.com/lunaris/agent/internal/proxy"
+	"github.com/lunaris/agent/internal/signing"
+	"github.com/lunaris/agent/internal/updates"
+	"github.com/lunaris/agent/internal/websocket"
 )
 
 const AgentVersion = "1.0.0"
 
+// heartbeatRetryDelay is the wait before the first heartbeat retry; it grows with every attempt
+var heartbeatRetryDelay = 5 * time.Second
+
 // Logger is an interface for logging
 type Logger interface {
 	Printf(format string, v ...interface{})
@@ -25,93 +39,214 @@ type Logger interface {
 
 // Agent is the main agent controller
 type Agent struct {
-	config    *config.Config
-	client    *api.Client
-	scanner   *winget.Scanner
-	installer *winget.Installer
-	logger    Logger
+	config   *config.Config
+	client   *api.Client
+	sources  []updates.UpdateSource
+	commands *commands.Registry
+	journal  *journal.Journal
+	logger   Logger
+
+	// idMu guards config.DeviceID, which re-registration changes while other goroutines read it
+	idMu sync.RWMutex
+
+	// Device credentials issued at registration
+	credentials *credstore.Store
+
+	// Re-registration guardrails for when the server no longer knows the device
+	device *deviceState
+
+	// Server policy applied on top of the local configuration
+	policy        policyState
+	policyChanged chan struct{}
+
+	// Outbound proxy shared by API and websocket connections
+	proxy *proxy.Resolver
+
+	// Mutual TLS identity and server trust settings
+	identity  *identity.Identity
+	tlsConfig *tls.Config
+
+	// Request signing; clock tracks the server time offset measured from heartbeats
+	signer *signing.Ed25519Signer
+	clock  *signing.Clock
+
+	// Console keys commands must be signed with
+	keyring *cmdsig.Keyring
+
+	// setupErr is a security, network or state setup error that stops Run
+	setupErr error
+
+	// Calls that couldn't reach the API, replayed in order once it is back
+	outbox     *outbox.Outbox
+	outboxKick chan struct{}
+
+	// Push delivery; commands from the socket and from polling share one queue
+	ws              *websocket.Client
+	realtimeChanged chan struct{}
+	pollRequested   chan struct{}
+	commandQueue    chan api.Command
+	queuedMu        sync.Mutex
+	queued          map[string]bool
+
+	// packageSources holds the packages the last scan found, by source; nil until a scan succeeds.
+	// sourceItems holds what each source reported last, kept while its scans fail.
+	packageSourcesMu sync.Mutex
+	packageSources   map[packageKey]bool
+	sourceItems      map[string][]api.UpdateItem
+
+	// Update scans run off the main loop one at a time; scanPending asks for
+	// another scan once the running one finishes
+	scanMu      sync.Mutex
+	scanning    bool
+	scanPending bool
 }
 
 // New creates a new agent instance
 func New(cfg *config.Config) *Agent {
 	logger := log.New(os.Stdout, "[Lunaris Agent] ", log.LstdFlags)
 
-	return &Agent{
-		config:    cfg,
-		client:    api.NewClient(cfg.APIURL),
-		scanner:   winget.NewScanner(),
-		installer: winget.NewInstaller(),
-		logger:    logger,
-	}
+	return NewWithLogger(cfg, logger)
 }
 
-// NewWithLogger creates a new agent instance with a custom logger
+// NewWithLogger creates a new agent instance with a custom logger.
+// Messages also go to the agent log file in the log directory.
 func NewWithLogger(cfg *config.Config, logger Logger) *Agent {
-	return &Agent{
-		config:    cfg,
-		client:    api.NewClient(cfg.APIURL),
-		scanner:   winget.NewScanner(),
-		installer: winget.NewInstaller(),
-		logger:    logger,
+	a := &Agent{
+		config:          cfg,
+		client:          newAPIClient(cfg),
+		sources:         defaultSources(),
+		commands:        commands.NewRegistry(),
+		logger:          logger,
+		commandQueue:    make(chan api.Command, commandQueueSize),
+		queued:          make(map[string]bool),
+		realtimeChanged: make(chan struct{}, 1),
+		pollRequested:   make(chan struct{}, 1),
+		policyChanged:   make(chan struct{}, 1),
+		outboxKick:      make(chan struct{}, 1),
+	}
+	if err := config.EnsureDirs(); err != nil {
+		logger.Printf("Warning: %v", err)
 	}
+	logger = withLogFile(logger)
+	a.logger = logger
+	a.registerBuiltinHandlers()
+	migrateState(config.LegacyStateDir(), logger)
+	a.setupEndpoints()
+	a.setupProxy()
+	a.setupTLS()
+	a.setupSigning()
+	a.setupCommandSigning()
+	a.credentials = openCredentialStore()
+	a.loadCredentials()
+	a.device = openDeviceHistory(logger)
+	a.loadPolicy()
+	a.setupJournal()
+	a.outbox = openOutbox(logger)
+	return a
+}
+
+// RegisterHandler adds a handler for a new command type.
+// Handlers must be registered before Run so the type is advertised to the server.
+func (a *Agent) RegisterHandler(h commands.Handler) error {
+	return a.commands.Register(h)
 }
 
 // Run starts the agent main loop
 func (a *Agent) Run(ctx context.Context) error {
 	a.logger.Println("Starting Lunaris Agent v" + AgentVersion)
-	a.logger.Printf("API URL: %s", a.config.APIURL)
+	a.logger.Printf("API URL: %s", strings.Join(a.config.Endpoints(), ", "))
+	a.logger.Printf("Config: %s, state: %s, log: %s", config.ConfigPath(), config.Directories().State, config.LogPath(LogFile))
+
+	if a.setupErr != nil {
+		return a.setupErr
+	}
 
 	// Register device if not already registered
-	if a.config.DeviceID == "" {
-		if err := a.register(); err != nil {
+	if a.deviceID() == "" {
+		if err := a.register(ctx, ""); err != nil {
 			return fmt.Errorf("registration failed: %w", err)
 		}
 	} else {
-		a.logger.Printf("Device already registered: %s", a.config.DeviceID)
+		a.logger.Printf("Device already registered: %s", a.deviceID())
 	}
 
+	// Make sure the client certificate is valid before anything else
+	a.renewCertificateIfDue(ctx)
+
 	// Start background tasks
-	heartbeatTicker := time.NewTicker(time.Duration(a.config.HeartbeatIntervalSec) * time.Second)
-	updateScanTicker := time.NewTicker(time.Duration(a.config.UpdateScanIntervalMin) * time.Minute)
-	commandPollTicker := time.NewTicker(10 * time.Second) // Poll for commands every 10 seconds
+	certificateTicker := time.NewTicker(certificateCheckInterval)
+	defer certificateTicker.Stop()
+	heartbeatInterval := a.heartbeatInterval()
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	updateScanInterval := a.updateScanInterval()
+	updateScanTicker := time.NewTicker(updateScanInterval)
+	pollInterval := a.commandPollInterval()
+	commandPollTicker := time.NewTicker(pollInterval)
 	defer heartbeatTicker.Stop()
 	defer updateScanTicker.Stop()
 	defer commandPollTicker.Stop()
 
+	// Commands that were running when the agent last stopped will never finish
+	a.failInterruptedCommands(ctx)
+
+	// The websocket client exists before any goroutine that may reconnect it
+	a.startRealtime(ctx)
+	defer a.stopRealtime()
+	go a.runOutbox(ctx)
+	go a.runCommandWorker(ctx)
+
 	// Initial heartbeat and scan
-	a.sendHeartbeat()
-	a.scanAndReportUpdates()
+	a.sendHeartbeat(ctx)
+	a.requestScan(ctx)
 
-	a.logger.Println("Agent started - polling for commands every 10 seconds")
+	a.logger.Printf("Agent started - polling for commands every %v until the websocket connects", pollInterval)
 
 	// Main loop
 	for {
 		select {
 		case <-ctx.Done():
 			a.logger.Println("Agent shutting down...")
+			a.journal.Close()
 			return nil
 
 		case <-heartbeatTicker.C:
-			a.sendHeartbeat()
+			a.sendHeartbeat(ctx)
+
+		case <-certificateTicker.C:
+			a.renewCertificateIfDue(ctx)
 
 		case <-updateScanTicker.C:
-			a.scanAndReportUpdates()
+			a.requestScan(ctx)
 
 		case <-commandPollTicker.C:
-			a.pollAndExecuteCommands()
+			a.pollAndExecuteCommands(ctx)
+
+		case <-a.pollRequested:
+			a.pollAndExecuteCommands(ctx)
+
+		case <-a.realtimeChanged:
+			a.adjustPollInterval(ctx, commandPollTicker, &pollInterval)
+
+		case <-a.policyChanged:
+			a.resetInterval("Heartbeat", heartbeatTicker, &heartbeatInterval, a.heartbeatInterval())
+			a.resetInterval("Update scan", updateScanTicker, &updateScanInterval, a.updateScanInterval())
+			a.adjustPollInterval(ctx, commandPollTicker, &pollInterval)
 		}
 	}
 }
 
-// pollAndExecuteCommands polls for pending commands and executes them
-func (a *Agent) pollAndExecuteCommands() {
+// pollAndExecuteCommands polls for pending commands and queues them for execution
+func (a *Agent) pollAndExecuteCommands(ctx context.Context) {
 	// Get pending commands from server
-	cmdResp, err := a.client.GetPendingCommands(a.config.DeviceID)
+	cmdResp, err := a.client.GetPendingCommands(ctx, a.deviceID())
 	if err != nil {
+		a.recoverAuth(ctx, err)
+		a.recoverDevice(ctx, err)
 		// Only log error if it's not a network timeout
 		a.logger.Printf("Failed to poll commands: %v", err)
 		return
 	}
+	a.deviceFound()
 
 	if len(cmdResp.Commands) == 0 {
 		return
@@ -119,171 +254,82 @@ func (a *Agent) pollAndExecuteCommands() {
 
 	a.logger.Printf("Received %d command(s) to execute", len(cmdResp.Commands))
 
-	// Execute each command
+	// Queue each command for the worker
 	for _, cmd := range cmdResp.Commands {
-		a.executeCommand(cmd)
+		a.enqueueCommand(ctx, cmd)
 	}
 }
 
-// executeCommand executes a single command
-func (a *Agent) executeCommand(cmd api.Command) {
-	a.logger.Printf("Executing command %s (type: %s)", cmd.ID, cmd.Type)
-
-	switch cmd.Type {
-	case "install_updates":
-		a.executeInstallCommand(cmd)
-	case "run_scan":
-		a.executeSyncCommand(cmd)
-	default:
-		a.logger.Printf("Unknown command type: %s", cmd.Type)
-		a.client.CompleteCommand(cmd.ID, false, fmt.Sprintf("Unknown command type: %s", cmd.Type))
-	}
-}
-
-// executeInstallCommand executes an install_updates command
-func (a *Agent) executeInstallCommand(cmd api.Command) {
-	a.logger.Printf("Installing %d package(s): %v", len(cmd.PackageIdentifiers), cmd.PackageIdentifiers)
-
-	// Install each package
-	results := a.installer.InstallMultiple(cmd.PackageIdentifiers)
-
-	// Log results
-	successCount := 0
-	failureCount := 0
-	resultMessages := []string{}
-
-	for _, result := range results {
-		if result.Success {
-			successCount++
-			a.logger.Printf("  ✓ %s: %s", result.PackageIdentifier, result.Message)
-			resultMessages = append(resultMessages, fmt.Sprintf("✓ %s: %s", result.PackageIdentifier, result.Message))
-		} else {
-			failureCount++
-			a.logger.Printf("  ✗ %s: %s", result.PackageIdentifier, result.Message)
-			resultMessages = append(resultMessages, fmt.Sprintf("✗ %s: %s", result.PackageIdentifier, result.Message))
-			if result.Error != nil {
-				a.logger.Printf("    Error: %v", result.Error)
-			}
-		}
-	}
-
-	a.logger.Printf("Install command completed: %d/%d successful", successCount, len(results))
-
-	// Report command completion
-	success := failureCount == 0
-	resultText := fmt.Sprintf("%d/%d successful\n%s", successCount, len(results), strings.Join(resultMessages, "\n"))
-
-	if err := a.client.CompleteCommand(cmd.ID, success, resultText); err != nil {
-		a.logger.Printf("Failed to report command completion: %v", err)
-	}
-
-	// Trigger update scan to report new state
-	go func() {
-		time.Sleep(5 * time.Second) // Wait a bit for installations to complete
-		a.scanAndReportUpdates()
-	}()
-}
-
-// executeSyncCommand executes a run_scan command to force an immediate update scan
-func (a *Agent) executeSyncCommand(cmd api.Command) {
-	a.logger.Println("Executing sync command - triggering immediate update scan")
-
-	// Run scan in a goroutine so we can report command completion
-	go func() {
-		updates, err := a.scanner.ScanUpdates()
-		if err != nil {
-			a.logger.Printf("Sync scan failed: %v", err)
-			a.client.CompleteCommand(cmd.ID, false, fmt.Sprintf("Scan failed: %v", err))
-			return
-		}
-
-		a.logger.Printf("Sync scan completed: found %d available updates", len(updates))
-		for i, u := range updates {
-			a.logger.Printf("  Update %d: %s (%s) - %s -> %s", i+1, u.PackageName, u.PackageIdentifier, u.InstalledVersion, u.AvailableVersion)
-		}
-
-		// Report updates to backend
-		apiUpdates := winget.ToAPIUpdates(updates)
-		a.logger.Printf("Reporting %d updates to backend...", len(apiUpdates))
-		req := &api.UpdateReportRequest{
-			DeviceID: a.config.DeviceID,
-			Updates:  apiUpdates,
-		}
-
-		resp, err := a.client.ReportUpdates(req)
-		if err != nil {
-			a.logger.Printf("Failed to report updates: %v", err)
-			a.client.CompleteCommand(cmd.ID, false, fmt.Sprintf("Failed to report updates: %v", err))
-			return
-		}
-
-		a.logger.Printf("Sync completed: %d updates reported to server (response: %d received)", len(updates), resp.Received)
-		resultText := fmt.Sprintf("Scan completed successfully. Found %d available updates.", len(updates))
-		a.client.CompleteCommand(cmd.ID, true, resultText)
-	}()
-}
-
-// register registers the device with the backend
-func (a *Agent) register() error {
+// register registers the device with the backend.
+// previousDeviceID is the ID the server lost track of, if any.
+func (a *Agent) register(ctx context.Context, previousDeviceID string) error {
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = "unknown"
 	}
 
+	// The MAC address is informational; the fingerprint is what identifies the device
 	macAddr := metrics.GetPrimaryMAC()
-	if macAddr == "" {
-		return fmt.Errorf("could not determine MAC address")
+	fp := a.fingerprint()
+	if fp == nil && macAddr == "" {
+		return fmt.Errorf("could not determine a device fingerprint or MAC address")
 	}
 
 	osName, osVersion := getOSInfo()
 
 	req := &api.RegisterRequest{
-		Hostname:     hostname,
-		OS:           osName,
-		OSVersion:    osVersion,
-		MACAddress:   macAddr,
-		AgentVersion: AgentVersion,
+		Hostname:          hostname,
+		OS:                osName,
+		OSVersion:         osVersion,
+		MACAddress:        macAddr,
+		AgentVersion:      AgentVersion,
+		SupportedCommands: a.commands.Types(),
+		EnrollmentSecret:  a.config.EnrollmentSecret,
+		CSR:               a.certificateRequest(hostname),
+		SigningPublicKey:  a.signingPublicKey(),
+		PreviousDeviceID:  previousDeviceID,
+	}
+	if fp != nil {
+		req.DeviceFingerprint = fp.ID
+		req.FingerprintComponents = fp.Components
 	}
 
 	a.logger.Printf("Registering device: %s (%s)", hostname, macAddr)
 
-	resp, err := a.client.Register(req)
+	resp, err := a.client.Register(ctx, req)
 	if err != nil {
 		return err
 	}
 
-	a.config.DeviceID = resp.DeviceID
-	if err := a.config.Save(); err != nil {
+	if err := a.setDeviceID(resp.DeviceID); err != nil {
 		a.logger.Printf("Warning: failed to save config: %v", err)
 	}
+	a.applyRegistration(resp)
+	a.installCertificate(resp.Certificate)
 
 	a.logger.Printf("Device registered successfully: %s", resp.DeviceID)
 	return nil
 }
 
-// sendHeartbeat sends a heartbeat to the backend with retry logic
-func (a *Agent) sendHeartbeat() {
+// sendHeartbeat sends a heartbeat to the backend with retry logic.
+// If the API stays unreachable the heartbeat is queued in the outbox.
+func (a *Agent) sendHeartbeat(ctx context.Context) {
 	sysMetrics, err := metrics.Collect()
 	if err != nil {
 		a.logger.Printf("Warning: failed to collect metrics: %v", err)
 	}
 
-	ipAddr := metrics.GetPrimaryIP()
+	req := a.heartbeatRequest(sysMetrics)
 
-	req := &api.HeartbeatRequest{
-		DeviceID:  a.config.DeviceID,
-		IPAddress: ipAddr,
-	}
-
-	if sysMetrics != nil {
-		req.CPUUsage = &sysMetrics.CPUUsage
-		req.MemoryUsage = &sysMetrics.MemoryUsage
-		req.DiskUsage = &sysMetrics.DiskUsage
+	// While older calls are waiting the API is known to be down; queue behind them
+	if a.outbox.Len() > 0 {
+		a.spoolHeartbeat(req)
+		return
 	}
 
 	// Retry logic with exponential backoff
 	maxRetries := 3
-	retryDelay := 5 * time.Second
+	retryDelay := heartbeatRetryDelay
 
 	var resp *api.HeartbeatResponse
 	var lastErr error
@@ -292,12 +338,23 @@ func (a *Agent) sendHeartbeat() {
 		if attempt > 0 {
 			// Exponential backoff: 5s, 10s, 20s
 			delay := time.Duration(attempt) * retryDelay
+			if retryAfter := api.RetryAfter(lastErr); retryAfter > delay {
+				delay = retryAfter
+			}
 			a.logger.Printf("Retrying heartbeat in %v (attempt %d/%d)...", delay, attempt, maxRetries)
-			time.Sleep(delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
 		}
 
-		resp, err = a.client.Heartbeat(req)
+		sentAt := time.Now()
+		resp, err = a.client.Heartbeat(ctx, req)
 		if err == nil {
+			a.deviceFound()
+			a.observeServerTime(resp.ServerTime, sentAt)
+			a.applyPolicy(resp.Policy)
 			// Success
 			a.logger.Printf("Heartbeat OK (server time: %s)", resp.ServerTime)
 			return
@@ -305,41 +362,113 @@ func (a *Agent) sendHeartbeat() {
 
 		lastErr = err
 		a.logger.Printf("Heartbeat attempt %d failed: %v", attempt+1, err)
+		if a.recoverAuth(ctx, err) {
+			// Re-enrollment may have issued a new device ID
+			req = a.heartbeatRequest(sysMetrics)
+			continue
+		}
+		// The queued heartbeat would carry an ID the server doesn't know
+		if a.recoverDevice(ctx, err) {
+			return
+		}
+		if !api.IsTemporary(err) {
+			break
+		}
 	}
 
 	// All retries exhausted
-	a.logger.Printf("Heartbeat failed after %d attempts: %v", maxRetries+1, lastErr)
+	a.logger.Printf("Heartbeat failed: %v", lastErr)
+	if api.IsTemporary(lastErr) {
+		a.spoolHeartbeat(req)
+	}
+}
+
+// heartbeatRequest builds a heartbeat for the current device ID
+func (a *Agent) heartbeatRequest(sysMetrics *metrics.SystemMetrics) *api.HeartbeatRequest {
+	req := &api.HeartbeatRequest{
+		DeviceID:          a.deviceID(),
+		IPAddress:         metrics.GetPrimaryIP(),
+		SupportedCommands: a.commands.Types(),
+		RealtimeState:     a.realtimeState(),
+		APIEndpoint:       a.client.BaseURL(),
+	}
+	req.PolicyID, req.PolicyVersion, req.PolicyError = a.policyReport()
+
+	if sysMetrics != nil {
+		req.CPUUsage = &sysMetrics.CPUUsage
+		req.MemoryUsage = &sysMetrics.MemoryUsage
+		req.DiskUsage = &sysMetrics.DiskUsage
+	}
+	return req
+}
+
+// requestScan starts an update scan in the background, so slow package managers don't hold
+// up heartbeats and command polling. A request while a scan runs queues a single rescan.
+func (a *Agent) requestScan(ctx context.Context) {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+
+	if a.scanning {
+		a.scanPending = true
+		return
+	}
+	a.scanning = true
+	go a.runScans(ctx)
+}
+
+// runScans scans and reports updates until no more scans are requested
+func (a *Agent) runScans(ctx context.Context) {
+	for {
+		a.scanAndReportUpdates(ctx)
+
+		a.scanMu.Lock()
+		if !a.scanPending || ctx.Err() != nil {
+			a.scanning, a.scanPending = false, false
+			a.scanMu.Unlock()
+			return
+		}
+		a.scanPending = false
+		a.scanMu.Unlock()
+	}
 }
 
 // scanAndReportUpdates scans for updates and reports them
-func (a *Agent) scanAndReportUpdates() {
+func (a *Agent) scanAndReportUpdates(ctx context.Context) {
 	a.logger.Println("Scanning for updates...")
 
-	updates, err := a.scanner.ScanUpdates()
+	scan, err := a.scanAllSources()
 	if err != nil {
 		a.logger.Printf("Update scan failed: %v", err)
 		return
 	}
+	apiUpdates := scan.Items
 
-	a.logger.Printf("Found %d available updates", len(updates))
-	for i, u := range updates {
-		a.logger.Printf("  Update %d: %s (%s) - %s -> %s", i+1, u.PackageName, u.PackageIdentifier, u.InstalledVersion, u.AvailableVersion)
-	}
+	a.logger.Printf("Found %d available updates", len(apiUpdates))
+	logUpdates(a.logger, apiUpdates)
 
-	apiUpdates := winget.ToAPIUpdates(updates)
 	a.logger.Printf("Reporting %d updates to backend...", len(apiUpdates))
 	req := &api.UpdateReportRequest{
-		DeviceID: a.config.DeviceID,
-		Updates:  apiUpdates,
+		DeviceID:      a.deviceID(),
+		Updates:       apiUpdates,
+		UnparsedLines: len(scan.Unparsed),
 	}
 
-	resp, err := a.client.ReportUpdates(req)
+	if a.outbox.Len() > 0 {
+		a.spoolUpdateReport(req)
+		return
+	}
+
+	resp, err := a.client.ReportUpdates(ctx, req)
 	if err != nil {
 		a.logger.Printf("Update report failed: %v", err)
+		a.recoverDevice(ctx, err)
+		if api.IsTemporary(err) {
+			a.spoolUpdateReport(req)
+		}
 		return
 	}
 
-	a.logger.Printf("Update report sent: %d updates received by server (reported %d)", resp.Received, len(updates))
+	a.logger.Printf("Update report sent: %d updates received by server (reported %d)", resp.Received, len(apiUpdates))
 }
 
 // getOSInfo returns OS name and version
@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/commands"
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/journal"
+	"github.com/lunaris/agent/internal/outbox"
+	"github.com/lunaris/agent/internal/websocket/wstest"
+)
+
+// testLogger sends agent logs to the test log
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Printf(format string, v ...interface{}) { l.t.Logf(format, v...) }
+func (l testLogger) Println(v ...interface{})               { l.t.Log(v...) }
+
+// apiCall is a request received by fakeAPI
+type apiCall struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   json.RawMessage
+}
+
+// fakeAPI is a stand-in Lunaris API recording every call. Handler may override
+// responses; by default every call succeeds with an empty JSON object.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []apiCall
+	Handler func(w http.ResponseWriter, r *http.Request, body []byte) bool
+
+	// Socket serves /socket.io/ when set, so the agent's websocket reaches the same host
+	Socket *wstest.Server
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	f := &fakeAPI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		socket := f.Socket
+		f.mu.Unlock()
+		if socket != nil && strings.HasPrefix(r.URL.Path, "/socket.io/") {
+			socket.ServeHTTP(w, r)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.calls = append(f.calls, apiCall{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		handler := f.Handler
+		f.mu.Unlock()
+
+		if handler != nil && handler(w, r, body) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Calls returns the calls received so far
+func (f *fakeAPI) Calls() []apiCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]apiCall(nil), f.calls...)
+}
+
+// newTestAgent returns an agent talking to serverURL with in-memory journal and outbox.
+// Its configuration and state live in a temporary directory.
+func newTestAgent(t *testing.T, serverURL string) *Agent {
+	t.Helper()
+	dir := t.TempDir()
+	config.SetDirs(config.Dirs{
+		Config: filepath.Join(dir, "config"),
+		State:  filepath.Join(dir, "state"),
+		Log:    filepath.Join(dir, "log"),
+		Cache:  filepath.Join(dir, "cache"),
+	})
+	j, err := journal.Open("", journal.DefaultMaxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := outbox.Open("", outbox.DefaultLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Agent{
+		config:          &config.Config{DeviceID: "device-1"},
+		client:          api.NewClient(serverURL),
+		commands:        commands.NewRegistry(),
+		logger:          testLogger{t},
+		journal:         j,
+		outbox:          o,
+		commandQueue:    make(chan api.Command, commandQueueSize),
+		queued:          make(map[string]bool),
+		realtimeChanged: make(chan struct{}, 1),
+		pollRequested:   make(chan struct{}, 1),
+		policyChanged:   make(chan struct{}, 1),
+		outboxKick:      make(chan struct{}, 1),
+	}
+	a.credentials = openCredentialStore()
+	a.device = &deviceState{path: config.DataPath(DeviceHistoryFile)}
+	return a
+}
+
+func TestExecuteCommandAcknowledgesAndReportsProgress(t *testing.T) {
+	server := newFakeAPI(t)
+	a := newTestAgent(t, server.URL)
+
+	handler := commands.NewTypedHandler("test_steps", commands.DecodeNoPayload,
+		func(ctx context.Context, cmd api.Command, _ commands.NoPayload) commands.Result {
+			commands.ReportStep(ctx, 0, 2, "Installing a", "a")
+			commands.ReportStep(ctx, 1, 2, "Installing b", "b")
+			return commands.Result{Success: true, Message: "done"}
+		})
+	if err := a.commands.Register(handler); err != nil {
+		t.Fatal(err)
+	}
+
+	a.executeCommand(context.Background(), api.Command{ID: "cmd-1", Type: "test_steps"})
+
+	calls := server.Calls()
+	want := []string{
+		"PATCH /agent/commands/cmd-1/ack",
+		"PATCH /agent/commands/cmd-1/progress",
+		"PATCH /agent/commands/cmd-1/progress",
+		"PATCH /agent/commands/cmd-1/complete",
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("got %d calls %+v, want %v", len(calls), calls, want)
+	}
+	for i, call := range calls {
+		if got := call.Method + " " + call.Path; got != want[i] {
+			t.Errorf("call %d = %s, want %s", i, got, want[i])
+		}
+	}
+
+	var progress api.CommandProgress
+	if err := json.Unmarshal(calls[2].Body, &progress); err != nil {
+		t.Fatal(err)
+	}
+	if progress.Percent != 50 || progress.PackageIdentifier != "b" || progress.Total != 2 {
+		t.Errorf("second progress = %+v", progress)
+	}
+
+	var complete api.CompleteCommandRequest
+	if err := json.Unmarshal(calls[3].Body, &complete); err != nil {
+		t.Fatal(err)
+	}
+	if !complete.Success || complete.Result != "done" {
+		t.Errorf("completion = %+v", complete)
+	}
+
+	// A second delivery of the same command is skipped
+	a.executeCommand(context.Background(), api.Command{ID: "cmd-1", Type: "test_steps"})
+	if n := len(server.Calls()); n != len(want) {
+		t.Errorf("duplicate delivery made %d more calls", n-len(want))
+	}
+}
+
+func TestUnusableJournalStopsRun(t *testing.T) {
+	a := newTestAgent(t, "http://127.0.0.1:0")
+
+	// A directory where the journal file belongs can't be read as a journal
+	if err := os.MkdirAll(filepath.Join(config.DataPath(JournalFile), "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	a.setupJournal()
+
+	if a.journal == nil {
+		t.Fatal("no journal after a failed setup")
+	}
+	err := a.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "command journal") {
+		t.Errorf("Run() = %v, want the journal error", err)
+	}
+}
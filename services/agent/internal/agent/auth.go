@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/credstore"
+)
+
+// CredentialsFile is the device credentials file name in the agent data directory
+const CredentialsFile = "credentials.dat"
+
+// loadCredentials restores the stored device credentials into the API client
+// and persists every refresh the client makes
+func (a *Agent) loadCredentials() {
+	a.client.SetDeviceID(a.deviceID())
+	a.client.OnCredentialsChanged(a.saveCredentials)
+
+	creds, err := a.credentials.Load()
+	if err != nil {
+		a.logger.Printf("Warning: failed to load device credentials: %v", err)
+		return
+	}
+	if creds != nil {
+		a.client.SetCredentials(creds)
+	}
+}
+
+// saveCredentials persists device credentials
+func (a *Agent) saveCredentials(creds *api.Credentials) {
+	if err := a.credentials.Save(creds); err != nil {
+		a.logger.Printf("Warning: failed to save device credentials: %v", err)
+	}
+}
+
+// applyRegistration stores the credentials issued by a registration in place of
+// any earlier ones
+func (a *Agent) applyRegistration(resp *api.RegisterResponse) {
+	a.client.SetDeviceID(resp.DeviceID)
+
+	creds := resp.Credentials()
+	if creds == nil {
+		a.logger.Println("Warning: server issued no device credentials; API requests are unauthenticated")
+		a.client.SetCredentials(nil)
+		if err := a.credentials.Clear(); err != nil {
+			a.logger.Printf("Warning: %v", err)
+		}
+		return
+	}
+
+	a.client.SetCredentials(creds)
+	a.saveCredentials(creds)
+}
+
+// recoverAuth re-enrolls the device when the API rejected its credentials and they
+// couldn't be refreshed. Re-enrollment keeps the device ID where the server allows it
+// and shares the backoff of re-registrations. It reports whether err was an
+// authentication failure.
+func (a *Agent) recoverAuth(ctx context.Context, err error) bool {
+	if !errors.Is(err, api.ErrUnauthorized) {
+		return false
+	}
+
+	a.device.mu.Lock()
+	defer a.device.mu.Unlock()
+	a.reregister(ctx, err, "server rejected the device credentials")
+	return true
+}
+
+// openCredentialStore returns the store for device credentials
+func openCredentialStore() *credstore.Store {
+	return credstore.New(config.DataPath(CredentialsFile))
+}
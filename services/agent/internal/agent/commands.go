@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/commands"
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/journal"
+)
+
+// Built-in command types
+const (
+	CommandInstallUpdates = "install_updates"
+	CommandRunScan        = "run_scan"
+)
+
+// JournalFile is the command journal file name in the agent data directory
+const JournalFile = "commands.journal"
+
+// FailureAgentRestarted is reported for commands interrupted by an agent restart
+const FailureAgentRestarted = "agent_restarted"
+
+// setupJournal opens the on-disk command journal. Without it a restart could run
+// a command twice, so an unusable journal stops Run.
+func (a *Agent) setupJournal() {
+	j, err := journal.Open(config.DataPath(JournalFile), journal.DefaultMaxEntries)
+	if err != nil {
+		a.setupErr = fmt.Errorf("command journal: %w", err)
+		j, _ = journal.Open("", journal.DefaultMaxEntries)
+	}
+	a.journal = j
+}
+
+// failInterruptedCommands reports commands left running by a previous agent process as failed
+func (a *Agent) failInterruptedCommands(ctx context.Context) {
+	for _, entry := range a.journal.Interrupted() {
+		a.logger.Printf("Command %s (type: %s) was interrupted by an agent restart", entry.CommandID, entry.Type)
+
+		a.reportCommandResult(ctx, commandResult{
+			CommandID: entry.CommandID,
+			Result:    "agent restarted",
+			Failure:   &api.CommandFailure{Code: FailureAgentRestarted, Message: "agent restarted"},
+		})
+		if err := a.journal.Finish(entry.CommandID, false, "agent restarted"); err != nil {
+			a.logger.Printf("Warning: failed to update command journal: %v", err)
+		}
+	}
+}
+
+// registerBuiltinHandlers registers the command handlers every agent supports
+func (a *Agent) registerBuiltinHandlers() {
+	builtins := []commands.Handler{
+		commands.NewTypedHandler(CommandInstallUpdates, commands.DecodeInstallUpdates, a.handleInstallUpdates),
+		commands.NewTypedHandler(CommandRunScan, commands.DecodeNoPayload, a.handleRunScan),
+	}
+
+	for _, h := range builtins {
+		if err := a.commands.Register(h); err != nil {
+			a.logger.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// executeCommand dispatches a single command to its handler and reports the result
+func (a *Agent) executeCommand(ctx context.Context, cmd api.Command) {
+	// Nothing runs unless the console signed it. Only verified commands are journaled,
+	// so a forged command reusing a real ID can't block the real one.
+	if err := a.verifyCommand(&cmd); err != nil {
+		a.rejectCommand(ctx, cmd, err)
+		return
+	}
+
+	// The server may deliver a command again before it sees the completion
+	started, err := a.journal.Begin(cmd.ID, cmd.Type)
+	if err != nil {
+		a.logger.Printf("Warning: failed to record command %s in journal: %v", cmd.ID, err)
+	}
+	if !started {
+		a.logger.Printf("Skipping command %s (type: %s): already executed", cmd.ID, cmd.Type)
+		return
+	}
+
+	a.logger.Printf("Executing command %s (type: %s)", cmd.ID, cmd.Type)
+
+	// Acknowledge first so the server shows the command as running and doesn't deliver it again
+	if err := a.client.AcknowledgeCommand(ctx, cmd.ID); err != nil {
+		a.logger.Printf("Failed to acknowledge command %s: %v", cmd.ID, err)
+	}
+
+	ctx = commands.WithProgress(ctx, func(progress api.CommandProgress) {
+		a.logger.Printf("Command %s progress: %d%% %s", cmd.ID, progress.Percent, progress.CurrentStep)
+		if err := a.client.ReportCommandProgress(ctx, cmd.ID, &progress); err != nil {
+			a.logger.Printf("Failed to report progress for command %s: %v", cmd.ID, err)
+		}
+	})
+
+	result := a.commands.Dispatch(ctx, cmd)
+	if !result.Success {
+		a.logger.Printf("Command %s failed: %s", cmd.ID, result.Message)
+	}
+
+	// Only the summary line is journaled; full output goes to the server
+	summary, _, _ := strings.Cut(result.Message, "\n")
+	if err := a.journal.Finish(cmd.ID, result.Success, summary); err != nil {
+		a.logger.Printf("Warning: failed to update command journal: %v", err)
+	}
+
+	a.reportCommandResult(ctx, commandResult{
+		CommandID: cmd.ID,
+		Success:   result.Success,
+		Result:    result.Message,
+		Failure:   result.Failure,
+	})
+}
+
+// handleInstallUpdates executes an install_updates command
+func (a *Agent) handleInstallUpdates(ctx context.Context, cmd api.Command, payload commands.InstallUpdatesPayload) commands.Result {
+	packages := payload.Refs()
+	a.logger.Printf("Installing %d package(s): %v", len(packages), packages)
+
+	// Install each package through the source that reported it
+	results := a.installPackages(ctx, packages)
+
+	// Log results
+	successCount := 0
+	failureCount := 0
+	resultMessages := []string{}
+
+	for _, result := range results {
+		if result.Success {
+			successCount++
+			a.logger.Printf("  ✓ %s: %s", result.PackageIdentifier, result.Message)
+			resultMessages = append(resultMessages, fmt.Sprintf("✓ %s: %s", result.PackageIdentifier, result.Message))
+		} else {
+			failureCount++
+			a.logger.Printf("  ✗ %s: %s", result.PackageIdentifier, result.Message)
+			resultMessages = append(resultMessages, fmt.Sprintf("✗ %s: %s", result.PackageIdentifier, result.Message))
+			if result.Error != nil {
+				a.logger.Printf("    Error: %v", result.Error)
+			}
+		}
+	}
+
+	a.logger.Printf("Install command completed: %d/%d successful", successCount, len(results))
+
+	// Trigger update scan to report new state
+	go func() {
+		// Wait a bit for installations to complete
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			a.requestScan(ctx)
+		}
+	}()
+
+	return commands.Result{
+		Success: failureCount == 0,
+		Message: fmt.Sprintf("%d/%d successful\n%s", successCount, len(results), strings.Join(resultMessages, "\n")),
+	}
+}
+
+// handleRunScan executes a run_scan command to force an immediate update scan
+func (a *Agent) handleRunScan(ctx context.Context, cmd api.Command, _ commands.NoPayload) commands.Result {
+	a.logger.Println("Executing sync command - triggering immediate update scan")
+
+	commands.ReportStep(ctx, 0, 2, "Scanning for updates", "")
+	scan, err := a.scanAllSources()
+	if err != nil {
+		a.logger.Printf("Sync scan failed: %v", err)
+		return commands.Result{Success: false, Message: fmt.Sprintf("Scan failed: %v", err)}
+	}
+	apiUpdates := scan.Items
+
+	a.logger.Printf("Sync scan completed: found %d available updates", len(apiUpdates))
+	logUpdates(a.logger, apiUpdates)
+
+	// Report updates to backend
+	commands.ReportStep(ctx, 1, 2, "Reporting updates", "")
+	a.logger.Printf("Reporting %d updates to backend...", len(apiUpdates))
+	req := &api.UpdateReportRequest{
+		DeviceID:      a.deviceID(),
+		Updates:       apiUpdates,
+		UnparsedLines: len(scan.Unparsed),
+	}
+
+	resp, err := a.client.ReportUpdates(ctx, req)
+	if err != nil {
+		a.logger.Printf("Failed to report updates: %v", err)
+		a.recoverDevice(ctx, err)
+		if api.IsTemporary(err) {
+			a.spoolUpdateReport(req)
+		}
+		return commands.Result{Success: false, Message: fmt.Sprintf("Failed to report updates: %v", err)}
+	}
+
+	a.logger.Printf("Sync completed: %d updates reported to server (response: %d received)", len(apiUpdates), resp.Received)
+	return commands.Result{
+		Success: true,
+		Message: fmt.Sprintf("Scan completed successfully. Found %d available updates.", len(apiUpdates)),
+	}
+}
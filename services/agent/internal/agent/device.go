@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/config"
+)
+
+// DeviceHistoryFile records re-registrations in the agent data directory
+const DeviceHistoryFile = "device-history.json"
+
+const (
+	// deviceNotFoundThreshold is how many "device not found" responses in a row trigger re-registration,
+	// so a single misrouted request doesn't give the device a new identity
+	deviceNotFoundThreshold = 3
+
+	// reregisterMinInterval is the wait after a re-registration; it doubles with every
+	// re-registration in reregisterWindow, up to reregisterMaxInterval
+	reregisterMinInterval = 10 * time.Minute
+	reregisterMaxInterval = 24 * time.Hour
+	reregisterWindow      = 24 * time.Hour
+
+	// maxDeviceHistory bounds the re-registrations kept on disk
+	maxDeviceHistory = 50
+)
+
+// Reregistration is a recorded attempt to register again after the server lost the device
+type Reregistration struct {
+	At               time.Time `json:"at"`
+	PreviousDeviceID string    `json:"previousDeviceId"`
+	DeviceID         string    `json:"deviceId,omitempty"`
+	Reason           string    `json:"reason"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// deviceState tracks "device not found" responses and past re-registrations
+type deviceState struct {
+	mu       sync.Mutex
+	path     string
+	notFound int
+	history  []Reregistration
+}
+
+// openDeviceHistory loads the re-registration history; the guardrails survive restarts
+func openDeviceHistory(logger Logger) *deviceState {
+	d := &deviceState{path: config.DataPath(DeviceHistoryFile)}
+
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Printf("Warning: failed to read device history: %v", err)
+		}
+		return d
+	}
+	if err := json.Unmarshal(data, &d.history); err != nil {
+		logger.Printf("Warning: ignoring unreadable device history: %v", err)
+	}
+	return d
+}
+
+// nextAttempt returns the earliest time another re-registration is allowed.
+// Callers must hold d.mu.
+func (d *deviceState) nextAttempt(now time.Time) time.Time {
+	if len(d.history) == 0 {
+		return time.Time{}
+	}
+
+	recent := 0
+	for _, r := range d.history {
+		if now.Sub(r.At) < reregisterWindow {
+			recent++
+		}
+	}
+
+	wait := reregisterMinInterval
+	for i := 1; i < recent && wait < reregisterMaxInterval; i++ {
+		wait *= 2
+	}
+	if wait > reregisterMaxInterval {
+		wait = reregisterMaxInterval
+	}
+	return d.history[len(d.history)-1].At.Add(wait)
+}
+
+// record appends a re-registration to the history and persists it.
+// Callers must hold d.mu.
+func (d *deviceState) record(r Reregistration) error {
+	d.history = append(d.history, r)
+	if len(d.history) > maxDeviceHistory {
+		d.history = d.history[len(d.history)-maxDeviceHistory:]
+	}
+
+	data, err := json.MarshalIndent(d.history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode device history: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return fmt.Errorf("create device history directory: %w", err)
+	}
+
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write device history: %w", err)
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace device history: %w", err)
+	}
+	return nil
+}
+
+// deviceFound resets the "device not found" count after the server accepted the device ID
+func (a *Agent) deviceFound() {
+	a.device.mu.Lock()
+	defer a.device.mu.Unlock()
+	a.device.notFound = 0
+}
+
+// recoverDevice registers the device again when the server no longer knows its ID,
+// e.g. after a database reset or when the device was deleted in the console.
+// It reports whether err was a "device not found" response.
+func (a *Agent) recoverDevice(ctx context.Context, err error) bool {
+	if !errors.Is(err, api.ErrDeviceNotFound) {
+		return false
+	}
+
+	d := a.device
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.notFound++
+	if d.notFound < deviceNotFoundThreshold {
+		a.logger.Printf("Server doesn't know device %s (%d/%d before re-registering)", a.deviceID(), d.notFound, deviceNotFoundThreshold)
+		return true
+	}
+
+	a.reregister(ctx, err, "server no longer knew the device")
+	return true
+}
+
+// reregister registers the device again under its previous ID, at most as often as
+// the re-registration history allows, and reports whether it got a device ID.
+// Callers must hold a.device.mu.
+func (a *Agent) reregister(ctx context.Context, cause error, why string) bool {
+	d := a.device
+	previous := a.deviceID()
+
+	now := time.Now()
+	if next := d.nextAttempt(now); now.Before(next) {
+		a.logger.Printf("Re-registration of device %s (%s) held back until %s", previous, why, next.Local().Format(time.RFC3339))
+		return false
+	}
+
+	a.logger.Printf("Registering device %s again: %s", previous, why)
+
+	// The old credentials stay until a registration replaces them, so a failed
+	// attempt doesn't leave the device without any
+	entry := Reregistration{At: now.UTC(), PreviousDeviceID: previous, Reason: cause.Error()}
+	if regErr := a.register(ctx, previous); regErr != nil {
+		entry.Error = regErr.Error()
+		a.logger.Printf("Re-registration failed: %v", regErr)
+	} else {
+		entry.DeviceID = a.deviceID()
+		d.notFound = 0
+	}
+
+	if err := d.record(entry); err != nil {
+		a.logger.Printf("Warning: failed to record re-registration: %v", err)
+	}
+	if entry.DeviceID == "" {
+		return false
+	}
+
+	a.logger.Printf("Device re-registered: %s -> %s", previous, entry.DeviceID)
+	if a.ws != nil {
+		a.ws.SetDeviceID(entry.DeviceID)
+	}
+	a.reportSecurityEvent(ctx, &api.SecurityEvent{
+		Type:             api.SecurityEventReregistered,
+		PreviousDeviceID: previous,
+		Reason:           why + ": " + cause.Error(),
+	})
+	return true
+}
+
+// deviceID returns the ID the server knows the device by
+func (a *Agent) deviceID() string {
+	a.idMu.RLock()
+	defer a.idMu.RUnlock()
+	return a.config.DeviceID
+}
+
+// setDeviceID stores the ID issued by a registration and saves the configuration
+func (a *Agent) setDeviceID(id string) error {
+	a.idMu.Lock()
+	defer a.idMu.Unlock()
+	a.config.DeviceID = id
+	return a.config.Save()
+}
@@ -0,0 +1,268 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// reregisteringAPI is a fake API that issues device-2 on registration and
+// rejects every other call made for device-1 with status
+func reregisteringAPI(t *testing.T, status int) *fakeAPI {
+	server := newFakeAPI(t)
+	server.Handler = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		if r.URL.Path == "/agent/register" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"deviceId":"device-2","deviceToken":"token-2"}`))
+			return true
+		}
+		var req struct {
+			DeviceID string `json:"deviceId"`
+		}
+		json.Unmarshal(body, &req)
+		if req.DeviceID == "device-1" {
+			http.Error(w, `{"message":"rejected"}`, status)
+			return true
+		}
+		return false
+	}
+	return server
+}
+
+// registrations returns the previous device IDs sent with each registration
+func registrations(t *testing.T, server *fakeAPI) []string {
+	t.Helper()
+	var previous []string
+	for _, call := range server.Calls() {
+		if call.Path != "/agent/register" {
+			continue
+		}
+		var req api.RegisterRequest
+		if err := json.Unmarshal(call.Body, &req); err != nil {
+			t.Fatal(err)
+		}
+		previous = append(previous, req.PreviousDeviceID)
+	}
+	return previous
+}
+
+func TestRecoverAuthReregistersWithBackoff(t *testing.T) {
+	server := reregisteringAPI(t, http.StatusUnauthorized)
+	a := newTestAgent(t, server.URL)
+	unauthorized := fmt.Errorf("heartbeat: %w", api.ErrUnauthorized)
+
+	if !a.recoverAuth(context.Background(), unauthorized) {
+		t.Fatal("recoverAuth() = false for a 401")
+	}
+	if got := a.deviceID(); got != "device-2" {
+		t.Errorf("deviceID() = %q after re-enrollment, want device-2", got)
+	}
+	if got := registrations(t, server); len(got) != 1 || got[0] != "device-1" {
+		t.Fatalf("registrations = %q, want one for previous device-1", got)
+	}
+	if len(a.device.history) != 1 || a.device.history[0].DeviceID != "device-2" {
+		t.Errorf("history = %+v, want the re-enrollment recorded", a.device.history)
+	}
+
+	// Another 401 right away waits for the re-registration backoff
+	a.recoverAuth(context.Background(), unauthorized)
+	if got := registrations(t, server); len(got) != 1 {
+		t.Errorf("registered %d times, want the second attempt held back", len(got))
+	}
+	if a.recoverAuth(context.Background(), fmt.Errorf("connection refused")) {
+		t.Error("recoverAuth() = true for a network error")
+	}
+}
+
+func TestHeartbeatRebuiltAfterReenrollment(t *testing.T) {
+	defer func(d time.Duration) { heartbeatRetryDelay = d }(heartbeatRetryDelay)
+	heartbeatRetryDelay = time.Millisecond
+
+	server := reregisteringAPI(t, http.StatusUnauthorized)
+	a := newTestAgent(t, server.URL)
+
+	a.sendHeartbeat(context.Background())
+
+	var sent []string
+	for _, call := range server.Calls() {
+		if call.Path != "/agent/heartbeat" {
+			continue
+		}
+		var req api.HeartbeatRequest
+		json.Unmarshal(call.Body, &req)
+		sent = append(sent, req.DeviceID)
+	}
+	if len(sent) != 2 || sent[0] != "device-1" || sent[1] != "device-2" {
+		t.Errorf("heartbeats sent for %q, want device-1 then device-2", sent)
+	}
+	if a.outbox.Len() != 0 {
+		t.Errorf("%d item(s) queued, want none", a.outbox.Len())
+	}
+}
+
+func TestRecoverDeviceWaitsForThreshold(t *testing.T) {
+	server := reregisteringAPI(t, http.StatusNotFound)
+	a := newTestAgent(t, server.URL)
+	notFound := fmt.Errorf("heartbeat: %w", api.ErrDeviceNotFound)
+
+	for i := 1; i < deviceNotFoundThreshold; i++ {
+		a.recoverDevice(context.Background(), notFound)
+	}
+	if got := registrations(t, server); len(got) != 0 {
+		t.Fatalf("registered before the threshold: %q", got)
+	}
+
+	a.recoverDevice(context.Background(), notFound)
+	if got := registrations(t, server); len(got) != 1 || got[0] != "device-1" {
+		t.Fatalf("registrations = %q, want one for previous device-1", got)
+	}
+	if got := a.deviceID(); got != "device-2" {
+		t.Errorf("deviceID() = %q, want device-2", got)
+	}
+}
+
+// authorizations returns the Authorization header of every call to path
+func authorizations(server *fakeAPI, path string) []string {
+	var headers []string
+	for _, call := range server.Calls() {
+		if call.Path == path {
+			headers = append(headers, call.Header.Get("Authorization"))
+		}
+	}
+	return headers
+}
+
+func TestRegistrationSendsEnrollmentSecretAndAuthorizesRequests(t *testing.T) {
+	server := newFakeAPI(t)
+	server.Handler = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		if r.URL.Path == "/agent/register" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"deviceId":"device-7","deviceToken":"token-7"}`))
+			return true
+		}
+		return false
+	}
+	a := newTestAgent(t, server.URL)
+	a.config.DeviceID = ""
+	a.config.EnrollmentSecret = "enroll-s3cret"
+
+	if err := a.register(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	var req api.RegisterRequest
+	for _, call := range server.Calls() {
+		if call.Path == "/agent/register" {
+			json.Unmarshal(call.Body, &req)
+		}
+	}
+	if req.EnrollmentSecret != "enroll-s3cret" {
+		t.Errorf("registration enrollment secret = %q, want enroll-s3cret", req.EnrollmentSecret)
+	}
+
+	a.sendHeartbeat(context.Background())
+	if got := authorizations(server, "/agent/heartbeat"); len(got) != 1 || got[0] != "Bearer token-7" {
+		t.Errorf("heartbeat Authorization = %q, want Bearer token-7", got)
+	}
+
+	// The token survives a restart
+	if creds, err := openCredentialStore().Load(); err != nil || creds == nil || creds.AccessToken != "token-7" {
+		t.Errorf("stored credentials = %+v, %v; want token-7", creds, err)
+	}
+}
+
+func TestUnauthorizedRequestRefreshesToken(t *testing.T) {
+	server := newFakeAPI(t)
+	var refreshes []api.RefreshRequest
+	server.Handler = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		switch {
+		case r.URL.Path == "/agent/token/refresh":
+			var req api.RefreshRequest
+			json.Unmarshal(body, &req)
+			refreshes = append(refreshes, req)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"accessToken":"access-2","refreshToken":"refresh-2","expiresIn":3600}`))
+			return true
+		case r.Header.Get("Authorization") != "Bearer access-2":
+			http.Error(w, `{"message":"token expired"}`, http.StatusUnauthorized)
+			return true
+		}
+		return false
+	}
+	a := newTestAgent(t, server.URL)
+	if err := a.credentials.Save(&api.Credentials{AccessToken: "access-1", RefreshToken: "refresh-1"}); err != nil {
+		t.Fatal(err)
+	}
+	a.loadCredentials()
+
+	a.sendHeartbeat(context.Background())
+
+	if got := authorizations(server, "/agent/heartbeat"); len(got) != 2 || got[0] != "Bearer access-1" || got[1] != "Bearer access-2" {
+		t.Errorf("heartbeat Authorization = %q, want access-1 then access-2", got)
+	}
+	if len(refreshes) != 1 || refreshes[0].RefreshToken != "refresh-1" || refreshes[0].DeviceID != "device-1" {
+		t.Errorf("refresh requests = %+v, want one with refresh-1 for device-1", refreshes)
+	}
+	if got := registrations(t, server); len(got) != 0 {
+		t.Errorf("re-registered %d times, want the refreshed token to be enough", len(got))
+	}
+	creds, err := a.credentials.Load()
+	if err != nil || creds == nil || creds.AccessToken != "access-2" || creds.RefreshToken != "refresh-2" {
+		t.Errorf("stored credentials = %+v, %v; want the refreshed pair", creds, err)
+	}
+}
+
+func TestFailedReregistrationKeepsCredentials(t *testing.T) {
+	server := newFakeAPI(t)
+	registerStatus := http.StatusServiceUnavailable
+	server.Handler = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		if r.URL.Path != "/agent/register" {
+			return false
+		}
+		if registerStatus != http.StatusOK {
+			http.Error(w, `{"message":"unavailable"}`, registerStatus)
+			return true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"deviceId":"device-2","deviceToken":"token-2"}`))
+		return true
+	}
+	a := newTestAgent(t, server.URL)
+	if err := a.credentials.Save(&api.Credentials{AccessToken: "token-1"}); err != nil {
+		t.Fatal(err)
+	}
+	a.loadCredentials()
+	notFound := fmt.Errorf("heartbeat: %w", api.ErrDeviceNotFound)
+
+	a.device.mu.Lock()
+	ok := a.reregister(context.Background(), notFound, "test")
+	a.device.mu.Unlock()
+	if ok {
+		t.Fatal("reregister() = true while registration fails")
+	}
+	if got := a.client.AccessToken(); got != "token-1" {
+		t.Errorf("client token after failed re-registration = %q, want token-1", got)
+	}
+	if creds, err := a.credentials.Load(); err != nil || creds == nil || creds.AccessToken != "token-1" {
+		t.Errorf("stored credentials after failed re-registration = %+v, %v; want token-1", creds, err)
+	}
+
+	registerStatus = http.StatusOK
+	a.device.history = nil
+	a.device.mu.Lock()
+	ok = a.reregister(context.Background(), notFound, "test")
+	a.device.mu.Unlock()
+	if !ok {
+		t.Fatal("reregister() = false once registration succeeds")
+	}
+	if got := a.client.AccessToken(); got != "token-2" {
+		t.Errorf("client token after re-registration = %q, want token-2", got)
+	}
+	if creds, err := a.credentials.Load(); err != nil || creds == nil || creds.AccessToken != "token-2" {
+		t.Errorf("stored credentials after re-registration = %+v, %v; want token-2", creds, err)
+	}
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/config"
+)
+
+// newAPIClient creates the API client for the configured endpoints, in order of preference
+func newAPIClient(cfg *config.Config) *api.Client {
+	endpoints := cfg.Endpoints()
+	return api.NewClient(endpoints[0], endpoints[1:]...)
+}
+
+// setupEndpoints follows API failovers: the websocket moves to the active endpoint
+// and the next heartbeat reports it
+func (a *Agent) setupEndpoints() {
+	a.client.Endpoints().OnChange(func(from, to string) {
+		a.logger.Printf("API endpoint changed: %s -> %s", from, to)
+		for _, status := range a.client.Endpoints().Status() {
+			if status.LastError != "" {
+				a.logger.Printf("  %s: health %.2f, %d failure(s), last error: %s", status.URL, status.Score, status.Failures, status.LastError)
+			}
+		}
+
+		if a.ws != nil {
+			a.ws.SetServerURL(websocketURL(to))
+		}
+	})
+}
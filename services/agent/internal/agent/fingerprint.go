@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/deviceid"
+)
+
+// AgentIDFile is the persisted agent UUID file name in the agent data directory
+const AgentIDFile = "agent-id"
+
+// fingerprint computes the device fingerprint sent at registration, or nil if it can't be computed
+func (a *Agent) fingerprint() *deviceid.Fingerprint {
+	collector := &deviceid.Collector{AgentIDPath: config.DataPath(AgentIDFile)}
+	fp, err := collector.Collect()
+	if err != nil {
+		a.logger.Printf("Warning: failed to compute device fingerprint: %v", err)
+		return nil
+	}
+
+	sources := make([]string, 0, len(fp.Components))
+	for _, source := range []string{deviceid.SourceMachineID, deviceid.SourceHardwareUUID, deviceid.SourceAgentUUID} {
+		if fp.Components[source] != "" {
+			sources = append(sources, source)
+		}
+	}
+	a.logger.Printf("Device fingerprint %.16s… from %v", fp.ID, sources)
+	return fp
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/identity"
+	"github.com/lunaris/agent/internal/transport"
+)
+
+// IdentityDir is the directory of the device key and certificate in the agent data directory
+const IdentityDir = "identity"
+
+const (
+	// certificateCheckInterval is how often the client certificate expiry is checked
+	certificateCheckInterval = time.Hour
+
+	// certificateRenewBefore renews certificates at the latest this long before they expire
+	certificateRenewBefore = 7 * 24 * time.Hour
+)
+
+// setupTLS builds the TLS configuration from config.Config.TLS and loads the device identity.
+// A broken TLS configuration is kept as an error so Run refuses to start instead of
+// silently connecting without the configured CA or pins.
+func (a *Agent) setupTLS() {
+	opts := transport.TLSOptions{
+		CABundle:   a.config.TLS.CABundle,
+		PinnedKeys: a.config.TLS.PinnedKeys,
+	}
+
+	if a.config.TLS.ClientCertificate {
+		id, err := identity.Load(config.DataPath(IdentityDir))
+		if err != nil {
+			a.setupErr = fmt.Errorf("load device identity: %w", err)
+			return
+		}
+		a.identity = id
+		opts.GetClientCertificate = id.GetClientCertificate
+	}
+
+	if opts.CABundle == "" && len(opts.PinnedKeys) == 0 && opts.GetClientCertificate == nil {
+		return
+	}
+
+	tlsConfig, err := transport.NewTLSConfig(opts)
+	if err != nil {
+		a.setupErr = fmt.Errorf("TLS configuration: %w", err)
+		return
+	}
+	a.tlsConfig = tlsConfig
+	a.client.SetTLSConfig(tlsConfig)
+}
+
+// certificateRequest returns a CSR for registration, or "" if mutual TLS is disabled
+func (a *Agent) certificateRequest(hostname string) string {
+	if a.identity == nil {
+		return ""
+	}
+
+	csr, err := a.identity.CreateCSR(hostname)
+	if err != nil {
+		a.logger.Printf("Warning: failed to create certificate request: %v", err)
+		return ""
+	}
+	return csr
+}
+
+// installCertificate installs a certificate issued by the server
+func (a *Agent) installCertificate(certPEM string) {
+	if a.identity == nil {
+		return
+	}
+	if certPEM == "" {
+		a.logger.Println("Warning: server issued no client certificate; mutual TLS is not in use")
+		return
+	}
+
+	if err := a.identity.Install(certPEM); err != nil {
+		a.logger.Printf("Failed to install client certificate: %v", err)
+		return
+	}
+
+	// Connections made with the previous certificate are dropped once idle
+	a.client.CloseIdleConnections()
+	a.logger.Printf("Client certificate installed (expires %s)", a.identity.NotAfter().Format(time.RFC3339))
+}
+
+// renewCertificateIfDue requests a new client certificate when the current one is
+// missing or close to expiry. A new key is generated for every renewal.
+func (a *Agent) renewCertificateIfDue(ctx context.Context) {
+	if a.identity == nil || a.deviceID() == "" || !a.identity.NeedsRenewal(certificateRenewBefore) {
+		return
+	}
+
+	if a.identity.HasCertificate() {
+		a.logger.Printf("Renewing client certificate (expires %s)", a.identity.NotAfter().Format(time.RFC3339))
+	} else {
+		a.logger.Println("Requesting client certificate")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = a.deviceID()
+	}
+	csr := a.certificateRequest(hostname)
+	if csr == "" {
+		return
+	}
+
+	resp, err := a.client.RenewCertificate(ctx, &api.CertificateRequest{
+		DeviceID: a.deviceID(),
+		CSR:      csr,
+	})
+	if err != nil {
+		a.logger.Printf("Client certificate renewal failed: %v", err)
+		return
+	}
+
+	a.installCertificate(resp.Certificate)
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/lunaris/agent/internal/config"
+)
+
+// LogFile is the agent log file name in the log directory
+const LogFile = "agent.log"
+
+// maxLogSize is the size at which the log file is rotated to LogFile.1
+var maxLogSize int64 = 10 << 20
+
+// logFile is an append-only log file rotated once it grows past maxLogSize,
+// keeping one previous file
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+}
+
+// openLogFile opens the log file at path for appending
+func openLogFile(path string) (*logFile, error) {
+	l := &logFile{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open log file: %w", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it past maxLogSize
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(p)) > maxLogSize {
+		l.f.Close()
+		// A failed rotation keeps appending to the current file
+		os.Rename(l.path, l.path+".1")
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// teeLogger writes every message to the agent's logger and to the log file
+type teeLogger struct {
+	Logger
+	file *log.Logger
+}
+
+func (t teeLogger) Printf(format string, v ...interface{}) {
+	t.Logger.Printf(format, v...)
+	t.file.Printf(format, v...)
+}
+
+func (t teeLogger) Println(v ...interface{}) {
+	t.Logger.Println(v...)
+	t.file.Println(v...)
+}
+
+// withLogFile returns a logger that also writes to the agent log file in the log
+// directory, or logger itself if the file can't be opened
+func withLogFile(logger Logger) Logger {
+	f, err := openLogFile(config.LogPath(LogFile))
+	if err != nil {
+		logger.Printf("Warning: logging to the console only: %v", err)
+		return logger
+	}
+	return teeLogger{Logger: logger, file: log.New(f, "", log.LstdFlags)}
+}
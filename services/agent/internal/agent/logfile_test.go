@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lunaris/agent/internal/config"
+)
+
+func TestLogFileRotates(t *testing.T) {
+	defer func(size int64) { maxLogSize = size }(maxLogSize)
+	maxLogSize = 64
+
+	path := filepath.Join(t.TempDir(), LogFile)
+	l, err := openLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.f.Close()
+
+	first := strings.Repeat("a", 40) + "\n"
+	second := strings.Repeat("b", 40) + "\n"
+	for _, line := range []string{first, second} {
+		if _, err := l.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != second {
+		t.Errorf("log file = %q, want only the line written after rotation", data)
+	}
+	if data, _ := os.ReadFile(path + ".1"); string(data) != first {
+		t.Errorf("rotated log file = %q, want the first line", data)
+	}
+}
+
+func TestAgentLogsToLogDirectory(t *testing.T) {
+	a := newTestAgent(t, "http://127.0.0.1:0")
+	if err := config.EnsureDirs(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := withLogFile(a.logger)
+	logger.Printf("Device %s registered", "device-1")
+	logger.Println("Agent started")
+
+	data, err := os.ReadFile(config.LogPath(LogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Device device-1 registered", "Agent started"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log file missing %q:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/fsacl"
+)
+
+// stateFiles are the agent state files and directories moved when the state directory changes
+var stateFiles = []string{
+	CredentialsFile,
+	SigningKeyFile,
+	ConsoleKeysFile,
+	IdentityDir,
+	JournalFile,
+	OutboxDir,
+	DeviceHistoryFile,
+	AgentIDFile,
+	PolicyFile,
+	ProxyPasswordFile,
+}
+
+// migrateState moves state left in legacyDir, next to config.json, by older agents into the
+// state directory. Anything already in the state directory wins, and a failed move leaves
+// the old file in place. An empty legacyDir means there's nothing to migrate.
+func migrateState(legacyDir string, logger Logger) {
+	if legacyDir == "" {
+		return
+	}
+	for _, name := range stateFiles {
+		from := filepath.Join(legacyDir, name)
+		if _, err := os.Lstat(from); err != nil {
+			continue
+		}
+
+		to := config.DataPath(name)
+		if _, err := os.Lstat(to); err == nil {
+			logger.Printf("Warning: %s exists in both %s and %s; using the state directory", name, filepath.Dir(from), filepath.Dir(to))
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+			logger.Printf("Warning: failed to migrate %s: %v", from, err)
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			logger.Printf("Warning: failed to migrate %s: %v", from, err)
+			continue
+		}
+		// A moved file keeps the permissions of the old directory
+		if err := fsacl.Restrict(to); err != nil {
+			logger.Printf("Warning: %v", err)
+		}
+		logger.Printf("Migrated %s to %s", from, to)
+	}
+}
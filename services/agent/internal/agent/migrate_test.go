@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/lunaris/agent/internal/config"
+)
+
+func TestMigrateState(t *testing.T) {
+	newTestAgent(t, "http://127.0.0.1:0")
+	legacy := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// State an older agent left next to config.json
+	write(filepath.Join(legacy, CredentialsFile), "credentials")
+	write(filepath.Join(legacy, ProxyPasswordFile), "proxy password")
+	write(filepath.Join(legacy, IdentityDir, "device.pem"), "identity")
+	write(filepath.Join(legacy, PolicyFile), "old policy")
+	write(filepath.Join(legacy, "config.json"), "{}")
+
+	// A newer file already in the state directory
+	write(config.DataPath(PolicyFile), "new policy")
+
+	migrateState(legacy, testLogger{t})
+
+	for _, moved := range []struct{ name, content string }{
+		{CredentialsFile, "credentials"},
+		{ProxyPasswordFile, "proxy password"},
+		{filepath.Join(IdentityDir, "device.pem"), "identity"},
+	} {
+		data, err := os.ReadFile(config.DataPath(moved.name))
+		if err != nil || string(data) != moved.content {
+			t.Errorf("%s in the state directory = %q, %v; want %q", moved.name, data, err, moved.content)
+		}
+		if _, err := os.Lstat(filepath.Join(legacy, moved.name)); !os.IsNotExist(err) {
+			t.Errorf("%s left in the legacy directory", moved.name)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(config.DataPath(ProxyPasswordFile)); err == nil && info.Mode().Perm() != 0600 {
+			t.Errorf("migrated %s mode = %v, want 0600", ProxyPasswordFile, info.Mode().Perm())
+		}
+	}
+
+	// The state directory wins; the old copy stays where it was
+	if data, _ := os.ReadFile(config.DataPath(PolicyFile)); string(data) != "new policy" {
+		t.Errorf("%s = %q, want the state directory's copy", PolicyFile, data)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, PolicyFile)); err != nil {
+		t.Errorf("legacy %s removed: %v", PolicyFile, err)
+	}
+
+	// Files that aren't agent state stay put
+	if _, err := os.Stat(filepath.Join(legacy, "config.json")); err != nil {
+		t.Errorf("config.json moved: %v", err)
+	}
+}
+
+func TestMigrateStateWithoutLegacyDir(t *testing.T) {
+	newTestAgent(t, "http://127.0.0.1:0")
+	migrateState("", testLogger{t})
+	if entries, _ := os.ReadDir(config.Directories().State); len(entries) != 0 {
+		t.Errorf("state directory has %d entries after migrating nothing", len(entries))
+	}
+}
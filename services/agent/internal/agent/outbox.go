@@ -0,0 +1,253 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/backoff"
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/outbox"
+)
+
+// OutboxDir is the outbox directory name in the agent data directory
+const OutboxDir = "outbox"
+
+// Kinds of outbound calls queued in the outbox
+const (
+	outboxHeartbeat     = "heartbeat"
+	outboxUpdateReport  = "update_report"
+	outboxCommandResult = "command_result"
+	outboxSecurityEvent = "security_event"
+)
+
+// commandResult is a queued command completion
+type commandResult struct {
+	CommandID string              `json:"commandId"`
+	Success   bool                `json:"success"`
+	Result    string              `json:"result"`
+	Failure   *api.CommandFailure `json:"failure,omitempty"`
+}
+
+// errUndeliverable marks queued items that can never be sent
+var errUndeliverable = errors.New("undeliverable outbox item")
+
+// outboxBackoff spaces out replay attempts while the API stays unreachable
+var outboxBackoff = &backoff.Backoff{Min: 5 * time.Second, Max: 5 * time.Minute}
+
+// openOutbox opens the on-disk outbox, falling back to memory if it can't be opened
+func openOutbox(logger Logger) *outbox.Outbox {
+	o, err := outbox.Open(config.DataPath(OutboxDir), outbox.DefaultLimits())
+	if err != nil {
+		logger.Printf("Warning: outbox unavailable, undelivered reports won't survive restarts: %v", err)
+		o, _ = outbox.Open("", outbox.DefaultLimits())
+	}
+	return o
+}
+
+// spoolHeartbeat queues a heartbeat, replacing any older one still waiting
+func (a *Agent) spoolHeartbeat(req *api.HeartbeatRequest) {
+	a.spool(outboxHeartbeat, req, true)
+}
+
+// spoolUpdateReport queues an update report, replacing any older one still waiting
+func (a *Agent) spoolUpdateReport(req *api.UpdateReportRequest) {
+	a.spool(outboxUpdateReport, req, true)
+}
+
+func (a *Agent) spool(kind string, payload interface{}, replace bool) {
+	var err error
+	if replace {
+		err = a.outbox.Replace(kind, payload)
+	} else {
+		err = a.outbox.Append(kind, payload)
+	}
+	if err != nil {
+		a.logger.Printf("Warning: failed to queue %s for later delivery: %v", kind, err)
+		return
+	}
+
+	a.logger.Printf("Queued %s for delivery when the API is reachable (%d waiting)", kind, a.outbox.Len())
+	a.kickOutbox()
+}
+
+// reportCommandResult sends a command completion, queueing it unless the API says the
+// command is unknown or already complete, so the console learns how every command ended
+func (a *Agent) reportCommandResult(ctx context.Context, result commandResult) {
+	// Keep completions behind anything already waiting
+	if a.outbox.Len() == 0 {
+		err := a.deliverCommandResult(ctx, result)
+		if err == nil {
+			return
+		}
+		a.logger.Printf("Failed to report command completion: %v", err)
+		if !keepsCompletion(outboxCommandResult, err) {
+			return
+		}
+	}
+
+	a.spool(outboxCommandResult, result, false)
+}
+
+// deliverCommandResult sends a command completion to the API
+func (a *Agent) deliverCommandResult(ctx context.Context, result commandResult) error {
+	if result.Failure != nil {
+		return a.client.FailCommand(ctx, result.CommandID, result.Result, result.Failure)
+	}
+	return a.client.CompleteCommand(ctx, result.CommandID, result.Success, result.Result)
+}
+
+// kickOutbox wakes the replay loop
+func (a *Agent) kickOutbox() {
+	select {
+	case a.outboxKick <- struct{}{}:
+	default:
+	}
+}
+
+// runOutbox replays queued calls in order whenever there are any,
+// backing off while the API stays unreachable
+func (a *Agent) runOutbox(ctx context.Context) {
+	attempt := 0
+	for {
+		var wait <-chan time.Time
+		if a.outbox.Len() > 0 {
+			if err := a.flushOutbox(ctx); err != nil {
+				delay := outboxBackoff.Delay(attempt)
+				attempt++
+				// A rate-limited API says when to come back
+				if retryAfter := api.RetryAfter(err); retryAfter > delay {
+					delay = retryAfter
+				}
+				a.logger.Printf("Outbox replay stopped (%d waiting), retrying in %v: %v", a.outbox.Len(), delay.Round(time.Second), err)
+				wait = time.After(delay)
+			} else {
+				attempt = 0
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.outboxKick:
+			// New items only trigger a replay once the current backoff is over
+			if wait != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-wait:
+				}
+			}
+		case <-wait:
+		}
+	}
+}
+
+// flushOutbox delivers queued items oldest first, stopping at the first temporary failure,
+// at an item that waits for re-enrollment, or at a completion the API hasn't settled.
+// Other items the API rejects outright are dropped so they don't block the queue.
+func (a *Agent) flushOutbox(ctx context.Context) error {
+	delivered := 0
+	defer func() {
+		if delivered > 0 {
+			a.logger.Printf("Outbox replayed %d item(s), %d waiting", delivered, a.outbox.Len())
+		}
+	}()
+
+	for ctx.Err() == nil {
+		it := a.outbox.Peek()
+		if it == nil {
+			return nil
+		}
+
+		err := a.deliverOutboxItem(ctx, it)
+		if err == nil {
+			delivered++
+			a.outbox.Remove(it.Seq)
+			continue
+		}
+
+		if errors.Is(err, api.ErrUnauthorized) {
+			a.recoverAuth(ctx, err)
+			if waitsForReenrollment(it.Kind, err) {
+				a.outbox.MarkFailed(it.Seq, err)
+				return err
+			}
+		}
+
+		if errors.Is(err, errUndeliverable) || !(api.IsTemporary(err) || keepsCompletion(it.Kind, err)) {
+			a.logger.Printf("Dropping queued %s from %s: %v", it.Kind, it.CreatedAt.Local().Format(time.RFC3339), err)
+			a.outbox.Remove(it.Seq)
+			a.recoverDevice(ctx, err)
+			continue
+		}
+
+		a.outbox.MarkFailed(it.Seq, err)
+		return err
+	}
+	return ctx.Err()
+}
+
+// waitsForReenrollment reports whether a queued item of kind rejected with err
+// should be kept until the agent has re-enrolled. Heartbeats and update reports
+// are superseded by the next ones; completions and security events aren't.
+func waitsForReenrollment(kind string, err error) bool {
+	if !errors.Is(err, api.ErrUnauthorized) {
+		return false
+	}
+	return kind == outboxCommandResult || kind == outboxSecurityEvent
+}
+
+// keepsCompletion reports whether an item of kind rejected with err is a command
+// completion to keep for another attempt. Only the API saying the command is unknown
+// or already complete settles it; any other rejection may be fixed on either side.
+func keepsCompletion(kind string, err error) bool {
+	if kind != outboxCommandResult || errors.Is(err, errUndeliverable) {
+		return false
+	}
+	return !errors.Is(err, api.ErrCommandSettled)
+}
+
+// deliverOutboxItem sends a single queued item.
+// Items queued before a re-registration are sent for the current device ID.
+func (a *Agent) deliverOutboxItem(ctx context.Context, it *outbox.Item) error {
+	switch it.Kind {
+	case outboxHeartbeat:
+		var req api.HeartbeatRequest
+		if err := it.Decode(&req); err != nil {
+			return fmt.Errorf("%w: decode heartbeat: %v", errUndeliverable, err)
+		}
+		req.DeviceID = a.deviceID()
+		_, err := a.client.Heartbeat(ctx, &req)
+		return err
+
+	case outboxUpdateReport:
+		var req api.UpdateReportRequest
+		if err := it.Decode(&req); err != nil {
+			return fmt.Errorf("%w: decode update report: %v", errUndeliverable, err)
+		}
+		req.DeviceID = a.deviceID()
+		_, err := a.client.ReportUpdates(ctx, &req)
+		return err
+
+	case outboxCommandResult:
+		var result commandResult
+		if err := it.Decode(&result); err != nil {
+			return fmt.Errorf("%w: decode command result: %v", errUndeliverable, err)
+		}
+		return a.deliverCommandResult(ctx, result)
+
+	case outboxSecurityEvent:
+		var event api.SecurityEvent
+		if err := it.Decode(&event); err != nil {
+			return fmt.Errorf("%w: decode security event: %v", errUndeliverable, err)
+		}
+		event.DeviceID = a.deviceID()
+		return a.client.ReportSecurityEvent(ctx, &event)
+
+	default:
+		return fmt.Errorf("%w: unknown kind %q", errUndeliverable, it.Kind)
+	}
+}
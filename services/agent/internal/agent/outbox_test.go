@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+func TestFlushOutboxSendsForCurrentDevice(t *testing.T) {
+	server := newFakeAPI(t)
+	a := newTestAgent(t, server.URL)
+
+	// Queued while the device was still known as device-1
+	a.outbox.Replace(outboxHeartbeat, &api.HeartbeatRequest{DeviceID: "device-1"})
+	a.outbox.Replace(outboxUpdateReport, &api.UpdateReportRequest{DeviceID: "device-1"})
+	a.outbox.Append(outboxSecurityEvent, &api.SecurityEvent{DeviceID: "device-1", Type: api.SecurityEventReregistered})
+	a.config.DeviceID = "device-2"
+
+	if err := a.flushOutbox(context.Background()); err != nil {
+		t.Fatalf("flushOutbox() error = %v", err)
+	}
+	if a.outbox.Len() != 0 {
+		t.Errorf("%d item(s) left in the outbox", a.outbox.Len())
+	}
+
+	calls := server.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("got %d calls %+v, want 3", len(calls), calls)
+	}
+	for _, call := range calls {
+		var body struct {
+			DeviceID string `json:"deviceId"`
+		}
+		if err := json.Unmarshal(call.Body, &body); err != nil {
+			t.Fatalf("%s: %v", call.Path, err)
+		}
+		if body.DeviceID != "device-2" {
+			t.Errorf("%s sent for device %q, want device-2", call.Path, body.DeviceID)
+		}
+	}
+}
+
+func TestFlushOutboxKeepsCompletionsUntilReenrolled(t *testing.T) {
+	server := newFakeAPI(t)
+	server.Handler = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		http.Error(w, `{"message":"invalid token"}`, http.StatusUnauthorized)
+		return true
+	}
+	a := newTestAgent(t, server.URL)
+	// Hold re-enrollment back so the test only sees the replay
+	a.device.history = []Reregistration{{At: time.Now()}}
+
+	a.outbox.Replace(outboxHeartbeat, &api.HeartbeatRequest{DeviceID: "device-1"})
+	a.outbox.Append(outboxCommandResult, commandResult{CommandID: "cmd-1", Success: true, Result: "done"})
+
+	err := a.flushOutbox(context.Background())
+	if !errors.Is(err, api.ErrUnauthorized) {
+		t.Fatalf("flushOutbox() error = %v, want ErrUnauthorized", err)
+	}
+
+	// The heartbeat is superseded by the next one; the completion waits
+	it := a.outbox.Peek()
+	if a.outbox.Len() != 1 || it.Kind != outboxCommandResult {
+		t.Fatalf("outbox holds %d item(s), first %+v; want only the completion", a.outbox.Len(), it)
+	}
+	if it.Attempts != 1 {
+		t.Errorf("completion attempts = %d, want 1", it.Attempts)
+	}
+}
+
+func TestReportCommandResultSpoolsUntilSettled(t *testing.T) {
+	tests := []struct {
+		status int
+		queued bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusUnauthorized, true},
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, false},
+		{http.StatusConflict, false},
+		{http.StatusGone, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := newFakeAPI(t)
+			server.Handler = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+				http.Error(w, `{"message":"no"}`, tt.status)
+				return true
+			}
+			a := newTestAgent(t, server.URL)
+
+			a.reportCommandResult(context.Background(), commandResult{CommandID: "cmd-1", Success: true})
+			if queued := a.outbox.Len() == 1; queued != tt.queued {
+				t.Errorf("queued = %v, want %v", queued, tt.queued)
+			}
+		})
+	}
+}
+
+func TestFlushOutboxKeepsRejectedCompletions(t *testing.T) {
+	status := http.StatusBadRequest
+	server := newFakeAPI(t)
+	server.Handler = func(w http.ResponseWriter, r *http.Request, body []byte) bool {
+		if r.URL.Path != "/agent/commands/cmd-1/complete" {
+			return false
+		}
+		http.Error(w, `{"message":"no"}`, status)
+		return true
+	}
+	a := newTestAgent(t, server.URL)
+
+	a.outbox.Append(outboxCommandResult, commandResult{CommandID: "cmd-1", Success: true, Result: "done"})
+	a.outbox.Replace(outboxHeartbeat, &api.HeartbeatRequest{DeviceID: "device-1"})
+
+	// A rejection that isn't about the command itself keeps the completion first in line
+	if err := a.flushOutbox(context.Background()); err == nil {
+		t.Fatal("flushOutbox() = nil, want the rejection")
+	}
+	it := a.outbox.Peek()
+	if a.outbox.Len() != 2 || it.Kind != outboxCommandResult || it.Attempts != 1 {
+		t.Fatalf("outbox holds %d item(s), first %+v; want the completion kept with 1 attempt", a.outbox.Len(), it)
+	}
+
+	// Once the API says the command is already complete, the completion is dropped
+	status = http.StatusConflict
+	if err := a.flushOutbox(context.Background()); err != nil {
+		t.Fatalf("flushOutbox() = %v", err)
+	}
+	if a.outbox.Len() != 0 {
+		t.Errorf("%d item(s) left in the outbox, want none", a.outbox.Len())
+	}
+}
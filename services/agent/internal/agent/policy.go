@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/config"
+)
+
+// PolicyFile is the applied server policy file name in the agent data directory
+const PolicyFile = "policy.json"
+
+// policyRange bounds a policy setting
+type policyRange struct {
+	name     string
+	min, max time.Duration
+}
+
+// Accepted ranges for server policy settings
+var (
+	heartbeatIntervalRange         = policyRange{"heartbeatIntervalSec", 10 * time.Second, time.Hour}
+	updateScanIntervalRange        = policyRange{"updateScanIntervalMin", time.Minute, 24 * time.Hour}
+	commandPollIntervalRange       = policyRange{"commandPollIntervalSec", 2 * time.Second, 5 * time.Minute}
+	commandPollSafetyIntervalRange = policyRange{"commandPollSafetyIntervalSec", 30 * time.Second, time.Hour}
+)
+
+// check returns an error if a set value is outside the range
+func (r policyRange) check(value int, unit time.Duration) error {
+	if value == 0 {
+		return nil
+	}
+	d := time.Duration(value) * unit
+	if value < 0 || d < r.min || d > r.max {
+		return fmt.Errorf("%s %d out of range %v-%v", r.name, value, r.min, r.max)
+	}
+	return nil
+}
+
+// policyKey identifies a policy version within its history
+type policyKey struct {
+	id      string
+	version int
+}
+
+func keyOf(p *api.AgentPolicy) policyKey {
+	return policyKey{p.ID, p.Version}
+}
+
+// policyState holds the applied server policy and the last rejected one
+type policyState struct {
+	mu       sync.Mutex
+	current  *api.AgentPolicy
+	rejected policyKey
+	err      string
+}
+
+// supersedes reports whether p replaces the current policy: a newer version of it,
+// or any version of a policy with another ID, which the server sends after starting over
+func (s *policyState) supersedes(p *api.AgentPolicy) bool {
+	if keyOf(p) == s.rejected {
+		return false
+	}
+	return s.current == nil || p.ID != s.current.ID || p.Version > s.current.Version
+}
+
+// validatePolicy checks a server policy before it is applied
+func validatePolicy(p *api.AgentPolicy) error {
+	if p.Version <= 0 {
+		return fmt.Errorf("invalid policy version %d", p.Version)
+	}
+	checks := []error{
+		heartbeatIntervalRange.check(p.HeartbeatIntervalSec, time.Second),
+		updateScanIntervalRange.check(p.UpdateScanIntervalMin, time.Minute),
+		commandPollIntervalRange.check(p.CommandPollIntervalSec, time.Second),
+		commandPollSafetyIntervalRange.check(p.CommandPollSafetyIntervalSec, time.Second),
+	}
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPolicy restores the last applied server policy so it holds across restarts
+func (a *Agent) loadPolicy() {
+	data, err := os.ReadFile(config.DataPath(PolicyFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.logger.Printf("Warning: failed to read agent policy: %v", err)
+		}
+		return
+	}
+
+	var p api.AgentPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		a.logger.Printf("Warning: ignoring unreadable agent policy: %v", err)
+		return
+	}
+	if err := validatePolicy(&p); err != nil {
+		a.logger.Printf("Warning: ignoring stored agent policy: %v", err)
+		return
+	}
+
+	a.policy.current = &p
+	a.logger.Printf("Agent policy version %d loaded", p.Version)
+}
+
+// applyPolicy applies a policy from a heartbeat response if it supersedes the current one.
+// Invalid policies are rejected and the error is reported in the next heartbeats.
+func (a *Agent) applyPolicy(p *api.AgentPolicy) {
+	if p == nil {
+		return
+	}
+
+	a.policy.mu.Lock()
+	if !a.policy.supersedes(p) {
+		a.policy.mu.Unlock()
+		return
+	}
+
+	if err := validatePolicy(p); err != nil {
+		a.policy.rejected = keyOf(p)
+		a.policy.err = fmt.Sprintf("policy version %d rejected: %v", p.Version, err)
+		a.policy.mu.Unlock()
+		a.logger.Printf("Warning: %s", a.policy.err)
+		return
+	}
+
+	applied := *p
+	previous := a.policy.current
+	a.policy.current = &applied
+	a.policy.rejected = policyKey{}
+	a.policy.err = ""
+	a.policy.mu.Unlock()
+
+	if previous != nil && previous.ID != applied.ID {
+		a.logger.Printf("Agent policy %q replaces %q", applied.ID, previous.ID)
+	}
+	a.logger.Printf("Agent policy version %d applied", applied.Version)
+	if err := savePolicy(&applied); err != nil {
+		a.logger.Printf("Warning: failed to save agent policy: %v", err)
+	}
+
+	select {
+	case a.policyChanged <- struct{}{}:
+	default:
+	}
+}
+
+// savePolicy persists the applied policy
+func savePolicy(p *api.AgentPolicy) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode agent policy: %w", err)
+	}
+
+	path := config.DataPath(PolicyFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create agent policy directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write agent policy: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace agent policy: %w", err)
+	}
+	return nil
+}
+
+// policyReport returns the applied policy ID and version and the latest rejection, for heartbeats
+func (a *Agent) policyReport() (string, int, string) {
+	a.policy.mu.Lock()
+	defer a.policy.mu.Unlock()
+
+	if a.policy.current == nil {
+		return "", 0, a.policy.err
+	}
+	return a.policy.current.ID, a.policy.current.Version, a.policy.err
+}
+
+// policyInterval returns a policy setting if set, otherwise fallback
+func (a *Agent) policyInterval(get func(p *api.AgentPolicy) int, unit, fallback time.Duration) time.Duration {
+	a.policy.mu.Lock()
+	defer a.policy.mu.Unlock()
+
+	if a.policy.current != nil {
+		if v := get(a.policy.current); v > 0 {
+			return time.Duration(v) * unit
+		}
+	}
+	return fallback
+}
+
+// heartbeatInterval returns how often heartbeats are sent
+func (a *Agent) heartbeatInterval() time.Duration {
+	return a.policyInterval(func(p *api.AgentPolicy) int { return p.HeartbeatIntervalSec },
+		time.Second, time.Duration(a.config.HeartbeatIntervalSec)*time.Second)
+}
+
+// updateScanInterval returns how often updates are scanned for
+func (a *Agent) updateScanInterval() time.Duration {
+	return a.policyInterval(func(p *api.AgentPolicy) int { return p.UpdateScanIntervalMin },
+		time.Minute, time.Duration(a.config.UpdateScanIntervalMin)*time.Minute)
+}
+
+// commandPollInterval returns how often commands are polled while the websocket is down
+func (a *Agent) commandPollInterval() time.Duration {
+	return a.policyInterval(func(p *api.AgentPolicy) int { return p.CommandPollIntervalSec },
+		time.Second, CommandPollInterval)
+}
+
+// commandPollSafetyInterval returns how often commands are polled while the websocket is up
+func (a *Agent) commandPollSafetyInterval() time.Duration {
+	return a.policyInterval(func(p *api.AgentPolicy) int { return p.CommandPollSafetyIntervalSec },
+		time.Second, CommandPollSafetyInterval)
+}
+
+// resetInterval moves a ticker to a new interval if it changed
+func (a *Agent) resetInterval(name string, ticker *time.Ticker, current *time.Duration, desired time.Duration) {
+	if desired == *current {
+		return
+	}
+	a.logger.Printf("%s interval changed: %v -> %v", name, *current, desired)
+	*current = desired
+	ticker.Reset(desired)
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		current *api.AgentPolicy
+		policy  *api.AgentPolicy
+		applied bool
+	}{
+		{"first policy", nil, &api.AgentPolicy{ID: "p1", Version: 1}, true},
+		{"newer version", &api.AgentPolicy{ID: "p1", Version: 3}, &api.AgentPolicy{ID: "p1", Version: 4}, true},
+		{"same version", &api.AgentPolicy{ID: "p1", Version: 3}, &api.AgentPolicy{ID: "p1", Version: 3}, false},
+		{"older version", &api.AgentPolicy{ID: "p1", Version: 3}, &api.AgentPolicy{ID: "p1", Version: 2}, false},
+		{"server reset", &api.AgentPolicy{ID: "p1", Version: 40}, &api.AgentPolicy{ID: "p2", Version: 1}, true},
+		{"first policy with an ID", &api.AgentPolicy{Version: 40}, &api.AgentPolicy{ID: "p1", Version: 1}, true},
+		{"older version without IDs", &api.AgentPolicy{Version: 3}, &api.AgentPolicy{Version: 2}, false},
+		{"invalid", nil, &api.AgentPolicy{ID: "p1", Version: 1, HeartbeatIntervalSec: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, "http://127.0.0.1:0")
+			a.policy.current = tt.current
+
+			a.applyPolicy(tt.policy)
+
+			applied := false
+			select {
+			case <-a.policyChanged:
+				applied = true
+			default:
+			}
+			if applied != tt.applied {
+				t.Fatalf("applied = %v, want %v", applied, tt.applied)
+			}
+
+			want := tt.current
+			if tt.applied {
+				want = tt.policy
+			}
+			id, version, _ := a.policyReport()
+			if want != nil && (id != want.ID || version != want.Version) {
+				t.Errorf("policyReport() = %q version %d, want %q version %d", id, version, want.ID, want.Version)
+			}
+		})
+	}
+}
+
+func TestApplyPolicyAfterServerReset(t *testing.T) {
+	a := newTestAgent(t, "http://127.0.0.1:0")
+	a.applyPolicy(&api.AgentPolicy{ID: "p1", Version: 12, HeartbeatIntervalSec: 60})
+
+	// The server lost its database and starts numbering from 1 under a new ID
+	a.applyPolicy(&api.AgentPolicy{ID: "p2", Version: 1, HeartbeatIntervalSec: 15})
+	if got := a.heartbeatInterval(); got != 15*time.Second {
+		t.Fatalf("heartbeatInterval() = %v, want 15s from the reset policy", got)
+	}
+
+	// Versions of the new policy compare among themselves again
+	a.applyPolicy(&api.AgentPolicy{ID: "p2", Version: 2, HeartbeatIntervalSec: 20})
+	a.applyPolicy(&api.AgentPolicy{ID: "p2", Version: 1, HeartbeatIntervalSec: 15})
+	if got := a.heartbeatInterval(); got != 20*time.Second {
+		t.Fatalf("heartbeatInterval() = %v, want 20s from version 2", got)
+	}
+
+	// The applied policy survives a restart
+	restarted := &Agent{logger: a.logger}
+	restarted.loadPolicy()
+	if id, version, _ := restarted.policyReport(); id != "p2" || version != 2 {
+		t.Errorf("loaded policy %q version %d, want p2 version 2", id, version)
+	}
+}
+
+func TestRejectedPolicyIsRetriedUnderNewID(t *testing.T) {
+	a := newTestAgent(t, "http://127.0.0.1:0")
+
+	a.applyPolicy(&api.AgentPolicy{ID: "p1", Version: 1, HeartbeatIntervalSec: 1})
+	if _, _, reason := a.policyReport(); reason == "" {
+		t.Fatal("invalid policy not reported")
+	}
+
+	a.applyPolicy(&api.AgentPolicy{ID: "p2", Version: 1, HeartbeatIntervalSec: 30})
+	id, version, reason := a.policyReport()
+	if id != "p2" || version != 1 || reason != "" {
+		t.Errorf("policyReport() = %q, %d, %q; want p2 version 1 and no error", id, version, reason)
+	}
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/credstore"
+	"github.com/lunaris/agent/internal/proxy"
+)
+
+const (
+	// ProxyPasswordFile holds the proxy password in the agent data directory
+	ProxyPasswordFile = "proxy-password.dat"
+
+	// PACCacheFile keeps the last fetched PAC script in the agent cache directory
+	PACCacheFile = "proxy.pac"
+)
+
+// setupProxy routes API and websocket connections through the configured proxy
+func (a *Agent) setupProxy() {
+	cfg := a.config.Proxy
+	password, err := a.proxyPassword()
+	if err != nil {
+		a.setupErr = fmt.Errorf("proxy configuration: %w", err)
+		return
+	}
+
+	resolver, err := proxy.New(proxy.Options{
+		URL:            cfg.URL,
+		UseEnvironment: !cfg.IgnoreEnvironment,
+		PAC:            cfg.PAC,
+		PACCache:       config.CachePath(PACCacheFile),
+		Username:       cfg.Username,
+		Password:       password,
+	}, a.logger)
+	if err != nil {
+		a.setupErr = fmt.Errorf("proxy configuration: %w", err)
+		return
+	}
+
+	a.proxy = resolver
+	a.client.SetProxy(resolver.Proxy)
+	if cfg.URL != "" || cfg.PAC != "" {
+		a.logger.Printf("Using proxy: %s", resolver)
+	}
+}
+
+// proxyPassword returns the proxy password. A password found in the config file is
+// moved to a protected state file, so config.json never keeps it in plaintext.
+func (a *Agent) proxyPassword() (string, error) {
+	secret := credstore.NewSecret(config.DataPath(ProxyPasswordFile))
+	password := a.config.Proxy.Password
+	if password == "" {
+		return secret.Load()
+	}
+
+	if err := secret.Save(password); err != nil {
+		a.logger.Printf("Warning: proxy password stays in the config file: %v", err)
+		return password, nil
+	}
+	a.config.Proxy.Password = ""
+	if err := a.config.Save(); err != nil {
+		a.logger.Printf("Warning: failed to remove the proxy password from the config file: %v", err)
+	} else {
+		a.logger.Println("Moved the proxy password from the config file to the agent state directory")
+	}
+	return password, nil
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lunaris/agent/internal/config"
+)
+
+func TestProxyPasswordMovesOutOfConfig(t *testing.T) {
+	a := newTestAgent(t, "http://127.0.0.1:0")
+	a.config.Proxy.Username = "agent"
+	a.config.Proxy.Password = "s3cret"
+
+	password, err := a.proxyPassword()
+	if err != nil || password != "s3cret" {
+		t.Fatalf("proxyPassword() = %q, %v", password, err)
+	}
+
+	data, err := os.ReadFile(config.ConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("config file still holds the proxy password:\n%s", data)
+	}
+
+	// Later starts read it from the state directory
+	if password, err := a.proxyPassword(); err != nil || password != "s3cret" {
+		t.Errorf("proxyPassword() on the next start = %q, %v", password, err)
+	}
+	info, err := os.Stat(config.DataPath(ProxyPasswordFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 && os.PathSeparator == '/' {
+		t.Errorf("proxy password file mode = %v, want 0600", perm)
+	}
+}
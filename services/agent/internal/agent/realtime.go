@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/websocket"
+)
+
+const (
+	// CommandPollInterval is used while commands can only arrive by polling,
+	// unless the server policy sets another interval
+	CommandPollInterval = 10 * time.Second
+
+	// CommandPollSafetyInterval is used while the websocket is delivering commands,
+	// to catch anything sent while the socket was briefly down; server policy may override it
+	CommandPollSafetyInterval = 5 * time.Minute
+
+	// commandQueueSize bounds commands waiting for the worker
+	commandQueueSize = 64
+)
+
+// websocketURL derives the realtime server URL from the API URL
+func websocketURL(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return apiURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// startRealtime starts the websocket supervisor so commands arrive by push.
+// Connection failures aren't fatal: polling covers them while the supervisor retries.
+func (a *Agent) startRealtime(ctx context.Context) {
+	a.ws = websocket.NewClient(websocketURL(a.client.BaseURL()), a.deviceID(), a.logger)
+	a.ws.SetInstallHandler(func(cmd *websocket.InstallCommand) error {
+		a.handlePushedInstall(ctx, cmd)
+		return nil
+	})
+	a.ws.SetStateHandler(a.onRealtimeStateChange)
+	a.ws.SetTokenSource(a.client.AccessToken)
+	if a.tlsConfig != nil {
+		a.ws.SetTLSConfig(a.tlsConfig)
+	}
+	if a.proxy != nil {
+		a.ws.SetProxy(a.proxy.Proxy)
+	}
+	a.ws.Start(ctx)
+}
+
+// stopRealtime closes the websocket, giving it a few seconds to shut down
+func (a *Agent) stopRealtime() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.ws.Close(ctx); err != nil {
+		a.logger.Printf("Warning: websocket did not close cleanly: %v", err)
+	}
+}
+
+// onRealtimeStateChange logs websocket state changes and wakes the main loop to adjust polling
+func (a *Agent) onRealtimeStateChange(change websocket.StateChange) {
+	switch {
+	case change.To == websocket.StateBackingOff:
+		a.logger.Printf("WebSocket %s -> %s (attempt %d, retry in %v): %v",
+			change.From, change.To, change.Attempt, change.RetryIn.Round(time.Millisecond), change.Err)
+	case change.Err != nil:
+		a.logger.Printf("WebSocket %s -> %s: %v", change.From, change.To, change.Err)
+	default:
+		a.logger.Printf("WebSocket %s -> %s", change.From, change.To)
+	}
+
+	select {
+	case a.realtimeChanged <- struct{}{}:
+	default:
+	}
+}
+
+// realtimeState returns the websocket state reported in heartbeats
+func (a *Agent) realtimeState() string {
+	if a.ws == nil {
+		return ""
+	}
+	return a.ws.State().String()
+}
+
+// desiredPollInterval returns how often to poll given the websocket state
+func (a *Agent) desiredPollInterval() time.Duration {
+	if a.ws != nil && a.ws.IsConnected() {
+		return a.commandPollSafetyInterval()
+	}
+	return a.commandPollInterval()
+}
+
+// adjustPollInterval slows polling while the websocket is healthy and speeds it up when it drops
+func (a *Agent) adjustPollInterval(ctx context.Context, ticker *time.Ticker, current *time.Duration) {
+	desired := a.desiredPollInterval()
+	if desired == *current {
+		return
+	}
+
+	a.logger.Printf("Command polling interval changed: %v -> %v", *current, desired)
+	*current = desired
+	ticker.Reset(desired)
+
+	// Catch anything sent while the socket was going down
+	if desired == a.commandPollInterval() {
+		a.pollAndExecuteCommands(ctx)
+	}
+}
+
+// handlePushedInstall queues an install_updates command pushed over the websocket.
+// Unless the agent can verify it, the push only triggers a poll: with request signing
+// on, polled commands come in verified API responses while pushed ones don't.
+func (a *Agent) handlePushedInstall(ctx context.Context, cmd *websocket.InstallCommand) {
+	if !a.trustsPushedCommands() {
+		a.logger.Printf("Command %s pushed over the websocket can't be verified; polling for it instead", cmd.CommandID)
+		a.requestPoll()
+		return
+	}
+
+	a.enqueueCommand(ctx, api.Command{
+		ID:                 cmd.CommandID,
+		Type:               CommandInstallUpdates,
+		PackageIdentifiers: cmd.PackageIdentifiers,
+		Signature:          cmd.Signature,
+		KeyID:              cmd.KeyID,
+	})
+}
+
+// requestPoll asks the main loop to poll for commands now
+func (a *Agent) requestPoll() {
+	select {
+	case a.pollRequested <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueCommand hands a polled or pushed command to the worker.
+// Commands already waiting in the queue are not queued again.
+func (a *Agent) enqueueCommand(ctx context.Context, cmd api.Command) {
+	a.queuedMu.Lock()
+	if a.queued[cmd.ID] {
+		a.queuedMu.Unlock()
+		return
+	}
+	a.queued[cmd.ID] = true
+	a.queuedMu.Unlock()
+
+	select {
+	case a.commandQueue <- cmd:
+	case <-ctx.Done():
+	}
+}
+
+// runCommandWorker executes queued commands one at a time
+func (a *Agent) runCommandWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-a.commandQueue:
+			a.executeCommand(ctx, cmd)
+
+			a.queuedMu.Lock()
+			delete(a.queued, cmd.ID)
+			a.queuedMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/websocket/wstest"
+)
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRealtimeDeliversCommandsAndSlowsPolling(t *testing.T) {
+	server := newFakeAPI(t)
+	socket := wstest.NewServer()
+	server.Socket = socket
+
+	a := newTestAgent(t, server.URL+"/api")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a.startRealtime(ctx)
+	defer a.stopRealtime()
+
+	select {
+	case deviceID := <-socket.Joined():
+		if deviceID != "device-1" {
+			t.Fatalf("joined room %q, want device-1", deviceID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent never joined its device room")
+	}
+	waitFor(t, "connected state", a.ws.IsConnected)
+
+	if got := a.desiredPollInterval(); got != CommandPollSafetyInterval {
+		t.Errorf("poll interval while connected = %v, want %v", got, CommandPollSafetyInterval)
+	}
+
+	socket.Emit("install_updates", map[string]interface{}{
+		"type": "install_updates",
+		"payload": map[string]interface{}{
+			"commandId":          "cmd-push",
+			"deviceId":           "device-1",
+			"packageIdentifiers": []string{"Git.Git"},
+		},
+	})
+	// Commands for another device are ignored
+	socket.Emit("install_updates", map[string]interface{}{
+		"type":    "install_updates",
+		"payload": map[string]interface{}{"commandId": "cmd-other", "deviceId": "device-2", "packageIdentifiers": []string{"x"}},
+	})
+
+	select {
+	case cmd := <-a.commandQueue:
+		if cmd.ID != "cmd-push" || cmd.Type != CommandInstallUpdates || len(cmd.PackageIdentifiers) != 1 {
+			t.Errorf("queued command = %+v", cmd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushed command was not queued")
+	}
+	select {
+	case cmd := <-a.commandQueue:
+		t.Errorf("queued command for another device: %+v", cmd)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Polling speeds up again once the socket drops
+	socket.CloseAll()
+	waitFor(t, "disconnect", func() bool { return !a.ws.IsConnected() })
+	if got := a.desiredPollInterval(); got != CommandPollInterval {
+		t.Errorf("poll interval while disconnected = %v, want %v", got, CommandPollInterval)
+	}
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/lunaris/agent/internal/config"
+	"github.com/lunaris/agent/internal/signing"
+)
+
+// SigningKeyFile is the device request signing key in the agent data directory
+const SigningKeyFile = "signing.key"
+
+// clockSkewWarning is the server clock offset worth logging
+const clockSkewWarning = 30 * time.Second
+
+// setupSigning enables request signing and response verification from config.Config.Signing
+func (a *Agent) setupSigning() {
+	cfg := a.config.Signing
+	if cfg.Algorithm == "" {
+		return
+	}
+
+	var signer signing.Signer
+	var verifier signing.Verifier
+
+	switch cfg.Algorithm {
+	case signing.AlgorithmHMAC:
+		key, err := base64.StdEncoding.DecodeString(cfg.Secret)
+		if err != nil || len(key) < 16 {
+			a.setupErr = fmt.Errorf("signing: secret must be at least 16 base64-encoded bytes")
+			return
+		}
+		h := signing.NewHMAC(key)
+		signer, verifier = h, h
+
+	case signing.AlgorithmEd25519:
+		key, err := signing.LoadOrCreateEd25519Key(config.DataPath(SigningKeyFile))
+		if err != nil {
+			a.setupErr = fmt.Errorf("signing: %w", err)
+			return
+		}
+		a.signer = signing.NewEd25519Signer(key)
+		signer = a.signer
+
+		if cfg.ServerPublicKey != "" {
+			v, err := signing.NewEd25519Verifier(cfg.ServerPublicKey)
+			if err != nil {
+				a.setupErr = fmt.Errorf("signing: server_public_key: %w", err)
+				return
+			}
+			verifier = v
+		}
+
+	default:
+		a.setupErr = fmt.Errorf("signing: unsupported algorithm %q", cfg.Algorithm)
+		return
+	}
+
+	a.clock = &signing.Clock{}
+	maxSkew := time.Duration(cfg.MaxClockSkewSec) * time.Second
+	a.client.SetRequestAuth(signing.NewAuth(signer, verifier, a.clock, maxSkew))
+
+	if verifier == nil {
+		a.logger.Println("Warning: no server public key configured; API responses are not verified")
+	}
+}
+
+// signingPublicKey returns the Ed25519 public key sent at registration, if any
+func (a *Agent) signingPublicKey() string {
+	if a.signer == nil {
+		return ""
+	}
+	return a.signer.PublicKey()
+}
+
+// observeServerTime updates the server clock offset from a heartbeat response
+func (a *Agent) observeServerTime(serverTime string, sentAt time.Time) {
+	if a.clock == nil || serverTime == "" {
+		return
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, serverTime)
+	if err != nil {
+		a.logger.Printf("Warning: unparseable server time %q: %v", serverTime, err)
+		return
+	}
+
+	a.clock.Observe(t, sentAt, time.Now())
+	if offset := a.clock.Offset(); offset > clockSkewWarning || offset < -clockSkewWarning {
+		a.logger.Printf("Warning: local clock differs from the server by %v; signatures use server time", offset.Round(time.Second))
+	}
+}
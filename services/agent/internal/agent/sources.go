@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/apt"
+	"github.com/lunaris/agent/internal/commands"
+	"github.com/lunaris/agent/internal/dnf"
+	"github.com/lunaris/agent/internal/flatpak"
+	"github.com/lunaris/agent/internal/snap"
+	"github.com/lunaris/agent/internal/updates"
+	"github.com/lunaris/agent/internal/winget"
+)
+
+// defaultSources returns every update source the agent knows about.
+// Sources that aren't usable on this system are skipped at scan time.
+func defaultSources() []updates.UpdateSource {
+	return []updates.UpdateSource{
+		winget.NewSource(),
+		apt.NewSource(),
+		dnf.NewSource(),
+		flatpak.NewSource(),
+		snap.NewSource(),
+	}
+}
+
+// availableSources returns the sources that report themselves available
+func (a *Agent) availableSources() []updates.UpdateSource {
+	available := make([]updates.UpdateSource, 0, len(a.sources))
+	for _, src := range a.sources {
+		if err := src.CheckAvailable(); err != nil {
+			continue
+		}
+		available = append(available, src)
+	}
+	return available
+}
+
+// scanAllSources scans every available source and merges the results.
+// A failing source is logged and reported with what its last successful scan found,
+// so a transient failure doesn't drop its packages; an error is only returned if no
+// source could be scanned.
+func (a *Agent) scanAllSources() (*updates.ScanResult, error) {
+	sources := a.availableSources()
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no update sources available")
+	}
+
+	a.packageSourcesMu.Lock()
+	previous := a.sourceItems
+	a.packageSourcesMu.Unlock()
+
+	merged := &updates.ScanResult{Items: []api.UpdateItem{}}
+	var failures []string
+	sourceItems := make(map[string][]api.UpdateItem)
+
+	for _, src := range sources {
+		result, err := src.ScanUpdates()
+		if err != nil {
+			a.logger.Printf("Update scan failed for %s: %v", src.Name(), err)
+			failures = append(failures, fmt.Sprintf("%s: %v", src.Name(), err))
+			if kept, ok := previous[src.Name()]; ok {
+				sourceItems[src.Name()] = kept
+			}
+			continue
+		}
+
+		items := make([]api.UpdateItem, 0, len(result.Items))
+		for _, item := range result.Items {
+			item.Source = src.Name()
+			items = append(items, item)
+		}
+		sourceItems[src.Name()] = items
+
+		for _, line := range result.Unparsed {
+			a.logger.Printf("Warning: %s output line %d not parsed (%s): %q", src.Name(), line.LineNumber, line.Reason, line.Text)
+		}
+		merged.Unparsed = append(merged.Unparsed, result.Unparsed...)
+	}
+
+	if len(failures) == len(sources) {
+		return nil, fmt.Errorf("all update sources failed: %s", strings.Join(failures, "; "))
+	}
+
+	packageSources := make(map[packageKey]bool)
+	for _, src := range sources {
+		for _, item := range sourceItems[src.Name()] {
+			packageSources[packageKey{source: src.Name(), identifier: item.PackageIdentifier}] = true
+			merged.Items = append(merged.Items, item)
+		}
+	}
+
+	a.packageSourcesMu.Lock()
+	a.packageSources = packageSources
+	a.sourceItems = sourceItems
+	a.packageSourcesMu.Unlock()
+
+	return merged, nil
+}
+
+// packageKey identifies a package reported by a scan; sources may report the same identifier
+type packageKey struct {
+	source     string
+	identifier string
+}
+
+// sourceFor returns the source that should install the given package.
+// Only packages the last scan reported can be installed. A package naming its source
+// goes to that source if it reported the package. Otherwise it goes to the source that
+// reported it, and is rejected if several sources did.
+func (a *Agent) sourceFor(pkg commands.PackageRef, available []updates.UpdateSource) (updates.UpdateSource, error) {
+	if err := checkPackageIdentifier(pkg.PackageIdentifier); err != nil {
+		return nil, err
+	}
+
+	a.packageSourcesMu.Lock()
+	defer a.packageSourcesMu.Unlock()
+
+	if pkg.Source != "" {
+		for _, src := range available {
+			if src.Name() != pkg.Source {
+				continue
+			}
+			if !a.packageSources[packageKey{source: src.Name(), identifier: pkg.PackageIdentifier}] {
+				return nil, fmt.Errorf("%s was not reported by %s in the last scan", pkg.PackageIdentifier, pkg.Source)
+			}
+			return src, nil
+		}
+		return nil, fmt.Errorf("update source %s is not available", pkg.Source)
+	}
+
+	var matches []updates.UpdateSource
+	for _, src := range available {
+		if a.packageSources[packageKey{source: src.Name(), identifier: pkg.PackageIdentifier}] {
+			matches = append(matches, src)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%s was not reported by any update source in the last scan", pkg.PackageIdentifier)
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, src := range matches {
+			names[i] = src.Name()
+		}
+		return nil, fmt.Errorf("%s is reported by several update sources (%s); the command must name one", pkg.PackageIdentifier, strings.Join(names, ", "))
+	}
+}
+
+// checkPackageIdentifier rejects identifiers a package manager could read as an option
+// or as several arguments
+func checkPackageIdentifier(id string) error {
+	if id == "" {
+		return fmt.Errorf("empty package identifier")
+	}
+	if strings.HasPrefix(id, "-") || strings.IndexFunc(id, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid package identifier %q", id)
+	}
+	return nil
+}
+
+// installPackages installs each package through its update source,
+// reporting progress to the command context before each package
+func (a *Agent) installPackages(ctx context.Context, packages []commands.PackageRef) []*updates.InstallResult {
+	// Packages are matched to the sources that reported them, so scan once if nothing has been scanned yet
+	a.packageSourcesMu.Lock()
+	scanned := a.packageSources != nil
+	a.packageSourcesMu.Unlock()
+	if !scanned {
+		if _, err := a.scanAllSources(); err != nil {
+			a.logger.Printf("Update scan before install failed: %v", err)
+		}
+	}
+
+	available := a.availableSources()
+	results := make([]*updates.InstallResult, 0, len(packages))
+	total := len(packages)
+
+	for i, pkg := range packages {
+		pkgID := pkg.PackageIdentifier
+		commands.ReportStep(ctx, i, total, fmt.Sprintf("Installing %s (%d/%d)", pkgID, i+1, total), pkgID)
+
+		src, err := a.sourceFor(pkg, available)
+		if err != nil {
+			results = append(results, &updates.InstallResult{
+				PackageIdentifier: pkgID,
+				Message:           "No update source for package",
+				Error:             err,
+			})
+			continue
+		}
+
+		results = append(results, src.Install(pkgID))
+	}
+
+	commands.ReportStep(ctx, total, total, "Installation finished", "")
+	return results
+}
+
+// logUpdates logs each update in a scan result
+func logUpdates(logger Logger, items []api.UpdateItem) {
+	for i, u := range items {
+		installed := "unknown"
+		if u.InstalledVersion != nil {
+			installed = *u.InstalledVersion
+		}
+		advisory := ""
+		if u.AdvisoryID != "" {
+			advisory = fmt.Sprintf(" {%s %s %s}", u.AdvisoryID, u.AdvisoryType, u.Severity)
+		}
+		logger.Printf("  Update %d: %s (%s) [%s] - %s -> %s%s", i+1, u.PackageName, u.PackageIdentifier, u.Source, installed, u.AvailableVersion, advisory)
+	}
+}
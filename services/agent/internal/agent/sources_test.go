@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/commands"
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// fakeSource is an update source that only has a name
+type fakeSource struct {
+	updates.UpdateSource
+	name string
+}
+
+func (s fakeSource) Name() string { return s.name }
+
+func TestSourceFor(t *testing.T) {
+	apt, snap := fakeSource{name: "apt"}, fakeSource{name: "snap"}
+	a := &Agent{packageSources: map[packageKey]bool{
+		{source: "apt", identifier: "firefox"}:  true,
+		{source: "snap", identifier: "firefox"}: true,
+		{source: "snap", identifier: "code"}:    true,
+	}}
+	available := []updates.UpdateSource{apt, snap}
+
+	tests := []struct {
+		name    string
+		pkg     commands.PackageRef
+		want    string
+		wantErr string
+	}{
+		{"single source", commands.PackageRef{PackageIdentifier: "code"}, "snap", ""},
+		{"explicit source", commands.PackageRef{PackageIdentifier: "firefox", Source: "apt"}, "apt", ""},
+		{"ambiguous", commands.PackageRef{PackageIdentifier: "firefox"}, "", "several update sources"},
+		{"unknown", commands.PackageRef{PackageIdentifier: "vim"}, "", "not reported"},
+		{"unavailable source", commands.PackageRef{PackageIdentifier: "vim", Source: "dnf"}, "", "not available"},
+		{"explicit source didn't report it", commands.PackageRef{PackageIdentifier: "code", Source: "apt"}, "", "not reported by apt"},
+		{"explicit source, unknown package", commands.PackageRef{PackageIdentifier: "vim", Source: "snap"}, "", "not reported by snap"},
+		{"option", commands.PackageRef{PackageIdentifier: "-oDpkg::Pre-Invoke::=id", Source: "apt"}, "", "invalid package identifier"},
+		{"whitespace", commands.PackageRef{PackageIdentifier: "code --classic"}, "", "invalid package identifier"},
+		{"empty", commands.PackageRef{}, "", "empty package identifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := a.sourceFor(tt.pkg, available)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("sourceFor() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sourceFor() error = %v", err)
+			}
+			if src.Name() != tt.want {
+				t.Errorf("sourceFor() = %s, want %s", src.Name(), tt.want)
+			}
+		})
+	}
+}
+
+// scanSource is an available update source returning a settable scan result
+type scanSource struct {
+	fakeSource
+	items []api.UpdateItem
+	err   error
+}
+
+func (s *scanSource) CheckAvailable() error { return nil }
+
+func (s *scanSource) ScanUpdates() (*updates.ScanResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &updates.ScanResult{Items: s.items}, nil
+}
+
+// scanned returns the source and identifier of every item in result, sorted
+func scanned(result *updates.ScanResult) []string {
+	var got []string
+	for _, item := range result.Items {
+		got = append(got, item.Source+"/"+item.PackageIdentifier)
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestScanAllSourcesKeepsEntriesOfFailedSources(t *testing.T) {
+	apt := &scanSource{fakeSource: fakeSource{name: "apt"}, items: []api.UpdateItem{{PackageIdentifier: "firefox"}}}
+	snap := &scanSource{fakeSource: fakeSource{name: "snap"}, items: []api.UpdateItem{{PackageIdentifier: "code"}}}
+	flatpak := &scanSource{fakeSource: fakeSource{name: "flatpak"}, err: errors.New("flatpak not responding")}
+	a := newTestAgent(t, "http://127.0.0.1:0")
+	a.sources = []updates.UpdateSource{apt, snap, flatpak}
+
+	result, err := a.scanAllSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(scanned(result), " "); got != "apt/firefox snap/code" {
+		t.Fatalf("first scan = %s, want apt/firefox snap/code", got)
+	}
+
+	// snap fails this round; its packages stay in the report and stay installable
+	apt.items = append(apt.items, api.UpdateItem{PackageIdentifier: "vim"})
+	snap.err = errors.New("snapd restarting")
+	result, err = a.scanAllSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(scanned(result), " "); got != "apt/firefox apt/vim snap/code" {
+		t.Errorf("scan with snap failing = %s, want apt/firefox apt/vim snap/code", got)
+	}
+	if src, err := a.sourceFor(commands.PackageRef{PackageIdentifier: "code"}, a.sources); err != nil || src.Name() != "snap" {
+		t.Errorf("sourceFor(code) = %v, %v; want snap from the previous scan", src, err)
+	}
+
+	// Once snap scans again, its new result replaces the kept one
+	snap.err, snap.items = nil, nil
+	result, err = a.scanAllSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(scanned(result), " "); got != "apt/firefox apt/vim" {
+		t.Errorf("scan after snap recovered = %s, want apt/firefox apt/vim", got)
+	}
+
+	// When every source fails nothing is reported and nothing is forgotten
+	apt.err, snap.err = errors.New("down"), errors.New("down")
+	if _, err := a.scanAllSources(); err == nil {
+		t.Fatal("scanAllSources() = nil error with every source failing")
+	}
+	if _, err := a.sourceFor(commands.PackageRef{PackageIdentifier: "vim"}, a.sources); err != nil {
+		t.Errorf("sourceFor(vim) after a failed round = %v", err)
+	}
+}
+
+// blockingSource is an available update source whose scans wait for release
+type blockingSource struct {
+	fakeSource
+	started chan struct{}
+	release chan struct{}
+
+	mu      sync.Mutex
+	running int
+	overlap bool
+}
+
+func (s *blockingSource) CheckAvailable() error { return nil }
+
+func (s *blockingSource) ScanUpdates() (*updates.ScanResult, error) {
+	s.mu.Lock()
+	s.running++
+	s.overlap = s.overlap || s.running > 1
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+	}()
+
+	s.started <- struct{}{}
+	<-s.release
+	return &updates.ScanResult{Items: []api.UpdateItem{{PackageIdentifier: "firefox"}}}, nil
+}
+
+func TestRequestScanRunsOneScanAtATime(t *testing.T) {
+	server := newFakeAPI(t)
+	a := newTestAgent(t, server.URL)
+	src := &blockingSource{fakeSource: fakeSource{name: "apt"}, started: make(chan struct{}), release: make(chan struct{})}
+	a.sources = []updates.UpdateSource{src}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// requestScan returns while the scan is still running
+	a.requestScan(ctx)
+	select {
+	case <-src.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scan didn't start")
+	}
+
+	// Requests during the scan collapse into one rescan
+	a.requestScan(ctx)
+	a.requestScan(ctx)
+	select {
+	case <-src.started:
+		t.Fatal("second scan started while the first was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	src.release <- struct{}{}
+	select {
+	case <-src.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("requested rescan didn't run")
+	}
+	src.release <- struct{}{}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		a.scanMu.Lock()
+		scanning := a.scanning
+		a.scanMu.Unlock()
+		if !scanning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("scan still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-src.started:
+		t.Error("third scan ran for requests made during the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if src.overlap {
+		t.Error("scans overlapped")
+	}
+	reports := 0
+	for _, call := range server.Calls() {
+		if call.Path == "/agent/update-report" {
+			reports++
+		}
+	}
+	if reports != 2 {
+		t.Errorf("%d update reports, want 2", reports)
+	}
+}
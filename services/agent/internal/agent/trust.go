@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/cmdsig"
+	"github.com/lunaris/agent/internal/commands"
+	"github.com/lunaris/agent/internal/config"
+)
+
+// ConsoleKeysFile stores console keys added or retired by rollovers
+const ConsoleKeysFile = "console-keys.json"
+
+// CommandRotateConsoleKey rotates the console key commands are signed with
+const CommandRotateConsoleKey = "rotate_console_key"
+
+// setupCommandSigning loads the pinned console keys commands are verified against
+func (a *Agent) setupCommandSigning() {
+	keyring, err := cmdsig.Open(config.DataPath(ConsoleKeysFile), a.config.ConsoleKeys)
+	if err != nil {
+		a.setupErr = fmt.Errorf("command signing: %w", err)
+		return
+	}
+	a.keyring = keyring
+	if a.clock != nil {
+		// Expiry is set by the console, so check it in server time
+		keyring.SetClock(a.clock.Now)
+	}
+
+	if !keyring.Enabled() {
+		a.logger.Println("Warning: no console keys configured; commands are executed without signature checks")
+		return
+	}
+
+	handler := commands.NewTypedHandler(CommandRotateConsoleKey, commands.DecodeJSON[cmdsig.KeyRollover], a.handleRotateConsoleKey)
+	if err := a.commands.Register(handler); err != nil {
+		a.logger.Printf("Warning: %v", err)
+	}
+}
+
+// verifyCommand checks a command's console signature. Commands pass unchecked
+// when no console keys are configured.
+func (a *Agent) verifyCommand(cmd *api.Command) error {
+	if a.keyring == nil || !a.keyring.Enabled() {
+		return nil
+	}
+	return a.keyring.VerifyCommand(a.deviceID(), cmd)
+}
+
+// trustsPushedCommands reports whether commands pushed over the websocket may be
+// executed. Console signatures cover them when console keys are configured; otherwise
+// they are only acceptable while API traffic isn't signed either.
+func (a *Agent) trustsPushedCommands() bool {
+	if a.keyring != nil && a.keyring.Enabled() {
+		return true
+	}
+	return a.config.Signing.Algorithm == ""
+}
+
+// rejectCommand refuses a command whose signature didn't verify and reports it.
+// The command isn't journaled; a completion is only reported for IDs the agent hasn't
+// run, so a forged copy of an executed command can't overwrite its result.
+func (a *Agent) rejectCommand(ctx context.Context, cmd api.Command, reason error) {
+	a.logger.Printf("SECURITY: refusing command %s (type: %s): %v", cmd.ID, cmd.Type, reason)
+
+	a.reportSecurityEvent(ctx, &api.SecurityEvent{
+		Type:      api.SecurityEventCommandRejected,
+		CommandID: cmd.ID,
+		KeyID:     cmd.KeyID,
+		Reason:    reason.Error(),
+	})
+	if a.journal.Seen(cmd.ID) {
+		return
+	}
+
+	a.reportCommandResult(ctx, commandResult{
+		CommandID: cmd.ID,
+		Result:    "command refused: " + reason.Error(),
+		Failure:   &api.CommandFailure{Code: api.FailureSignatureInvalid, Message: reason.Error()},
+	})
+}
+
+// reportSecurityEvent sends a security event, queueing it if the API can't be reached
+func (a *Agent) reportSecurityEvent(ctx context.Context, event *api.SecurityEvent) {
+	event.DeviceID = a.deviceID()
+	event.OccurredAt = time.Now().UTC()
+
+	if a.outbox.Len() == 0 {
+		err := a.client.ReportSecurityEvent(ctx, event)
+		if err == nil {
+			return
+		}
+		a.logger.Printf("Failed to report security event: %v", err)
+		if !(api.IsTemporary(err) || waitsForReenrollment(outboxSecurityEvent, err)) {
+			return
+		}
+	}
+
+	a.spool(outboxSecurityEvent, event, false)
+}
+
+// handleRotateConsoleKey trusts a new console key introduced by a signed rollover
+func (a *Agent) handleRotateConsoleKey(ctx context.Context, cmd api.Command, rollover cmdsig.KeyRollover) commands.Result {
+	if err := a.keyring.ApplyRollover(&rollover); err != nil {
+		a.logger.Printf("SECURITY: console key rollover rejected: %v", err)
+		a.reportSecurityEvent(ctx, &api.SecurityEvent{
+			Type:      api.SecurityEventCommandRejected,
+			CommandID: cmd.ID,
+			KeyID:     rollover.SignedBy,
+			Reason:    "key rollover rejected: " + err.Error(),
+		})
+		return commands.Failed(api.FailureSignatureInvalid, fmt.Sprintf("Key rollover rejected: %v", err))
+	}
+
+	message := fmt.Sprintf("Console key %s trusted", rollover.KeyID)
+	if rollover.RetireKeyID != "" {
+		message += fmt.Sprintf(", key %s retired", rollover.RetireKeyID)
+	}
+	a.logger.Println(message)
+
+	a.reportSecurityEvent(ctx, &api.SecurityEvent{
+		Type:      api.SecurityEventKeyRotated,
+		CommandID: cmd.ID,
+		KeyID:     rollover.KeyID,
+		Reason:    message,
+	})
+	return commands.Result{Success: true, Message: message}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/cmdsig"
+	"github.com/lunaris/agent/internal/commands"
+	"github.com/lunaris/agent/internal/websocket"
+)
+
+// trustConsoleKey pins a new console key in the agent's keyring and returns its private key
+func trustConsoleKey(t *testing.T, a *Agent) ed25519.PrivateKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := cmdsig.Open("", map[string]string{"console-1": base64.StdEncoding.EncodeToString(pub)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.keyring = keyring
+	return priv
+}
+
+// signCommand signs cmd for the agent's device the way the console does
+func signCommand(t *testing.T, a *Agent, priv ed25519.PrivateKey, cmd *api.Command) {
+	t.Helper()
+	if cmd.CreatedAt == "" {
+		cmd.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	message, err := cmdsig.CommandMessage(a.deviceID(), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.KeyID = "console-1"
+	cmd.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, message))
+}
+
+func TestPushedCommandsNeedVerificationWhileSigning(t *testing.T) {
+	push := &websocket.InstallCommand{CommandID: "cmd-push", PackageIdentifiers: []string{"Git.Git"}}
+
+	tests := []struct {
+		name        string
+		algorithm   string
+		consoleKeys bool
+		queued      bool
+	}{
+		{"signing off", "", false, true},
+		{"signing on", "hmac-sha256", false, false},
+		{"signing on with console keys", "hmac-sha256", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, "http://127.0.0.1:0")
+			a.config.Signing.Algorithm = tt.algorithm
+			if tt.consoleKeys {
+				trustConsoleKey(t, a)
+			}
+
+			a.handlePushedInstall(context.Background(), push)
+
+			queued := len(a.commandQueue) == 1
+			polled := len(a.pollRequested) == 1
+			if queued != tt.queued || polled == tt.queued {
+				t.Errorf("queued = %v, poll requested = %v; want queued = %v", queued, polled, tt.queued)
+			}
+		})
+	}
+}
+
+func TestForgedCommandDoesNotBlockRealOne(t *testing.T) {
+	server := newFakeAPI(t)
+	a := newTestAgent(t, server.URL)
+	priv := trustConsoleKey(t, a)
+
+	runs := 0
+	handler := commands.NewTypedHandler("test_run", commands.DecodeNoPayload,
+		func(ctx context.Context, cmd api.Command, _ commands.NoPayload) commands.Result {
+			runs++
+			return commands.Result{Success: true, Message: "done"}
+		})
+	if err := a.commands.Register(handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// A forged command arrives first under the ID of a real one
+	forged := api.Command{ID: "cmd-1", Type: "test_run", KeyID: "console-1", Signature: base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))}
+	a.executeCommand(context.Background(), forged)
+	if runs != 0 {
+		t.Fatal("forged command was executed")
+	}
+	if a.journal.Seen("cmd-1") {
+		t.Fatal("forged command was journaled")
+	}
+
+	genuine := api.Command{ID: "cmd-1", Type: "test_run"}
+	signCommand(t, a, priv, &genuine)
+	a.executeCommand(context.Background(), genuine)
+	if runs != 1 {
+		t.Fatalf("real command ran %d times, want 1", runs)
+	}
+
+	// A forged replay of the executed command only raises a security event
+	before := len(server.Calls())
+	a.executeCommand(context.Background(), forged)
+	var paths []string
+	for _, call := range server.Calls()[before:] {
+		paths = append(paths, call.Path)
+	}
+	if len(paths) != 1 || paths[0] != "/agent/security-events" {
+		t.Errorf("forged replay made calls %v, want only the security event", paths)
+	}
+}
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lunaris/agent/internal/signing"
+)
+
+// refreshMargin is how long before expiry an access token is refreshed
+const refreshMargin = 30 * time.Second
+
+// Credentials authenticate the device to the API.
+// A plain device token is stored as an AccessToken without a RefreshToken.
+type Credentials struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+}
+
+// expiring reports whether the access token expires within the refresh margin
+func (c *Credentials) expiring() bool {
+	return !c.ExpiresAt.IsZero() && time.Until(c.ExpiresAt) < refreshMargin
+}
+
+// Credentials returns the credentials issued at registration, or nil if the server issued none
+func (r *RegisterResponse) Credentials() *Credentials {
+	switch {
+	case r.AccessToken != "":
+		return newCredentials(r.AccessToken, r.RefreshToken, r.ExpiresIn)
+	case r.DeviceToken != "":
+		return &Credentials{AccessToken: r.DeviceToken}
+	default:
+		return nil
+	}
+}
+
+// newCredentials builds credentials from a token response
+func newCredentials(accessToken, refreshToken string, expiresIn int) *Credentials {
+	creds := &Credentials{AccessToken: accessToken, RefreshToken: refreshToken}
+	if expiresIn > 0 {
+		creds.ExpiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second).UTC()
+	}
+	return creds
+}
+
+// RefreshRequest is the payload for refreshing an access token
+type RefreshRequest struct {
+	DeviceID     string `json:"deviceId"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// RefreshResponse is the response from a token refresh
+type RefreshResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int    `json:"expiresIn,omitempty"`
+}
+
+// SetDeviceID sets the device the credentials belong to, used when refreshing them
+func (c *Client) SetDeviceID(deviceID string) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.deviceID = deviceID
+}
+
+// SetCredentials sets the credentials attached to every request. Nil clears them.
+func (c *Client) SetCredentials(creds *Credentials) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if creds != nil {
+		copied := *creds
+		creds = &copied
+	}
+	c.credentials = creds
+}
+
+// AccessToken returns the current access token, or "" if the client has no credentials
+func (c *Client) AccessToken() string {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.credentials == nil {
+		return ""
+	}
+	return c.credentials.AccessToken
+}
+
+// OnCredentialsChanged sets a callback run after the client refreshes its credentials,
+// so they can be persisted
+func (c *Client) OnCredentialsChanged(fn func(*Credentials)) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.credentialsChanged = fn
+}
+
+// RefreshCredentials exchanges the refresh token for a new access token.
+// If the token was already replaced since stale was sent, nothing is refreshed.
+func (c *Client) RefreshCredentials(ctx context.Context, stale string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.authMu.Lock()
+	creds, deviceID := c.credentials, c.deviceID
+	c.authMu.Unlock()
+
+	if creds == nil || creds.RefreshToken == "" {
+		return fmt.Errorf("no refresh token")
+	}
+	if creds.AccessToken != stale && !creds.expiring() {
+		return nil
+	}
+
+	body, err := json.Marshal(&RefreshRequest{DeviceID: deviceID, RefreshToken: creds.RefreshToken})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL()+"/agent/token/refresh", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.send(req)
+	if err != nil {
+		return fmt.Errorf("token refresh request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return newStatusError("token refresh", resp)
+	}
+
+	var result RefreshResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return fmt.Errorf("token refresh returned no access token")
+	}
+
+	refreshed := newCredentials(result.AccessToken, result.RefreshToken, result.ExpiresIn)
+	if refreshed.RefreshToken == "" {
+		// Servers that don't rotate refresh tokens keep the old one valid
+		refreshed.RefreshToken = creds.RefreshToken
+	}
+
+	c.authMu.Lock()
+	c.credentials = refreshed
+	notify := c.credentialsChanged
+	c.authMu.Unlock()
+
+	if notify != nil {
+		copied := *refreshed
+		notify(&copied)
+	}
+	return nil
+}
+
+// authorize attaches the current access token to a request and returns it
+func (c *Client) authorize(req *http.Request) string {
+	c.authMu.Lock()
+	creds := c.credentials
+	c.authMu.Unlock()
+
+	if creds == nil {
+		return ""
+	}
+	req.Header.Set("Authorization", "Bearer "+creds.AccessToken)
+	return creds.AccessToken
+}
+
+// do sends an authenticated request. Tokens about to expire are refreshed first,
+// and a 401 is retried once with refreshed credentials when a refresh token is available.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.authMu.Lock()
+	creds := c.credentials
+	c.authMu.Unlock()
+	if creds != nil && creds.RefreshToken != "" && creds.expiring() {
+		// A failed early refresh is retried below if the server rejects the old token
+		c.RefreshCredentials(req.Context(), creds.AccessToken)
+	}
+
+	token := c.authorize(req)
+	resp, err := c.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" || !verified(resp) {
+		return resp, err
+	}
+
+	c.authMu.Lock()
+	canRefresh := c.credentials != nil && c.credentials.RefreshToken != ""
+	c.authMu.Unlock()
+	if !canRefresh || (req.GetBody == nil && req.Body != nil) {
+		return resp, nil
+	}
+
+	if err := c.RefreshCredentials(req.Context(), token); err != nil {
+		// Keep the original 401 so callers can fall back to re-enrolling
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	c.authorize(retry)
+	return c.send(retry)
+}
+
+// SetRequestAuth enables request signing and, if the auth has a verifier,
+// verification of responses
+func (c *Client) SetRequestAuth(auth *signing.Auth) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.requestAuth = auth
+}
+
+// send sends a request, failing over to the next healthy endpoint when the
+// one it was built for can't be reached or is unavailable
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	base := c.endpoints.baseOf(req.URL.String())
+	tried := map[string]bool{base: true}
+	for {
+		resp, err := c.sendOnce(req)
+		if base == "" {
+			return resp, err
+		}
+
+		failure := endpointFailure(req, resp, err)
+		if failure == nil {
+			c.endpoints.Succeeded(base)
+			return resp, err
+		}
+		c.endpoints.Failed(base, failure)
+
+		next := c.endpoints.Active()
+		if tried[next] || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		retry, rebaseErr := rebase(req, base, next)
+		if rebaseErr != nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		req, base = retry, next
+		tried[base] = true
+	}
+}
+
+// endpointFailure returns why a request counts against its endpoint's health, or nil
+// if the endpoint answered. Cancelled requests and rejected signatures aren't the endpoint's fault.
+func endpointFailure(req *http.Request, resp *http.Response, err error) error {
+	if err != nil {
+		var urlErr *url.Error
+		if req.Context().Err() == nil && errors.As(err, &urlErr) {
+			return err
+		}
+		return nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// rebase returns a copy of req sent to another base URL, with a fresh body
+func rebase(req *http.Request, from, to string) (*http.Request, error) {
+	target, err := url.Parse(to + strings.TrimPrefix(req.URL.String(), from))
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	retry.URL = target
+	retry.Host = ""
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return retry, nil
+}
+
+// sendOnce signs a request, sends it and verifies the response signature.
+// A successful response must verify. Error pages often come from proxies that can't sign,
+// so an error response that doesn't verify is still returned but marked unverified,
+// and the errors built from it don't make the agent drop its credentials or identity.
+func (c *Client) sendOnce(req *http.Request) (*http.Response, error) {
+	c.authMu.Lock()
+	auth := c.requestAuth
+	c.authMu.Unlock()
+
+	if auth == nil {
+		resp, err := c.httpClient.Do(req)
+		return resp, wrapProxyError(err)
+	}
+
+	nonce, err := auth.SignRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("sign request: %w", err)
+	}
+
+	sentAt := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, wrapProxyError(err)
+	}
+	auth.ObserveResponse(resp, sentAt)
+	if err := auth.VerifyResponse(resp, nonce); err != nil {
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = unverifiedBody{resp.Body}
+	}
+	return resp, nil
+}
+
+// unverifiedBody marks the body of an error response whose signature didn't verify
+type unverifiedBody struct {
+	io.ReadCloser
+}
+
+// verified reports whether resp wasn't marked unverified by sendOnce
+func verified(resp *http.Response) bool {
+	_, unverified := resp.Body.(unverifiedBody)
+	return !unverified
+}
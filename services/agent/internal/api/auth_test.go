@@ -0,0 +1,142 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/signing"
+)
+
+var testSigningKey = []byte("0123456789abcdef0123456789abcdef")
+
+// signResponse signs a response to r the way the API does
+func signResponse(w http.ResponseWriter, r *http.Request, status int, body string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 16)
+	sum := sha256.Sum256([]byte(body))
+	message := strings.Join([]string{strconv.Itoa(status), r.Header.Get(signing.HeaderNonce), timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")
+	signature := signing.NewHMAC(testSigningKey).Sign([]byte(message))
+
+	w.Header().Set(signing.HeaderTimestamp, timestamp)
+	w.Header().Set(signing.HeaderNonce, nonce)
+	w.Header().Set(signing.HeaderSignature, signing.AlgorithmHMAC+"="+base64.StdEncoding.EncodeToString(signature))
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// signedServer answers heartbeats with status, signing the response if signed is set,
+// and counts token refreshes
+type signedServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	refreshes int
+}
+
+func newSignedServer(t *testing.T, status int, signed bool) *signedServer {
+	t.Helper()
+	s := &signedServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/agent/token/refresh" {
+			s.mu.Lock()
+			s.refreshes++
+			s.mu.Unlock()
+			signResponse(w, r, http.StatusOK, `{"accessToken":"access-2"}`)
+			return
+		}
+		body := `{"status":"ok"}`
+		if status != http.StatusOK {
+			body = `{"message":"` + http.StatusText(status) + `"}`
+		}
+		if signed {
+			signResponse(w, r, status, body)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newSigningClient(url string) *Client {
+	c := NewClient(url + "/api")
+	h := signing.NewHMAC(testSigningKey)
+	c.SetRequestAuth(signing.NewAuth(h, h, nil, 0))
+	c.SetCredentials(&Credentials{AccessToken: "access-1", RefreshToken: "refresh-1"})
+	return c
+}
+
+func TestUnverifiedErrorResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		signed bool
+		target error
+		match  bool
+	}{
+		{"signed 401", http.StatusUnauthorized, true, ErrUnauthorized, true},
+		{"unsigned 401", http.StatusUnauthorized, false, ErrUnauthorized, false},
+		{"signed 404", http.StatusNotFound, true, ErrDeviceNotFound, true},
+		{"unsigned 404", http.StatusNotFound, false, ErrDeviceNotFound, false},
+		// Proxies can't sign their error pages; they still count as outages
+		{"unsigned 503", http.StatusServiceUnavailable, false, ErrServer, true},
+		{"unsigned 429", http.StatusTooManyRequests, false, ErrRateLimited, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSignedServer(t, tt.status, tt.signed)
+			c := newSigningClient(server.URL)
+
+			err := heartbeat(t, c)
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("heartbeat error = %v, want a StatusError", err)
+			}
+			if statusErr.Unverified == tt.signed {
+				t.Errorf("Unverified = %v for a response signed %v", statusErr.Unverified, tt.signed)
+			}
+			if got := errors.Is(err, tt.target); got != tt.match {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", err, tt.target, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestUnverifiedUnauthorizedDoesNotRefresh(t *testing.T) {
+	server := newSignedServer(t, http.StatusUnauthorized, false)
+	c := newSigningClient(server.URL)
+
+	heartbeat(t, c)
+	if server.refreshes != 0 {
+		t.Errorf("refreshed %d times on an unsigned 401, want 0", server.refreshes)
+	}
+	if got := c.AccessToken(); got != "access-1" {
+		t.Errorf("AccessToken() = %q, want access-1 kept", got)
+	}
+
+	signed := newSignedServer(t, http.StatusUnauthorized, true)
+	c = newSigningClient(signed.URL)
+	heartbeat(t, c)
+	if signed.refreshes != 1 {
+		t.Errorf("refreshed %d times on a signed 401, want 1", signed.refreshes)
+	}
+}
+
+func TestUnsignedSuccessIsRejected(t *testing.T) {
+	server := newSignedServer(t, http.StatusOK, false)
+	c := newSigningClient(server.URL)
+
+	if err := heartbeat(t, c); !errors.Is(err, signing.ErrBadSignature) {
+		t.Errorf("heartbeat error = %v, want ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// CertificateRequest asks the server to sign a new device certificate
+type CertificateRequest struct {
+	DeviceID string `json:"deviceId"`
+	CSR      string `json:"csr"`
+}
+
+// CertificateResponse carries a signed device certificate
+type CertificateResponse struct {
+	Certificate string `json:"certificate"`
+}
+
+// RenewCertificate requests a client certificate for a new CSR.
+// The request is authenticated with the current certificate and credentials.
+func (c *Client) RenewCertificate(ctx context.Context, req *CertificateRequest) (*CertificateResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	resp, err := c.post(ctx, "/agent/certificate", body)
+	if err != nil {
+		return nil, fmt.Errorf("certificate request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, newStatusError("certificate", resp)
+	}
+
+	var result CertificateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if result.Certificate == "" {
+		return nil, fmt.Errorf("certificate response has no certificate")
+	}
+
+	return &result, nil
+}
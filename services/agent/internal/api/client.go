@@ -2,60 +2,153 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
+
+	"github.com/lunaris/agent/internal/signing"
 )
 
 // Client handles communication with the Lunaris API
 type Client struct {
-	baseURL    string
+	endpoints  *Endpoints
 	httpClient *http.Client
+
+	authMu             sync.Mutex
+	refreshMu          sync.Mutex
+	deviceID           string
+	credentials        *Credentials
+	credentialsChanged func(*Credentials)
+	requestAuth        *signing.Auth
 }
 
-// NewClient creates a new API client
-func NewClient(baseURL string) *Client {
+// DefaultTimeout bounds every API request unless the caller's context ends sooner
+const DefaultTimeout = 30 * time.Second
+
+// NewClient creates a new API client. Requests fail over to the fallback
+// base URLs, in order, while baseURL is unreachable.
+func NewClient(baseURL string, fallbacks ...string) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.OnProxyConnectResponse = proxyConnectError
 	return &Client{
-		baseURL: baseURL,
+		endpoints: NewEndpoints(append([]string{baseURL}, fallbacks...)...),
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: transport,
+			Timeout:   DefaultTimeout,
 		},
 	}
 }
 
+// BaseURL returns the API base URL requests currently go to
+func (c *Client) BaseURL() string {
+	return c.endpoints.Active()
+}
+
+// Endpoints returns the health tracking of the API base URLs
+func (c *Client) Endpoints() *Endpoints {
+	return c.endpoints
+}
+
+// SetHTTPClient replaces the http.Client every request is sent with
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	c.httpClient = hc
+}
+
+// HTTPClient returns the http.Client every request is sent with
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
+// SetTLSConfig sets the TLS configuration used for API connections
+func (c *Client) SetTLSConfig(cfg *tls.Config) {
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport.TLSClientConfig = cfg
+	c.httpClient.Transport = transport
+}
+
+// SetProxy sets the function choosing the proxy for each API request; nil connects directly
+func (c *Client) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport.Proxy = proxy
+	transport.OnProxyConnectResponse = proxyConnectError
+	c.httpClient.Transport = transport
+}
+
+// CloseIdleConnections closes kept-alive connections, e.g. after the client certificate changed
+func (c *Client) CloseIdleConnections() {
+	c.httpClient.CloseIdleConnections()
+}
+
 // RegisterRequest is the payload for device registration
 type RegisterRequest struct {
-	Hostname     string `json:"hostname"`
-	OS           string `json:"os"`
-	OSVersion    string `json:"osVersion"`
-	MACAddress   string `json:"macAddress"`
-	AgentVersion string `json:"agentVersion"`
+	Hostname          string   `json:"hostname"`
+	OS                string   `json:"os"`
+	OSVersion         string   `json:"osVersion"`
+	MACAddress        string   `json:"macAddress"`
+	AgentVersion      string   `json:"agentVersion"`
+	SupportedCommands []string `json:"supportedCommands,omitempty"`
+
+	// EnrollmentSecret proves the device may join this console
+	EnrollmentSecret string `json:"enrollmentSecret,omitempty"`
+
+	// CSR is a PEM certificate signing request for the device's mutual TLS identity
+	CSR string `json:"csr,omitempty"`
+
+	// SigningPublicKey is the base64 Ed25519 key the device signs requests with
+	SigningPublicKey string `json:"signingPublicKey,omitempty"`
+
+	// PreviousDeviceID is the ID the device had before the server lost track of it
+	PreviousDeviceID string `json:"previousDeviceId,omitempty"`
+
+	// DeviceFingerprint identifies the machine independently of its network interfaces,
+	// so a reinstalled agent or a changed MAC address doesn't create a duplicate device
+	DeviceFingerprint string `json:"deviceFingerprint,omitempty"`
+
+	// FingerprintComponents holds the hashed components by source, for partial matches
+	FingerprintComponents map[string]string `json:"fingerprintComponents,omitempty"`
 }
 
-// RegisterResponse is the response from device registration
+// RegisterResponse is the response from device registration.
+// The server issues either a long-lived DeviceToken or an access/refresh token pair.
 type RegisterResponse struct {
-	DeviceID string `json:"deviceId"`
-	Message  string `json:"message"`
+	DeviceID     string `json:"deviceId"`
+	Message      string `json:"message"`
+	DeviceToken  string `json:"deviceToken,omitempty"`
+	AccessToken  string `json:"accessToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int    `json:"expiresIn,omitempty"`
+
+	// Certificate is the PEM client certificate chain issued for RegisterRequest.CSR
+	Certificate string `json:"certificate,omitempty"`
 }
 
 // Register registers the device with the backend
-func (c *Client) Register(req *RegisterRequest) (*RegisterResponse, error) {
+func (c *Client) Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	resp, err := c.post("/agent/register", body)
+	resp, err := c.post(ctx, "/agent/register", body)
 	if err != nil {
 		return nil, fmt.Errorf("register request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("register failed: %s - %s", resp.Status, string(bodyBytes))
+		return nil, newStatusError("register", resp)
 	}
 
 	var result RegisterResponse
@@ -73,30 +166,62 @@ type HeartbeatRequest struct {
 	CPUUsage    *float64 `json:"cpuUsage,omitempty"`
 	MemoryUsage *float64 `json:"memoryUsage,omitempty"`
 	DiskUsage   *float64 `json:"diskUsage,omitempty"`
+
+	// SupportedCommands lists the command types this agent version can execute
+	SupportedCommands []string `json:"supportedCommands,omitempty"`
+
+	// RealtimeState is the websocket connection state (connecting, connected, backing_off, ...)
+	RealtimeState string `json:"realtimeState,omitempty"`
+
+	// APIEndpoint is the API base URL the agent is using, which changes on failover
+	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// PolicyID and PolicyVersion identify the server policy the agent has applied
+	PolicyID      string `json:"policyId,omitempty"`
+	PolicyVersion int    `json:"policyVersion,omitempty"`
+
+	// PolicyError explains why the latest policy the server sent was rejected
+	PolicyError string `json:"policyError,omitempty"`
 }
 
 // HeartbeatResponse is the response from heartbeat
 type HeartbeatResponse struct {
 	Status     string `json:"status"`
 	ServerTime string `json:"serverTime"`
+
+	// Policy is the agent policy the server wants applied, if any
+	Policy *AgentPolicy `json:"policy,omitempty"`
+}
+
+// AgentPolicy is a versioned set of agent settings managed from the console.
+// Zero values leave the local configuration in effect.
+type AgentPolicy struct {
+	// ID identifies the policy's version history. Versions only compare within one ID;
+	// the server issues a new ID when it starts over, e.g. after its database was reset.
+	ID string `json:"id,omitempty"`
+
+	Version                      int `json:"version"`
+	HeartbeatIntervalSec         int `json:"heartbeatIntervalSec,omitempty"`
+	UpdateScanIntervalMin        int `json:"updateScanIntervalMin,omitempty"`
+	CommandPollIntervalSec       int `json:"commandPollIntervalSec,omitempty"`
+	CommandPollSafetyIntervalSec int `json:"commandPollSafetyIntervalSec,omitempty"`
 }
 
 // Heartbeat sends a heartbeat to the backend
-func (c *Client) Heartbeat(req *HeartbeatRequest) (*HeartbeatResponse, error) {
+func (c *Client) Heartbeat(ctx context.Context, req *HeartbeatRequest) (*HeartbeatResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	resp, err := c.post("/agent/heartbeat", body)
+	resp, err := c.post(ctx, "/agent/heartbeat", body)
 	if err != nil {
 		return nil, fmt.Errorf("heartbeat request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("heartbeat failed: %s - %s", resp.Status, string(bodyBytes))
+		return nil, newDeviceStatusError("heartbeat", resp)
 	}
 
 	var result HeartbeatResponse
@@ -114,12 +239,20 @@ type UpdateItem struct {
 	InstalledVersion  *string `json:"installedVersion,omitempty"`
 	AvailableVersion  string  `json:"availableVersion"`
 	Source            string  `json:"source"`
+
+	// Advisory metadata, only set by sources that publish it (e.g. dnf updateinfo)
+	AdvisoryID   string `json:"advisoryId,omitempty"`
+	AdvisoryType string `json:"advisoryType,omitempty"`
+	Severity     string `json:"severity,omitempty"`
 }
 
 // UpdateReportRequest is the payload for update reporting
 type UpdateReportRequest struct {
 	DeviceID string       `json:"deviceId"`
 	Updates  []UpdateItem `json:"updates"`
+
+	// UnparsedLines counts package manager output lines the scanners had to drop
+	UnparsedLines int `json:"unparsedLines,omitempty"`
 }
 
 // UpdateReportResponse is the response from update reporting
@@ -129,21 +262,20 @@ type UpdateReportResponse struct {
 }
 
 // ReportUpdates sends available updates to the backend
-func (c *Client) ReportUpdates(req *UpdateReportRequest) (*UpdateReportResponse, error) {
+func (c *Client) ReportUpdates(ctx context.Context, req *UpdateReportRequest) (*UpdateReportResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	resp, err := c.post("/agent/update-report", body)
+	resp, err := c.post(ctx, "/agent/update-report", body)
 	if err != nil {
 		return nil, fmt.Errorf("update-report request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("update-report failed: %s - %s", resp.Status, string(bodyBytes))
+		return nil, newDeviceStatusError("update-report", resp)
 	}
 
 	var result UpdateReportResponse
@@ -155,14 +287,20 @@ func (c *Client) ReportUpdates(req *UpdateReportRequest) (*UpdateReportResponse,
 }
 
 // post makes a POST request to the API
-func (c *Client) post(endpoint string, body []byte) (*http.Response, error) {
-	url := c.baseURL + endpoint
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+func (c *Client) post(ctx context.Context, endpoint string, body []byte) (*http.Response, error) {
+	url := c.BaseURL() + endpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	return c.httpClient.Do(req)
+	return c.do(req)
+}
+
+// readErrorBody reads the start of an error response body for error messages
+func readErrorBody(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return string(body)
 }
 
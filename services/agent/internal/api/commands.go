@@ -2,17 +2,140 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+)
+
+// CommandSchemaVersion is the newest command payload schema this agent understands.
+// Version 0 is the legacy format with only PackageIdentifiers and no Payload.
+const CommandSchemaVersion = 1
+
+// Failure codes reported when a command can't be executed
+const (
+	FailureUnknownCommand    = "unknown_command"
+	FailureInvalidPayload    = "invalid_payload"
+	FailureUnsupportedSchema = "unsupported_schema_version"
+	FailureHandlerPanic      = "handler_panic"
+	FailureSignatureInvalid  = "signature_invalid"
 )
 
 // Command represents a command from the server
 type Command struct {
-	ID                 string   `json:"id"`
-	Type               string   `json:"type"`
-	PackageIdentifiers []string `json:"packageIdentifiers"`
-	CreatedAt          string   `json:"createdAt"`
+	ID                 string          `json:"id"`
+	Type               string          `json:"type"`
+	PackageIdentifiers []string        `json:"packageIdentifiers"`
+	SchemaVersion      int             `json:"schemaVersion,omitempty"`
+	Payload            json.RawMessage `json:"payload,omitempty"`
+	CreatedAt          string          `json:"createdAt"`
+	ExpiresAt          string          `json:"expiresAt,omitempty"`
+
+	// Detached Ed25519 signature by the console and the ID of the key that made it
+	Signature string `json:"signature,omitempty"`
+	KeyID     string `json:"keyId,omitempty"`
+}
+
+// PayloadValidator is implemented by payload structs that check their own fields after decoding
+type PayloadValidator interface {
+	Validate() error
+}
+
+// PayloadError describes why a command payload couldn't be decoded
+type PayloadError struct {
+	Code   string
+	Field  string
+	Reason string
+}
+
+func (e *PayloadError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: field %q: %s", e.Code, e.Field, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+// Failure converts the error to the failure reported on command completion
+func (e *PayloadError) Failure() *CommandFailure {
+	return &CommandFailure{
+		Code:    e.Code,
+		Message: e.Reason,
+		Field:   e.Field,
+	}
+}
+
+// HasPayload reports whether the command carries a typed payload
+func (c *Command) HasPayload() bool {
+	trimmed := bytes.TrimSpace(c.Payload)
+	return len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null"))
+}
+
+// DecodePayload decodes the command payload into v, rejecting unknown fields.
+// If v implements PayloadValidator it is validated after decoding.
+// All errors are returned as *PayloadError.
+func (c *Command) DecodePayload(v interface{}) error {
+	if c.SchemaVersion > CommandSchemaVersion {
+		return &PayloadError{
+			Code:   FailureUnsupportedSchema,
+			Reason: fmt.Sprintf("schema version %d is newer than supported version %d", c.SchemaVersion, CommandSchemaVersion),
+		}
+	}
+
+	if !c.HasPayload() {
+		return &PayloadError{Code: FailureInvalidPayload, Reason: "payload is required"}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(c.Payload))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return payloadDecodeError(err)
+	}
+	if decoder.More() {
+		return &PayloadError{Code: FailureInvalidPayload, Reason: "unexpected data after payload"}
+	}
+
+	if validator, ok := v.(PayloadValidator); ok {
+		if err := validator.Validate(); err != nil {
+			var payloadErr *PayloadError
+			if errors.As(err, &payloadErr) {
+				return payloadErr
+			}
+			return &PayloadError{Code: FailureInvalidPayload, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// payloadDecodeError maps JSON decoding errors to a PayloadError naming the offending field
+func payloadDecodeError(err error) *PayloadError {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &typeErr):
+		return &PayloadError{
+			Code:   FailureInvalidPayload,
+			Field:  typeErr.Field,
+			Reason: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		}
+	case errors.As(err, &syntaxErr):
+		return &PayloadError{
+			Code:   FailureInvalidPayload,
+			Reason: fmt.Sprintf("malformed JSON at offset %d: %v", syntaxErr.Offset, err),
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return &PayloadError{
+			Code:   FailureInvalidPayload,
+			Field:  strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`),
+			Reason: "unknown field",
+		}
+	default:
+		return &PayloadError{Code: FailureInvalidPayload, Reason: err.Error()}
+	}
 }
 
 // CommandsResponse represents the response from polling for commands
@@ -20,24 +143,46 @@ type CommandsResponse struct {
 	Commands []Command `json:"commands"`
 }
 
+// CommandFailure is a machine-readable reason for a failed command
+type CommandFailure struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
 // CompleteCommandRequest represents a command completion request
 type CompleteCommandRequest struct {
-	Success bool   `json:"success"`
-	Result  string `json:"result,omitempty"`
+	Success bool            `json:"success"`
+	Result  string          `json:"result,omitempty"`
+	Failure *CommandFailure `json:"failure,omitempty"`
+}
+
+// CommandProgress reports how far a running command has got
+type CommandProgress struct {
+	Percent           int    `json:"percent"`
+	CurrentStep       string `json:"currentStep,omitempty"`
+	PackageIdentifier string `json:"packageIdentifier,omitempty"`
+	Completed         int    `json:"completed"`
+	Total             int    `json:"total"`
 }
 
 // GetPendingCommands polls for pending commands from the server
-func (c *Client) GetPendingCommands(deviceID string) (*CommandsResponse, error) {
-	url := fmt.Sprintf("%s/agent/commands/%s", c.baseURL, deviceID)
+func (c *Client) GetPendingCommands(ctx context.Context, deviceID string) (*CommandsResponse, error) {
+	url := fmt.Sprintf("%s/agent/commands/%s", c.BaseURL(), deviceID)
 
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get commands: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get commands failed with status: %d", resp.StatusCode)
+		return nil, newDeviceStatusError("get commands", resp)
 	}
 
 	var cmdResp CommandsResponse
@@ -48,35 +193,63 @@ func (c *Client) GetPendingCommands(deviceID string) (*CommandsResponse, error)
 	return &cmdResp, nil
 }
 
-// CompleteCommand marks a command as completed
-func (c *Client) CompleteCommand(commandID string, success bool, result string) error {
-	url := fmt.Sprintf("%s/agent/commands/%s/complete", c.baseURL, commandID)
+// AcknowledgeCommand tells the server the command was received and is about to run,
+// so it isn't delivered again
+func (c *Client) AcknowledgeCommand(ctx context.Context, commandID string) error {
+	return c.patchCommand(ctx, commandID, "ack", struct{}{})
+}
+
+// ReportCommandProgress sends a progress update for a running command
+func (c *Client) ReportCommandProgress(ctx context.Context, commandID string, progress *CommandProgress) error {
+	return c.patchCommand(ctx, commandID, "progress", progress)
+}
 
-	reqBody := CompleteCommandRequest{
+// CompleteCommand marks a command as completed
+func (c *Client) CompleteCommand(ctx context.Context, commandID string, success bool, result string) error {
+	return c.completeCommand(ctx, commandID, &CompleteCommandRequest{
 		Success: success,
 		Result:  result,
-	}
+	})
+}
+
+// FailCommand marks a command as failed with a machine-readable reason
+func (c *Client) FailCommand(ctx context.Context, commandID string, result string, failure *CommandFailure) error {
+	return c.completeCommand(ctx, commandID, &CompleteCommandRequest{
+		Success: false,
+		Result:  result,
+		Failure: failure,
+	})
+}
+
+// completeCommand sends a command completion request
+func (c *Client) completeCommand(ctx context.Context, commandID string, reqBody *CompleteCommandRequest) error {
+	return c.patchCommand(ctx, commandID, "complete", reqBody)
+}
+
+// patchCommand sends a lifecycle update to /agent/commands/:commandId/:action
+func (c *Client) patchCommand(ctx context.Context, commandID string, action string, reqBody interface{}) error {
+	url := fmt.Sprintf("%s/agent/commands/%s/%s", c.BaseURL(), commandID, action)
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return fmt.Errorf("failed to complete command: %w", err)
+		return fmt.Errorf("failed to %s command: %w", action, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("complete command failed with status: %d", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newCommandStatusError(action+" command", resp)
 	}
 
 	return nil
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// endpointFailureThreshold is how many failures in a row take an endpoint out of rotation
+	endpointFailureThreshold = 2
+
+	// An endpoint out of rotation is retried after a cooldown that doubles with every
+	// further failure, so a preferred endpoint is probed again once it may have recovered
+	endpointCooldownMin = 30 * time.Second
+	endpointCooldownMax = 10 * time.Minute
+
+	// healthAlpha weighs the latest result in an endpoint's health score
+	healthAlpha = 0.3
+)
+
+// EndpointStatus is a snapshot of an API endpoint's health
+type EndpointStatus struct {
+	URL       string
+	Active    bool
+	Score     float64
+	Failures  int
+	DownUntil time.Time
+	LastError string
+}
+
+// Endpoints tracks the health of the API base URLs and picks the one to use.
+// The first endpoint in configuration order that isn't cooling down is active,
+// so the agent fails back to a preferred endpoint once it recovers.
+type Endpoints struct {
+	mu       sync.Mutex
+	list     []*endpointHealth
+	active   int
+	onChange func(from, to string)
+	now      func() time.Time
+}
+
+type endpointHealth struct {
+	url       string
+	score     float64
+	failures  int
+	downUntil time.Time
+	lastErr   string
+}
+
+// NewEndpoints returns endpoints for the given base URLs in order of preference
+func NewEndpoints(urls ...string) *Endpoints {
+	e := &Endpoints{now: time.Now}
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		u = strings.TrimRight(strings.TrimSpace(u), "/")
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		e.list = append(e.list, &endpointHealth{url: u, score: 1})
+	}
+	return e
+}
+
+// OnChange sets a function called whenever the active endpoint changes
+func (e *Endpoints) OnChange(fn func(from, to string)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onChange = fn
+}
+
+// Active returns the base URL requests should go to
+func (e *Endpoints) Active() string {
+	e.mu.Lock()
+	url, notify := e.reselect()
+	e.mu.Unlock()
+	notify()
+	return url
+}
+
+// Len returns the number of endpoints
+func (e *Endpoints) Len() int {
+	return len(e.list)
+}
+
+// Status returns the health of every endpoint in configuration order
+func (e *Endpoints) Status() []EndpointStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := make([]EndpointStatus, len(e.list))
+	for i, ep := range e.list {
+		status[i] = EndpointStatus{
+			URL:       ep.url,
+			Active:    i == e.active,
+			Score:     ep.score,
+			Failures:  ep.failures,
+			DownUntil: ep.downUntil,
+			LastError: ep.lastErr,
+		}
+	}
+	return status
+}
+
+// Succeeded records that an endpoint answered
+func (e *Endpoints) Succeeded(url string) {
+	e.mu.Lock()
+	if ep := e.find(url); ep != nil {
+		ep.score = ep.score*(1-healthAlpha) + healthAlpha
+		ep.failures = 0
+		ep.downUntil = time.Time{}
+		ep.lastErr = ""
+	}
+	_, notify := e.reselect()
+	e.mu.Unlock()
+	notify()
+}
+
+// Failed records that an endpoint couldn't be reached or couldn't serve the request
+func (e *Endpoints) Failed(url string, err error) {
+	e.mu.Lock()
+	if ep := e.find(url); ep != nil {
+		ep.score *= 1 - healthAlpha
+		ep.failures++
+		if err != nil {
+			ep.lastErr = err.Error()
+		}
+		if ep.failures >= endpointFailureThreshold {
+			cooldown := endpointCooldownMin
+			for i := endpointFailureThreshold; i < ep.failures && cooldown < endpointCooldownMax; i++ {
+				cooldown *= 2
+			}
+			if cooldown > endpointCooldownMax {
+				cooldown = endpointCooldownMax
+			}
+			ep.downUntil = e.now().Add(cooldown)
+		}
+	}
+	_, notify := e.reselect()
+	e.mu.Unlock()
+	notify()
+}
+
+// find returns the endpoint a URL belongs to. Callers must hold e.mu.
+func (e *Endpoints) find(url string) *endpointHealth {
+	for _, ep := range e.list {
+		if ep.url == url {
+			return ep
+		}
+	}
+	return nil
+}
+
+// baseOf returns the endpoint a request URL was built from, or ""
+func (e *Endpoints) baseOf(rawURL string) string {
+	for _, ep := range e.list {
+		if rawURL == ep.url || strings.HasPrefix(rawURL, ep.url+"/") || strings.HasPrefix(rawURL, ep.url+"?") {
+			return ep.url
+		}
+	}
+	return ""
+}
+
+// reselect makes the first endpoint that isn't cooling down active; when all are,
+// the one that recovers first. It returns the active URL and a function notifying
+// a change, to be called without e.mu held. Callers must hold e.mu.
+func (e *Endpoints) reselect() (string, func()) {
+	if len(e.list) == 0 {
+		return "", func() {}
+	}
+
+	now := e.now()
+	next := -1
+	for i, ep := range e.list {
+		if !now.Before(ep.downUntil) {
+			next = i
+			break
+		}
+	}
+	if next < 0 {
+		next = 0
+		for i, ep := range e.list {
+			if ep.downUntil.Before(e.list[next].downUntil) {
+				next = i
+			}
+		}
+	}
+
+	previous := e.active
+	e.active = next
+	to := e.list[next].url
+	if previous == next || e.onChange == nil {
+		return to, func() {}
+	}
+	from, fn := e.list[previous].url, e.onChange
+	return to, func() { fn(from, to) }
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock drives endpoint cooldowns in tests
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestEndpoints(urls ...string) (*Endpoints, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e := NewEndpoints(urls...)
+	e.now = clock.now
+	return e, clock
+}
+
+var errDown = errors.New("connection refused")
+
+func TestNewEndpointsNormalizesURLs(t *testing.T) {
+	e := NewEndpoints(" https://a.example/api/ ", "", "https://a.example/api", "https://b.example/api")
+	if e.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", e.Len())
+	}
+	if got := e.Active(); got != "https://a.example/api" {
+		t.Errorf("Active() = %q, want https://a.example/api", got)
+	}
+}
+
+func TestEndpointsFailOverAfterThreshold(t *testing.T) {
+	e, _ := newTestEndpoints("https://a", "https://b", "https://c")
+
+	e.Failed("https://a", errDown)
+	if got := e.Active(); got != "https://a" {
+		t.Fatalf("after one failure Active() = %q, want https://a", got)
+	}
+
+	e.Failed("https://a", errDown)
+	if got := e.Active(); got != "https://b" {
+		t.Fatalf("after %d failures Active() = %q, want https://b", endpointFailureThreshold, got)
+	}
+
+	status := e.Status()
+	if status[0].Active || !status[1].Active {
+		t.Errorf("Status() active flags = %v/%v, want false/true", status[0].Active, status[1].Active)
+	}
+	if status[0].Failures != 2 || status[0].LastError != errDown.Error() {
+		t.Errorf("Status()[0] = %+v, want 2 failures and %q", status[0], errDown)
+	}
+	if status[0].Score >= status[1].Score {
+		t.Errorf("failed endpoint score %v not below healthy %v", status[0].Score, status[1].Score)
+	}
+}
+
+func TestEndpointsSuccessResetsFailures(t *testing.T) {
+	e, _ := newTestEndpoints("https://a", "https://b")
+
+	e.Failed("https://a", errDown)
+	e.Succeeded("https://a")
+	e.Failed("https://a", errDown)
+
+	if got := e.Active(); got != "https://a" {
+		t.Errorf("Active() = %q, want https://a: failures weren't in a row", got)
+	}
+}
+
+func TestEndpointsCooldownDoubles(t *testing.T) {
+	e, clock := newTestEndpoints("https://a", "https://b")
+	start := clock.now()
+
+	tests := []struct {
+		failures int
+		cooldown time.Duration
+	}{
+		{2, endpointCooldownMin},
+		{3, 2 * endpointCooldownMin},
+		{4, 4 * endpointCooldownMin},
+		{10, endpointCooldownMax},
+		{20, endpointCooldownMax},
+	}
+	failures := 0
+	for _, tt := range tests {
+		for failures < tt.failures {
+			e.Failed("https://a", errDown)
+			failures++
+		}
+		if got := e.Status()[0].DownUntil.Sub(start); got != tt.cooldown {
+			t.Errorf("after %d failures cooldown = %v, want %v", tt.failures, got, tt.cooldown)
+		}
+	}
+}
+
+func TestEndpointsFailBackAfterCooldown(t *testing.T) {
+	e, clock := newTestEndpoints("https://a", "https://b")
+
+	var changes [][2]string
+	e.OnChange(func(from, to string) {
+		changes = append(changes, [2]string{from, to})
+	})
+
+	e.Failed("https://a", errDown)
+	e.Failed("https://a", errDown)
+	if got := e.Active(); got != "https://b" {
+		t.Fatalf("Active() = %q, want https://b", got)
+	}
+
+	clock.advance(endpointCooldownMin - time.Second)
+	if got := e.Active(); got != "https://b" {
+		t.Fatalf("during cooldown Active() = %q, want https://b", got)
+	}
+
+	clock.advance(time.Second)
+	if got := e.Active(); got != "https://a" {
+		t.Fatalf("after cooldown Active() = %q, want https://a", got)
+	}
+
+	// The preferred endpoint is probed again; failing once more sends it straight back out
+	e.Failed("https://a", errDown)
+	if got := e.Active(); got != "https://b" {
+		t.Fatalf("after failed probe Active() = %q, want https://b", got)
+	}
+	if got := e.Status()[0].DownUntil.Sub(clock.now()); got != 2*endpointCooldownMin {
+		t.Errorf("cooldown after failed probe = %v, want %v", got, 2*endpointCooldownMin)
+	}
+
+	want := [][2]string{
+		{"https://a", "https://b"},
+		{"https://b", "https://a"},
+		{"https://a", "https://b"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("OnChange calls = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("OnChange call %d = %v, want %v", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestEndpointsAllDownPicksFirstToRecover(t *testing.T) {
+	e, clock := newTestEndpoints("https://a", "https://b")
+
+	// a cools down longer than b
+	for i := 0; i < 3; i++ {
+		e.Failed("https://a", errDown)
+	}
+	clock.advance(time.Second)
+	e.Failed("https://b", errDown)
+	e.Failed("https://b", errDown)
+
+	if got := e.Active(); got != "https://b" {
+		t.Errorf("Active() = %q, want https://b, which recovers first", got)
+	}
+}
+
+func TestEndpointsIgnoreUnknownURL(t *testing.T) {
+	e, _ := newTestEndpoints("https://a")
+
+	e.Failed("https://other", errDown)
+	e.Failed("https://other", errDown)
+
+	status := e.Status()
+	if status[0].Failures != 0 || !status[0].Active {
+		t.Errorf("Status() = %+v, want the known endpoint untouched", status[0])
+	}
+}
+
+func TestEndpointsBaseOf(t *testing.T) {
+	e := NewEndpoints("https://a.example/api", "https://a.example/api2")
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://a.example/api/agent/heartbeat", "https://a.example/api"},
+		{"https://a.example/api2/agent/heartbeat", "https://a.example/api2"},
+		{"https://a.example/api?x=1", "https://a.example/api"},
+		{"https://a.example/api", "https://a.example/api"},
+		{"https://a.example/apix/agent", ""},
+		{"https://b.example/api/agent", ""},
+	}
+	for _, tt := range tests {
+		if got := e.baseOf(tt.url); got != tt.want {
+			t.Errorf("baseOf(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Errors matched with errors.Is against failures returned by Client methods
+var (
+	// ErrUnauthorized means the API rejected the device credentials
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrDeviceNotFound means the API doesn't know the device ID, e.g. it was deleted from the console
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// ErrRateLimited means the API asked the agent to slow down; see RetryAfter
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrServer means the API failed with a 5xx status
+	ErrServer = errors.New("server error")
+
+	// ErrProxy means the configured proxy couldn't be reached, rejected the agent's credentials
+	// or refused to open a tunnel to the API
+	ErrProxy = errors.New("proxy error")
+
+	// ErrCommandSettled means the API doesn't know the command or it was already completed,
+	// so reporting on it again can't succeed
+	ErrCommandSettled = errors.New("command unknown or already complete")
+)
+
+// StatusError is returned when the API answers with an unexpected status code
+type StatusError struct {
+	Op         string
+	StatusCode int
+	Status     string
+	Body       string
+
+	// RetryAfter is the delay requested by a Retry-After header, if any
+	RetryAfter time.Duration
+
+	// Unverified marks a response whose signature didn't verify. It may not come from
+	// the API, so it never matches ErrUnauthorized or ErrDeviceNotFound.
+	Unverified bool
+
+	// deviceScoped marks endpoints where a 404 means the device itself is unknown
+	deviceScoped bool
+
+	// commandScoped marks endpoints addressed by command ID
+	commandScoped bool
+
+	// fromProxy marks a status the proxy answered a CONNECT with; the API wasn't reached
+	fromProxy bool
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s failed: %s", e.Op, e.Status)
+	}
+	return fmt.Sprintf("%s failed: %s - %s", e.Op, e.Status, e.Body)
+}
+
+// Is matches the typed errors of this package
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized && !e.Unverified
+	case ErrDeviceNotFound:
+		return e.StatusCode == http.StatusNotFound && e.deviceScoped && !e.Unverified
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500 && !e.fromProxy
+	case ErrProxy:
+		return e.StatusCode == http.StatusProxyAuthRequired || e.fromProxy
+	case ErrCommandSettled:
+		switch e.StatusCode {
+		case http.StatusNotFound, http.StatusConflict, http.StatusGone:
+			return e.commandScoped && !e.Unverified
+		}
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed if retried later.
+// A 407 counts: the request is fine once the proxy settings are fixed.
+// A 401 doesn't: retrying can't help until the agent has re-enrolled.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusProxyAuthRequired
+}
+
+// IsTemporary reports whether err is worth retrying: the request never reached the API,
+// or the API failed in a way that isn't about the request itself
+func IsTemporary(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return true
+}
+
+// RetryAfter returns the delay the API asked for before retrying, or 0 if it didn't say
+func RetryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// wrapProxyError marks transport errors that happened while connecting through a proxy,
+// including a CONNECT the proxy refused
+func wrapProxyError(err error) error {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return fmt.Errorf("%w: %w", ErrProxy, err)
+	}
+	return err
+}
+
+// proxyConnectError fails a CONNECT the proxy refused with a StatusError, so a 502
+// from the proxy matches ErrProxy instead of passing as a bare transport error.
+// It is an http.Transport OnProxyConnectResponse hook.
+func proxyConnectError(_ context.Context, _ *url.URL, _ *http.Request, resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	e := newStatusError("proxy connect", resp)
+	e.fromProxy = true
+	return e
+}
+
+// newStatusError builds a StatusError from a response, including a bounded part of its body
+func newStatusError(op string, resp *http.Response) *StatusError {
+	return &StatusError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       readErrorBody(resp),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Unverified: !verified(resp),
+	}
+}
+
+// newDeviceStatusError builds a StatusError for an endpoint addressed by device ID
+func newDeviceStatusError(op string, resp *http.Response) *StatusError {
+	e := newStatusError(op, resp)
+	e.deviceScoped = true
+	return e
+}
+
+// newCommandStatusError builds a StatusError for an endpoint addressed by command ID
+func newCommandStatusError(op string, resp *http.Response) *StatusError {
+	e := newStatusError(op, resp)
+	e.commandScoped = true
+	return e
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStatusErrorIs(t *testing.T) {
+	targets := []error{ErrUnauthorized, ErrDeviceNotFound, ErrRateLimited, ErrServer, ErrProxy, ErrCommandSettled}
+
+	tests := []struct {
+		name      string
+		err       *StatusError
+		matches   []error
+		temporary bool
+	}{
+		{"401", &StatusError{StatusCode: 401}, []error{ErrUnauthorized}, false},
+		{"unverified 401", &StatusError{StatusCode: 401, Unverified: true}, nil, false},
+		{"device-scoped 404", &StatusError{StatusCode: 404, deviceScoped: true}, []error{ErrDeviceNotFound}, false},
+		{"unverified device-scoped 404", &StatusError{StatusCode: 404, deviceScoped: true, Unverified: true}, nil, false},
+		{"other 404", &StatusError{StatusCode: 404}, nil, false},
+		{"command-scoped 404", &StatusError{StatusCode: 404, commandScoped: true}, []error{ErrCommandSettled}, false},
+		{"command-scoped 409", &StatusError{StatusCode: 409, commandScoped: true}, []error{ErrCommandSettled}, false},
+		{"command-scoped 400", &StatusError{StatusCode: 400, commandScoped: true}, nil, false},
+		{"408", &StatusError{StatusCode: 408}, nil, true},
+		{"429", &StatusError{StatusCode: 429}, []error{ErrRateLimited}, true},
+		{"500", &StatusError{StatusCode: 500}, []error{ErrServer}, true},
+		{"502", &StatusError{StatusCode: 502}, []error{ErrServer}, true},
+		{"503", &StatusError{StatusCode: 503}, []error{ErrServer}, true},
+		{"407", &StatusError{StatusCode: 407}, []error{ErrProxy}, true},
+		{"502 from the proxy", &StatusError{StatusCode: 502, fromProxy: true}, []error{ErrProxy}, true},
+		{"400", &StatusError{StatusCode: 400}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Callers see the error wrapped in context
+			err := fmt.Errorf("heartbeat: %w", tt.err)
+			for _, target := range targets {
+				want := false
+				for _, m := range tt.matches {
+					want = want || m == target
+				}
+				if got := errors.Is(err, target); got != want {
+					t.Errorf("errors.Is(%v) = %v, want %v", target, got, want)
+				}
+			}
+			if got := IsTemporary(err); got != tt.temporary {
+				t.Errorf("IsTemporary() = %v, want %v", got, tt.temporary)
+			}
+		})
+	}
+}
+
+func TestIsTemporaryTransportErrors(t *testing.T) {
+	if IsTemporary(nil) {
+		t.Error("IsTemporary(nil) = true")
+	}
+	if !IsTemporary(&url.Error{Op: "Post", URL: "https://api.example", Err: errors.New("connection refused")}) {
+		t.Error("IsTemporary() = false for a connection error")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"missing", "", 0, 0},
+		{"seconds", "120", 120 * time.Second, 120 * time.Second},
+		{"zero seconds", "0", 0, 0},
+		{"negative seconds", "-5", 0, 0},
+		{"garbage", "soon", 0, 0},
+		{"HTTP date", time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 88 * time.Second, 90 * time.Second},
+		{"HTTP date in the past", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetryAfterFromResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, `{"message":"slow down"}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	err := heartbeat(t, NewClient(server.URL))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("heartbeat error = %v, want ErrRateLimited", err)
+	}
+	if got := RetryAfter(err); got != 30*time.Second {
+		t.Errorf("RetryAfter() = %v, want 30s", got)
+	}
+	if got := RetryAfter(errors.New("other")); got != 0 {
+		t.Errorf("RetryAfter() of a non-status error = %v, want 0", got)
+	}
+}
+
+func TestProxyRefusingConnect(t *testing.T) {
+	for _, status := range []int{http.StatusProxyAuthRequired, http.StatusBadGateway} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodConnect {
+					t.Errorf("proxy got %s %s, want CONNECT", r.Method, r.URL)
+				}
+				w.WriteHeader(status)
+			}))
+			defer proxy.Close()
+			proxyURL, err := url.Parse(proxy.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := NewClient("https://api.example.invalid/api")
+			c.SetProxy(http.ProxyURL(proxyURL))
+
+			err = heartbeat(t, c)
+			if !errors.Is(err, ErrProxy) {
+				t.Errorf("heartbeat error = %v, want ErrProxy", err)
+			}
+			if errors.Is(err, ErrServer) {
+				t.Errorf("heartbeat error = %v matches ErrServer; the API wasn't reached", err)
+			}
+			if !IsTemporary(err) {
+				t.Errorf("IsTemporary(%v) = false", err)
+			}
+		})
+	}
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	proxy := httptest.NewServer(http.NotFoundHandler())
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxy.Close()
+
+	c := NewClient("https://api.example.invalid/api")
+	c.SetProxy(http.ProxyURL(proxyURL))
+	if err := heartbeat(t, c); !errors.Is(err, ErrProxy) {
+		t.Errorf("heartbeat error = %v, want ErrProxy", err)
+	}
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubServer is a stand-in API server answering heartbeats with a settable status
+type stubServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []HeartbeatRequest
+}
+
+func newStubServer(t *testing.T) *stubServer {
+	t.Helper()
+	s := &stubServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/agent/heartbeat" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req HeartbeatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		status := s.status
+		s.mu.Unlock()
+
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		json.NewEncoder(w).Encode(HeartbeatResponse{Status: "ok"})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// hits returns how many heartbeats reached the server
+func (s *stubServer) hits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func (s *stubServer) api() string {
+	return s.URL + "/api"
+}
+
+// newFailoverClient returns a client for the given servers with a fake clock
+func newFailoverClient(servers ...string) (*Client, *fakeClock) {
+	c := NewClient(servers[0], servers[1:]...)
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c.endpoints.now = clock.now
+	return c, clock
+}
+
+func heartbeat(t *testing.T, c *Client) error {
+	t.Helper()
+	_, err := c.Heartbeat(context.Background(), &HeartbeatRequest{DeviceID: "device-1"})
+	return err
+}
+
+func TestClientFailsOverOnUnavailable(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			primary, fallback := newStubServer(t), newStubServer(t)
+			primary.setStatus(status)
+			c, _ := newFailoverClient(primary.api(), fallback.api())
+
+			// One failure isn't enough to give up on the preferred endpoint
+			if err := heartbeat(t, c); !errors.Is(err, ErrServer) {
+				t.Fatalf("first heartbeat error = %v, want ErrServer", err)
+			}
+			if fallback.hits() != 0 {
+				t.Fatalf("fallback got %d heartbeats after one failure, want 0", fallback.hits())
+			}
+
+			// The second one takes the primary out of rotation and is retried on the fallback
+			if err := heartbeat(t, c); err != nil {
+				t.Fatalf("second heartbeat: %v", err)
+			}
+			if fallback.hits() != 1 || primary.hits() != 2 {
+				t.Fatalf("hits primary/fallback = %d/%d, want 2/1", primary.hits(), fallback.hits())
+			}
+			if fallback.requests[0].DeviceID != "device-1" {
+				t.Errorf("retried body device ID = %q, want device-1", fallback.requests[0].DeviceID)
+			}
+			if c.BaseURL() != fallback.api() {
+				t.Errorf("BaseURL() = %q, want %q", c.BaseURL(), fallback.api())
+			}
+
+			// Later requests go straight to the fallback
+			if err := heartbeat(t, c); err != nil {
+				t.Fatalf("third heartbeat: %v", err)
+			}
+			if primary.hits() != 2 || fallback.hits() != 2 {
+				t.Errorf("hits primary/fallback = %d/%d, want 2/2", primary.hits(), fallback.hits())
+			}
+		})
+	}
+}
+
+func TestClientFailsOverWhenUnreachable(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL + "/api"
+	down.Close()
+
+	fallback := newStubServer(t)
+	c, _ := newFailoverClient(downURL, fallback.api())
+
+	if err := heartbeat(t, c); err == nil {
+		t.Fatal("first heartbeat succeeded, want a connection error")
+	}
+	if err := heartbeat(t, c); err != nil {
+		t.Fatalf("second heartbeat: %v", err)
+	}
+	if fallback.hits() != 1 {
+		t.Errorf("fallback hits = %d, want 1", fallback.hits())
+	}
+
+	status := c.Endpoints().Status()
+	if status[0].Active || status[0].Failures != 2 || status[0].LastError == "" {
+		t.Errorf("Status()[0] = %+v, want inactive with 2 failures and an error", status[0])
+	}
+	if !status[1].Active || status[1].Failures != 0 {
+		t.Errorf("Status()[1] = %+v, want active and healthy", status[1])
+	}
+}
+
+func TestClientFailsBackAfterCooldown(t *testing.T) {
+	primary, fallback := newStubServer(t), newStubServer(t)
+	primary.setStatus(http.StatusServiceUnavailable)
+	c, clock := newFailoverClient(primary.api(), fallback.api())
+
+	var changes []string
+	c.Endpoints().OnChange(func(from, to string) {
+		changes = append(changes, to)
+	})
+
+	heartbeat(t, c)
+	if err := heartbeat(t, c); err != nil {
+		t.Fatalf("heartbeat on fallback: %v", err)
+	}
+
+	primary.setStatus(http.StatusOK)
+	clock.advance(endpointCooldownMin)
+
+	if err := heartbeat(t, c); err != nil {
+		t.Fatalf("heartbeat after cooldown: %v", err)
+	}
+	if primary.hits() != 3 || fallback.hits() != 1 {
+		t.Errorf("hits primary/fallback = %d/%d, want 3/1", primary.hits(), fallback.hits())
+	}
+	if c.BaseURL() != primary.api() {
+		t.Errorf("BaseURL() = %q, want the primary back", c.BaseURL())
+	}
+	if len(changes) != 2 || changes[0] != fallback.api() || changes[1] != primary.api() {
+		t.Errorf("OnChange targets = %v, want [fallback primary]", changes)
+	}
+}
+
+func TestClientTriesEachEndpointOnce(t *testing.T) {
+	servers := []*stubServer{newStubServer(t), newStubServer(t), newStubServer(t)}
+	var urls []string
+	for _, s := range servers {
+		s.setStatus(http.StatusServiceUnavailable)
+		urls = append(urls, s.api())
+	}
+	c, _ := newFailoverClient(urls...)
+
+	total := func() int {
+		n := 0
+		for _, s := range servers {
+			n += s.hits()
+		}
+		return n
+	}
+
+	// No request loops over the endpoints; each is tried at most once per request
+	for i := 0; i < 4; i++ {
+		before := total()
+		if err := heartbeat(t, c); !errors.Is(err, ErrServer) {
+			t.Fatalf("heartbeat %d error = %v, want ErrServer", i, err)
+		}
+		if sent := total() - before; sent > len(servers) {
+			t.Fatalf("heartbeat %d was sent %d times, want at most %d", i, sent, len(servers))
+		}
+	}
+	for i, s := range servers {
+		if s.hits() == 0 {
+			t.Errorf("server %d was never tried", i)
+		}
+	}
+}
+
+func TestClientDoesNotFailOverOnApplicationErrors(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusNotFound, http.StatusBadRequest} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			primary, fallback := newStubServer(t), newStubServer(t)
+			primary.setStatus(status)
+			c, _ := newFailoverClient(primary.api(), fallback.api())
+
+			for i := 0; i < 3; i++ {
+				if err := heartbeat(t, c); err == nil {
+					t.Fatalf("heartbeat %d succeeded, want status %d", i, status)
+				}
+			}
+			if fallback.hits() != 0 {
+				t.Errorf("fallback got %d heartbeats, want 0: the primary answered", fallback.hits())
+			}
+			if c.BaseURL() != primary.api() {
+				t.Errorf("BaseURL() = %q, want the primary", c.BaseURL())
+			}
+		})
+	}
+}
+
+func TestClientCancelledRequestDoesNotCountAgainstEndpoint(t *testing.T) {
+	primary, fallback := newStubServer(t), newStubServer(t)
+	c, _ := newFailoverClient(primary.api(), fallback.api())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		c.Heartbeat(ctx, &HeartbeatRequest{DeviceID: "device-1"})
+	}
+
+	if status := c.Endpoints().Status(); status[0].Failures != 0 || !status[0].Active {
+		t.Errorf("Status()[0] = %+v, want no failures recorded for cancelled requests", status[0])
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Security event types reported by the agent
+const (
+	SecurityEventCommandRejected = "command_signature_rejected"
+	SecurityEventKeyRotated      = "console_key_rotated"
+	SecurityEventReregistered    = "device_reregistered"
+)
+
+// SecurityEvent reports something the console should look into
+type SecurityEvent struct {
+	DeviceID  string `json:"deviceId"`
+	Type      string `json:"type"`
+	CommandID string `json:"commandId,omitempty"`
+	KeyID     string `json:"keyId,omitempty"`
+	Reason    string `json:"reason"`
+
+	// PreviousDeviceID is set when the device re-registered under a new ID
+	PreviousDeviceID string `json:"previousDeviceId,omitempty"`
+
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// ReportSecurityEvent sends a security event to the backend
+func (c *Client) ReportSecurityEvent(ctx context.Context, event *SecurityEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	resp, err := c.post(ctx, "/agent/security-events", body)
+	if err != nil {
+		return fmt.Errorf("security-event request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return newStatusError("security-event", resp)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package apt
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// InstallResult represents the result of an install operation
+type InstallResult = updates.InstallResult
+
+// Installer handles APT package upgrades
+type Installer struct{}
+
+// NewInstaller creates a new APT installer
+func NewInstaller() *Installer {
+	return &Installer{}
+}
+
+// packageNameRe matches a Debian package name, optionally qualified with an architecture
+var packageNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+(:[a-z0-9-]+)?$`)
+
+// ValidPackageName reports whether name is a package name apt-get can't mistake for an option
+func ValidPackageName(name string) bool {
+	return packageNameRe.MatchString(name)
+}
+
+// Install upgrades a single already-installed package
+func (i *Installer) Install(packageIdentifier string) *InstallResult {
+	if !ValidPackageName(packageIdentifier) {
+		return &InstallResult{
+			PackageIdentifier: packageIdentifier,
+			Message:           "Invalid package name",
+			Error:             fmt.Errorf("invalid apt package name %q", packageIdentifier),
+		}
+	}
+
+	// --only-upgrade never installs a package that isn't already present,
+	// and the dpkg options keep locally modified config files without prompting
+	output, err := runAptGet("install",
+		"--only-upgrade",
+		"-y", "-q",
+		"-o", "Dpkg::Options::=--force-confdef",
+		"-o", "Dpkg::Options::=--force-confold",
+		"--", packageIdentifier,
+	)
+	return installResult(packageIdentifier, output, err)
+}
+
+// installResult interprets the output of apt-get install --only-upgrade
+func installResult(packageIdentifier, output string, err error) *InstallResult {
+	result := &InstallResult{
+		PackageIdentifier: packageIdentifier,
+		Message:           strings.TrimSpace(output),
+	}
+
+	if err != nil {
+		result.Error = fmt.Errorf("apt-get install failed: %w", err)
+
+		// Check for common error messages
+		if strings.Contains(output, "Unable to locate package") {
+			result.Message = "Package not found in apt repository"
+		} else if strings.Contains(output, "Could not get lock") {
+			result.Message = "Another package manager is running"
+		}
+		return result
+	}
+
+	switch {
+	case strings.Contains(output, "is already the newest version"):
+		// Nothing to do is what the command asked for
+		result.Success = true
+		result.Message = "Already up to date"
+	case strings.Contains(output, "it is not installed and only upgrades are requested"):
+		result.Message = "Package is not installed"
+		result.Error = fmt.Errorf("%s is not installed", packageIdentifier)
+	default:
+		result.Success = true
+		result.Message = "Successfully installed"
+	}
+	return result
+}
+
+// InstallMultiple upgrades multiple packages
+func (i *Installer) InstallMultiple(packageIdentifiers []string) []*InstallResult {
+	results := make([]*InstallResult, 0, len(packageIdentifiers))
+
+	for _, pkgID := range packageIdentifiers {
+		results = append(results, i.Install(pkgID))
+	}
+
+	return results
+}
+
+// Uninstall removes a package using apt-get
+func (i *Installer) Uninstall(packageIdentifier string) error {
+	if !ValidPackageName(packageIdentifier) {
+		return fmt.Errorf("invalid apt package name %q", packageIdentifier)
+	}
+	output, err := runAptGet("remove", "-y", "-q", "--", packageIdentifier)
+	if err != nil {
+		return fmt.Errorf("apt-get remove failed: %w (output: %s)", err, output)
+	}
+	return nil
+}
+
+// CanInstall checks if apt is available on the system
+func (i *Installer) CanInstall() error {
+	for _, bin := range []string{"apt-get", "apt"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return fmt.Errorf("%s is not available: %w", bin, err)
+		}
+	}
+	return nil
+}
+
+// runAptGet runs apt-get non-interactively and returns its combined output
+func runAptGet(args ...string) (string, error) {
+	cmd := exec.Command("apt-get", args...)
+	cmd.Env = nonInteractiveEnv()
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	output := stdout.String()
+	if stderr.Len() > 0 {
+		output += "\n" + stderr.String()
+	}
+	return output, err
+}
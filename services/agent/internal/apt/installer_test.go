@@ -0,0 +1,69 @@
+package apt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidPackageName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"libc6", true},
+		{"python3.10", true},
+		{"g++", true},
+		{"linux-image-6.5.0-14-generic", true},
+		{"libc6:i386", true},
+		{"-oDpkg::Pre-Invoke::=id", false},
+		{"--reinstall", false},
+		{"firefox vim", false},
+		{"Firefox", false},
+		{"a", false},
+		{"", false},
+		{"libc6:", false},
+		{"pkg/jammy", false},
+	}
+	for _, tt := range tests {
+		if got := ValidPackageName(tt.name); got != tt.want {
+			t.Errorf("ValidPackageName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInstallRejectsInvalidName(t *testing.T) {
+	result := NewInstaller().Install("-oDpkg::Pre-Invoke::=touch /tmp/pwned")
+	if result.Success || result.Error == nil {
+		t.Errorf("Install() = %+v, want an error without running apt-get", result)
+	}
+	if err := NewInstaller().Uninstall("--purge"); err == nil {
+		t.Error("Uninstall() accepted an option as package name")
+	}
+}
+
+func TestInstallResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		err     error
+		success bool
+		message string
+	}{
+		{"upgraded", "Setting up libc6 (2.35-0ubuntu3.8) ...", nil, true, "Successfully installed"},
+		{"already newest", "libc6 is already the newest version (2.35-0ubuntu3.8).", nil, true, "Already up to date"},
+		{"not installed", "Skipping vim, it is not installed and only upgrades are requested.", nil, false, "Package is not installed"},
+		{"unknown", "E: Unable to locate package nope", errors.New("exit status 100"), false, "Package not found in apt repository"},
+		{"locked", "E: Could not get lock /var/lib/dpkg/lock-frontend", errors.New("exit status 100"), false, "Another package manager is running"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := installResult("libc6", tt.output, tt.err)
+			if result.Success != tt.success || result.Message != tt.message {
+				t.Errorf("installResult() = %v %q, want %v %q", result.Success, result.Message, tt.success, tt.message)
+			}
+			if result.Success != (result.Error == nil) {
+				t.Errorf("installResult() success %v with error %v", result.Success, result.Error)
+			}
+		})
+	}
+}
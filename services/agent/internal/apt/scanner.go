@@ -0,0 +1,167 @@
+package apt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+const (
+	// RefreshInterval is the minimum time between package list refreshes
+	RefreshInterval = time.Hour
+
+	// refreshTimeout bounds apt-get update, which hangs on unreachable mirrors
+	refreshTimeout = 5 * time.Minute
+
+	// listTimeout bounds apt list --upgradable
+	listTimeout = 2 * time.Minute
+)
+
+// Scanner handles APT update scanning
+type Scanner struct {
+	mu          sync.Mutex
+	lastRefresh time.Time
+}
+
+// NewScanner creates a new APT scanner
+func NewScanner() *Scanner {
+	return &Scanner{}
+}
+
+// Update represents an available update from APT
+type Update struct {
+	PackageName      string
+	Suite            string
+	InstalledVersion string
+	AvailableVersion string
+	Architecture     string
+}
+
+// ScanUpdates refreshes stale package lists and parses apt list --upgradable
+func (s *Scanner) ScanUpdates() ([]Update, error) {
+	s.refresh()
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "apt", "list", "--upgradable")
+	cmd.Env = nonInteractiveEnv()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("apt list timed out after %v", listTimeout)
+		}
+		return nil, fmt.Errorf("apt list failed: %w (stderr: %s)", err, stderr.String())
+	}
+
+	return parseAptOutput(stdout.String()), nil
+}
+
+// refresh runs apt-get update at most once per RefreshInterval so we don't report stale results.
+// This needs root; if it fails or times out we still report what the cached lists know about.
+func (s *Scanner) refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.lastRefresh.IsZero() && time.Since(s.lastRefresh) < RefreshInterval {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "apt-get", "update", "-q")
+	cmd.Env = nonInteractiveEnv()
+	_ = cmd.Run()
+	s.lastRefresh = time.Now()
+}
+
+// parseAptOutput parses the output of apt list --upgradable
+func parseAptOutput(output string) []Update {
+	var updates []Update
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		update := parseUpdateLine(scanner.Text())
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+
+	return updates
+}
+
+// parseUpdateLine parses a single line from apt list --upgradable output
+// Format: name/suite[,suite...] available-version arch [upgradable from: installed-version]
+func parseUpdateLine(line string) *Update {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "Listing...") || strings.HasPrefix(line, "WARNING:") {
+		return nil
+	}
+
+	const marker = "[upgradable from:"
+	markerIdx := strings.Index(line, marker)
+	if markerIdx < 0 {
+		return nil
+	}
+
+	installedVersion := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line[markerIdx+len(marker):]), "]"))
+
+	fields := strings.Fields(line[:markerIdx])
+	if len(fields) != 3 {
+		return nil
+	}
+
+	name, suite, ok := strings.Cut(fields[0], "/")
+	if !ok || name == "" {
+		return nil
+	}
+
+	if installedVersion == "" || fields[1] == "" {
+		return nil
+	}
+
+	return &Update{
+		PackageName:      name,
+		Suite:            suite,
+		AvailableVersion: fields[1],
+		Architecture:     fields[2],
+		InstalledVersion: installedVersion,
+	}
+}
+
+// ToAPIUpdates converts scanner updates to API update items
+func ToAPIUpdates(updates []Update) []api.UpdateItem {
+	items := make([]api.UpdateItem, len(updates))
+	for i, u := range updates {
+		installed := u.InstalledVersion
+		items[i] = api.UpdateItem{
+			PackageIdentifier: u.PackageName,
+			PackageName:       u.PackageName,
+			InstalledVersion:  &installed,
+			AvailableVersion:  u.AvailableVersion,
+			Source:            SourceName,
+		}
+	}
+	return items
+}
+
+// nonInteractiveEnv returns the environment for apt commands that must never prompt
+func nonInteractiveEnv() []string {
+	return append(os.Environ(),
+		"DEBIAN_FRONTEND=noninteractive",
+		"APT_LISTCHANGES_FRONTEND=none",
+		"LC_ALL=C",
+	)
+}
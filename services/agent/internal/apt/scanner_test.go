@@ -0,0 +1,45 @@
+package apt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAptOutput(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []Update
+	}{
+		{
+			fixture: "list-upgradable.txt",
+			want: []Update{
+				{PackageName: "base-files", Suite: "jammy-updates", InstalledVersion: "12ubuntu4.4", AvailableVersion: "12ubuntu4.6", Architecture: "amd64"},
+				{PackageName: "firefox", Suite: "jammy-updates,jammy-security", InstalledVersion: "1:1snap1-0ubuntu1", AvailableVersion: "1:1snap1-0ubuntu2", Architecture: "amd64"},
+				{PackageName: "libc6", Suite: "jammy-updates,jammy-security", InstalledVersion: "2.35-0ubuntu3.6", AvailableVersion: "2.35-0ubuntu3.8", Architecture: "amd64"},
+				{PackageName: "linux-firmware", Suite: "jammy-updates", InstalledVersion: "20220329.git681281e4-0ubuntu3.29", AvailableVersion: "20220329.git681281e4-0ubuntu3.31", Architecture: "all"},
+				{PackageName: "python3.10", Suite: "jammy-updates", InstalledVersion: "3.10.12-1~22.04.5", AvailableVersion: "3.10.12-1~22.04.6", Architecture: "amd64"},
+			},
+		},
+		{
+			fixture: "list-upgradable-noise.txt",
+			want: []Update{
+				{PackageName: "tzdata", Suite: "jammy-updates", InstalledVersion: "2023c-0ubuntu0.22.04.2", AvailableVersion: "2024a-0ubuntu0.22.04", Architecture: "all"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := parseAptOutput(string(data))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAptOutput() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// Package backoff computes retry delays shared by the agent's reconnect and replay loops.
+package backoff
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff computes retry delays using exponential backoff with full jitter,
+// so agents disconnected together don't retry together
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// Delay returns a random delay in [0, min(Max, Min*2^attempt)]
+func (b *Backoff) Delay(attempt int) time.Duration {
+	ceiling := b.Max
+	if attempt < 32 {
+		if d := b.Min << uint(attempt); d > 0 && d < b.Max {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rand == nil {
+		b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return time.Duration(b.rand.Int63n(int64(ceiling) + 1))
+}
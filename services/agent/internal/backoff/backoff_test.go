@@ -0,0 +1,27 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayStaysWithinCeiling(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: 10 * time.Second}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := b.Delay(tt.attempt); d < 0 || d > tt.ceiling {
+				t.Fatalf("Delay(%d) = %v, want within [0, %v]", tt.attempt, d, tt.ceiling)
+			}
+		}
+	}
+}
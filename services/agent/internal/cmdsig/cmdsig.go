@@ -0,0 +1,348 @@
+package cmdsig
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// Domain prefixes keep command and rollover signatures from being swapped
+const (
+	commandDomain  = "lunaris-command-v2"
+	rolloverDomain = "lunaris-key-rollover-v1"
+)
+
+const (
+	// MaxCommandAge is how long a command without an expiry stays valid after it was issued
+	MaxCommandAge = 24 * time.Hour
+
+	// maxClockSkew is how far in the future a command may have been issued,
+	// allowing for the console's clock to be ahead
+	maxClockSkew = 5 * time.Minute
+)
+
+var (
+	// ErrUnsigned is returned for commands without a signature
+	ErrUnsigned = errors.New("command is not signed")
+
+	// ErrUnknownKey is returned when a signature names a key that isn't trusted
+	ErrUnknownKey = errors.New("command signed with an untrusted key")
+
+	// ErrBadSignature is returned when a signature doesn't match the command
+	ErrBadSignature = errors.New("command signature is invalid")
+
+	// ErrExpired is returned for signed commands outside their validity period,
+	// so a captured command can't be replayed later
+	ErrExpired = errors.New("command has expired")
+)
+
+// KeyRollover introduces a new console signing key. It must be signed by a key
+// that is already trusted, and may retire an old key at the same time.
+type KeyRollover struct {
+	KeyID       string `json:"keyId"`
+	PublicKey   string `json:"publicKey"`
+	RetireKeyID string `json:"retireKeyId,omitempty"`
+	SignedBy    string `json:"signedBy"`
+	Signature   string `json:"signature"`
+}
+
+// Validate implements api.PayloadValidator
+func (r KeyRollover) Validate() error {
+	switch {
+	case r.KeyID == "":
+		return &api.PayloadError{Code: api.FailureInvalidPayload, Field: "keyId", Reason: "required"}
+	case r.PublicKey == "":
+		return &api.PayloadError{Code: api.FailureInvalidPayload, Field: "publicKey", Reason: "required"}
+	case r.SignedBy == "" || r.Signature == "":
+		return &api.PayloadError{Code: api.FailureInvalidPayload, Field: "signature", Reason: "required"}
+	}
+	return nil
+}
+
+// keyringFile is the persisted set of trusted keys after rollovers
+type keyringFile struct {
+	Keys      map[string]string `json:"keys"`
+	Retired   []string          `json:"retired,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// Keyring holds the pinned console public keys commands are verified against
+type Keyring struct {
+	mu      sync.RWMutex
+	path    string
+	keys    map[string]ed25519.PublicKey
+	pinned  map[string]bool
+	retired map[string]bool
+	now     func() time.Time
+}
+
+// Open builds a keyring from the pinned keys in config (key ID to base64 Ed25519 key),
+// merged with keys added or retired by rollovers stored at path. Pinned keys always win:
+// stored rollovers can't retire or replace them.
+func Open(path string, pinned map[string]string) (*Keyring, error) {
+	k := &Keyring{
+		path:    path,
+		keys:    make(map[string]ed25519.PublicKey),
+		pinned:  make(map[string]bool),
+		retired: make(map[string]bool),
+		now:     time.Now,
+	}
+
+	var stored keyringFile
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read console keys: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return nil, fmt.Errorf("decode console keys: %w", err)
+			}
+		}
+	}
+
+	for id, encoded := range pinned {
+		pub, err := parsePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("console key %s: %w", id, err)
+		}
+		k.keys[id] = pub
+		k.pinned[id] = true
+	}
+	for _, id := range stored.Retired {
+		if !k.pinned[id] {
+			k.retired[id] = true
+		}
+	}
+	for id, encoded := range stored.Keys {
+		if k.pinned[id] || k.retired[id] {
+			continue
+		}
+		pub, err := parsePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("console key %s: %w", id, err)
+		}
+		k.keys[id] = pub
+	}
+
+	return k, nil
+}
+
+// SetClock sets the clock commands are checked for expiry against, e.g. one
+// following the server's time
+func (k *Keyring) SetClock(now func() time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.now = now
+}
+
+// Enabled reports whether any key is trusted; without keys commands can't be verified
+func (k *Keyring) Enabled() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys) > 0
+}
+
+// KeyIDs returns the trusted key IDs
+func (k *Keyring) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// VerifyCommand checks the detached signature of a command addressed to deviceID
+// and that the command is within its validity period
+func (k *Keyring) VerifyCommand(deviceID string, cmd *api.Command) error {
+	if cmd.Signature == "" {
+		return ErrUnsigned
+	}
+	message, err := CommandMessage(deviceID, cmd)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	if err := k.verify(cmd.KeyID, message, cmd.Signature); err != nil {
+		return err
+	}
+	return k.checkValidity(cmd)
+}
+
+// checkValidity rejects commands issued in the future or past their expiry.
+// Commands without an expiry are valid for MaxCommandAge.
+func (k *Keyring) checkValidity(cmd *api.Command) error {
+	k.mu.RLock()
+	now := k.now()
+	k.mu.RUnlock()
+
+	issued, err := time.Parse(time.RFC3339, cmd.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("%w: no valid issue time", ErrExpired)
+	}
+	expires := issued.Add(MaxCommandAge)
+	if cmd.ExpiresAt != "" {
+		if expires, err = time.Parse(time.RFC3339, cmd.ExpiresAt); err != nil {
+			return fmt.Errorf("%w: invalid expiry %q", ErrExpired, cmd.ExpiresAt)
+		}
+	}
+
+	if issued.After(now.Add(maxClockSkew)) {
+		return fmt.Errorf("%w: issued in the future at %s", ErrExpired, cmd.CreatedAt)
+	}
+	if !now.Before(expires) {
+		return fmt.Errorf("%w: expired at %s", ErrExpired, expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ApplyRollover trusts the key introduced by a rollover signed with a trusted key
+func (k *Keyring) ApplyRollover(r *KeyRollover) error {
+	if err := k.verify(r.SignedBy, RolloverMessage(r), r.Signature); err != nil {
+		return err
+	}
+
+	pub, err := parsePublicKey(r.PublicKey)
+	if err != nil {
+		return fmt.Errorf("new console key: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.retired[r.KeyID] {
+		return fmt.Errorf("console key %s was retired and can't be trusted again", r.KeyID)
+	}
+	if k.pinned[r.KeyID] {
+		return fmt.Errorf("console key %s is pinned in the configuration and can't be replaced", r.KeyID)
+	}
+	if k.pinned[r.RetireKeyID] {
+		return fmt.Errorf("console key %s is pinned in the configuration; remove it there to retire it", r.RetireKeyID)
+	}
+	if r.RetireKeyID == r.KeyID {
+		return fmt.Errorf("rollover can't retire the key it introduces")
+	}
+
+	k.keys[r.KeyID] = pub
+	if r.RetireKeyID != "" {
+		delete(k.keys, r.RetireKeyID)
+		k.retired[r.RetireKeyID] = true
+	}
+	return k.save()
+}
+
+// verify checks a base64 signature with a trusted key
+func (k *Keyring) verify(keyID string, message []byte, signature string) error {
+	k.mu.RLock()
+	pub, ok := k.keys[keyID]
+	if !ok && keyID == "" && len(k.keys) == 1 {
+		// Single-key setups may omit the key ID
+		for _, only := range k.keys {
+			pub, ok = only, true
+		}
+	}
+	k.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(pub, message, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// save persists the trusted and retired keys. Callers must hold k.mu.
+func (k *Keyring) save() error {
+	if k.path == "" {
+		return nil
+	}
+
+	stored := keyringFile{Keys: make(map[string]string), UpdatedAt: time.Now().UTC()}
+	for id, pub := range k.keys {
+		stored.Keys[id] = base64.StdEncoding.EncodeToString(pub)
+	}
+	for id := range k.retired {
+		stored.Retired = append(stored.Retired, id)
+	}
+	sort.Strings(stored.Retired)
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode console keys: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return fmt.Errorf("create console keys directory: %w", err)
+	}
+
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write console keys: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace console keys: %w", err)
+	}
+	return nil
+}
+
+// CommandMessage returns the bytes the console signs for a command:
+// the domain, command ID, target device, type, schema version, issue and expiry times,
+// and SHA-256 hashes of the package list and the compacted payload, one per line
+func CommandMessage(deviceID string, cmd *api.Command) ([]byte, error) {
+	var payload bytes.Buffer
+	if len(cmd.Payload) > 0 && !bytes.Equal(bytes.TrimSpace(cmd.Payload), []byte("null")) {
+		if err := json.Compact(&payload, cmd.Payload); err != nil {
+			return nil, fmt.Errorf("compact payload: %w", err)
+		}
+	}
+
+	packages := sha256.Sum256([]byte(strings.Join(cmd.PackageIdentifiers, "\n")))
+	payloadSum := sha256.Sum256(payload.Bytes())
+
+	return []byte(strings.Join([]string{
+		commandDomain,
+		cmd.ID,
+		deviceID,
+		cmd.Type,
+		strconv.Itoa(cmd.SchemaVersion),
+		cmd.CreatedAt,
+		cmd.ExpiresAt,
+		hex.EncodeToString(packages[:]),
+		hex.EncodeToString(payloadSum[:]),
+	}, "\n")), nil
+}
+
+// RolloverMessage returns the bytes signed for a key rollover
+func RolloverMessage(r *KeyRollover) []byte {
+	return []byte(strings.Join([]string{rolloverDomain, r.KeyID, r.PublicKey, r.RetireKeyID}, "\n"))
+}
+
+// parsePublicKey decodes a base64 Ed25519 public key
+func parsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected a base64 Ed25519 public key")
+	}
+	return raw, nil
+}
@@ -0,0 +1,284 @@
+package cmdsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// testKey is a console signing key
+type testKey struct {
+	id   string
+	pub  string
+	priv ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T, id string) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{id: id, pub: base64.StdEncoding.EncodeToString(pub), priv: priv}
+}
+
+func (k testKey) sign(message []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(k.priv, message))
+}
+
+// signCommand signs cmd for device-1 the way the console does
+func (k testKey) signCommand(t *testing.T, cmd *api.Command) {
+	t.Helper()
+	message, err := CommandMessage("device-1", cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.KeyID = k.id
+	cmd.Signature = k.sign(message)
+}
+
+// rollover introduces next, signed by k
+func (k testKey) rollover(next testKey, retire string) *KeyRollover {
+	r := &KeyRollover{KeyID: next.id, PublicKey: next.pub, RetireKeyID: retire, SignedBy: k.id}
+	r.Signature = k.sign(RolloverMessage(r))
+	return r
+}
+
+var issued = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newCommand() *api.Command {
+	return &api.Command{
+		ID:                 "cmd-1",
+		Type:               "install",
+		PackageIdentifiers: []string{"Git.Git"},
+		Payload:            json.RawMessage(`{ "scope": "machine" }`),
+		CreatedAt:          issued.Format(time.RFC3339),
+	}
+}
+
+func openKeyring(t *testing.T, path string, keys ...testKey) *Keyring {
+	t.Helper()
+	pinned := make(map[string]string)
+	for _, k := range keys {
+		pinned[k.id] = k.pub
+	}
+	keyring, err := Open(path, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring.SetClock(func() time.Time { return issued.Add(time.Minute) })
+	return keyring
+}
+
+func TestVerifyCommand(t *testing.T) {
+	key := newTestKey(t, "console-1")
+	other := newTestKey(t, "console-2")
+
+	tests := []struct {
+		name   string
+		modify func(cmd *api.Command)
+		want   error
+	}{
+		{"valid", func(cmd *api.Command) { key.signCommand(t, cmd) }, nil},
+		{"payload whitespace", func(cmd *api.Command) {
+			key.signCommand(t, cmd)
+			cmd.Payload = json.RawMessage(`{"scope":"machine"}`)
+		}, nil},
+		{"unsigned", func(cmd *api.Command) {}, ErrUnsigned},
+		{"untrusted key", func(cmd *api.Command) { other.signCommand(t, cmd) }, ErrUnknownKey},
+		{"wrong key ID", func(cmd *api.Command) {
+			other.signCommand(t, cmd)
+			cmd.KeyID = key.id
+		}, ErrBadSignature},
+		{"garbage signature", func(cmd *api.Command) {
+			key.signCommand(t, cmd)
+			cmd.Signature = "not base64!"
+		}, ErrBadSignature},
+		{"other package", func(cmd *api.Command) {
+			key.signCommand(t, cmd)
+			cmd.PackageIdentifiers = []string{"Evil.Package"}
+		}, ErrBadSignature},
+		{"other payload", func(cmd *api.Command) {
+			key.signCommand(t, cmd)
+			cmd.Payload = json.RawMessage(`{"scope":"user"}`)
+		}, ErrBadSignature},
+		{"other type", func(cmd *api.Command) {
+			key.signCommand(t, cmd)
+			cmd.Type = "uninstall"
+		}, ErrBadSignature},
+		{"moved issue time", func(cmd *api.Command) {
+			key.signCommand(t, cmd)
+			cmd.CreatedAt = issued.Add(time.Hour).Format(time.RFC3339)
+		}, ErrBadSignature},
+		{"extended expiry", func(cmd *api.Command) {
+			cmd.ExpiresAt = issued.Add(30 * time.Second).Format(time.RFC3339)
+			key.signCommand(t, cmd)
+			cmd.ExpiresAt = issued.Add(time.Hour).Format(time.RFC3339)
+		}, ErrBadSignature},
+		{"expired", func(cmd *api.Command) {
+			cmd.ExpiresAt = issued.Add(30 * time.Second).Format(time.RFC3339)
+			key.signCommand(t, cmd)
+		}, ErrExpired},
+		{"within expiry", func(cmd *api.Command) {
+			cmd.ExpiresAt = issued.Add(time.Hour).Format(time.RFC3339)
+			key.signCommand(t, cmd)
+		}, nil},
+		{"older than the maximum age", func(cmd *api.Command) {
+			cmd.CreatedAt = issued.Add(-MaxCommandAge).Format(time.RFC3339)
+			key.signCommand(t, cmd)
+		}, ErrExpired},
+		{"issued in the future", func(cmd *api.Command) {
+			cmd.CreatedAt = issued.Add(time.Hour).Format(time.RFC3339)
+			key.signCommand(t, cmd)
+		}, ErrExpired},
+		{"no issue time", func(cmd *api.Command) {
+			cmd.CreatedAt = ""
+			key.signCommand(t, cmd)
+		}, ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring := openKeyring(t, "", key)
+			cmd := newCommand()
+			tt.modify(cmd)
+
+			err := keyring.VerifyCommand("device-1", cmd)
+			if tt.want == nil && err != nil {
+				t.Fatalf("VerifyCommand() = %v, want nil", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyCommand() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyCommandForOtherDevice(t *testing.T) {
+	key := newTestKey(t, "console-1")
+	keyring := openKeyring(t, "", key)
+	cmd := newCommand()
+	key.signCommand(t, cmd)
+
+	if err := keyring.VerifyCommand("device-2", cmd); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyCommand() for another device = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestSingleKeyMayOmitKeyID(t *testing.T) {
+	key := newTestKey(t, "console-1")
+	cmd := newCommand()
+	key.signCommand(t, cmd)
+	cmd.KeyID = ""
+
+	if err := openKeyring(t, "", key).VerifyCommand("device-1", cmd); err != nil {
+		t.Errorf("single key: VerifyCommand() = %v, want nil", err)
+	}
+	if err := openKeyring(t, "", key, newTestKey(t, "console-2")).VerifyCommand("device-1", cmd); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("two keys: VerifyCommand() = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyRollover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console-keys.json")
+	pinned := newTestKey(t, "console-1")
+	second := newTestKey(t, "console-2")
+	third := newTestKey(t, "console-3")
+	keyring := openKeyring(t, path, pinned)
+
+	if err := keyring.ApplyRollover(pinned.rollover(second, "")); err != nil {
+		t.Fatalf("rollover to console-2: %v", err)
+	}
+	if err := keyring.ApplyRollover(second.rollover(third, second.id)); err != nil {
+		t.Fatalf("rollover to console-3 retiring console-2: %v", err)
+	}
+
+	// The stored rollovers survive a restart
+	keyring = openKeyring(t, path, pinned)
+	if got := keyring.KeyIDs(); len(got) != 2 || got[0] != "console-1" || got[1] != "console-3" {
+		t.Fatalf("KeyIDs() after restart = %v, want [console-1 console-3]", got)
+	}
+
+	cmd := newCommand()
+	third.signCommand(t, cmd)
+	if err := keyring.VerifyCommand("device-1", cmd); err != nil {
+		t.Errorf("command signed with console-3: %v", err)
+	}
+	cmd = newCommand()
+	second.signCommand(t, cmd)
+	if err := keyring.VerifyCommand("device-1", cmd); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("command signed with retired console-2 = %v, want ErrUnknownKey", err)
+	}
+
+	// A retired key can't come back, even through a rollover signed by a trusted key
+	if err := keyring.ApplyRollover(third.rollover(second, "")); err == nil {
+		t.Error("retired console-2 was trusted again")
+	}
+}
+
+func TestKeyRolloverRejected(t *testing.T) {
+	pinned := newTestKey(t, "console-1")
+	next := newTestKey(t, "console-2")
+	stranger := newTestKey(t, "stranger")
+
+	tests := []struct {
+		name     string
+		rollover func() *KeyRollover
+	}{
+		{"untrusted signer", func() *KeyRollover { return stranger.rollover(next, "") }},
+		{"bad signature", func() *KeyRollover {
+			r := pinned.rollover(next, "")
+			r.PublicKey = stranger.pub
+			return r
+		}},
+		{"retires itself", func() *KeyRollover { return pinned.rollover(next, next.id) }},
+		{"replaces a pinned key", func() *KeyRollover { return pinned.rollover(testKey{id: pinned.id, pub: stranger.pub}, "") }},
+		{"retires a pinned key", func() *KeyRollover { return pinned.rollover(next, pinned.id) }},
+		{"invalid key", func() *KeyRollover { return pinned.rollover(testKey{id: next.id, pub: "short"}, "") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring := openKeyring(t, "", pinned)
+			if err := keyring.ApplyRollover(tt.rollover()); err == nil {
+				t.Fatal("ApplyRollover() succeeded")
+			}
+			if got := keyring.KeyIDs(); len(got) != 1 || got[0] != pinned.id {
+				t.Errorf("KeyIDs() = %v, want only the pinned key", got)
+			}
+		})
+	}
+}
+
+func TestPinnedKeysWinOverStoredKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console-keys.json")
+	pinned := newTestKey(t, "console-1")
+	impostor := newTestKey(t, "console-1")
+
+	// A stored file that retires the pinned key and replaces it with another
+	data, err := json.Marshal(keyringFile{Keys: map[string]string{pinned.id: impostor.pub}, Retired: []string{pinned.id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := openKeyring(t, path, pinned)
+	cmd := newCommand()
+	pinned.signCommand(t, cmd)
+	if err := keyring.VerifyCommand("device-1", cmd); err != nil {
+		t.Errorf("command signed with the pinned key: %v", err)
+	}
+	cmd = newCommand()
+	impostor.signCommand(t, cmd)
+	if err := keyring.VerifyCommand("device-1", cmd); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("command signed with the stored key = %v, want ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// ProgressReporter receives progress updates from a running handler
+type ProgressReporter func(progress api.CommandProgress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that carries a progress reporter for the handler
+func WithProgress(ctx context.Context, report ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// ReportProgress sends a progress update if the context carries a reporter
+func ReportProgress(ctx context.Context, progress api.CommandProgress) {
+	if report, ok := ctx.Value(progressKey{}).(ProgressReporter); ok && report != nil {
+		report(progress)
+	}
+}
+
+// ReportStep reports that step completed of total steps are done and names the current one
+func ReportStep(ctx context.Context, completed, total int, currentStep, packageIdentifier string) {
+	percent := 100
+	if total > 0 {
+		percent = completed * 100 / total
+	}
+	ReportProgress(ctx, api.CommandProgress{
+		Percent:           percent,
+		CurrentStep:       currentStep,
+		PackageIdentifier: packageIdentifier,
+		Completed:         completed,
+		Total:             total,
+	})
+}
@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// Result is the outcome of a command, reported back to the server on completion
+type Result struct {
+	Success bool
+	Message string
+
+	// Failure explains why the command couldn't be executed, if it never ran
+	Failure *api.CommandFailure
+}
+
+// Failed returns a result for a command that couldn't be executed
+func Failed(code, message string) Result {
+	return Result{
+		Success: false,
+		Message: message,
+		Failure: &api.CommandFailure{Code: code, Message: message},
+	}
+}
+
+// Handler executes a single command type
+type Handler interface {
+	// Type returns the command type this handler executes, e.g. "install_updates"
+	Type() string
+
+	// Handle executes the command and returns its result
+	Handle(ctx context.Context, cmd api.Command) Result
+}
+
+// Registry maps command types to their handlers
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewRegistry creates an empty command registry
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register adds a handler; registering the same command type twice is an error
+func (r *Registry) Register(h Handler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.handlers[h.Type()]; exists {
+		return fmt.Errorf("handler for command type %q already registered", h.Type())
+	}
+	r.handlers[h.Type()] = h
+	return nil
+}
+
+// Lookup returns the handler for a command type
+func (r *Registry) Lookup(commandType string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.handlers[commandType]
+	return h, ok
+}
+
+// Types returns the supported command types in sorted order
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.handlers))
+	for t := range r.handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Dispatch runs the handler registered for the command's type.
+// A panicking handler fails the command instead of crashing the agent.
+func (r *Registry) Dispatch(ctx context.Context, cmd api.Command) (result Result) {
+	h, ok := r.Lookup(cmd.Type)
+	if !ok {
+		return Failed(api.FailureUnknownCommand, fmt.Sprintf("Unknown command type: %s", cmd.Type))
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			result = Failed(api.FailureHandlerPanic, fmt.Sprintf("Handler for %s panicked: %v", cmd.Type, p))
+		}
+	}()
+
+	return h.Handle(ctx, cmd)
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// funcHandler is a Handler running a function
+type funcHandler struct {
+	commandType string
+	handle      func(ctx context.Context, cmd api.Command) Result
+}
+
+func (h funcHandler) Type() string { return h.commandType }
+
+func (h funcHandler) Handle(ctx context.Context, cmd api.Command) Result {
+	return h.handle(ctx, cmd)
+}
+
+func newRegistry(t *testing.T, handlers ...Handler) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	for _, h := range handlers {
+		if err := r.Register(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestDispatch(t *testing.T) {
+	r := newRegistry(t,
+		funcHandler{"scan", func(ctx context.Context, cmd api.Command) Result {
+			return Result{Success: true, Message: "scanned " + cmd.ID}
+		}},
+		funcHandler{"panic", func(ctx context.Context, cmd api.Command) Result {
+			panic("nil map")
+		}},
+	)
+
+	tests := []struct {
+		name        string
+		cmd         api.Command
+		success     bool
+		failureCode string
+		message     string
+	}{
+		{"registered", api.Command{ID: "cmd-1", Type: "scan"}, true, "", "scanned cmd-1"},
+		{"unknown type", api.Command{ID: "cmd-2", Type: "reboot"}, false, api.FailureUnknownCommand, "Unknown command type: reboot"},
+		{"empty type", api.Command{ID: "cmd-3"}, false, api.FailureUnknownCommand, "Unknown command type"},
+		{"handler panics", api.Command{ID: "cmd-4", Type: "panic"}, false, api.FailureHandlerPanic, "Handler for panic panicked: nil map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := r.Dispatch(context.Background(), tt.cmd)
+			if result.Success != tt.success {
+				t.Errorf("Dispatch() success = %v, want %v", result.Success, tt.success)
+			}
+			if !strings.Contains(result.Message, tt.message) {
+				t.Errorf("Dispatch() message = %q, want %q", result.Message, tt.message)
+			}
+			if tt.failureCode == "" {
+				if result.Failure != nil {
+					t.Errorf("Dispatch() failure = %+v, want none", result.Failure)
+				}
+				return
+			}
+			if result.Failure == nil || result.Failure.Code != tt.failureCode {
+				t.Errorf("Dispatch() failure = %+v, want code %s", result.Failure, tt.failureCode)
+			}
+		})
+	}
+}
+
+func TestDispatchKeepsWorkingAfterPanic(t *testing.T) {
+	calls := 0
+	r := newRegistry(t, funcHandler{"flaky", func(ctx context.Context, cmd api.Command) Result {
+		calls++
+		if calls == 1 {
+			panic("first call")
+		}
+		return Result{Success: true}
+	}})
+
+	if result := r.Dispatch(context.Background(), api.Command{Type: "flaky"}); result.Success {
+		t.Fatal("panicking handler succeeded")
+	}
+	if result := r.Dispatch(context.Background(), api.Command{Type: "flaky"}); !result.Success {
+		t.Errorf("second Dispatch() = %+v, want success", result)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	r := newRegistry(t, funcHandler{commandType: "scan"})
+	if err := r.Register(funcHandler{commandType: "scan"}); err == nil {
+		t.Error("Register() accepted a second handler for scan")
+	}
+	if err := r.Register(funcHandler{commandType: "install"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(r.Types(), " "); got != "install scan" {
+		t.Errorf("Types() = %s, want install scan", got)
+	}
+}
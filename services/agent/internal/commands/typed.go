@@ -0,0 +1,182 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// DecodeFunc extracts a typed payload from a command
+type DecodeFunc[T any] func(cmd api.Command) (T, error)
+
+// HandleFunc executes a command with its decoded payload
+type HandleFunc[T any] func(ctx context.Context, cmd api.Command, payload T) Result
+
+// TypedHandler is a Handler that decodes its payload before running
+type TypedHandler[T any] struct {
+	commandType string
+	decode      DecodeFunc[T]
+	handle      HandleFunc[T]
+}
+
+// NewTypedHandler creates a handler for commandType that decodes the payload with decode.
+// A nil decode uses DecodeJSON.
+func NewTypedHandler[T any](commandType string, decode DecodeFunc[T], handle HandleFunc[T]) *TypedHandler[T] {
+	if decode == nil {
+		decode = DecodeJSON[T]
+	}
+	return &TypedHandler[T]{
+		commandType: commandType,
+		decode:      decode,
+		handle:      handle,
+	}
+}
+
+// Type returns the command type
+func (h *TypedHandler[T]) Type() string {
+	return h.commandType
+}
+
+// Handle decodes the payload and runs the handler, failing the command if decoding fails
+func (h *TypedHandler[T]) Handle(ctx context.Context, cmd api.Command) Result {
+	payload, err := h.decode(cmd)
+	if err != nil {
+		message := fmt.Sprintf("Invalid %s payload: %v", h.commandType, err)
+
+		var payloadErr *api.PayloadError
+		if errors.As(err, &payloadErr) {
+			return Result{Success: false, Message: message, Failure: payloadErr.Failure()}
+		}
+		return Failed(api.FailureInvalidPayload, message)
+	}
+	return h.handle(ctx, cmd, payload)
+}
+
+// DecodeJSON decodes the command's JSON payload into T
+func DecodeJSON[T any](cmd api.Command) (T, error) {
+	var payload T
+	err := cmd.DecodePayload(&payload)
+	return payload, err
+}
+
+// InstallUpdatesPayload is the payload of an install_updates command
+type InstallUpdatesPayload struct {
+	PackageIdentifiers []string `json:"packageIdentifiers,omitempty"`
+
+	// Packages names the update source of each package, for identifiers several sources report
+	Packages []PackageRef `json:"packages,omitempty"`
+
+	// packagesFirst records that the payload listed Packages before PackageIdentifiers
+	packagesFirst bool
+}
+
+// UnmarshalJSON decodes the payload, rejecting unknown fields, and remembers
+// the order of the two package lists so Refs keeps the console's order
+func (p *InstallUpdatesPayload) UnmarshalJSON(data []byte) error {
+	type plain InstallUpdatesPayload
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var decoded plain
+	if err := decoder.Decode(&decoded); err != nil {
+		return err
+	}
+	*p = InstallUpdatesPayload(decoded)
+	p.packagesFirst = firstKey(data, "packages", "packageIdentifiers") == "packages"
+	return nil
+}
+
+// firstKey returns whichever of keys appears first in the JSON object data, or ""
+func firstKey(data []byte, keys ...string) string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return ""
+	}
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		for _, key := range keys {
+			if tok == key {
+				return key
+			}
+		}
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// PackageRef is a package to install and, optionally, the update source to install it from
+type PackageRef struct {
+	PackageIdentifier string `json:"packageIdentifier"`
+	Source            string `json:"source,omitempty"`
+}
+
+// Validate checks that at least one non-empty package identifier was given
+func (p *InstallUpdatesPayload) Validate() error {
+	if len(p.PackageIdentifiers) == 0 && len(p.Packages) == 0 {
+		return &api.PayloadError{Code: api.FailureInvalidPayload, Field: "packageIdentifiers", Reason: "at least one package identifier is required"}
+	}
+	for i, id := range p.PackageIdentifiers {
+		if id == "" {
+			return &api.PayloadError{Code: api.FailureInvalidPayload, Field: fmt.Sprintf("packageIdentifiers[%d]", i), Reason: "must not be empty"}
+		}
+	}
+	for i, pkg := range p.Packages {
+		if pkg.PackageIdentifier == "" {
+			return &api.PayloadError{Code: api.FailureInvalidPayload, Field: fmt.Sprintf("packages[%d].packageIdentifier", i), Reason: "must not be empty"}
+		}
+	}
+	return nil
+}
+
+// Refs returns every package to install in the order the payload listed them
+func (p *InstallUpdatesPayload) Refs() []PackageRef {
+	refs := make([]PackageRef, 0, len(p.PackageIdentifiers)+len(p.Packages))
+	if p.packagesFirst {
+		refs = append(refs, p.Packages...)
+	}
+	for _, id := range p.PackageIdentifiers {
+		refs = append(refs, PackageRef{PackageIdentifier: id})
+	}
+	if !p.packagesFirst {
+		refs = append(refs, p.Packages...)
+	}
+	return refs
+}
+
+// DecodeInstallUpdates reads the package list of an install_updates command,
+// from the payload if present or from the legacy PackageIdentifiers field
+func DecodeInstallUpdates(cmd api.Command) (InstallUpdatesPayload, error) {
+	if cmd.HasPayload() || cmd.SchemaVersion > 0 {
+		return DecodeJSON[InstallUpdatesPayload](cmd)
+	}
+
+	payload := InstallUpdatesPayload{PackageIdentifiers: cmd.PackageIdentifiers}
+	if err := payload.Validate(); err != nil {
+		return InstallUpdatesPayload{}, err
+	}
+	return payload, nil
+}
+
+// NoPayload is the payload of commands that take no parameters
+type NoPayload struct{}
+
+// DecodeNoPayload accepts commands without a payload or with an empty object
+func DecodeNoPayload(cmd api.Command) (NoPayload, error) {
+	if !cmd.HasPayload() {
+		if cmd.SchemaVersion > api.CommandSchemaVersion {
+			return NoPayload{}, cmd.DecodePayload(&NoPayload{})
+		}
+		return NoPayload{}, nil
+	}
+	return DecodeJSON[NoPayload](cmd)
+}
@@ -0,0 +1,225 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// fieldPath drops the array indexes newer Go versions put in JSON type error fields,
+// e.g. "packages.0.source" becomes "packages.source"
+func fieldPath(field string) string {
+	var parts []string
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func TestDecodeInstallUpdatesPayloadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		cmd   api.Command
+		code  string
+		field string
+	}{
+		{
+			name:  "unknown field",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":["Git.Git"],"force":true}`)},
+			code:  api.FailureInvalidPayload,
+			field: "force",
+		},
+		{
+			name:  "unknown field of a package",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packages":[{"packageIdentifier":"Git.Git","scope":"user"}]}`)},
+			code:  api.FailureInvalidPayload,
+			field: "scope",
+		},
+		{
+			name:  "identifiers not a list",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":"Git.Git"}`)},
+			code:  api.FailureInvalidPayload,
+			field: "packageIdentifiers",
+		},
+		{
+			name:  "identifier not a string",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":[42]}`)},
+			code:  api.FailureInvalidPayload,
+			field: "packageIdentifiers",
+		},
+		{
+			name:  "source not a string",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packages":[{"packageIdentifier":"Git.Git","source":1}]}`)},
+			code:  api.FailureInvalidPayload,
+			field: "packages.source",
+		},
+		{
+			name: "missing payload",
+			cmd:  api.Command{SchemaVersion: 1},
+			code: api.FailureInvalidPayload,
+		},
+		{
+			name: "null payload",
+			cmd:  api.Command{SchemaVersion: 1, Payload: json.RawMessage(`null`)},
+			code: api.FailureInvalidPayload,
+		},
+		{
+			name:  "no packages",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{}`)},
+			code:  api.FailureInvalidPayload,
+			field: "packageIdentifiers",
+		},
+		{
+			name:  "empty identifier",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":["Git.Git",""]}`)},
+			code:  api.FailureInvalidPayload,
+			field: "packageIdentifiers[1]",
+		},
+		{
+			name:  "empty package",
+			cmd:   api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packages":[{"source":"apt"}]}`)},
+			code:  api.FailureInvalidPayload,
+			field: "packages[0].packageIdentifier",
+		},
+		{
+			name: "trailing data",
+			cmd:  api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":["Git.Git"]} {}`)},
+			code: api.FailureInvalidPayload,
+		},
+		{
+			name: "malformed",
+			cmd:  api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":[`)},
+			code: api.FailureInvalidPayload,
+		},
+		{
+			name: "newer schema",
+			cmd:  api.Command{SchemaVersion: api.CommandSchemaVersion + 1, Payload: json.RawMessage(`{"packageIdentifiers":["Git.Git"]}`)},
+			code: api.FailureUnsupportedSchema,
+		},
+		{
+			name:  "legacy command without packages",
+			cmd:   api.Command{},
+			code:  api.FailureInvalidPayload,
+			field: "packageIdentifiers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := DecodeInstallUpdates(tt.cmd)
+			var payloadErr *api.PayloadError
+			if !errors.As(err, &payloadErr) {
+				t.Fatalf("DecodeInstallUpdates() = %+v, %v; want a *api.PayloadError", payload, err)
+			}
+			if payloadErr.Code != tt.code || fieldPath(payloadErr.Field) != tt.field {
+				t.Errorf("DecodeInstallUpdates() error = code %q field %q, want code %q field %q",
+					payloadErr.Code, payloadErr.Field, tt.code, tt.field)
+			}
+		})
+	}
+}
+
+func TestInstallUpdatesRefsKeepOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  api.Command
+		want []PackageRef
+	}{
+		{
+			name: "legacy command",
+			cmd:  api.Command{PackageIdentifiers: []string{"Mozilla.Firefox", "Git.Git"}},
+			want: []PackageRef{{PackageIdentifier: "Mozilla.Firefox"}, {PackageIdentifier: "Git.Git"}},
+		},
+		{
+			name: "identifiers",
+			cmd:  api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":["vim","code","firefox"]}`)},
+			want: []PackageRef{{PackageIdentifier: "vim"}, {PackageIdentifier: "code"}, {PackageIdentifier: "firefox"}},
+		},
+		{
+			name: "packages",
+			cmd:  api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"packages":[{"packageIdentifier":"vim","source":"snap"},{"packageIdentifier":"code"}]}`)},
+			want: []PackageRef{{PackageIdentifier: "vim", Source: "snap"}, {PackageIdentifier: "code"}},
+		},
+		{
+			name: "identifiers listed first",
+			cmd: api.Command{SchemaVersion: 1, Payload: json.RawMessage(
+				`{"packageIdentifiers":["vim"],"packages":[{"packageIdentifier":"code","source":"snap"}]}`)},
+			want: []PackageRef{{PackageIdentifier: "vim"}, {PackageIdentifier: "code", Source: "snap"}},
+		},
+		{
+			name: "packages listed first",
+			cmd: api.Command{SchemaVersion: 1, Payload: json.RawMessage(
+				`{"packages":[{"packageIdentifier":"code","source":"snap"},{"packageIdentifier":"firefox","source":"apt"}],"packageIdentifiers":["vim"]}`)},
+			want: []PackageRef{{PackageIdentifier: "code", Source: "snap"}, {PackageIdentifier: "firefox", Source: "apt"}, {PackageIdentifier: "vim"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := DecodeInstallUpdates(tt.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := payload.Refs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Refs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedHandlerReportsPayloadErrors(t *testing.T) {
+	handled := false
+	h := NewTypedHandler("install_updates", DecodeInstallUpdates,
+		func(ctx context.Context, cmd api.Command, payload InstallUpdatesPayload) Result {
+			handled = true
+			return Result{Success: true}
+		})
+
+	cmd := api.Command{Type: "install_updates", SchemaVersion: 1, Payload: json.RawMessage(`{"packageIdentifiers":[1]}`)}
+	result := h.Handle(context.Background(), cmd)
+	if handled {
+		t.Fatal("handler ran with an invalid payload")
+	}
+	if result.Success || result.Failure == nil {
+		t.Fatalf("Handle() = %+v, want a failure", result)
+	}
+	if result.Failure.Code != api.FailureInvalidPayload || fieldPath(result.Failure.Field) != "packageIdentifiers" {
+		t.Errorf("Handle() failure = %+v, want invalid_payload for packageIdentifiers", result.Failure)
+	}
+}
+
+func TestDecodeNoPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  api.Command
+		code string
+	}{
+		{"no payload", api.Command{}, ""},
+		{"null payload", api.Command{SchemaVersion: 1, Payload: json.RawMessage(`null`)}, ""},
+		{"empty object", api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{}`)}, ""},
+		{"unknown field", api.Command{SchemaVersion: 1, Payload: json.RawMessage(`{"force":true}`)}, api.FailureInvalidPayload},
+		{"not an object", api.Command{SchemaVersion: 1, Payload: json.RawMessage(`[]`)}, api.FailureInvalidPayload},
+		{"newer schema", api.Command{SchemaVersion: api.CommandSchemaVersion + 1}, api.FailureUnsupportedSchema},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeNoPayload(tt.cmd)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("DecodeNoPayload() = %v", err)
+				}
+				return
+			}
+			var payloadErr *api.PayloadError
+			if !errors.As(err, &payloadErr) || payloadErr.Code != tt.code {
+				t.Errorf("DecodeNoPayload() = %v, want a %s PayloadError", err, tt.code)
+			}
+		})
+	}
+}
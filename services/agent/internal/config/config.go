@@ -2,15 +2,17 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/lunaris/agent/internal/fsacl"
 )
 
 const (
 	DefaultAPIURL        = "http://localhost:3001/api"
 	DefaultHeartbeatSec  = 30
 	DefaultUpdateScanMin = 5
-	ConfigDir            = "C:\\ProgramData\\LunarisAgent"
 	ConfigFile           = "config.json"
 )
 
@@ -19,6 +21,9 @@ type Config struct {
 	// API endpoint URL
 	APIURL string `json:"api_url"`
 
+	// API endpoint URLs in order of preference; when set, takes precedence over APIURL
+	APIURLs []string `json:"api_urls,omitempty"`
+
 	// Device ID assigned by the backend
 	DeviceID string `json:"device_id,omitempty"`
 
@@ -30,6 +35,64 @@ type Config struct {
 
 	// Enrollment secret (optional)
 	EnrollmentSecret string `json:"enrollment_secret,omitempty"`
+
+	// TLS settings for API and websocket connections
+	TLS TLSConfig `json:"tls"`
+
+	// Request signing settings for API traffic
+	Signing SigningConfig `json:"signing"`
+
+	// Proxy settings for API and websocket connections
+	Proxy ProxyConfig `json:"proxy"`
+
+	// Pinned console keys commands must be signed with, by key ID (base64 Ed25519).
+	// When empty, commands are executed without signature checks.
+	ConsoleKeys map[string]string `json:"console_keys,omitempty"`
+}
+
+// TLSConfig holds server trust and device identity settings
+type TLSConfig struct {
+	// PEM file of extra CAs to trust, e.g. a private console CA
+	CABundle string `json:"ca_bundle,omitempty"`
+
+	// Base64 SHA-256 hashes of accepted server public keys (optional)
+	PinnedKeys []string `json:"pinned_keys,omitempty"`
+
+	// Request a client certificate at registration and use it for mutual TLS
+	ClientCertificate bool `json:"client_certificate,omitempty"`
+}
+
+// ProxyConfig holds outbound proxy settings.
+// A PAC file is consulted first, then the explicit URL, then the environment.
+type ProxyConfig struct {
+	// Explicit proxy URL, e.g. http://proxy.corp:3128
+	URL string `json:"url,omitempty"`
+
+	// Path or http(s) URL of a proxy auto-config (PAC) file
+	PAC string `json:"pac,omitempty"`
+
+	// Ignore HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	IgnoreEnvironment bool `json:"ignore_environment,omitempty"`
+
+	// Basic auth credentials for proxies that require them. The agent moves the
+	// password to a protected file in the state directory on its first start.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// SigningConfig holds request signing and response verification settings
+type SigningConfig struct {
+	// "hmac-sha256" or "ed25519"; empty disables signing
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Base64 shared key for hmac-sha256, used for requests and responses
+	Secret string `json:"secret,omitempty"`
+
+	// Base64 Ed25519 public key the server signs responses with
+	ServerPublicKey string `json:"server_public_key,omitempty"`
+
+	// Maximum tolerated difference from the server clock in seconds
+	MaxClockSkewSec int `json:"max_clock_skew_sec,omitempty"`
 }
 
 // DefaultConfig returns a config with default values
@@ -41,9 +104,22 @@ func DefaultConfig() *Config {
 	}
 }
 
+// Endpoints returns the API endpoint URLs in order of preference
+func (c *Config) Endpoints() []string {
+	if len(c.APIURLs) > 0 {
+		return c.APIURLs
+	}
+	return []string{c.APIURL}
+}
+
 // ConfigPath returns the full path to the config file
 func ConfigPath() string {
-	return filepath.Join(ConfigDir, ConfigFile)
+	return filepath.Join(Directories().Config, ConfigFile)
+}
+
+// DataPath returns the path of an agent state file
+func DataPath(name string) string {
+	return filepath.Join(Directories().State, name)
 }
 
 // Load reads config from disk, returns default if not found
@@ -59,6 +135,9 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	// Older agents wrote the config readable by everyone
+	fsacl.Restrict(configPath)
+
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
@@ -78,10 +157,12 @@ func Load() (*Config, error) {
 	return &cfg, nil
 }
 
-// Save writes config to disk
+// Save writes config to disk. The file holds secrets such as the signing secret and
+// the proxy password, so it is only readable by the agent's account, and it is
+// replaced atomically so a crash never leaves a truncated config.
 func (c *Config) Save() error {
 	// Ensure config directory exists
-	if err := os.MkdirAll(ConfigDir, 0755); err != nil {
+	if err := os.MkdirAll(Directories().Config, 0755); err != nil {
 		return err
 	}
 
@@ -90,6 +171,29 @@ func (c *Config) Save() error {
 		return err
 	}
 
-	return os.WriteFile(ConfigPath(), data, 0644)
+	path := ConfigPath()
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsacl.Restrict(tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace config: %w", err)
+	}
+	return nil
 }
 
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveIsOwnerOnly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes don't apply on Windows")
+	}
+	dir := t.TempDir()
+	SetDirs(Dirs{Config: dir})
+
+	// A config written by an older agent
+	path := filepath.Join(dir, ConfigFile)
+	if err := os.WriteFile(path, []byte(`{"api_url":"https://console.example"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("config mode after Load = %v, want 0600", info.Mode().Perm())
+	}
+
+	cfg.Signing.Secret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config mode after Save = %v, want 0600", info.Mode().Perm())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary config file left behind")
+	}
+
+	saved, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Signing.Secret != cfg.Signing.Secret || saved.APIURL != "https://console.example" {
+		t.Errorf("Load() = %+v after Save", saved)
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lunaris/agent/internal/fsacl"
+)
+
+// Environment variables overriding the agent directories
+const (
+	EnvConfigDir = "LUNARIS_CONFIG_DIR"
+	EnvStateDir  = "LUNARIS_STATE_DIR"
+	EnvLogDir    = "LUNARIS_LOG_DIR"
+	EnvCacheDir  = "LUNARIS_CACHE_DIR"
+)
+
+// Dirs are the directories the agent keeps its files in
+type Dirs struct {
+	// Config holds config.json
+	Config string
+
+	// State holds credentials, keys, the command journal, the outbox and other agent state
+	State string
+
+	// Log holds agent log files
+	Log string
+
+	// Cache holds data the agent can rebuild at any time
+	Cache string
+}
+
+var (
+	dirsMu sync.RWMutex
+	dirs   = DefaultDirs()
+)
+
+// DefaultDirs returns the platform directories with environment overrides applied
+func DefaultDirs() Dirs {
+	d := platformDirs()
+	d.override(Dirs{
+		Config: os.Getenv(EnvConfigDir),
+		State:  os.Getenv(EnvStateDir),
+		Log:    os.Getenv(EnvLogDir),
+		Cache:  os.Getenv(EnvCacheDir),
+	})
+	return d
+}
+
+// override replaces the directories set in o
+func (d *Dirs) override(o Dirs) {
+	if o.Config != "" {
+		d.Config = o.Config
+	}
+	if o.State != "" {
+		d.State = o.State
+	}
+	if o.Log != "" {
+		d.Log = o.Log
+	}
+	if o.Cache != "" {
+		d.Cache = o.Cache
+	}
+}
+
+// SetDirs overrides the agent directories, e.g. from command line flags.
+// Empty fields keep the current directory.
+func SetDirs(o Dirs) {
+	dirsMu.Lock()
+	defer dirsMu.Unlock()
+	dirs.override(o)
+}
+
+// Directories returns the agent directories in use
+func Directories() Dirs {
+	dirsMu.RLock()
+	defer dirsMu.RUnlock()
+	return dirs
+}
+
+// EnsureDirs creates the state, log and cache directories.
+// The state and cache directories are only accessible by the accounts running the
+// agent; on Windows they get their own ACL rather than inheriting ProgramData's.
+func EnsureDirs() error {
+	d := Directories()
+	for _, dir := range []struct {
+		path       string
+		perm       os.FileMode
+		restricted bool
+	}{{d.State, 0700, true}, {d.Log, 0755, false}, {d.Cache, 0700, true}} {
+		if err := os.MkdirAll(dir.path, dir.perm); err != nil {
+			return fmt.Errorf("create %s: %w", dir.path, err)
+		}
+		if dir.restricted {
+			if err := fsacl.Restrict(dir.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LogPath returns the path of a file in the log directory
+func LogPath(name string) string {
+	return filepath.Join(Directories().Log, name)
+}
+
+// CachePath returns the path of a file in the cache directory
+func CachePath(name string) string {
+	return filepath.Join(Directories().Cache, name)
+}
+
+// LegacyStateDir returns where older agents kept their state files,
+// or "" if the state directory hasn't moved
+func LegacyStateDir() string {
+	legacy := legacyStateDir()
+	if legacy == "" || filepath.Clean(legacy) == filepath.Clean(Directories().State) {
+		return ""
+	}
+	return legacy
+}
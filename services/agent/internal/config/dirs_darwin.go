@@ -0,0 +1,18 @@
+//go:build darwin
+
+package config
+
+// platformDirs returns the default macOS system directories
+func platformDirs() Dirs {
+	return Dirs{
+		Config: "/Library/Application Support/LunarisAgent",
+		State:  "/Library/Application Support/LunarisAgent/state",
+		Log:    "/Library/Logs/LunarisAgent",
+		Cache:  "/Library/Caches/LunarisAgent",
+	}
+}
+
+// legacyStateDir is empty: earlier agents had no usable state directory on macOS
+func legacyStateDir() string {
+	return ""
+}
@@ -0,0 +1,18 @@
+//go:build !windows && !darwin
+
+package config
+
+// platformDirs returns the default FHS directories
+func platformDirs() Dirs {
+	return Dirs{
+		Config: "/etc/lunaris-agent",
+		State:  "/var/lib/lunaris-agent",
+		Log:    "/var/log/lunaris-agent",
+		Cache:  "/var/cache/lunaris-agent",
+	}
+}
+
+// legacyStateDir is empty: earlier agents had no usable state directory on this platform
+func legacyStateDir() string {
+	return ""
+}
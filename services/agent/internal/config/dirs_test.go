@@ -0,0 +1,106 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// restoreDirs puts back the directories in use once the test ends
+func restoreDirs(t *testing.T) {
+	saved := Directories()
+	t.Cleanup(func() {
+		dirsMu.Lock()
+		dirs = saved
+		dirsMu.Unlock()
+	})
+}
+
+func TestDefaultDirsEnvironment(t *testing.T) {
+	platform := platformDirs()
+
+	t.Setenv(EnvConfigDir, "")
+	t.Setenv(EnvStateDir, "")
+	t.Setenv(EnvLogDir, "")
+	t.Setenv(EnvCacheDir, "")
+	if got := DefaultDirs(); got != platform {
+		t.Errorf("DefaultDirs() without overrides = %+v, want %+v", got, platform)
+	}
+
+	t.Setenv(EnvStateDir, "/srv/lunaris/state")
+	want := platform
+	want.State = "/srv/lunaris/state"
+	if got := DefaultDirs(); got != want {
+		t.Errorf("DefaultDirs() with %s = %+v, want %+v", EnvStateDir, got, want)
+	}
+
+	t.Setenv(EnvConfigDir, "/srv/lunaris/config")
+	t.Setenv(EnvLogDir, "/srv/lunaris/log")
+	t.Setenv(EnvCacheDir, "/srv/lunaris/cache")
+	want = Dirs{
+		Config: "/srv/lunaris/config",
+		State:  "/srv/lunaris/state",
+		Log:    "/srv/lunaris/log",
+		Cache:  "/srv/lunaris/cache",
+	}
+	if got := DefaultDirs(); got != want {
+		t.Errorf("DefaultDirs() with every override = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetDirsOverridesEnvironment(t *testing.T) {
+	restoreDirs(t)
+	t.Setenv(EnvConfigDir, "/env/config")
+	t.Setenv(EnvStateDir, "/env/state")
+	t.Setenv(EnvLogDir, "")
+	t.Setenv(EnvCacheDir, "")
+	dirsMu.Lock()
+	dirs = DefaultDirs()
+	dirsMu.Unlock()
+
+	// Flags win over the environment; flags left empty keep the current directory
+	SetDirs(Dirs{State: "/flag/state", Cache: "/flag/cache"})
+	want := Dirs{
+		Config: "/env/config",
+		State:  "/flag/state",
+		Log:    platformDirs().Log,
+		Cache:  "/flag/cache",
+	}
+	if got := Directories(); got != want {
+		t.Errorf("Directories() = %+v, want %+v", got, want)
+	}
+
+	if got := ConfigPath(); got != filepath.Join("/env/config", ConfigFile) {
+		t.Errorf("ConfigPath() = %s", got)
+	}
+	if got := DataPath("policy.json"); got != filepath.Join("/flag/state", "policy.json") {
+		t.Errorf("DataPath() = %s", got)
+	}
+	if got := LogPath("agent.log"); got != filepath.Join(platformDirs().Log, "agent.log") {
+		t.Errorf("LogPath() = %s", got)
+	}
+	if got := CachePath("proxy.pac"); got != filepath.Join("/flag/cache", "proxy.pac") {
+		t.Errorf("CachePath() = %s", got)
+	}
+}
+
+func TestLegacyStateDir(t *testing.T) {
+	restoreDirs(t)
+	legacy := legacyStateDir()
+	if legacy == "" {
+		if got := LegacyStateDir(); got != "" {
+			t.Errorf("LegacyStateDir() = %q on a platform without one", got)
+		}
+		return
+	}
+
+	SetDirs(Dirs{State: t.TempDir()})
+	if got := LegacyStateDir(); got != legacy {
+		t.Errorf("LegacyStateDir() = %q, want %q", got, legacy)
+	}
+
+	// State kept in the legacy directory has nowhere to move
+	SetDirs(Dirs{State: legacy})
+	if got := LegacyStateDir(); got != "" {
+		t.Errorf("LegacyStateDir() with the state directory unchanged = %q, want none", got)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// programDataDir returns the agent directory under %ProgramData%
+func programDataDir() string {
+	base := os.Getenv("ProgramData")
+	if base == "" {
+		base = `C:\ProgramData`
+	}
+	return filepath.Join(base, "LunarisAgent")
+}
+
+// platformDirs returns the default directories under %ProgramData%\LunarisAgent
+func platformDirs() Dirs {
+	root := programDataDir()
+	return Dirs{
+		Config: root,
+		State:  filepath.Join(root, "state"),
+		Log:    filepath.Join(root, "logs"),
+		Cache:  filepath.Join(root, "cache"),
+	}
+}
+
+// legacyStateDir is where agents kept their state next to config.json
+func legacyStateDir() string {
+	return programDataDir()
+}
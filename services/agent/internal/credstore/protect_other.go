@@ -0,0 +1,13 @@
+//go:build !windows
+
+package credstore
+
+// protect returns data unchanged; the credentials file relies on 0600 permissions
+func protect(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// unprotect returns data unchanged
+func unprotect(data []byte) ([]byte, error) {
+	return data, nil
+}
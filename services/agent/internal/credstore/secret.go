@@ -0,0 +1,66 @@
+package credstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Secret persists a single secret string, such as a proxy password, with the same
+// protection as the device credentials
+type Secret struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewSecret returns a secret backed by the file at path
+func NewSecret(path string) *Secret {
+	return &Secret{path: path}
+}
+
+// Load reads the secret. It returns "" without an error if none is stored.
+func (s *Secret) Load() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read %s: %w", filepath.Base(s.path), err)
+	}
+
+	plain, err := unprotect(data)
+	if err != nil {
+		return "", fmt.Errorf("decrypt %s: %w", filepath.Base(s.path), err)
+	}
+	return string(plain), nil
+}
+
+// Save replaces the secret
+func (s *Secret) Save(secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := protect([]byte(secret))
+	if err != nil {
+		return fmt.Errorf("encrypt %s: %w", filepath.Base(s.path), err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create %s directory: %w", filepath.Base(s.path), err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := writeRestricted(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", filepath.Base(s.path), err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace %s: %w", filepath.Base(s.path), err)
+	}
+	return nil
+}
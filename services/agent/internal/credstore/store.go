@@ -0,0 +1,104 @@
+package credstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lunaris/agent/internal/api"
+	"github.com/lunaris/agent/internal/fsacl"
+)
+
+// Store persists the device credentials issued at registration.
+// The file is only readable by the agent's account and, where the platform
+// supports it, encrypted at rest (DPAPI on Windows).
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New returns a store backed by the file at path
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// Load reads the stored credentials. It returns nil without an error if none are stored.
+func (s *Store) Load() (*api.Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read credentials: %w", err)
+	}
+
+	plain, err := unprotect(data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt credentials: %w", err)
+	}
+
+	var creds api.Credentials
+	if err := json.Unmarshal(plain, &creds); err != nil {
+		return nil, fmt.Errorf("decode credentials: %w", err)
+	}
+	if creds.AccessToken == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// Save replaces the stored credentials
+func (s *Store) Save(creds *api.Credentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plain, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("encode credentials: %w", err)
+	}
+
+	data, err := protect(plain)
+	if err != nil {
+		return fmt.Errorf("encrypt credentials: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create credentials directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := writeRestricted(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace credentials: %w", err)
+	}
+	return nil
+}
+
+// Clear removes the stored credentials
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove credentials: %w", err)
+	}
+	return nil
+}
+
+// writeRestricted writes a file only the accounts running the agent can read.
+// On Windows 0600 means nothing, so the file gets an explicit ACL.
+func writeRestricted(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return fsacl.Restrict(path)
+}
@@ -0,0 +1,166 @@
+package deviceid
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fingerprintVersion prefixes the hashed input so the derivation can change later
+const fingerprintVersion = "lunaris-device-v1"
+
+// Sources of fingerprint components
+const (
+	SourceMachineID    = "machine-id"
+	SourceHardwareUUID = "smbios-uuid"
+	SourceAgentUUID    = "agent-uuid"
+)
+
+// Fingerprint identifies a machine independently of its network interfaces.
+// Components are hashed so raw hardware identifiers never leave the device.
+type Fingerprint struct {
+	// ID is derived from the machine ID and SMBIOS UUID, or from the
+	// agent UUID when neither can be read
+	ID string `json:"id"`
+
+	// Components maps each source that was read to the hash of its value
+	Components map[string]string `json:"components"`
+}
+
+// Collector reads the fingerprint components of the local machine
+type Collector struct {
+	// Root is prepended to system paths such as /etc/machine-id; empty means the real root
+	Root string
+
+	// AgentIDPath stores the random agent UUID generated on first use
+	AgentIDPath string
+}
+
+// Collect computes the device fingerprint. Missing components are skipped;
+// only failing to read or create the agent UUID is an error.
+func (c *Collector) Collect() (*Fingerprint, error) {
+	agentUUID, err := c.agentUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{
+		SourceMachineID:    c.machineID(),
+		SourceHardwareUUID: c.hardwareUUID(),
+		SourceAgentUUID:    agentUUID,
+	}
+
+	fp := &Fingerprint{Components: make(map[string]string)}
+	for source, value := range values {
+		if value != "" {
+			fp.Components[source] = hash(source, value)
+		}
+	}
+
+	// The agent UUID changes on reinstall, so it only identifies machines with nothing better
+	var parts []string
+	for _, source := range []string{SourceMachineID, SourceHardwareUUID} {
+		if values[source] != "" {
+			parts = append(parts, source+"="+values[source])
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, SourceAgentUUID+"="+agentUUID)
+	}
+	fp.ID = hash("", strings.Join(parts, "\n"))
+	return fp, nil
+}
+
+// path returns a system path under c.Root
+func (c *Collector) path(name string) string {
+	if c.Root == "" {
+		return name
+	}
+	return filepath.Join(c.Root, filepath.FromSlash(name))
+}
+
+// agentUUID returns the persisted agent UUID, creating it on first use
+func (c *Collector) agentUUID() (string, error) {
+	data, err := os.ReadFile(c.AgentIDPath)
+	if err == nil {
+		if id := normalizeUUID(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("read agent UUID: %w", err)
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.AgentIDPath), 0700); err != nil {
+		return "", fmt.Errorf("create agent UUID directory: %w", err)
+	}
+	tmp := c.AgentIDPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(id+"\n"), 0600); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("write agent UUID: %w", err)
+	}
+	if err := os.Rename(tmp, c.AgentIDPath); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("replace agent UUID: %w", err)
+	}
+	return id, nil
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate agent UUID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32], nil
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// placeholderUUIDs are SMBIOS UUIDs firmware vendors ship on many machines
+var placeholderUUIDs = map[string]bool{
+	"00000000-0000-0000-0000-000000000000": true,
+	"ffffffff-ffff-ffff-ffff-ffffffffffff": true,
+	"03000200-0400-0500-0006-000700080009": true,
+	"12345678-1234-5678-90ab-cddeefaabbcc": true,
+}
+
+// normalizeUUID lowercases a UUID, dropping braces, and returns "" if it isn't
+// a UUID or is a known placeholder
+func normalizeUUID(s string) string {
+	s = strings.ToLower(strings.Trim(strings.TrimSpace(s), "{}"))
+	if !uuidPattern.MatchString(s) || placeholderUUIDs[s] {
+		return ""
+	}
+	return s
+}
+
+// normalizeMachineID returns a 32 hex digit machine ID, or "" if s isn't one
+func normalizeMachineID(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) != 32 || strings.Trim(s, "0") == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// hash returns the hex SHA-256 of a component value
+func hash(source, value string) string {
+	sum := sha256.Sum256([]byte(fingerprintVersion + "\n" + source + "\n" + value))
+	return hex.EncodeToString(sum[:])
+}
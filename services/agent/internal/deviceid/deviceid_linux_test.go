@@ -0,0 +1,96 @@
+package deviceid
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// collect fingerprints a fake root under testdata with a fresh agent UUID
+func collect(t *testing.T, root string) *Fingerprint {
+	t.Helper()
+	c := &Collector{
+		Root:        filepath.Join("testdata", root),
+		AgentIDPath: filepath.Join(t.TempDir(), "agent-id"),
+	}
+	fp, err := c.Collect()
+	if err != nil {
+		t.Fatalf("Collect(%s) error = %v", root, err)
+	}
+	return fp
+}
+
+func TestCollectFromSystemTrees(t *testing.T) {
+	tests := []struct {
+		root    string
+		sources []string
+		id      string
+	}{
+		{
+			root:    "full",
+			sources: []string{SourceMachineID, SourceHardwareUUID, SourceAgentUUID},
+			id:      hash("", "machine-id=4c4c4544003957108052b4c04f384833\nsmbios-uuid=4c4c4544-0039-5710-8052-b4c04f384833"),
+		},
+		{
+			// /etc/machine-id isn't initialized yet; D-Bus keeps the same ID
+			root:    "dbus-only",
+			sources: []string{SourceMachineID, SourceAgentUUID},
+			id:      hash("", "machine-id=b08dfa6083e7567a1921a715000001fb"),
+		},
+		{
+			// A zeroed machine ID and a vendor placeholder UUID identify nothing
+			root:    "placeholder",
+			sources: []string{SourceAgentUUID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.root, func(t *testing.T) {
+			fp := collect(t, tt.root)
+			if len(fp.Components) != len(tt.sources) {
+				t.Errorf("components = %v, want %v", fp.Components, tt.sources)
+			}
+			for _, source := range tt.sources {
+				if fp.Components[source] == "" {
+					t.Errorf("missing %s component", source)
+				}
+			}
+			if tt.id != "" && fp.ID != tt.id {
+				t.Errorf("ID = %s, want %s", fp.ID, tt.id)
+			}
+		})
+	}
+}
+
+func TestFingerprintSurvivesReinstall(t *testing.T) {
+	// A reinstall loses the agent UUID but keeps the machine
+	if a, b := collect(t, "full"), collect(t, "full"); a.ID != b.ID {
+		t.Errorf("ID changed with the agent UUID: %s != %s", a.ID, b.ID)
+	}
+
+	// Without machine identifiers the agent UUID is all there is
+	if a, b := collect(t, "placeholder"), collect(t, "placeholder"); a.ID == b.ID {
+		t.Error("machines without identifiers share a fingerprint")
+	}
+}
+
+func TestClonedImagesDiffer(t *testing.T) {
+	// Same hardware UUID, different machine ID: e.g. VMs cloned from one template
+	full, clone := collect(t, "full"), collect(t, "clone")
+	if full.ID == clone.ID {
+		t.Error("machines with different machine IDs share a fingerprint")
+	}
+	if full.Components[SourceHardwareUUID] != clone.Components[SourceHardwareUUID] {
+		t.Error("the same SMBIOS UUID hashed differently")
+	}
+}
+
+func TestComponentsAreHashed(t *testing.T) {
+	fp := collect(t, "full")
+	for source, value := range fp.Components {
+		if len(value) != 64 {
+			t.Errorf("%s component %q is not a SHA-256 hash", source, value)
+		}
+	}
+	if got, want := fp.Components[SourceMachineID], hash(SourceMachineID, "4c4c4544003957108052b4c04f384833"); got != want {
+		t.Errorf("machine ID component = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package deviceid
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeUUID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"4C4C4544-0039-5710-8052-B4C04F384833\n", "4c4c4544-0039-5710-8052-b4c04f384833"},
+		{"{6F1A2B3C-4D5E-4F60-8172-839405A6B7C8}", "6f1a2b3c-4d5e-4f60-8172-839405a6b7c8"},
+		{"03000200-0400-0500-0006-000700080009", ""},
+		{"FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF", ""},
+		{"Not Settable", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeUUID(tt.in); got != tt.want {
+			t.Errorf("normalizeUUID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeMachineID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"4C4C4544003957108052B4C04F384833\n", "4c4c4544003957108052b4c04f384833"},
+		{"uninitialized", ""},
+		{"00000000000000000000000000000000", ""},
+		{"4c4c4544003957108052b4c04f38483", ""},
+		{"zz4c4544003957108052b4c04f384833", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeMachineID(tt.in); got != tt.want {
+			t.Errorf("normalizeMachineID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAgentUUIDPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "agent-id")
+	c := &Collector{Root: t.TempDir(), AgentIDPath: path}
+
+	first, err := c.agentUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if normalizeUUID(first) != first {
+		t.Fatalf("agentUUID() = %q, not a UUID", first)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 && os.PathSeparator == '/' {
+		t.Errorf("agent UUID mode = %v, want 0600", perm)
+	}
+
+	again, err := c.agentUUID()
+	if err != nil || again != first {
+		t.Errorf("agentUUID() = %q, %v on second use, want %q", again, err, first)
+	}
+
+	// A damaged file is replaced instead of failing every registration
+	os.WriteFile(path, []byte("garbage"), 0600)
+	replaced, err := c.agentUUID()
+	if err != nil || replaced == first || normalizeUUID(replaced) == "" {
+		t.Errorf("agentUUID() = %q, %v after corruption, want a new UUID", replaced, err)
+	}
+}
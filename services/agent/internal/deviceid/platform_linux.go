@@ -0,0 +1,34 @@
+//go:build linux
+
+package deviceid
+
+import "os"
+
+// machineIDPaths are read in order; systemd and D-Bus keep the same ID
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// hardwareUUIDPath is the SMBIOS system UUID; it is only readable by root
+const hardwareUUIDPath = "/sys/class/dmi/id/product_uuid"
+
+// machineID returns the systemd machine ID, or "" if it can't be read
+func (c *Collector) machineID() string {
+	for _, name := range machineIDPaths {
+		data, err := os.ReadFile(c.path(name))
+		if err != nil {
+			continue
+		}
+		if id := normalizeMachineID(string(data)); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// hardwareUUID returns the SMBIOS system UUID, or "" if it can't be read
+func (c *Collector) hardwareUUID() string {
+	data, err := os.ReadFile(c.path(hardwareUUIDPath))
+	if err != nil {
+		return ""
+	}
+	return normalizeUUID(string(data))
+}
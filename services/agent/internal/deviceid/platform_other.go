@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package deviceid
+
+// machineID is not available on this platform
+func (c *Collector) machineID() string {
+	return ""
+}
+
+// hardwareUUID is not available on this platform
+func (c *Collector) hardwareUUID() string {
+	return ""
+}
@@ -0,0 +1,39 @@
+//go:build windows
+
+package deviceid
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// machineID returns the MachineGuid written by Windows setup, or "" if it can't be read
+func (c *Collector) machineID() string {
+	value := readRegistryString(`SOFTWARE\Microsoft\Cryptography`, "MachineGuid")
+	if id := normalizeUUID(value); id != "" {
+		return strings.ReplaceAll(id, "-", "")
+	}
+	return ""
+}
+
+// hardwareUUID returns the SMBIOS system UUID recorded by the hardware configuration
+// profile, or "" if it can't be read
+func (c *Collector) hardwareUUID() string {
+	return normalizeUUID(readRegistryString(`SYSTEM\HardwareConfig`, "LastConfig"))
+}
+
+// readRegistryString reads a string value under HKEY_LOCAL_MACHINE, returning "" on failure
+func readRegistryString(path, name string) string {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return ""
+	}
+	defer key.Close()
+
+	value, _, err := key.GetStringValue(name)
+	if err != nil {
+		return ""
+	}
+	return value
+}
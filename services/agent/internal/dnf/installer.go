@@ -0,0 +1,113 @@
+package dnf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// InstallResult represents the result of an install operation
+type InstallResult = updates.InstallResult
+
+// Installer handles DNF/YUM package upgrades
+type Installer struct{}
+
+// NewInstaller creates a new DNF installer
+func NewInstaller() *Installer {
+	return &Installer{}
+}
+
+// packageNameRe matches an RPM name or name.arch identifier
+var packageNameRe = regexp.MustCompile(`^[A-Za-z0-9_+][A-Za-z0-9._+-]*$`)
+
+// ValidPackageName reports whether name is a package identifier dnf and yum can't mistake for an option
+func ValidPackageName(name string) bool {
+	return packageNameRe.MatchString(name)
+}
+
+// Install upgrades a single already-installed package
+func (i *Installer) Install(packageIdentifier string) *InstallResult {
+	result := &InstallResult{
+		PackageIdentifier: packageIdentifier,
+	}
+
+	if !ValidPackageName(packageIdentifier) {
+		result.Message = "Invalid package name"
+		result.Error = fmt.Errorf("invalid package name %q", packageIdentifier)
+		return result
+	}
+
+	pm, err := packageManager()
+	if err != nil {
+		result.Message = err.Error()
+		result.Error = err
+		return result
+	}
+
+	stdout, stderr, err := runPackageManager(upgradeTimeout, pm, "upgrade", "-y", "--", packageIdentifier)
+	output := stdout
+	if stderr != "" {
+		output += "\n" + stderr
+	}
+
+	result.Message = strings.TrimSpace(output)
+
+	if err != nil {
+		result.Success = false
+		result.Error = fmt.Errorf("%s upgrade failed: %w", pm, err)
+
+		// Check for common error messages
+		if strings.Contains(output, "No match for argument") {
+			result.Message = fmt.Sprintf("Package not found in %s repositories", pm)
+		} else if strings.Contains(output, "but not installed") {
+			result.Message = "Package is not installed"
+		}
+	} else {
+		result.Success = true
+		if strings.Contains(output, "Nothing to do") || strings.Contains(output, "No packages marked for update") {
+			result.Success = false
+			result.Message = "Already up to date"
+		} else {
+			result.Message = "Successfully installed"
+		}
+	}
+
+	return result
+}
+
+// InstallMultiple upgrades multiple packages
+func (i *Installer) InstallMultiple(packageIdentifiers []string) []*InstallResult {
+	results := make([]*InstallResult, 0, len(packageIdentifiers))
+
+	for _, pkgID := range packageIdentifiers {
+		results = append(results, i.Install(pkgID))
+	}
+
+	return results
+}
+
+// Uninstall removes a package using dnf/yum
+func (i *Installer) Uninstall(packageIdentifier string) error {
+	if !ValidPackageName(packageIdentifier) {
+		return fmt.Errorf("invalid package name %q", packageIdentifier)
+	}
+
+	pm, err := packageManager()
+	if err != nil {
+		return err
+	}
+
+	stdout, stderr, err := runPackageManager(upgradeTimeout, pm, "remove", "-y", "--", packageIdentifier)
+	if err != nil {
+		return fmt.Errorf("%s remove failed: %w (output: %s)", pm, err, strings.TrimSpace(stdout+"\n"+stderr))
+	}
+	return nil
+}
+
+// CanInstall checks if dnf or yum is available on the system
+func (i *Installer) CanInstall() error {
+	_, err := packageManager()
+	return err
+}
@@ -0,0 +1,36 @@
+package dnf
+
+import "testing"
+
+func TestValidPackageName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"bash.x86_64", true},
+		{"NetworkManager.x86_64", true},
+		{"java-17-openjdk-headless", true},
+		{"libstdc++.i686", true},
+		{"texlive-collection-latexrecommended.noarch", true},
+		{"--setopt=tsflags=noscripts", false},
+		{"-y", false},
+		{"bash vim", false},
+		{"bash;id", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidPackageName(tt.name); got != tt.want {
+			t.Errorf("ValidPackageName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInstallRejectsInvalidName(t *testing.T) {
+	result := NewInstaller().Install("--setopt=tsflags=noscripts")
+	if result.Success || result.Error == nil {
+		t.Errorf("Install() = %+v, want an error without running the package manager", result)
+	}
+	if err := NewInstaller().Uninstall("--noautoremove"); err == nil {
+		t.Error("Uninstall() accepted an option as package name")
+	}
+}
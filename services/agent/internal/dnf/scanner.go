@@ -0,0 +1,391 @@
+package dnf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+const (
+	// checkUpdateAvailableExitCode is returned by dnf/yum check-update when updates are available
+	checkUpdateAvailableExitCode = 100
+
+	// checkUpdateTimeout bounds check-update, which refreshes metadata and hangs on unreachable mirrors
+	checkUpdateTimeout = 5 * time.Minute
+
+	// listTimeout bounds updateinfo list and the rpm database query
+	listTimeout = 2 * time.Minute
+
+	// upgradeTimeout bounds upgrading or removing a single package
+	upgradeTimeout = 30 * time.Minute
+
+	// outputWaitDelay bounds waiting for the output of a killed package manager's children
+	outputWaitDelay = 10 * time.Second
+)
+
+// Severity levels used by Red Hat style security advisories, most severe first
+var severityRank = map[string]int{
+	"Critical":  4,
+	"Important": 3,
+	"Moderate":  2,
+	"Low":       1,
+}
+
+// Scanner handles DNF/YUM update scanning
+type Scanner struct{}
+
+// NewScanner creates a new DNF scanner
+func NewScanner() *Scanner {
+	return &Scanner{}
+}
+
+// Update represents an available update from DNF/YUM
+type Update struct {
+	PackageName      string
+	Architecture     string
+	InstalledVersion string
+	AvailableVersion string
+	Repository       string
+	AdvisoryID       string
+	AdvisoryType     string
+	Severity         string
+}
+
+// Identifier returns the name.arch identifier dnf accepts for install and upgrade
+func (u Update) Identifier() string {
+	if u.Architecture == "" {
+		return u.PackageName
+	}
+	return u.PackageName + "." + u.Architecture
+}
+
+// Advisory is a single line from dnf updateinfo list
+type Advisory struct {
+	ID       string
+	Type     string
+	Severity string
+	Package  string
+}
+
+// ScanUpdates runs check-update and updateinfo and merges advisory metadata into the updates
+func (s *Scanner) ScanUpdates() ([]Update, error) {
+	pm, err := packageManager()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stderr, err := runPackageManager(checkUpdateTimeout, pm, "check-update", "-q")
+	if err := checkUpdateError(pm, stderr, err); err != nil {
+		return nil, err
+	}
+
+	updates := parseCheckUpdateOutput(stdout)
+	if len(updates) == 0 {
+		return updates, nil
+	}
+
+	// Installed versions aren't part of check-update output
+	installed := installedVersions()
+	for i := range updates {
+		updates[i].InstalledVersion = installed[updates[i].Identifier()]
+	}
+
+	// Advisory metadata is best-effort: not every repository publishes updateinfo
+	if infoOut, _, err := runPackageManager(listTimeout, pm, "updateinfo", "list", "-q"); err == nil {
+		applyAdvisories(updates, parseUpdateInfoOutput(infoOut))
+	}
+
+	return updates, nil
+}
+
+// checkUpdateError returns the error of a check-update run, which exits
+// with checkUpdateAvailableExitCode rather than 0 when updates are available
+func checkUpdateError(pm, stderr string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == checkUpdateAvailableExitCode {
+		return nil
+	}
+	return fmt.Errorf("%s check-update failed: %w (stderr: %s)", pm, err, stderr)
+}
+
+// parseCheckUpdateOutput parses the output of dnf/yum check-update
+// Format: name.arch    [epoch:]version-release    repository
+func parseCheckUpdateOutput(output string) []Update {
+	var updates []Update
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	var pending []string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		// Everything after this header describes obsoleted packages, which are reported again as upgrades
+		if strings.HasPrefix(trimmed, "Obsoleting Packages") {
+			break
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "Last metadata expiration check") ||
+			strings.HasPrefix(trimmed, "Security:") {
+			pending = nil
+			continue
+		}
+
+		// Long package names wrap the remaining columns onto the next line
+		fields := append(pending, strings.Fields(trimmed)...)
+		if len(fields) < 3 {
+			pending = fields
+			continue
+		}
+		pending = nil
+
+		update := parseUpdateFields(fields)
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+
+	return updates
+}
+
+// parseUpdateFields parses the columns of a single check-update entry
+func parseUpdateFields(fields []string) *Update {
+	if len(fields) != 3 {
+		return nil
+	}
+
+	dot := strings.LastIndex(fields[0], ".")
+	if dot <= 0 || dot == len(fields[0])-1 {
+		return nil
+	}
+
+	return &Update{
+		PackageName:      fields[0][:dot],
+		Architecture:     fields[0][dot+1:],
+		AvailableVersion: fields[1],
+		Repository:       fields[2],
+	}
+}
+
+// parseUpdateInfoOutput parses the output of dnf/yum updateinfo list
+// Format: ADVISORY-ID    Severity/Sec.|bugfix|enhancement    name-[epoch:]version-release.arch
+func parseUpdateInfoOutput(output string) []Advisory {
+	var advisories []Advisory
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		advisory := parseAdvisoryLine(scanner.Text())
+		if advisory != nil {
+			advisories = append(advisories, *advisory)
+		}
+	}
+
+	return advisories
+}
+
+// parseAdvisoryLine parses a single line from updateinfo list output
+func parseAdvisoryLine(line string) *Advisory {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil
+	}
+
+	advisory := &Advisory{ID: fields[0]}
+
+	switch {
+	case strings.HasSuffix(fields[1], "/Sec."):
+		// dnf4 and yum: "Important/Sec."
+		advisory.Type = "security"
+		advisory.Severity = normalizeSeverity(strings.TrimSuffix(fields[1], "/Sec."))
+		advisory.Package = fields[2]
+	case len(fields) >= 4 && (isSeverity(fields[2]) || fields[2] == "None"):
+		// dnf5: "security  Important  pkg-1.0-1.x86_64  2024-01-01 ...", severity None for non-security advisories
+		advisory.Type = strings.ToLower(fields[1])
+		advisory.Severity = normalizeSeverity(fields[2])
+		advisory.Package = fields[3]
+	default:
+		advisory.Type = strings.ToLower(fields[1])
+		advisory.Package = fields[2]
+	}
+
+	if advisory.Type == "" || !strings.Contains(advisory.Package, "-") {
+		return nil
+	}
+
+	return advisory
+}
+
+// applyAdvisories attaches the most severe advisory for each package to its update
+func applyAdvisories(updates []Update, advisories []Advisory) {
+	byIdentifier := make(map[string]Advisory)
+	for _, adv := range advisories {
+		name, arch := splitNEVRA(adv.Package)
+		if name == "" {
+			continue
+		}
+
+		key := name + "." + arch
+		current, ok := byIdentifier[key]
+		if !ok || advisoryRank(adv) > advisoryRank(current) {
+			byIdentifier[key] = adv
+		}
+	}
+
+	for i := range updates {
+		if adv, ok := byIdentifier[updates[i].Identifier()]; ok {
+			updates[i].AdvisoryID = adv.ID
+			updates[i].AdvisoryType = adv.Type
+			updates[i].Severity = adv.Severity
+		}
+	}
+}
+
+// advisoryRank orders advisories so security fixes outrank bugfixes and enhancements
+func advisoryRank(adv Advisory) int {
+	if adv.Type == "security" {
+		return 10 + severityRank[adv.Severity]
+	}
+	if adv.Type == "bugfix" {
+		return 1
+	}
+	return 0
+}
+
+// splitNEVRA splits name-[epoch:]version-release.arch into name and arch
+func splitNEVRA(nevra string) (string, string) {
+	dot := strings.LastIndex(nevra, ".")
+	if dot <= 0 {
+		return "", ""
+	}
+	arch := nevra[dot+1:]
+	rest := nevra[:dot]
+
+	// Strip release, then version
+	for i := 0; i < 2; i++ {
+		dash := strings.LastIndex(rest, "-")
+		if dash <= 0 {
+			return "", ""
+		}
+		rest = rest[:dash]
+	}
+
+	return rest, arch
+}
+
+// isSeverity reports whether s is a known advisory severity
+func isSeverity(s string) bool {
+	_, ok := severityRank[normalizeSeverity(s)]
+	return ok
+}
+
+// normalizeSeverity maps severity strings to Critical/Important/Moderate/Low
+func normalizeSeverity(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
+	if _, ok := severityRank[s]; ok {
+		return s
+	}
+	return ""
+}
+
+// installedVersions returns the installed version of every package keyed by name.arch.
+// Packages installed in several versions at once, such as kernels, map to the newest.
+func installedVersions() map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "rpm", "-qa", "--qf", "%{NAME}.%{ARCH} %{EPOCHNUM}:%{VERSION}-%{RELEASE}\\n")
+	out, err := cmd.Output()
+	if err != nil {
+		return make(map[string]string)
+	}
+	return parseInstalledVersions(string(out))
+}
+
+// parseInstalledVersions parses "name.arch epoch:version-release" lines from rpm -qa
+func parseInstalledVersions(output string) map[string]string {
+	versions := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// check-update omits a zero epoch, so match that format
+		version := strings.TrimPrefix(fields[1], "0:")
+		if current, ok := versions[fields[0]]; ok && compareEVR(current, version) >= 0 {
+			continue
+		}
+		versions[fields[0]] = version
+	}
+
+	return versions
+}
+
+// ToAPIUpdates converts scanner updates to API update items
+func ToAPIUpdates(updates []Update) []api.UpdateItem {
+	items := make([]api.UpdateItem, len(updates))
+	for i, u := range updates {
+		var installed *string
+		if u.InstalledVersion != "" {
+			v := u.InstalledVersion
+			installed = &v
+		}
+		items[i] = api.UpdateItem{
+			PackageIdentifier: u.Identifier(),
+			PackageName:       u.PackageName,
+			InstalledVersion:  installed,
+			AvailableVersion:  u.AvailableVersion,
+			Source:            SourceName,
+			AdvisoryID:        u.AdvisoryID,
+			AdvisoryType:      u.AdvisoryType,
+			Severity:          u.Severity,
+		}
+	}
+	return items
+}
+
+// packageManager returns dnf if installed, otherwise yum
+func packageManager() (string, error) {
+	for _, bin := range []string{"dnf", "yum"} {
+		if _, err := exec.LookPath(bin); err == nil {
+			return bin, nil
+		}
+	}
+	return "", fmt.Errorf("neither dnf nor yum is available")
+}
+
+// runPackageManager runs dnf/yum non-interactively and returns stdout and stderr.
+// The command is killed once timeout passes.
+func runPackageManager(timeout time.Duration, pm string, args ...string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, pm, args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	cmd.WaitDelay = outputWaitDelay
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("%s %s timed out after %v", pm, args[0], timeout)
+	}
+	return stdout.String(), stderr.String(), err
+}
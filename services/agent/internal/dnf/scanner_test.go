@@ -0,0 +1,133 @@
+package dnf
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseCheckUpdateOutput(t *testing.T) {
+	got := parseCheckUpdateOutput(readFixture(t, "check-update.txt"))
+	want := []Update{
+		{PackageName: "NetworkManager", Architecture: "x86_64", AvailableVersion: "1:1.44.2-1.fc39", Repository: "updates"},
+		{PackageName: "bash", Architecture: "x86_64", AvailableVersion: "5.2.26-1.fc39", Repository: "updates"},
+		{PackageName: "java-17-openjdk-headless", Architecture: "x86_64", AvailableVersion: "1:17.0.10.0.7-1.fc39", Repository: "updates"},
+		{PackageName: "kernel-core", Architecture: "x86_64", AvailableVersion: "6.7.3-100.fc39", Repository: "updates"},
+		{PackageName: "texlive-collection-latexrecommended", Architecture: "noarch", AvailableVersion: "9:svn54074-70.fc39", Repository: "updates"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCheckUpdateOutput() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestApplyAdvisories(t *testing.T) {
+	for _, fixture := range []string{"updateinfo-dnf4.txt", "updateinfo-dnf5.txt"} {
+		t.Run(fixture, func(t *testing.T) {
+			updates := parseCheckUpdateOutput(readFixture(t, "check-update.txt"))
+			applyAdvisories(updates, parseUpdateInfoOutput(readFixture(t, fixture)))
+
+			byName := make(map[string]Update)
+			for _, u := range updates {
+				byName[u.PackageName] = u
+			}
+
+			if u := byName["bash"]; u.AdvisoryID != "FEDORA-2024-2b3c4d5e6f" || u.AdvisoryType != "security" || u.Severity != "Moderate" {
+				t.Errorf("bash advisory = %s %s %s", u.AdvisoryID, u.AdvisoryType, u.Severity)
+			}
+			// The most severe of several advisories wins
+			if u := byName["kernel-core"]; u.AdvisoryID != "FEDORA-2024-3c4d5e6f70" || u.AdvisoryType != "security" {
+				t.Errorf("kernel-core advisory = %s %s %s", u.AdvisoryID, u.AdvisoryType, u.Severity)
+			}
+			if u := byName["NetworkManager"]; u.AdvisoryType != "bugfix" || u.Severity != "" {
+				t.Errorf("NetworkManager advisory = %s %s %s", u.AdvisoryID, u.AdvisoryType, u.Severity)
+			}
+			if u := byName["java-17-openjdk-headless"]; u.AdvisoryID != "" {
+				t.Errorf("java-17-openjdk-headless advisory = %s, want none", u.AdvisoryID)
+			}
+		})
+	}
+}
+
+func TestCheckUpdateError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	exit := func(code string) error {
+		return exec.Command("sh", "-c", "exit "+code).Run()
+	}
+
+	if err := checkUpdateError("dnf", "", nil); err != nil {
+		t.Errorf("exit 0: %v", err)
+	}
+	if err := checkUpdateError("dnf", "", exit("100")); err != nil {
+		t.Errorf("exit 100 (updates available): %v", err)
+	}
+	if err := checkUpdateError("dnf", "Error: Failed to download metadata", exit("1")); err == nil {
+		t.Error("exit 1: want error")
+	}
+}
+
+func TestSplitNEVRA(t *testing.T) {
+	tests := []struct{ nevra, name, arch string }{
+		{"kernel-core-6.7.3-100.fc39.x86_64", "kernel-core", "x86_64"},
+		{"NetworkManager-1:1.44.2-1.fc39.x86_64", "NetworkManager", "x86_64"},
+		{"noversion", "", ""},
+	}
+	for _, tt := range tests {
+		name, arch := splitNEVRA(tt.nevra)
+		if name != tt.name || arch != tt.arch {
+			t.Errorf("splitNEVRA(%q) = %q, %q, want %q, %q", tt.nevra, name, arch, tt.name, tt.arch)
+		}
+	}
+}
+
+func TestRunPackageManagerTimesOut(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	start := time.Now()
+	_, _, err := runPackageManager(50*time.Millisecond, "sh", "-c", "exec sleep 5")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("runPackageManager() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("runPackageManager() returned after %v, want it killed at the timeout", elapsed)
+	}
+	if err := checkUpdateError("sh", "", err); err == nil {
+		t.Error("checkUpdateError() accepted a timed out check-update")
+	}
+}
+
+func TestParseInstalledVersionsKeepsNewest(t *testing.T) {
+	got := parseInstalledVersions(`kernel-core.x86_64 0:6.6.9-200.fc39
+kernel-core.x86_64 0:6.7.3-100.fc39
+kernel-core.x86_64 0:6.6.14-200.fc39
+bash.x86_64 0:5.2.26-1.fc39
+NetworkManager.x86_64 1:1.44.0-1.fc39
+gpg-pubkey.(none) 0:abc-def
+garbage
+`)
+	want := map[string]string{
+		"kernel-core.x86_64":    "6.7.3-100.fc39",
+		"bash.x86_64":           "5.2.26-1.fc39",
+		"NetworkManager.x86_64": "1:1.44.0-1.fc39",
+		"gpg-pubkey.(none)":     "abc-def",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseInstalledVersions() = %v, want %v", got, want)
+	}
+}
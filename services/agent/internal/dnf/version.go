@@ -0,0 +1,132 @@
+package dnf
+
+import (
+	"strconv"
+	"strings"
+)
+
+// compareEVR compares two [epoch:]version-release strings the way rpm does,
+// returning -1, 0 or 1
+func compareEVR(a, b string) int {
+	aEpoch, aVersion, aRelease := splitEVR(a)
+	bEpoch, bVersion, bRelease := splitEVR(b)
+	if aEpoch != bEpoch {
+		if aEpoch < bEpoch {
+			return -1
+		}
+		return 1
+	}
+	if c := rpmvercmp(aVersion, bVersion); c != 0 {
+		return c
+	}
+	return rpmvercmp(aRelease, bRelease)
+}
+
+// splitEVR splits [epoch:]version-release; a missing epoch is 0
+func splitEVR(evr string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(evr, ":"); ok {
+		epoch, _ = strconv.Atoi(e)
+		evr = rest
+	}
+	version, release, _ := strings.Cut(evr, "-")
+	return epoch, version, release
+}
+
+// rpmvercmp compares version or release strings segment by segment like rpm:
+// numeric segments compare as numbers and outrank alphabetic ones, ~ sorts
+// before anything and ^ after the end of the other string
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	for a != "" || b != "" {
+		a = strings.TrimLeftFunc(a, isSeparator)
+		b = strings.TrimLeftFunc(b, isSeparator)
+
+		switch {
+		case strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~"):
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		case strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^"):
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		numeric := isDigit(rune(a[0]))
+		segment := isAlpha
+		if numeric {
+			segment = isDigit
+		}
+		aSeg, bSeg := leading(a, segment), leading(b, segment)
+		a, b = a[len(aSeg):], b[len(bSeg):]
+
+		if bSeg == "" {
+			// Segments of different types: numbers are newer
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			aSeg, bSeg = strings.TrimLeft(aSeg, "0"), strings.TrimLeft(bSeg, "0")
+			if len(aSeg) != len(bSeg) {
+				if len(aSeg) < len(bSeg) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(aSeg, bSeg); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func leading(s string, f func(rune) bool) string {
+	for i, r := range s {
+		if !f(r) {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isDigit(r rune) bool { return r >= '0' && r <= '9' }
+
+func isAlpha(r rune) bool { return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') }
+
+func isSeparator(r rune) bool { return !isDigit(r) && !isAlpha(r) && r != '~' && r != '^' }
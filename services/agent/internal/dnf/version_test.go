@@ -0,0 +1,34 @@
+package dnf
+
+import "testing"
+
+func TestCompareEVR(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"6.7.3-100.fc39", "6.7.3-100.fc39", 0},
+		{"6.6.14-200.fc39", "6.7.3-100.fc39", -1},
+		{"6.6.14-200.fc39", "6.6.9-200.fc39", 1},
+		{"1:1.0-1", "2.0-1", 1},
+		{"0:2.0-1", "2.0-1", 0},
+		{"1.0-2", "1.0-10", -1},
+		{"1.0a-1", "1.0-1", 1},
+		{"1.0-1", "1.0.1-1", -1},
+		{"1.0~rc1-1", "1.0-1", -1},
+		{"1.0~rc1-1", "1.0~rc2-1", -1},
+		{"1.0^git1-1", "1.0-1", 1},
+		{"1.0^git1-1", "1.0.1-1", -1},
+		{"1.a-1", "1.1-1", -1},
+		{"1.010-1", "1.10-1", 0},
+		{"1_0-1", "1.0-1", 0},
+	}
+	for _, tt := range tests {
+		if got := compareEVR(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareEVR(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareEVR(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareEVR(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package flatpak
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// userPrefix marks package identifiers of refs in a per-user installation,
+// e.g. "user:alice/org.gnome.Maps/x86_64/stable"
+const userPrefix = "user:"
+
+// passwdPath is the account database per-user installations are discovered from
+const passwdPath = "/etc/passwd"
+
+// Installation is a flatpak installation: the system-wide one, or a user's
+type Installation struct {
+	// User is the owning account, empty for the system installation
+	User string
+	Home string
+}
+
+// System reports whether this is the system-wide installation
+func (i Installation) System() bool {
+	return i.User == ""
+}
+
+// String names the installation for logs and errors
+func (i Installation) String() string {
+	if i.System() {
+		return "system"
+	}
+	return "user " + i.User
+}
+
+// identifier returns the package identifier reported for a ref in this installation
+func (i Installation) identifier(ref string) string {
+	if i.System() {
+		return ref
+	}
+	return userPrefix + i.User + "/" + ref
+}
+
+// command returns the command line running flatpak with args against this installation.
+// A user's installation is accessed as that user so the files stay theirs.
+func (i Installation) command(args []string) (string, []string, []string) {
+	env := append(os.Environ(), "LC_ALL=C")
+	if i.System() {
+		return "flatpak", append([]string{"--system"}, args...), env
+	}
+
+	env = append(env, "HOME="+i.Home, "FLATPAK_USER_DIR="+filepath.Join(i.Home, ".local", "share", "flatpak"))
+	flatpakArgs := append([]string{"--user"}, args...)
+	if current, err := user.Current(); err == nil && current.Username == i.User {
+		return "flatpak", flatpakArgs, env
+	}
+	return "runuser", append([]string{"-u", i.User, "--", "flatpak"}, flatpakArgs...), env
+}
+
+// refRe matches the application/arch/branch refs the scanner reports
+var refRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*(\.[A-Za-z0-9_-]+)+/[A-Za-z0-9_]+/[A-Za-z0-9_.][A-Za-z0-9_.-]*$`)
+
+// parseIdentifier splits a package identifier into its installation and ref.
+// Refs that flatpak could read as an option are rejected.
+func parseIdentifier(packageIdentifier string) (Installation, string, error) {
+	if !strings.HasPrefix(packageIdentifier, userPrefix) {
+		if !refRe.MatchString(packageIdentifier) {
+			return Installation{}, "", fmt.Errorf("invalid flatpak identifier %q", packageIdentifier)
+		}
+		return Installation{}, packageIdentifier, nil
+	}
+
+	name, ref, ok := strings.Cut(strings.TrimPrefix(packageIdentifier, userPrefix), "/")
+	if !ok || name == "" || !refRe.MatchString(ref) {
+		return Installation{}, "", fmt.Errorf("invalid flatpak identifier %q", packageIdentifier)
+	}
+	for _, inst := range userInstallations() {
+		if inst.User == name {
+			return inst, ref, nil
+		}
+	}
+	return Installation{}, "", fmt.Errorf("no flatpak installation for user %s", name)
+}
+
+// installations returns the system installation followed by every user installation
+// this process can manage: all users' when running as root, otherwise only its own
+func installations() []Installation {
+	return append([]Installation{{}}, userInstallations()...)
+}
+
+// userInstallations returns the per-user installations this process can manage
+func userInstallations() []Installation {
+	if os.Geteuid() != 0 {
+		current, err := user.Current()
+		if err != nil || !hasUserInstallation(current.HomeDir) {
+			return nil
+		}
+		return []Installation{{User: current.Username, Home: current.HomeDir}}
+	}
+
+	f, err := os.Open(passwdPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parsePasswd(f, hasUserInstallation)
+}
+
+// parsePasswd returns the regular accounts in passwd-format data whose home has an installation
+func parsePasswd(r io.Reader, hasInstallation func(home string) bool) []Installation {
+	var found []Installation
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// System accounts and nobody don't use flatpak
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil || uid < 1000 || uid == 65534 {
+			continue
+		}
+		if home := fields[5]; home != "" && hasInstallation(home) {
+			found = append(found, Installation{User: fields[0], Home: home})
+		}
+	}
+	return found
+}
+
+// hasUserInstallation reports whether a home directory holds a flatpak user installation
+func hasUserInstallation(home string) bool {
+	info, err := os.Stat(filepath.Join(home, ".local", "share", "flatpak", "repo"))
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,95 @@
+package flatpak
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// InstallResult represents the result of an install operation
+type InstallResult = updates.InstallResult
+
+// Installer handles Flatpak updates
+type Installer struct{}
+
+// NewInstaller creates a new Flatpak installer
+func NewInstaller() *Installer {
+	return &Installer{}
+}
+
+// Install updates a single installed ref
+func (i *Installer) Install(packageIdentifier string) *InstallResult {
+	result := &InstallResult{
+		PackageIdentifier: packageIdentifier,
+	}
+
+	inst, ref, err := parseIdentifier(packageIdentifier)
+	if err != nil {
+		result.Message = err.Error()
+		result.Error = err
+		return result
+	}
+
+	stdout, stderr, err := runFlatpak(inst, "update", "-y", "--noninteractive", "--", ref)
+	output := stdout
+	if stderr != "" {
+		output += "\n" + stderr
+	}
+
+	result.Message = strings.TrimSpace(output)
+
+	if err != nil {
+		result.Success = false
+		result.Error = fmt.Errorf("flatpak update failed: %w", err)
+
+		// Check for common error messages
+		if strings.Contains(output, "not installed") {
+			result.Message = "Ref is not installed"
+		}
+	} else {
+		result.Success = true
+		if strings.Contains(output, "Nothing to do") {
+			result.Success = false
+			result.Message = "Already up to date"
+		} else {
+			result.Message = "Successfully installed"
+		}
+	}
+
+	return result
+}
+
+// InstallMultiple updates multiple refs
+func (i *Installer) InstallMultiple(packageIdentifiers []string) []*InstallResult {
+	results := make([]*InstallResult, 0, len(packageIdentifiers))
+
+	for _, pkgID := range packageIdentifiers {
+		results = append(results, i.Install(pkgID))
+	}
+
+	return results
+}
+
+// Uninstall removes a ref using flatpak
+func (i *Installer) Uninstall(packageIdentifier string) error {
+	inst, ref, err := parseIdentifier(packageIdentifier)
+	if err != nil {
+		return err
+	}
+
+	stdout, stderr, err := runFlatpak(inst, "uninstall", "-y", "--noninteractive", "--", ref)
+	if err != nil {
+		return fmt.Errorf("flatpak uninstall failed: %w (output: %s)", err, strings.TrimSpace(stdout+"\n"+stderr))
+	}
+	return nil
+}
+
+// CanInstall checks if flatpak is available on the system
+func (i *Installer) CanInstall() error {
+	if _, err := exec.LookPath("flatpak"); err != nil {
+		return fmt.Errorf("flatpak is not available: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package flatpak
+
+import "testing"
+
+func TestParseIdentifierRejectsOptions(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"org.gnome.Maps/x86_64/stable", "org.gnome.Maps/x86_64/stable"},
+		{"org.freedesktop.Platform.GL.default/x86_64/23.08", "org.freedesktop.Platform.GL.default/x86_64/23.08"},
+		{"--system", ""},
+		{"-y", ""},
+		{"org.gnome.Maps", ""},
+		{"org.gnome.Maps/x86_64/--reinstall", ""},
+		{"org.gnome.Maps/x86_64/stable extra", ""},
+		{"user:alice/--assumeyes", ""},
+		{"user:alice/", ""},
+	}
+	for _, tt := range tests {
+		_, ref, err := parseIdentifier(tt.id)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseIdentifier(%q) = %q, want an error", tt.id, ref)
+			}
+			continue
+		}
+		if err != nil || ref != tt.want {
+			t.Errorf("parseIdentifier(%q) = %q, %v; want %q", tt.id, ref, err, tt.want)
+		}
+	}
+}
+
+func TestInstallRejectsInvalidIdentifier(t *testing.T) {
+	result := NewInstaller().Install("--from=https://evil.example/app.flatpakref")
+	if result.Success || result.Error == nil {
+		t.Errorf("Install() = %+v, want an error without running flatpak", result)
+	}
+	if err := NewInstaller().Uninstall("--all"); err == nil {
+		t.Error("Uninstall() accepted an option as identifier")
+	}
+}
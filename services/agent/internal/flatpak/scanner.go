@@ -0,0 +1,184 @@
+package flatpak
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// refColumns are the columns requested from flatpak, in order
+const refColumns = "application,arch,branch,origin,version,name"
+
+// Scanner handles Flatpak update scanning
+type Scanner struct{}
+
+// NewScanner creates a new Flatpak scanner
+func NewScanner() *Scanner {
+	return &Scanner{}
+}
+
+// Update represents an available update from Flatpak
+type Update struct {
+	Application      string
+	Architecture     string
+	Branch           string
+	Origin           string
+	Name             string
+	InstalledVersion string
+	AvailableVersion string
+	Installation     Installation
+}
+
+// Ref returns the partial ref flatpak update accepts for this update
+func (u Update) Ref() string {
+	return u.Application + "/" + u.Architecture + "/" + u.Branch
+}
+
+// Identifier returns the package identifier, which names the installation for user refs
+func (u Update) Identifier() string {
+	return u.Installation.identifier(u.Ref())
+}
+
+// ScanUpdates scans the system installation and every user installation.
+// It fails only if the system installation can't be scanned.
+func (s *Scanner) ScanUpdates() ([]Update, error) {
+	var updates []Update
+	for _, inst := range installations() {
+		found, err := scanInstallation(inst)
+		if err != nil {
+			if inst.System() {
+				return nil, err
+			}
+			continue
+		}
+		updates = append(updates, found...)
+	}
+	return updates, nil
+}
+
+// scanInstallation runs flatpak remote-ls --updates against one installation
+func scanInstallation(inst Installation) ([]Update, error) {
+	stdout, stderr, err := runFlatpak(inst, "remote-ls", "--updates", "--columns="+refColumns)
+	if err != nil {
+		return nil, fmt.Errorf("flatpak remote-ls (%s) failed: %w (stderr: %s)", inst, err, stderr)
+	}
+
+	updates := parseRemoteLsOutput(stdout)
+	for i := range updates {
+		updates[i].Installation = inst
+	}
+	if len(updates) == 0 {
+		return updates, nil
+	}
+
+	// remote-ls only knows the remote version, so look up what's installed
+	if listOut, _, err := runFlatpak(inst, "list", "--columns="+refColumns); err == nil {
+		installed := make(map[string]string)
+		for _, ref := range parseRemoteLsOutput(listOut) {
+			installed[ref.Ref()] = ref.AvailableVersion
+		}
+		for i := range updates {
+			updates[i].InstalledVersion = installed[updates[i].Ref()]
+		}
+	}
+
+	return updates, nil
+}
+
+// parseRemoteLsOutput parses tab-separated flatpak output in refColumns order
+func parseRemoteLsOutput(output string) []Update {
+	var updates []Update
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		update := parseRefLine(scanner.Text())
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+
+	return updates
+}
+
+// parseRefLine parses a single line of flatpak output
+// Format: application<TAB>arch<TAB>branch<TAB>origin<TAB>version<TAB>name
+func parseRefLine(line string) *Update {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+
+	fields := strings.Split(line, "\t")
+	if len(fields) < 3 {
+		return nil
+	}
+	for len(fields) < 6 {
+		fields = append(fields, "")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	// The header row only appears when output is a terminal, but skip it anyway
+	if fields[0] == "" || fields[1] == "" || fields[2] == "" || fields[0] == "Application ID" {
+		return nil
+	}
+
+	return &Update{
+		Application:      fields[0],
+		Architecture:     fields[1],
+		Branch:           fields[2],
+		Origin:           fields[3],
+		AvailableVersion: fields[4],
+		Name:             fields[5],
+	}
+}
+
+// ToAPIUpdates converts scanner updates to API update items
+func ToAPIUpdates(updates []Update) []api.UpdateItem {
+	items := make([]api.UpdateItem, len(updates))
+	for i, u := range updates {
+		name := u.Name
+		if name == "" {
+			name = u.Application
+		}
+
+		// Runtimes and many apps don't publish a version, so fall back to the branch
+		available := u.AvailableVersion
+		if available == "" {
+			available = u.Branch
+		}
+
+		var installed *string
+		if u.InstalledVersion != "" {
+			v := u.InstalledVersion
+			installed = &v
+		}
+
+		items[i] = api.UpdateItem{
+			PackageIdentifier: u.Identifier(),
+			PackageName:       name,
+			InstalledVersion:  installed,
+			AvailableVersion:  available,
+			Source:            SourceName,
+		}
+	}
+	return items
+}
+
+// runFlatpak runs flatpak against an installation and returns stdout and stderr
+func runFlatpak(inst Installation, args ...string) (string, string, error) {
+	name, cmdArgs, env := inst.command(args)
+	cmd := exec.Command(name, cmdArgs...)
+	cmd.Env = env
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
@@ -0,0 +1,84 @@
+package flatpak
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRemoteLsOutput(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "remote-ls-updates.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := parseRemoteLsOutput(string(data))
+	want := []Update{
+		{Application: "org.gnome.Maps", Architecture: "x86_64", Branch: "stable", Origin: "flathub", AvailableVersion: "45.4", Name: "Maps"},
+		{Application: "org.freedesktop.Platform.GL.default", Architecture: "x86_64", Branch: "23.08", Origin: "flathub", Name: "Mesa"},
+		{Application: "org.mozilla.firefox", Architecture: "x86_64", Branch: "stable", Origin: "flathub", AvailableVersion: "123.0", Name: "Firefox"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseRemoteLsOutput() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	// Runtimes without a version report their branch
+	items := ToAPIUpdates(got)
+	if items[1].AvailableVersion != "23.08" {
+		t.Errorf("runtime AvailableVersion = %q, want branch", items[1].AvailableVersion)
+	}
+}
+
+func TestUserInstallationIdentifier(t *testing.T) {
+	system := Update{Application: "org.gnome.Maps", Architecture: "x86_64", Branch: "stable"}
+	if got := system.Identifier(); got != "org.gnome.Maps/x86_64/stable" {
+		t.Errorf("system Identifier() = %q", got)
+	}
+
+	user := system
+	user.Installation = Installation{User: "alice", Home: "/home/alice"}
+	if got := user.Identifier(); got != "user:alice/org.gnome.Maps/x86_64/stable" {
+		t.Errorf("user Identifier() = %q", got)
+	}
+
+	name, args, env := user.Installation.command([]string{"update", "-y", "org.gnome.Maps/x86_64/stable"})
+	cmdline := name + " " + strings.Join(args, " ")
+	if !strings.Contains(cmdline, "flatpak --user update -y org.gnome.Maps/x86_64/stable") {
+		t.Errorf("user command = %q", cmdline)
+	}
+	if !contains(env, "HOME=/home/alice") {
+		t.Errorf("user command env misses HOME")
+	}
+
+	_, args, _ = system.Installation.command([]string{"list"})
+	if !reflect.DeepEqual(args, []string{"--system", "list"}) {
+		t.Errorf("system command args = %v", args)
+	}
+}
+
+func TestParsePasswd(t *testing.T) {
+	passwd := `root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+alice:x:1000:1000:Alice:/home/alice:/bin/bash
+bob:x:1001:1001:Bob:/home/bob:/bin/bash
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+`
+	withInstallation := map[string]bool{"/root": true, "/home/alice": true, "/nonexistent": true}
+	got := parsePasswd(strings.NewReader(passwd), func(home string) bool { return withInstallation[home] })
+
+	want := []Installation{{User: "alice", Home: "/home/alice"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePasswd() = %+v, want %+v", got, want)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
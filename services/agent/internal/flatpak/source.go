@@ -0,0 +1,53 @@
+package flatpak
+
+import (
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// SourceName is the api.UpdateItem.Source value for Flatpak updates
+const SourceName = "flatpak"
+
+// Source adapts the Flatpak Scanner and Installer to updates.UpdateSource
+type Source struct {
+	scanner   *Scanner
+	installer *Installer
+}
+
+var _ updates.UpdateSource = (*Source)(nil)
+
+// NewSource creates a new Flatpak update source
+func NewSource() *Source {
+	return &Source{
+		scanner:   NewScanner(),
+		installer: NewInstaller(),
+	}
+}
+
+// Name returns the source name
+func (s *Source) Name() string {
+	return SourceName
+}
+
+// CheckAvailable checks if flatpak is available on the system
+func (s *Source) CheckAvailable() error {
+	return s.installer.CanInstall()
+}
+
+// ScanUpdates scans Flatpak for available updates
+func (s *Source) ScanUpdates() (*updates.ScanResult, error) {
+	found, err := s.scanner.ScanUpdates()
+	if err != nil {
+		return nil, err
+	}
+	return &updates.ScanResult{Items: ToAPIUpdates(found)}, nil
+}
+
+// Install updates a single ref using flatpak
+func (s *Source) Install(packageIdentifier string) *InstallResult {
+	return s.installer.Install(packageIdentifier)
+}
+
+// Uninstall removes a ref using flatpak
+func (s *Source) Uninstall(packageIdentifier string) error {
+	return s.installer.Uninstall(packageIdentifier)
+}
@@ -0,0 +1,9 @@
+// Package fsacl restricts agent files holding secrets to the accounts that run the agent
+package fsacl
+
+// Restrict limits access to path to the accounts that run the agent: on Windows
+// SYSTEM, Administrators and the current account, elsewhere the owner. A directory
+// passes the restriction on to the files created in it.
+func Restrict(path string) error {
+	return restrict(path)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package fsacl
+
+import (
+	"fmt"
+	"os"
+)
+
+// restrict makes path owner-only; files created later rely on their own 0600 mode
+func restrict(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("restrict %s: %w", path, err)
+	}
+	mode := os.FileMode(0600)
+	if info.IsDir() {
+		mode = 0700
+	}
+	if info.Mode().Perm() == mode {
+		return nil
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("restrict %s: %w", path, err)
+	}
+	return nil
+}
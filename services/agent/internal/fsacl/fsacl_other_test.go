@@ -0,0 +1,37 @@
+//go:build !windows
+
+package fsacl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestrict(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "credentials.dat")
+	if err := os.WriteFile(file, []byte("token"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]os.FileMode{dir: 0700, file: 0600} {
+		if err := Restrict(path); err != nil {
+			t.Fatalf("Restrict(%s): %v", path, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s mode = %o, want %o", filepath.Base(path), got, want)
+		}
+	}
+
+	if err := Restrict(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Restrict() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,78 @@
+//go:build windows
+
+package fsacl
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// restrict replaces the DACL of path with one granting full control to SYSTEM,
+// Administrators and the current account only. The DACL is protected, so nothing is
+// inherited from the parent (ProgramData grants Users read access). For directories
+// the entries are inheritable and Windows propagates them to existing children.
+func restrict(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("restrict %s: %w", path, err)
+	}
+
+	sids, err := allowedSIDs()
+	if err != nil {
+		return fmt.Errorf("restrict %s: %w", path, err)
+	}
+
+	inheritance := uint32(windows.NO_INHERITANCE)
+	if info.IsDir() {
+		inheritance = windows.SUB_CONTAINERS_AND_OBJECTS_INHERIT
+	}
+	entries := make([]windows.EXPLICIT_ACCESS, len(sids))
+	for i, sid := range sids {
+		entries[i] = windows.EXPLICIT_ACCESS{
+			AccessPermissions: windows.GENERIC_ALL,
+			AccessMode:        windows.SET_ACCESS,
+			Inheritance:       inheritance,
+			Trustee: windows.TRUSTEE{
+				TrusteeForm:  windows.TRUSTEE_IS_SID,
+				TrusteeType:  windows.TRUSTEE_IS_UNKNOWN,
+				TrusteeValue: windows.TrusteeValueFromSID(sid),
+			},
+		}
+	}
+	acl, err := windows.ACLFromEntries(entries, nil)
+	if err != nil {
+		return fmt.Errorf("restrict %s: build ACL: %w", path, err)
+	}
+
+	err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION,
+		nil, nil, acl, nil)
+	if err != nil {
+		return fmt.Errorf("restrict %s: %w", path, err)
+	}
+	return nil
+}
+
+// allowedSIDs returns SYSTEM, Administrators and, if it is another account, the current one
+func allowedSIDs() ([]*windows.SID, error) {
+	system, err := windows.CreateWellKnownSid(windows.WinLocalSystemSid)
+	if err != nil {
+		return nil, err
+	}
+	admins, err := windows.CreateWellKnownSid(windows.WinBuiltinAdministratorsSid)
+	if err != nil {
+		return nil, err
+	}
+	sids := []*windows.SID{system, admins}
+
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	if !user.User.Sid.Equals(system) {
+		sids = append(sids, user.User.Sid)
+	}
+	return sids, nil
+}
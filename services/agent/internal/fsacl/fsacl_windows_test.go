@@ -0,0 +1,64 @@
+//go:build windows
+
+package fsacl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestRestrict(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restrict(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files created later inherit the restriction
+	file := filepath.Join(dir, "credentials.dat")
+	if err := os.WriteFile(file, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed, err := allowedSIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{dir, file} {
+		sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+		if err != nil {
+			t.Fatal(err)
+		}
+		control, _, err := sd.Control()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path == dir && control&windows.SE_DACL_PROTECTED == 0 {
+			t.Errorf("%s DACL inherits from its parent", path)
+		}
+		dacl, _, err := sd.DACL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := uint32(0); i < uint32(dacl.AceCount); i++ {
+			var ace *windows.ACCESS_ALLOWED_ACE
+			if err := windows.GetAce(dacl, i, &ace); err != nil {
+				t.Fatal(err)
+			}
+			sid := (*windows.SID)(unsafe.Pointer(&ace.SidStart))
+			ok := false
+			for _, a := range allowed {
+				ok = ok || sid.Equals(a)
+			}
+			if !ok {
+				t.Errorf("%s grants access to %s", path, sid)
+			}
+		}
+	}
+}
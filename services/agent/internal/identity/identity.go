@@ -0,0 +1,366 @@
+package identity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lunaris/agent/internal/fsacl"
+)
+
+// File names of the device identity
+const (
+	// BundleFile holds the device key followed by its certificate chain. Both are
+	// replaced by a single rename, so a crash can't leave a key without its certificate.
+	BundleFile = "device.pem"
+
+	// PendingKeyFile holds the key of a renewal until its certificate is installed
+	PendingKeyFile = "device-pending.key"
+
+	// KeyFile and CertificateFile are the separate files of older agents
+	KeyFile         = "device.key"
+	CertificateFile = "device.crt"
+)
+
+// ErrNoCertificate is returned when the device has no client certificate yet
+var ErrNoCertificate = errors.New("no device certificate")
+
+// Identity is the device's TLS client identity: a locally generated private key
+// and the certificate the server issued for it. Files are only readable by the agent's account.
+type Identity struct {
+	mu       sync.RWMutex
+	dir      string
+	key      crypto.Signer
+	cert     *tls.Certificate
+	pending  crypto.Signer
+	notAfter time.Time
+	lifetime time.Duration
+}
+
+// Load reads the identity stored in dir. On first run the device key is generated
+// and stored right away; a missing certificate is not an error.
+func Load(dir string) (*Identity, error) {
+	id := &Identity{dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, BundleFile))
+	if os.IsNotExist(err) {
+		data, err = id.migrate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read device identity: %w", err)
+	}
+
+	if data == nil {
+		key, err := generateKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := id.persist(key, nil); err != nil {
+			return nil, err
+		}
+		id.key = key
+		return id, nil
+	}
+
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, err
+	}
+	id.key = key
+	// A certificate that doesn't match the key is useless; enroll again
+	if cert, err := parseCertificate(key, data); err == nil {
+		id.use(cert)
+	}
+
+	// A renewal interrupted by a restart can still install its certificate
+	if pendingPEM, err := os.ReadFile(filepath.Join(dir, PendingKeyFile)); err == nil {
+		if pending, err := parseKey(pendingPEM); err == nil {
+			id.pending = pending
+		}
+	}
+	return id, nil
+}
+
+// migrate moves the separate key and certificate files of older agents into the bundle.
+// It returns the bundle, or nil if there was no key.
+func (id *Identity) migrate() ([]byte, error) {
+	keyPath := filepath.Join(id.dir, KeyFile)
+	certPath := filepath.Join(id.dir, CertificateFile)
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	var certPEM []byte
+	if data, err := os.ReadFile(certPath); err == nil {
+		if _, err := parseCertificate(key, data); err == nil {
+			certPEM = data
+		}
+	}
+
+	if err := id.persist(key, certPEM); err != nil {
+		return nil, err
+	}
+	os.Remove(keyPath)
+	os.Remove(certPath)
+	return os.ReadFile(filepath.Join(id.dir, BundleFile))
+}
+
+// CreateCSR returns a PEM certificate signing request. The first request is for the
+// device key; renewals use a new key that only replaces the current one once its
+// certificate is installed, so the existing certificate keeps working meanwhile.
+func (id *Identity) CreateCSR(commonName string) (string, error) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	key := id.key
+	if id.cert != nil {
+		if id.pending == nil {
+			pending, err := generateKey()
+			if err != nil {
+				return "", err
+			}
+			keyPEM, err := encodeKey(pending)
+			if err != nil {
+				return "", err
+			}
+			if err := writeFile(filepath.Join(id.dir, PendingKeyFile), keyPEM); err != nil {
+				return "", err
+			}
+			id.pending = pending
+		}
+		key = id.pending
+	}
+	if key == nil {
+		return "", fmt.Errorf("no device key")
+	}
+
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return "", fmt.Errorf("create certificate request: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// Install stores a PEM certificate chain issued for the renewal key or the device key
+func (id *Identity) Install(certPEM string) error {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	key := id.pending
+	cert, err := parseCertificate(key, []byte(certPEM))
+	if key == nil || err != nil {
+		key = id.key
+		cert, err = parseCertificate(key, []byte(certPEM))
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := id.persist(key, []byte(certPEM)); err != nil {
+		return err
+	}
+	if key == id.pending {
+		os.Remove(filepath.Join(id.dir, PendingKeyFile))
+		id.pending = nil
+	}
+	id.key = key
+	id.use(cert)
+	return nil
+}
+
+// parseCertificate parses the chain in PEM data and checks it belongs to key
+func parseCertificate(key crypto.Signer, certPEM []byte) (*tls.Certificate, error) {
+	if key == nil {
+		return nil, fmt.Errorf("no device key for certificate")
+	}
+
+	var chain [][]byte
+	rest := certPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate in PEM data")
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse device certificate: %w", err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("encode device public key: %w", err)
+	}
+	if !bytes.Equal(pub, leaf.RawSubjectPublicKeyInfo) {
+		return nil, fmt.Errorf("device certificate doesn't match the device key")
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// use makes cert current. Callers must hold id.mu or own id exclusively.
+func (id *Identity) use(cert *tls.Certificate) {
+	id.cert = cert
+	id.notAfter = cert.Leaf.NotAfter
+	id.lifetime = cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore)
+}
+
+// persist writes the key and its certificate chain, if any, as one file only the
+// accounts running the agent can read
+func (id *Identity) persist(key crypto.Signer, certPEM []byte) error {
+	if err := os.MkdirAll(id.dir, 0700); err != nil {
+		return fmt.Errorf("create identity directory: %w", err)
+	}
+	if err := fsacl.Restrict(id.dir); err != nil {
+		return err
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(id.dir, BundleFile), append(keyPEM, certPEM...))
+}
+
+// HasCertificate reports whether a client certificate is installed
+func (id *Identity) HasCertificate() bool {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.cert != nil
+}
+
+// NotAfter returns when the client certificate expires
+func (id *Identity) NotAfter() time.Time {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.notAfter
+}
+
+// NeedsRenewal reports whether the certificate is missing or has less than a third
+// of its lifetime, or less than minRemaining, left
+func (id *Identity) NeedsRenewal(minRemaining time.Duration) bool {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+
+	if id.cert == nil {
+		return true
+	}
+	remaining := time.Until(id.notAfter)
+	return remaining < id.lifetime/3 || remaining < minRemaining
+}
+
+// GetClientCertificate supplies the current certificate to TLS handshakes,
+// so renewed certificates are used without rebuilding clients
+func (id *Identity) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+
+	if id.cert == nil {
+		// Send no certificate; the server decides whether that's acceptable
+		return &tls.Certificate{}, nil
+	}
+	return id.cert, nil
+}
+
+// parseKey decodes a PEM private key
+func parseKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no private key in device key file")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse device key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported device key type %T", key)
+	}
+	return signer, nil
+}
+
+// generateKey creates a device key
+func generateKey() (crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate device key: %w", err)
+	}
+	return key, nil
+}
+
+// encodeKey returns a key as PKCS #8 PEM
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encode device key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFile atomically replaces path with a file only the accounts running the agent
+// can read; on Windows, where 0600 means nothing, it gets an explicit ACL.
+// The data is synced before the rename so a crash leaves the old or the new file.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	if err = fsacl.Restrict(tmp); err == nil {
+		if _, err = f.Write(data); err == nil {
+			err = f.Sync()
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
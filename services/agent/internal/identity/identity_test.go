@@ -0,0 +1,323 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is an in-memory certificate authority issuing device certificates
+type testCA struct {
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Device CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{t: t, key: key, cert: cert, serial: 1}
+}
+
+// Sign issues a client certificate for a PEM CSR
+func (ca *testCA) Sign(csrPEM string) string {
+	ca.t.Helper()
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil {
+		ca.t.Fatal("no CSR in PEM data")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		ca.t.Fatal(err)
+	}
+
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// Pool returns a pool trusting the CA
+func (ca *testCA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// enroll requests and installs a certificate
+func enroll(t *testing.T, id *Identity, ca *testCA) {
+	t.Helper()
+	csr, err := id.CreateCSR("device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := id.Install(ca.Sign(csr)); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+}
+
+func publicKey(t *testing.T, id *Identity) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(id.key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(der)
+}
+
+func TestLoadGeneratesKeyOnFirstRun(t *testing.T) {
+	dir := t.TempDir()
+	id, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.HasCertificate() {
+		t.Error("HasCertificate() = true before enrollment")
+	}
+
+	info, err := os.Stat(filepath.Join(dir, BundleFile))
+	if err != nil {
+		t.Fatalf("device key not stored on first run: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("identity file permissions = %v, want 0600", perm)
+	}
+
+	again, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey(t, again) != publicKey(t, id) {
+		t.Error("reloading generated a different device key")
+	}
+}
+
+func TestEnrollmentUsesStoredKey(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	id, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := publicKey(t, id)
+
+	// The CSR is for the key stored on first run, so a crash before the
+	// certificate arrives doesn't lose it
+	csr, err := id.CreateCSR("device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Install(ca.Sign(csr)); err != nil {
+		t.Fatalf("Install() after restart error = %v", err)
+	}
+	if publicKey(t, restarted) != before {
+		t.Error("enrollment replaced the device key")
+	}
+
+	reloaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.HasCertificate() {
+		t.Error("certificate not restored from the identity file")
+	}
+}
+
+func TestRenewalKeepsCurrentCertificateUntilInstalled(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	id, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enroll(t, id, ca)
+	oldKey := publicKey(t, id)
+	oldSerial := id.cert.Leaf.SerialNumber.Int64()
+
+	csr, err := id.CreateCSR("device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, PendingKeyFile)); err != nil {
+		t.Fatalf("renewal key not stored: %v", err)
+	}
+	if got := id.cert.Leaf.SerialNumber.Int64(); got != oldSerial {
+		t.Errorf("serving certificate %d during renewal, want %d", got, oldSerial)
+	}
+
+	// The renewal survives a restart between request and certificate
+	restarted, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Install(ca.Sign(csr)); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if publicKey(t, restarted) == oldKey {
+		t.Error("renewal kept the old key")
+	}
+	if _, err := os.Stat(filepath.Join(dir, PendingKeyFile)); !os.IsNotExist(err) {
+		t.Errorf("renewal key left behind: %v", err)
+	}
+
+	reloaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey(t, reloaded) != publicKey(t, restarted) || !reloaded.HasCertificate() {
+		t.Error("identity file doesn't hold the renewed key and certificate")
+	}
+}
+
+func TestInstallRejectsForeignCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	id, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := other.CreateCSR("someone-else")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := id.Install(ca.Sign(csr)); err == nil {
+		t.Fatal("Install() accepted a certificate for another key")
+	}
+	if id.HasCertificate() {
+		t.Error("HasCertificate() = true after a rejected install")
+	}
+}
+
+func TestLoadMigratesSeparateFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	// Write the layout of older agents
+	key, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device-1"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := ca.Sign(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})))
+	os.WriteFile(filepath.Join(dir, KeyFile), keyPEM, 0600)
+	os.WriteFile(filepath.Join(dir, CertificateFile), []byte(certPEM), 0600)
+
+	id, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.HasCertificate() {
+		t.Fatal("certificate lost in migration")
+	}
+	for _, name := range []string{KeyFile, CertificateFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after migration", name)
+		}
+	}
+}
+
+func TestMutualTLSWithLocalServer(t *testing.T) {
+	ca := newTestCA(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := r.TLS.PeerCertificates[0]
+		io.WriteString(w, cert.SerialNumber.String())
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: ca.Pool()}
+	server.StartTLS()
+	defer server.Close()
+
+	id, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client()
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.GetClientCertificate = id.GetClientCertificate
+
+	serial := func() (string, error) {
+		transport.CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	if _, err := serial(); err == nil {
+		t.Fatal("server accepted a handshake without a client certificate")
+	}
+
+	enroll(t, id, ca)
+	if got, err := serial(); err != nil || got != "2" {
+		t.Fatalf("presented certificate %q (error %v), want serial 2", got, err)
+	}
+
+	// A renewed certificate is used without rebuilding the client
+	enroll(t, id, ca)
+	if got, err := serial(); err != nil || got != "3" {
+		t.Fatalf("presented certificate %q (error %v), want serial 3", got, err)
+	}
+}
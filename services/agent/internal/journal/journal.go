@@ -0,0 +1,273 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntries is the number of commands remembered by default
+const DefaultMaxEntries = 1000
+
+// State is the lifecycle state of a journaled command
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+)
+
+// Entry records the latest known state of a command
+type Entry struct {
+	CommandID string    `json:"commandId"`
+	Type      string    `json:"type"`
+	State     State     `json:"state"`
+	Reason    string    `json:"reason,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Journal is an append-only on-disk record of command IDs and their states.
+// It keeps the most recent maxEntries commands and is compacted as it grows.
+type Journal struct {
+	mu          sync.Mutex
+	path        string
+	file        *os.File
+	maxEntries  int
+	entries     map[string]*Entry
+	order       []string
+	lines       int
+	interrupted []Entry
+}
+
+// Open loads the journal at path, creating it if needed.
+// Commands left running by a previous process are returned by Interrupted.
+// An empty path gives an in-memory journal.
+func Open(path string, maxEntries int) (*Journal, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+
+	j := &Journal{
+		path:       path,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*Entry),
+	}
+
+	if path == "" {
+		return j, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create journal directory: %w", err)
+	}
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range j.order {
+		if e := j.entries[id]; e.State == StateRunning {
+			j.interrupted = append(j.interrupted, *e)
+		}
+	}
+
+	// Start every process from a compacted file
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// load replays the journal file into memory, skipping a torn final line
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.CommandID == "" {
+			continue
+		}
+		j.apply(e)
+	}
+	return scanner.Err()
+}
+
+// apply records an entry in memory, evicting the oldest commands beyond maxEntries
+func (j *Journal) apply(e Entry) {
+	if existing, ok := j.entries[e.CommandID]; ok {
+		*existing = e
+		return
+	}
+
+	j.entries[e.CommandID] = &e
+	j.order = append(j.order, e.CommandID)
+
+	for len(j.order) > j.maxEntries {
+		oldest := j.order[0]
+		// Never forget a command that is still running
+		if j.entries[oldest].State == StateRunning {
+			break
+		}
+		delete(j.entries, oldest)
+		j.order = j.order[1:]
+	}
+}
+
+// Interrupted returns the commands that were running when the previous process stopped
+func (j *Journal) Interrupted() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return append([]Entry(nil), j.interrupted...)
+}
+
+// Seen reports whether a command ID has been recorded
+func (j *Journal) Seen(commandID string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, ok := j.entries[commandID]
+	return ok
+}
+
+// Begin records that a command started running.
+// It returns false without recording anything if the command was already seen.
+func (j *Journal) Begin(commandID, commandType string) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.entries[commandID]; ok {
+		return false, nil
+	}
+
+	return true, j.record(Entry{
+		CommandID: commandID,
+		Type:      commandType,
+		State:     StateRunning,
+		UpdatedAt: time.Now().UTC(),
+	})
+}
+
+// Finish records the final state of a command
+func (j *Journal) Finish(commandID string, success bool, reason string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e := Entry{
+		CommandID: commandID,
+		State:     StateCompleted,
+		Reason:    reason,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if !success {
+		e.State = StateFailed
+	}
+	if existing, ok := j.entries[commandID]; ok {
+		e.Type = existing.Type
+	}
+
+	return j.record(e)
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// record applies an entry and appends it to disk, compacting when the file holds
+// twice as many lines as live entries. Callers must hold j.mu.
+func (j *Journal) record(e Entry) error {
+	j.apply(e)
+
+	if j.path == "" {
+		return nil
+	}
+
+	if j.lines >= 2*j.maxEntries {
+		return j.compact()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal journal entry: %w", err)
+	}
+
+	if j.file == nil {
+		f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("open journal: %w", err)
+		}
+		j.file = f
+	}
+
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	j.lines++
+	return j.file.Sync()
+}
+
+// compact rewrites the journal with one line per live entry and swaps it in atomically.
+// Callers must hold j.mu.
+func (j *Journal) compact() error {
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("create compacted journal: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, id := range j.order {
+		data, err := json.Marshal(j.entries[id])
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("marshal journal entry: %w", err)
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write compacted journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync compacted journal: %w", err)
+	}
+	tmp.Close()
+
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return fmt.Errorf("replace journal: %w", err)
+	}
+
+	j.lines = len(j.order)
+	return nil
+}
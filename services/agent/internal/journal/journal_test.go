@@ -0,0 +1,200 @@
+package journal
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestJournal(t *testing.T, path string, maxEntries int) *Journal {
+	t.Helper()
+	j, err := Open(path, maxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+// countLines returns the number of lines in the journal file
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func begin(t *testing.T, j *Journal, id string) {
+	t.Helper()
+	started, err := j.Begin(id, "install_updates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !started {
+		t.Fatalf("Begin(%s) = false for a new command", id)
+	}
+}
+
+func finish(t *testing.T, j *Journal, id string) {
+	t.Helper()
+	if err := j.Finish(id, true, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBeginRejectsDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.journal")
+	j := openTestJournal(t, path, 10)
+
+	begin(t, j, "cmd-1")
+	if started, err := j.Begin("cmd-1", "install_updates"); started || err != nil {
+		t.Errorf("Begin() of a running command = %v, %v; want false", started, err)
+	}
+	finish(t, j, "cmd-1")
+	if started, _ := j.Begin("cmd-1", "install_updates"); started {
+		t.Error("Begin() of a completed command = true")
+	}
+	j.Close()
+
+	// Duplicates are still caught after a restart
+	j = openTestJournal(t, path, 10)
+	if !j.Seen("cmd-1") {
+		t.Fatal("Seen() = false after a restart")
+	}
+	if started, _ := j.Begin("cmd-1", "install_updates"); started {
+		t.Error("Begin() after a restart = true for a completed command")
+	}
+}
+
+func TestInterruptedAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.journal")
+	j := openTestJournal(t, path, 10)
+
+	begin(t, j, "done")
+	finish(t, j, "done")
+	begin(t, j, "failed")
+	if err := j.Finish("failed", false, "exit code 1"); err != nil {
+		t.Fatal(err)
+	}
+	begin(t, j, "running")
+	if got := j.Interrupted(); len(got) != 0 {
+		t.Errorf("Interrupted() = %+v before a restart, want none", got)
+	}
+	j.Close()
+
+	j = openTestJournal(t, path, 10)
+	got := j.Interrupted()
+	if len(got) != 1 || got[0].CommandID != "running" || got[0].Type != "install_updates" {
+		t.Fatalf("Interrupted() = %+v, want only the running command", got)
+	}
+
+	// Once reported as failed, the command isn't interrupted by the next restart
+	if err := j.Finish("running", false, "agent restarted"); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if got := openTestJournal(t, path, 10).Interrupted(); len(got) != 0 {
+		t.Errorf("Interrupted() = %+v after finishing, want none", got)
+	}
+}
+
+func TestOpenSkipsTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.journal")
+	data := `{"commandId":"cmd-1","type":"run_scan","state":"completed","updatedAt":"2024-01-01T00:00:00Z"}
+{"commandId":"cmd-2","type":"run_scan","state":"running","updatedAt":"2024-01-01T00:00:01Z"}
+{"commandId":"cmd-3","type":"run_sc`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j := openTestJournal(t, path, 10)
+	if !j.Seen("cmd-1") || !j.Seen("cmd-2") {
+		t.Error("entries before the torn line were lost")
+	}
+	if j.Seen("cmd-3") {
+		t.Error("torn entry was loaded")
+	}
+	if got := j.Interrupted(); len(got) != 1 || got[0].CommandID != "cmd-2" {
+		t.Errorf("Interrupted() = %+v, want cmd-2", got)
+	}
+
+	// The file was rewritten without the torn line, so appends start on a fresh line
+	begin(t, j, "cmd-4")
+	j.Close()
+	j = openTestJournal(t, path, 10)
+	if !j.Seen("cmd-4") {
+		t.Error("entry appended after recovery was lost")
+	}
+}
+
+func TestEntriesAreBounded(t *testing.T) {
+	j := openTestJournal(t, "", 2)
+
+	for _, id := range []string{"cmd-1", "cmd-2", "cmd-3"} {
+		begin(t, j, id)
+		finish(t, j, id)
+	}
+	if j.Seen("cmd-1") {
+		t.Error("oldest command kept beyond maxEntries")
+	}
+	if !j.Seen("cmd-2") || !j.Seen("cmd-3") {
+		t.Error("recent commands forgotten")
+	}
+}
+
+func TestRunningEntriesAreNotEvicted(t *testing.T) {
+	j := openTestJournal(t, "", 2)
+
+	begin(t, j, "running")
+	for _, id := range []string{"cmd-1", "cmd-2", "cmd-3"} {
+		begin(t, j, id)
+		finish(t, j, id)
+	}
+	if !j.Seen("running") {
+		t.Error("running command was evicted")
+	}
+}
+
+func TestCompactsAtTwiceMaxEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.journal")
+	j := openTestJournal(t, path, 2)
+
+	// Each command takes two lines: running and completed
+	begin(t, j, "cmd-1")
+	finish(t, j, "cmd-1")
+	begin(t, j, "cmd-2")
+	finish(t, j, "cmd-2")
+	if got := countLines(t, path); got != 4 {
+		t.Fatalf("journal has %d lines, want 4 before compaction", got)
+	}
+
+	// The next record finds 2×maxEntries lines and compacts to one per live entry
+	begin(t, j, "cmd-3")
+	if got := countLines(t, path); got != 2 {
+		t.Fatalf("journal has %d lines after compaction, want 2", got)
+	}
+
+	finish(t, j, "cmd-3")
+	if got := countLines(t, path); got != 3 {
+		t.Errorf("journal has %d lines, want appends to continue after compaction", got)
+	}
+	j.Close()
+
+	j = openTestJournal(t, path, 2)
+	if j.Seen("cmd-1") || !j.Seen("cmd-2") || !j.Seen("cmd-3") {
+		t.Error("compacted journal doesn't hold the two most recent commands")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default limits for queued items that may be dropped
+const (
+	DefaultMaxItems = 500
+	DefaultMaxBytes = 8 << 20
+	DefaultMaxAge   = 72 * time.Hour
+)
+
+// itemExt is the file extension of queued items
+const itemExt = ".json"
+
+// Limits bounds the outbox. They only apply to replaceable items:
+// items added with Append are kept until they are delivered.
+type Limits struct {
+	MaxItems int
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+// DefaultLimits returns the default outbox limits
+func DefaultLimits() Limits {
+	return Limits{
+		MaxItems: DefaultMaxItems,
+		MaxBytes: DefaultMaxBytes,
+		MaxAge:   DefaultMaxAge,
+	}
+}
+
+// Item is an outbound API call waiting to be delivered
+type Item struct {
+	Seq         uint64          `json:"seq"`
+	Kind        string          `json:"kind"`
+	Replaceable bool            `json:"replaceable,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Attempts    int             `json:"attempts,omitempty"`
+	LastError   string          `json:"lastError,omitempty"`
+
+	size int64
+}
+
+// Decode unmarshals the item payload into v
+func (it *Item) Decode(v interface{}) error {
+	return json.Unmarshal(it.Payload, v)
+}
+
+// Outbox is a durable FIFO queue of outbound API calls, one file per item.
+// An empty directory gives an in-memory outbox.
+type Outbox struct {
+	mu     sync.Mutex
+	dir    string
+	limits Limits
+	items  []*Item
+	bytes  int64
+	seq    uint64
+	now    func() time.Time
+}
+
+// Open loads the outbox stored in dir, creating the directory if needed.
+// Unreadable item files are removed.
+func Open(dir string, limits Limits) (*Outbox, error) {
+	o := &Outbox{
+		dir:    dir,
+		limits: limits,
+		now:    time.Now,
+	}
+
+	if dir == "" {
+		return o, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create outbox directory: %w", err)
+	}
+
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enforceLimits()
+	return o, nil
+}
+
+// load reads every item file in sequence order
+func (o *Outbox) load() error {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return fmt.Errorf("read outbox: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, itemExt) {
+			// Leftover temp files from an interrupted write
+			if strings.HasSuffix(name, ".tmp") {
+				os.Remove(filepath.Join(o.dir, name))
+			}
+			continue
+		}
+
+		path := filepath.Join(o.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var it Item
+		if err := json.Unmarshal(data, &it); err != nil || it.Kind == "" || it.Seq == 0 {
+			os.Remove(path)
+			continue
+		}
+		it.size = int64(len(data))
+
+		o.items = append(o.items, &it)
+		o.bytes += it.size
+		if it.Seq > o.seq {
+			o.seq = it.Seq
+		}
+	}
+
+	sort.Slice(o.items, func(i, j int) bool { return o.items[i].Seq < o.items[j].Seq })
+	return nil
+}
+
+// Append queues an item that must be delivered, such as a command completion.
+// It is never dropped by the outbox limits.
+func (o *Outbox) Append(kind string, payload interface{}) error {
+	return o.add(kind, payload, false)
+}
+
+// Replace queues an item that supersedes every queued item of the same kind,
+// such as a heartbeat or a full update report. Replaceable items are subject to the limits.
+func (o *Outbox) Replace(kind string, payload interface{}) error {
+	return o.add(kind, payload, true)
+}
+
+func (o *Outbox) add(kind string, payload interface{}, replaceable bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode %s payload: %w", kind, err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	it := &Item{
+		Seq:         o.seq,
+		Kind:        kind,
+		Replaceable: replaceable,
+		Payload:     data,
+		CreatedAt:   o.now().UTC(),
+	}
+	if err := o.write(it); err != nil {
+		return err
+	}
+
+	if replaceable {
+		kept := o.items[:0]
+		for _, old := range o.items {
+			if old.Replaceable && old.Kind == kind {
+				o.drop(old)
+				continue
+			}
+			kept = append(kept, old)
+		}
+		o.items = kept
+	}
+
+	o.items = append(o.items, it)
+	o.bytes += it.size
+	o.enforceLimits()
+	return nil
+}
+
+// Peek returns the oldest queued item, or nil if the outbox is empty
+func (o *Outbox) Peek() *Item {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.enforceLimits()
+	if len(o.items) == 0 {
+		return nil
+	}
+	it := *o.items[0]
+	return &it
+}
+
+// Remove deletes a delivered or undeliverable item
+func (o *Outbox) Remove(seq uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, it := range o.items {
+		if it.Seq == seq {
+			o.items = append(o.items[:i], o.items[i+1:]...)
+			return o.drop(it)
+		}
+	}
+	return nil
+}
+
+// MarkFailed records a failed delivery attempt of an item
+func (o *Outbox) MarkFailed(seq uint64, deliveryErr error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, it := range o.items {
+		if it.Seq == seq {
+			it.Attempts++
+			it.LastError = deliveryErr.Error()
+			o.bytes -= it.size
+			err := o.write(it)
+			o.bytes += it.size
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of queued items
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.items)
+}
+
+// enforceLimits drops the oldest replaceable items that are too old or over the size limits.
+// Callers must hold o.mu.
+func (o *Outbox) enforceLimits() {
+	cutoff := time.Time{}
+	if o.limits.MaxAge > 0 {
+		cutoff = o.now().Add(-o.limits.MaxAge)
+	}
+
+	overLimit := func() bool {
+		return (o.limits.MaxItems > 0 && len(o.items) > o.limits.MaxItems) ||
+			(o.limits.MaxBytes > 0 && o.bytes > o.limits.MaxBytes)
+	}
+
+	kept := o.items[:0]
+	for _, it := range o.items {
+		if it.Replaceable && it.CreatedAt.Before(cutoff) {
+			o.drop(it)
+			continue
+		}
+		kept = append(kept, it)
+	}
+	o.items = kept
+
+	for i := 0; i < len(o.items) && overLimit(); {
+		if !o.items[i].Replaceable {
+			i++
+			continue
+		}
+		o.drop(o.items[i])
+		o.items = append(o.items[:i], o.items[i+1:]...)
+	}
+}
+
+// write stores an item atomically. Callers must hold o.mu.
+func (o *Outbox) write(it *Item) error {
+	data, err := json.Marshal(it)
+	if err != nil {
+		return fmt.Errorf("encode outbox item: %w", err)
+	}
+	it.size = int64(len(data))
+
+	if o.dir == "" {
+		return nil
+	}
+
+	path := o.path(it)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write outbox item: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write outbox item: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("sync outbox item: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("close outbox item: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace outbox item: %w", err)
+	}
+	return nil
+}
+
+// drop deletes an item's file and accounting. Callers must hold o.mu and remove it from o.items.
+func (o *Outbox) drop(it *Item) error {
+	o.bytes -= it.size
+	if o.dir == "" {
+		return nil
+	}
+	if err := os.Remove(o.path(it)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove outbox item: %w", err)
+	}
+	return nil
+}
+
+// path returns the file of an item; zero-padded sequence numbers sort in queue order
+func (o *Outbox) path(it *Item) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%020d-%s%s", it.Seq, it.Kind, itemExt))
+}
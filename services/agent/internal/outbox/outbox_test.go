@@ -0,0 +1,130 @@
+package outbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type payload struct {
+	N int `json:"n"`
+}
+
+// peekN returns the payload of the oldest item, or -1 if the outbox is empty
+func peekN(t *testing.T, o *Outbox) (string, int) {
+	t.Helper()
+	it := o.Peek()
+	if it == nil {
+		return "", -1
+	}
+	var p payload
+	if err := it.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return it.Kind, p.N
+}
+
+func TestReplaceSupersedesOnlyItsKind(t *testing.T) {
+	o, err := Open("", DefaultLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Replace("heartbeat", payload{1})
+	o.Append("command_result", payload{2})
+	o.Replace("heartbeat", payload{3})
+	o.Append("command_result", payload{4})
+
+	want := []struct {
+		kind string
+		n    int
+	}{{"command_result", 2}, {"heartbeat", 3}, {"command_result", 4}}
+	for _, w := range want {
+		kind, n := peekN(t, o)
+		if kind != w.kind || n != w.n {
+			t.Fatalf("Peek() = %s %d, want %s %d", kind, n, w.kind, w.n)
+		}
+		o.Remove(o.Peek().Seq)
+	}
+	if o.Len() != 0 {
+		t.Errorf("Len() = %d after removing everything", o.Len())
+	}
+}
+
+func TestReopenRestoresQueue(t *testing.T) {
+	dir := t.TempDir()
+	o, err := Open(dir, DefaultLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Append("command_result", payload{1})
+	o.Replace("heartbeat", payload{2})
+	o.MarkFailed(o.Peek().Seq, errors.New("connection refused"))
+
+	// Leftovers of an interrupted write and unreadable files are cleaned up
+	os.WriteFile(filepath.Join(dir, "00000000000000000009-heartbeat.json.tmp"), []byte("{"), 0600)
+	os.WriteFile(filepath.Join(dir, "00000000000000000010-heartbeat.json"), []byte("not json"), 0600)
+
+	o, err = Open(dir, DefaultLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", o.Len())
+	}
+	it := o.Peek()
+	if it.Kind != "command_result" || it.Attempts != 1 || it.LastError != "connection refused" {
+		t.Errorf("Peek() = %+v, want the failed command result", it)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("outbox directory holds %d files, want 2", len(entries))
+	}
+
+	// New items continue the sequence
+	o.Append("command_result", payload{3})
+	o.Remove(it.Seq)
+	o.Remove(o.Peek().Seq)
+	if kind, n := peekN(t, o); kind != "command_result" || n != 3 {
+		t.Errorf("Peek() = %s %d, want command_result 3", kind, n)
+	}
+}
+
+func TestLimitsOnlyDropReplaceableItems(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	o, err := Open("", Limits{MaxItems: 2, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.now = func() time.Time { return now }
+
+	o.Replace("heartbeat", payload{1})
+	o.Append("command_result", payload{2})
+	o.Append("command_result", payload{3})
+	if o.Len() != 2 {
+		t.Fatalf("Len() = %d, want the heartbeat dropped", o.Len())
+	}
+	if kind, _ := peekN(t, o); kind != "command_result" {
+		t.Errorf("Peek() = %s, want command_result", kind)
+	}
+
+	o.Append("command_result", payload{4})
+	if o.Len() != 3 {
+		t.Errorf("Len() = %d, completions must be kept over the limit", o.Len())
+	}
+
+	// Replaceable items also expire
+	o.limits.MaxItems = 0
+	o.Replace("update_report", payload{5})
+	if o.Len() != 4 {
+		t.Fatalf("Len() = %d, want 4", o.Len())
+	}
+	now = now.Add(2 * time.Hour)
+	o.Peek()
+	if o.Len() != 3 {
+		t.Errorf("Len() = %d, want the expired update report dropped", o.Len())
+	}
+}
@@ -0,0 +1,977 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrPACUnsupported is returned for PAC constructs outside the supported subset
+var ErrPACUnsupported = errors.New("unsupported PAC construct")
+
+// maxCallDepth bounds recursion between functions defined in a PAC file
+const maxCallDepth = 64
+
+// PAC is a parsed proxy auto-config script.
+//
+// Only the subset of JavaScript PAC files are usually written in is supported:
+// function declarations, var, if/else, return, string and number literals,
+// ! && || == != === !== < <= > >= + and the standard PAC helper functions.
+// Time-based helpers (weekdayRange, dateRange, timeRange) are not supported.
+type PAC struct {
+	funcs map[string]*pacFunc
+
+	// Resolve looks up the addresses of a host; it defaults to net.LookupHost
+	Resolve func(host string) ([]string, error)
+
+	// LocalIP returns the address reported by myIpAddress
+	LocalIP func() string
+}
+
+// ParsePAC parses a PAC script. It must define FindProxyForURL(url, host).
+func ParsePAC(script string) (*PAC, error) {
+	tokens, err := lex(script)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	funcs := make(map[string]*pacFunc)
+	for !p.done() {
+		fn, err := p.function()
+		if err != nil {
+			return nil, err
+		}
+		funcs[fn.name] = fn
+	}
+
+	if fn := funcs["FindProxyForURL"]; fn == nil || len(fn.params) != 2 {
+		return nil, errors.New("PAC script doesn't define FindProxyForURL(url, host)")
+	}
+	return &PAC{funcs: funcs, Resolve: net.LookupHost, LocalIP: localIP}, nil
+}
+
+// FindProxyForURL runs the script for a request and returns its result, e.g. "PROXY p:8080; DIRECT"
+func (pac *PAC) FindProxyForURL(rawURL, host string) (string, error) {
+	e := &evaluator{pac: pac}
+	v, err := e.call("FindProxyForURL", []value{rawURL, host})
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("FindProxyForURL returned %v, not a string", v)
+	}
+	return s, nil
+}
+
+// --- lexer ---
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+var punctuation = []string{"===", "!==", "&&", "||", "==", "!=", "<=", ">=", "(", ")", "{", "}", ";", ",", "!", "<", ">", "+", "=", "."}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("PAC line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					return nil, fmt.Errorf("PAC line %d: unterminated string", line)
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("PAC line %d: unterminated string", line)
+			}
+			tokens = append(tokens, token{tokString, sb.String(), line})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, src[i:j], line})
+			i = j
+		case c == '_' || c == '$' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j], line})
+			i = j
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(src[i:], p) {
+					tokens = append(tokens, token{tokPunct, p, line})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("PAC line %d: %w: character %q", line, ErrPACUnsupported, c)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// --- parser ---
+
+type (
+	value interface{}
+
+	pacFunc struct {
+		name   string
+		params []string
+		body   []stmt
+	}
+
+	stmt interface{}
+
+	ifStmt struct {
+		cond      expr
+		then, els stmt
+	}
+	blockStmt  struct{ body []stmt }
+	returnStmt struct{ result expr }
+	varStmt    struct {
+		name string
+		init expr
+	}
+	exprStmt struct{ x expr }
+
+	expr interface{}
+
+	literal  struct{ v value }
+	ident    struct{ name string }
+	callExpr struct {
+		name string
+		args []expr
+		line int
+	}
+	methodExpr struct {
+		recv   expr
+		method string
+		args   []expr
+		line   int
+	}
+	unaryExpr  struct{ x expr }
+	binaryExpr struct {
+		op   string
+		x, y expr
+	}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokPunct, text: "<eof>"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) line() int {
+	if p.done() {
+		if len(p.tokens) == 0 {
+			return 1
+		}
+		return p.tokens[len(p.tokens)-1].line
+	}
+	return p.tokens[p.pos].line
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokIdent) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %q, found %q", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) identifier() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", p.errorf("expected a name, found %q", t.text)
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("PAC line %d: %s", p.line(), fmt.Sprintf(format, args...))
+}
+
+func (p *parser) unsupported(what string) error {
+	return fmt.Errorf("PAC line %d: %w: %s", p.line(), ErrPACUnsupported, what)
+}
+
+func (p *parser) function() (*pacFunc, error) {
+	if !p.accept("function") {
+		return nil, p.unsupported(fmt.Sprintf("top-level %q (only function declarations are supported)", p.peek().text))
+	}
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	fn := &pacFunc{name: name}
+	for !p.accept(")") {
+		if len(fn.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		param, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		fn.params = append(fn.params, param)
+	}
+
+	block, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	fn.body = block.body
+	return fn, nil
+}
+
+func (p *parser) block() (*blockStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	b := &blockStmt{}
+	for !p.accept("}") {
+		if p.done() {
+			return nil, p.errorf("unterminated block")
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			b.body = append(b.body, s)
+		}
+	}
+	return b, nil
+}
+
+func (p *parser) statement() (stmt, error) {
+	switch {
+	case p.accept(";"):
+		return nil, nil
+
+	case p.is("{"):
+		return p.block()
+
+	case p.accept("if"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		s := &ifStmt{cond: cond}
+		if s.then, err = p.statement(); err != nil {
+			return nil, err
+		}
+		if p.accept("else") {
+			if s.els, err = p.statement(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+
+	case p.accept("return"):
+		s := &returnStmt{}
+		if !p.is(";") && !p.is("}") {
+			var err error
+			if s.result, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		p.accept(";")
+		return s, nil
+
+	case p.accept("var"):
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		s := &varStmt{name: name, init: &literal{v: ""}}
+		if p.accept("=") {
+			if s.init, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		p.accept(";")
+		return s, nil
+
+	case p.peek().kind == tokIdent && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].text == "=":
+		name, _ := p.identifier()
+		p.pos++
+		init, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		p.accept(";")
+		return &varStmt{name: name, init: init}, nil
+
+	case p.is("for") || p.is("while") || p.is("switch") || p.is("do"):
+		return nil, p.unsupported(p.peek().text + " statement")
+	}
+
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	return &exprStmt{x: x}, nil
+}
+
+// binaryLevels lists binary operators from lowest to highest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"===", "!==", "==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+"},
+}
+
+func (p *parser) expression() (expr, error) {
+	return p.binary(0)
+}
+
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, candidate := range binaryLevels[level] {
+			if p.peek().kind == tokPunct && p.peek().text == candidate {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("!") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{x: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(".") {
+		line := p.line()
+		method, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		m := &methodExpr{recv: x, method: method, line: line}
+		if p.is("(") {
+			if m.args, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		} else if method != "length" {
+			return nil, p.unsupported("property " + method)
+		}
+		x = m
+	}
+	return x, nil
+}
+
+func (p *parser) arguments() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expr
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokString:
+		p.pos++
+		return &literal{v: t.text}, nil
+
+	case t.kind == tokNumber:
+		p.pos++
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", t.text)
+		}
+		return &literal{v: n}, nil
+
+	case t.kind == tokIdent:
+		p.pos++
+		switch t.text {
+		case "true":
+			return &literal{v: true}, nil
+		case "false":
+			return &literal{v: false}, nil
+		case "null", "undefined":
+			return &literal{v: nil}, nil
+		}
+		if p.is("(") {
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			return &callExpr{name: t.text, args: args, line: t.line}, nil
+		}
+		return &ident{name: t.text}, nil
+
+	case p.accept("("):
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	return nil, p.unsupported(fmt.Sprintf("%q in expression", t.text))
+}
+
+// --- evaluator ---
+
+type evaluator struct {
+	pac   *PAC
+	depth int
+}
+
+// errReturn carries a return value out of nested statements
+type errReturn struct{ v value }
+
+func (errReturn) Error() string { return "return" }
+
+func (e *evaluator) call(name string, args []value) (value, error) {
+	fn := e.pac.funcs[name]
+	if fn == nil {
+		return nil, fmt.Errorf("%w: function %s", ErrPACUnsupported, name)
+	}
+
+	e.depth++
+	defer func() { e.depth-- }()
+	if e.depth > maxCallDepth {
+		return nil, errors.New("PAC functions recurse too deeply")
+	}
+
+	scope := make(map[string]value, len(fn.params))
+	for i, param := range fn.params {
+		if i < len(args) {
+			scope[param] = args[i]
+		} else {
+			scope[param] = nil
+		}
+	}
+
+	for _, s := range fn.body {
+		if err := e.exec(s, scope); err != nil {
+			var ret errReturn
+			if errors.As(err, &ret) {
+				return ret.v, nil
+			}
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (e *evaluator) exec(s stmt, scope map[string]value) error {
+	switch s := s.(type) {
+	case *blockStmt:
+		for _, inner := range s.body {
+			if err := e.exec(inner, scope); err != nil {
+				return err
+			}
+		}
+	case *ifStmt:
+		cond, err := e.eval(s.cond, scope)
+		if err != nil {
+			return err
+		}
+		if truthy(cond) {
+			return e.exec(s.then, scope)
+		} else if s.els != nil {
+			return e.exec(s.els, scope)
+		}
+	case *returnStmt:
+		var v value
+		if s.result != nil {
+			var err error
+			if v, err = e.eval(s.result, scope); err != nil {
+				return err
+			}
+		}
+		return errReturn{v}
+	case *varStmt:
+		v, err := e.eval(s.init, scope)
+		if err != nil {
+			return err
+		}
+		scope[s.name] = v
+	case *exprStmt:
+		_, err := e.eval(s.x, scope)
+		return err
+	}
+	return nil
+}
+
+func (e *evaluator) eval(x expr, scope map[string]value) (value, error) {
+	switch x := x.(type) {
+	case *literal:
+		return x.v, nil
+
+	case *ident:
+		v, ok := scope[x.name]
+		if !ok {
+			return nil, fmt.Errorf("PAC: undefined variable %s", x.name)
+		}
+		return v, nil
+
+	case *unaryExpr:
+		v, err := e.eval(x.x, scope)
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(v), nil
+
+	case *binaryExpr:
+		return e.evalBinary(x, scope)
+
+	case *methodExpr:
+		recv, err := e.eval(x.recv, scope)
+		if err != nil {
+			return nil, err
+		}
+		s := toString(recv)
+		args, err := e.evalArgs(x.args, scope)
+		if err != nil {
+			return nil, err
+		}
+		switch x.method {
+		case "length":
+			return float64(len(s)), nil
+		case "toLowerCase":
+			return strings.ToLower(s), nil
+		case "toUpperCase":
+			return strings.ToUpper(s), nil
+		case "indexOf":
+			if len(args) < 1 {
+				return float64(-1), nil
+			}
+			return float64(strings.Index(s, toString(args[0]))), nil
+		case "substring":
+			return substring(s, args), nil
+		}
+		return nil, fmt.Errorf("PAC line %d: %w: method %s", x.line, ErrPACUnsupported, x.method)
+
+	case *callExpr:
+		args, err := e.evalArgs(x.args, scope)
+		if err != nil {
+			return nil, err
+		}
+		if builtin, ok := builtins[x.name]; ok {
+			return builtin(e.pac, args)
+		}
+		if _, ok := e.pac.funcs[x.name]; ok {
+			return e.call(x.name, args)
+		}
+		return nil, fmt.Errorf("PAC line %d: %w: function %s", x.line, ErrPACUnsupported, x.name)
+	}
+	return nil, fmt.Errorf("PAC: %w: expression %T", ErrPACUnsupported, x)
+}
+
+func (e *evaluator) evalArgs(args []expr, scope map[string]value) ([]value, error) {
+	values := make([]value, len(args))
+	for i, arg := range args {
+		v, err := e.eval(arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (e *evaluator) evalBinary(x *binaryExpr, scope map[string]value) (value, error) {
+	left, err := e.eval(x.x, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit like JavaScript, yielding the deciding operand
+	switch x.op {
+	case "||":
+		if truthy(left) {
+			return left, nil
+		}
+		return e.eval(x.y, scope)
+	case "&&":
+		if !truthy(left) {
+			return left, nil
+		}
+		return e.eval(x.y, scope)
+	}
+
+	right, err := e.eval(x.y, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x.op {
+	case "==", "===":
+		return equal(left, right), nil
+	case "!=", "!==":
+		return !equal(left, right), nil
+	case "+":
+		ln, lok := left.(float64)
+		rn, rok := right.(float64)
+		if lok && rok {
+			return ln + rn, nil
+		}
+		return toString(left) + toString(right), nil
+	}
+
+	ls, lok := left.(string)
+	rs, rok := right.(string)
+	if lok && rok {
+		switch x.op {
+		case "<":
+			return ls < rs, nil
+		case "<=":
+			return ls <= rs, nil
+		case ">":
+			return ls > rs, nil
+		default:
+			return ls >= rs, nil
+		}
+	}
+	ln, rn := toNumber(left), toNumber(right)
+	switch x.op {
+	case "<":
+		return ln < rn, nil
+	case "<=":
+		return ln <= rn, nil
+	case ">":
+		return ln > rn, nil
+	default:
+		return ln >= rn, nil
+	}
+}
+
+func truthy(v value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func equal(a, b value) bool {
+	if an, ok := a.(float64); ok {
+		if bn, ok := b.(float64); ok {
+			return an == bn
+		}
+	}
+	return a == b
+}
+
+func toString(v value) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func toNumber(v value) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return n
+	}
+	return math.NaN()
+}
+
+func substring(s string, args []value) string {
+	start, end := 0, len(s)
+	if len(args) > 0 {
+		start = clamp(int(toNumber(args[0])), len(s))
+	}
+	if len(args) > 1 {
+		end = clamp(int(toNumber(args[1])), len(s))
+	}
+	if start > end {
+		start, end = end, start
+	}
+	return s[start:end]
+}
+
+func clamp(n, max int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// --- PAC helper functions ---
+
+var builtins map[string]func(pac *PAC, args []value) (value, error)
+
+func init() {
+	builtins = map[string]func(pac *PAC, args []value) (value, error){
+		"isPlainHostName": func(_ *PAC, args []value) (value, error) {
+			return !strings.Contains(arg(args, 0), "."), nil
+		},
+		"dnsDomainIs": func(_ *PAC, args []value) (value, error) {
+			return strings.HasSuffix(strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1))), nil
+		},
+		"localHostOrDomainIs": func(_ *PAC, args []value) (value, error) {
+			host, hostdom := strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1))
+			return host == hostdom || (!strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+".")), nil
+		},
+		"dnsDomainLevels": func(_ *PAC, args []value) (value, error) {
+			return float64(strings.Count(arg(args, 0), ".")), nil
+		},
+		"shExpMatch": func(_ *PAC, args []value) (value, error) {
+			return shExpMatch(arg(args, 0), arg(args, 1)), nil
+		},
+		"isResolvable": func(pac *PAC, args []value) (value, error) {
+			return pac.resolveIPv4(arg(args, 0)) != nil, nil
+		},
+		"dnsResolve": func(pac *PAC, args []value) (value, error) {
+			if ip := pac.resolveIPv4(arg(args, 0)); ip != nil {
+				return ip.String(), nil
+			}
+			return nil, nil
+		},
+		"isInNet": func(pac *PAC, args []value) (value, error) {
+			ip := pac.resolveIPv4(arg(args, 0))
+			pattern := net.ParseIP(arg(args, 1)).To4()
+			mask := net.ParseIP(arg(args, 2)).To4()
+			if ip == nil || pattern == nil || mask == nil {
+				return false, nil
+			}
+			m := net.IPMask(mask)
+			return ip.Mask(m).Equal(pattern.Mask(m)), nil
+		},
+		"myIpAddress": func(pac *PAC, _ []value) (value, error) {
+			if pac.LocalIP == nil {
+				return "127.0.0.1", nil
+			}
+			return pac.LocalIP(), nil
+		},
+		"alert": func(_ *PAC, _ []value) (value, error) {
+			return nil, nil
+		},
+	}
+	for _, name := range []string{"weekdayRange", "dateRange", "timeRange"} {
+		name := name
+		builtins[name] = func(_ *PAC, _ []value) (value, error) {
+			return nil, fmt.Errorf("%w: function %s", ErrPACUnsupported, name)
+		}
+	}
+}
+
+func arg(args []value, i int) string {
+	if i >= len(args) || args[i] == nil {
+		return ""
+	}
+	return toString(args[i])
+}
+
+// resolveIPv4 returns host as an IPv4 address, resolving it if it isn't one
+func (pac *PAC) resolveIPv4(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4()
+	}
+	if pac.Resolve == nil {
+		return nil
+	}
+	addrs, err := pac.Resolve(host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr).To4(); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// shExpMatch matches s against a shell expression where * matches any run of
+// characters, including '/' and '.', and ? matches a single character
+func shExpMatch(s, pattern string) bool {
+	// Iterative wildcard matching with backtracking to the last star
+	si, pi := 0, 0
+	star, mark := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(pattern) && (pattern[pi] == '?' || pattern[pi] == s[si]):
+			si++
+			pi++
+		case pi < len(pattern) && pattern[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(pattern) && pattern[pi] == '*' {
+		pi++
+	}
+	return pi == len(pattern)
+}
+
+// localIP returns the address of the interface used for outbound traffic
+func localIP() string {
+	conn, err := net.Dial("udp", "192.0.2.1:80")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return "127.0.0.1"
+}
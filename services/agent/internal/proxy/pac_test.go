@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadFixture parses a PAC script from testdata with fake DNS and local address
+func loadFixture(t *testing.T, name string, hosts map[string]string, localIP string) *PAC {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pac, err := ParsePAC(string(data))
+	if err != nil {
+		t.Fatalf("ParsePAC(%s) error = %v", name, err)
+	}
+	pac.Resolve = func(host string) ([]string, error) {
+		if addr, ok := hosts[host]; ok {
+			return []string{addr}, nil
+		}
+		return nil, fmt.Errorf("lookup %s: no such host", host)
+	}
+	pac.LocalIP = func() string { return localIP }
+	return pac
+}
+
+func TestCorpPAC(t *testing.T) {
+	pac := loadFixture(t, "corp.pac", map[string]string{
+		"build.internal.example": "10.20.0.5",
+		"nas.home.example":       "192.168.1.20",
+		"api.example.com":        "198.51.100.7",
+	}, "10.1.2.3")
+
+	tests := []struct {
+		url  string
+		host string
+		want string
+	}{
+		{"https://intranet/", "intranet", "DIRECT"},
+		{"https://Wiki.Corp.Example.com/", "Wiki.Corp.Example.com", "DIRECT"},
+		{"https://build.internal.example/", "build.internal.example", "DIRECT"},
+		{"https://nas.home.example/", "nas.home.example", "DIRECT"},
+		{"https://10.9.8.7/", "10.9.8.7", "DIRECT"},
+		{"https://eu.updates.example.net/winget/index", "eu.updates.example.net", "PROXY updates-proxy.corp.example.com:8080; DIRECT"},
+		{"http://api.example.com/", "api.example.com", "PROXY proxy.corp.example.com:3128"},
+		{"https://api.example.com/", "api.example.com", "PROXY proxy.corp.example.com:3128; PROXY backup.corp.example.com:3128; DIRECT"},
+		{"https://a.b.c.example.com/", "a.b.c.example.com", "PROXY backup.corp.example.com:3128; DIRECT"},
+	}
+	for _, tt := range tests {
+		got, err := pac.FindProxyForURL(tt.url, tt.host)
+		if err != nil {
+			t.Errorf("FindProxyForURL(%s) error = %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FindProxyForURL(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLocalNetPAC(t *testing.T) {
+	hosts := map[string]string{
+		"files.example.com": "203.0.113.10",
+		"www.example.com":   "198.51.100.7",
+	}
+	tests := []struct {
+		localIP string
+		host    string
+		want    string
+	}{
+		{"172.20.1.5", "www.example.com", "PROXY branch-proxy:3128"},
+		{"10.1.2.3", "unknown.example.com", "PROXY proxy.corp.example.com:3128"},
+		{"10.1.2.3", "files.example.com", "SOCKS socks.corp.example.com:1080"},
+		{"10.1.2.3", "www.example.com", "DIRECT"},
+	}
+	for _, tt := range tests {
+		pac := loadFixture(t, "local-net.pac", hosts, tt.localIP)
+		got, err := pac.FindProxyForURL("https://"+tt.host+"/", tt.host)
+		if err != nil {
+			t.Errorf("FindProxyForURL(%s from %s) error = %v", tt.host, tt.localIP, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FindProxyForURL(%s from %s) = %q, want %q", tt.host, tt.localIP, got, tt.want)
+		}
+	}
+}
+
+func TestUnsupportedPAC(t *testing.T) {
+	// Time-based rules parse but can't be evaluated
+	pac := loadFixture(t, "time-based.pac", nil, "10.1.2.3")
+	if _, err := pac.FindProxyForURL("https://example.com/", "example.com"); !errors.Is(err, ErrPACUnsupported) {
+		t.Errorf("timeRange error = %v, want ErrPACUnsupported", err)
+	}
+
+	for _, name := range []string{"loop.pac", "missing-entry.pac"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParsePAC(string(data)); err == nil {
+			t.Errorf("ParsePAC(%s) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestShExpMatch(t *testing.T) {
+	tests := []struct {
+		s, pattern string
+		want       bool
+	}{
+		{"https://a.updates.example.net/x", "https://*.updates.example.net/*", true},
+		{"https://updates.example.net/x", "https://*.updates.example.net/*", false},
+		{"host.corp", "*.corp", true},
+		{"host1", "host?", true},
+		{"host12", "host?", false},
+		{"anything", "*", true},
+		{"", "*", true},
+		{"abc", "a*b*c", true},
+		{"acb", "a*b*c", false},
+	}
+	for _, tt := range tests {
+		if got := shExpMatch(tt.s, tt.pattern); got != tt.want {
+			t.Errorf("shExpMatch(%q, %q) = %v, want %v", tt.s, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestParsePACResult(t *testing.T) {
+	tests := []struct {
+		result string
+		want   string
+		direct bool
+		err    bool
+	}{
+		{"PROXY proxy:3128; DIRECT", "http://proxy:3128", false, false},
+		{"DIRECT", "", true, false},
+		{"HTTPS secure-proxy:443", "https://secure-proxy:443", false, false},
+		{"SOCKS5 socks:1080", "socks5://socks:1080", false, false},
+		{"PROXY noport; PROXY good:8080", "http://good:8080", false, false},
+		{"QUIC q:443; DIRECT", "", true, false},
+		{"  ", "", false, true},
+		{"PROXY", "", false, true},
+	}
+	for _, tt := range tests {
+		u, direct, err := ParsePACResult(tt.result)
+		if (err != nil) != tt.err || direct != tt.direct {
+			t.Errorf("ParsePACResult(%q) = %v, %v, %v", tt.result, u, direct, err)
+			continue
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("ParsePACResult(%q) = %q, want %q", tt.result, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pacRefreshInterval is how often a loaded PAC script is fetched again
+	pacRefreshInterval = time.Hour
+
+	// pacRetryInterval is how long to wait before retrying a PAC script that failed to load
+	pacRetryInterval = 5 * time.Minute
+
+	// maxPACSize bounds a PAC script
+	maxPACSize = 1 << 20
+)
+
+// Logger is the logging interface used by the resolver
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Options selects how outbound connections reach the network.
+// A PAC script is consulted first, then the explicit URL, then the environment.
+type Options struct {
+	// URL is an explicit proxy, e.g. http://proxy.corp:3128
+	URL string
+
+	// UseEnvironment honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	UseEnvironment bool
+
+	// PAC is the path or http(s) URL of a proxy auto-config script
+	PAC string
+
+	// PACCache is a file keeping the last PAC script fetched from a URL,
+	// used when the script can't be fetched before any fetch succeeded (optional)
+	PACCache string
+
+	// Username and Password are sent to proxies that don't carry credentials in their URL
+	Username string
+	Password string
+}
+
+// Resolver picks the proxy for each request. Its Proxy method fits both
+// http.Transport.Proxy and the websocket dialer.
+type Resolver struct {
+	opts     Options
+	explicit *url.URL
+	user     *url.Userinfo
+	logger   Logger
+	fetch    *http.Client
+
+	mu         sync.Mutex
+	pac        *PAC
+	pacErr     error
+	pacNext    time.Time
+	pacLoading chan struct{}
+	routes     map[string]string
+	lastWarn   string
+}
+
+// New validates opts and returns a resolver
+func New(opts Options, logger Logger) (*Resolver, error) {
+	r := &Resolver{
+		opts:   opts,
+		logger: logger,
+		routes: make(map[string]string),
+		// PAC scripts are fetched directly; they describe how to reach everything else
+		fetch: &http.Client{
+			Transport: &http.Transport{Proxy: nil},
+			Timeout:   30 * time.Second,
+		},
+	}
+
+	if opts.URL != "" {
+		u, err := parseProxyURL(opts.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		r.explicit = u
+	}
+	if opts.Username != "" {
+		r.user = url.UserPassword(opts.Username, opts.Password)
+	}
+	return r, nil
+}
+
+// String describes the configured proxy sources for logs, without credentials
+func (r *Resolver) String() string {
+	var parts []string
+	if r.opts.PAC != "" {
+		parts = append(parts, "PAC "+r.opts.PAC)
+	}
+	if r.explicit != nil {
+		parts = append(parts, r.explicit.Redacted())
+	}
+	if r.opts.UseEnvironment {
+		parts = append(parts, "environment")
+	}
+	if len(parts) == 0 {
+		return "direct"
+	}
+	s := strings.Join(parts, ", then ")
+	if r.user != nil {
+		s += " (authenticated as " + r.user.Username() + ")"
+	}
+	return s
+}
+
+// Proxy returns the proxy for req, or nil to connect directly.
+// It never fails the request: a broken PAC script falls back to the next source.
+func (r *Resolver) Proxy(req *http.Request) (*url.URL, error) {
+	u, source := r.resolve(req)
+	if u != nil && u.User == nil && r.user != nil {
+		withUser := *u
+		withUser.User = r.user
+		u = &withUser
+	}
+	r.logRoute(req.URL.Host, u, source)
+	return u, nil
+}
+
+// resolve returns the proxy for req and the source that chose it
+func (r *Resolver) resolve(req *http.Request) (*url.URL, string) {
+	if r.opts.PAC != "" {
+		u, direct, err := r.fromPAC(req)
+		if err == nil {
+			if direct {
+				return nil, "PAC"
+			}
+			return u, "PAC"
+		}
+		r.warn(fmt.Sprintf("PAC script %s unusable, falling back: %v", r.opts.PAC, err))
+	}
+
+	if r.explicit != nil {
+		return r.explicit, "config"
+	}
+
+	if r.opts.UseEnvironment {
+		u, err := http.ProxyFromEnvironment(req)
+		if err != nil {
+			r.warn(fmt.Sprintf("Proxy from environment unusable: %v", err))
+			return nil, "environment"
+		}
+		return u, "environment"
+	}
+	return nil, "direct"
+}
+
+// fromPAC evaluates the PAC script for req
+func (r *Resolver) fromPAC(req *http.Request) (*url.URL, bool, error) {
+	pac, err := r.loadPAC(req.Context())
+	if err != nil {
+		return nil, false, err
+	}
+
+	host := req.URL.Hostname()
+	result, err := pac.FindProxyForURL(req.URL.String(), host)
+	if err != nil {
+		return nil, false, err
+	}
+	return ParsePACResult(result)
+}
+
+// loadPAC returns the PAC script, fetching it again once it is stale.
+// The fetch runs without r.mu held: while it is in flight other requests keep
+// using the previous script, or wait for it if there is none yet.
+func (r *Resolver) loadPAC(ctx context.Context) (*PAC, error) {
+	r.mu.Lock()
+	if time.Now().Before(r.pacNext) || (r.pacLoading != nil && r.pac != nil) {
+		pac, err := r.pac, r.pacErr
+		r.mu.Unlock()
+		if pac != nil {
+			return pac, nil
+		}
+		return nil, err
+	}
+	if loading := r.pacLoading; loading != nil {
+		r.mu.Unlock()
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.pac != nil {
+			return r.pac, nil
+		}
+		return nil, r.pacErr
+	}
+	loading := make(chan struct{})
+	r.pacLoading = loading
+	r.mu.Unlock()
+
+	pac, err := r.readPAC(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pacLoading = nil
+	close(loading)
+
+	if err != nil {
+		r.pacNext = time.Now().Add(pacRetryInterval)
+		if r.pac != nil {
+			// Keep using the last good script
+			r.warnLocked(fmt.Sprintf("PAC script %s could not be refreshed, keeping the previous one: %v", r.opts.PAC, err))
+			return r.pac, nil
+		}
+		if cached, cacheErr := r.cachedPAC(); cacheErr == nil {
+			r.warnLocked(fmt.Sprintf("PAC script %s could not be fetched, using the cached copy: %v", r.opts.PAC, err))
+			r.pac = cached
+			return cached, nil
+		}
+		r.pacErr = err
+		return nil, err
+	}
+
+	r.pac, r.pacErr = pac, nil
+	r.pacNext = time.Now().Add(pacRefreshInterval)
+	return pac, nil
+}
+
+// readPAC reads and parses the PAC script from a file or URL
+func (r *Resolver) readPAC(ctx context.Context) (*PAC, error) {
+	var data []byte
+	if u, err := url.Parse(r.opts.PAC); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		// The request context may belong to a call that is about to give up
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.fetch.Timeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.opts.PAC, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := r.fetch.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetch PAC script: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch PAC script: %s", resp.Status)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxPACSize)); err != nil {
+			return nil, fmt.Errorf("fetch PAC script: %w", err)
+		}
+		pac, err := ParsePAC(string(data))
+		if err != nil {
+			return nil, err
+		}
+		if err := r.cachePAC(data); err != nil {
+			r.warn(fmt.Sprintf("PAC script %s could not be cached: %v", r.opts.PAC, err))
+		}
+		return pac, nil
+	} else {
+		path := r.opts.PAC
+		if u != nil && u.Scheme == "file" {
+			path = u.Path
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read PAC script: %w", err)
+		}
+	}
+	return ParsePAC(string(data))
+}
+
+// cachePAC keeps a fetched PAC script for starts where it can't be fetched
+func (r *Resolver) cachePAC(data []byte) error {
+	if r.opts.PACCache == "" {
+		return nil
+	}
+	tmp := r.opts.PACCache + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, r.opts.PACCache); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// cachedPAC returns the PAC script kept by cachePAC
+func (r *Resolver) cachedPAC() (*PAC, error) {
+	if r.opts.PACCache == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(r.opts.PACCache)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePAC(string(data))
+}
+
+// ParsePACResult returns the first usable proxy of a FindProxyForURL result such as
+// "PROXY a:3128; SOCKS b:1080; DIRECT". direct is true when the first usable entry is DIRECT.
+func ParsePACResult(result string) (u *url.URL, direct bool, err error) {
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		scheme := ""
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return nil, true, nil
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+			continue
+		}
+		return &url.URL{Scheme: scheme, Host: fields[1]}, false, nil
+	}
+	return nil, false, fmt.Errorf("no usable proxy in PAC result %q", result)
+}
+
+// logRoute logs the proxy used for a host whenever it changes
+func (r *Resolver) logRoute(host string, u *url.URL, source string) {
+	route := "direct"
+	if u != nil {
+		route = u.Redacted()
+	}
+	route += " (" + source + ")"
+
+	r.mu.Lock()
+	changed := r.routes[host] != route
+	r.routes[host] = route
+	r.mu.Unlock()
+
+	if changed && r.logger != nil {
+		r.logger.Printf("Proxy for %s: %s", host, route)
+	}
+}
+
+// warn logs a proxy problem once until a different one occurs
+func (r *Resolver) warn(message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnLocked(message)
+}
+
+// warnLocked is warn for callers holding r.mu
+func (r *Resolver) warnLocked(message string) {
+	if r.lastWarn == message {
+		return
+	}
+	r.lastWarn = message
+	if r.logger != nil {
+		r.logger.Printf("Warning: %s", message)
+	}
+}
+
+// parseProxyURL parses a proxy URL, defaulting to http:// when no scheme is given
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing proxy host in %q", raw)
+	}
+	return u, nil
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Printf(format string, v ...interface{}) { l.t.Logf(format, v...) }
+
+// pacServer serves a PAC script; hold, when set, delays every response until closed
+type pacServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	script string
+	status int
+	hold   chan struct{}
+	hits   int
+}
+
+func newPACServer(t *testing.T, script string) *pacServer {
+	s := &pacServer{script: script, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits++
+		script, status, hold := s.script, s.status, s.hold
+		s.mu.Unlock()
+
+		if hold != nil {
+			<-hold
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, script)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Hits returns how often the script was fetched
+func (s *pacServer) Hits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+func (s *pacServer) set(f func(s *pacServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s)
+}
+
+// proxyFor resolves the proxy for rawURL
+func proxyFor(t *testing.T, r *Resolver, rawURL string) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := r.Proxy(req)
+	if err != nil {
+		t.Fatalf("Proxy(%s) error = %v", rawURL, err)
+	}
+	if u == nil {
+		return "direct"
+	}
+	return u.String()
+}
+
+const testPAC = `function FindProxyForURL(url, host) {
+	if (dnsDomainIs(host, ".corp.example.com")) return "DIRECT";
+	return "PROXY pac-proxy.example.com:3128";
+}`
+
+func TestResolverPrefersPACAndFallsBack(t *testing.T) {
+	server := newPACServer(t, testPAC)
+	r, err := New(Options{PAC: server.URL + "/proxy.pac", URL: "fallback.example.com:8080"}, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := proxyFor(t, r, "https://wiki.corp.example.com/"); got != "direct" {
+		t.Errorf("intranet proxy = %s, want direct", got)
+	}
+	if got := proxyFor(t, r, "https://api.example.com/"); got != "http://pac-proxy.example.com:3128" {
+		t.Errorf("internet proxy = %s, want the PAC proxy", got)
+	}
+
+	// Without any good script the explicit proxy is used
+	broken := newPACServer(t, "")
+	broken.set(func(s *pacServer) { s.status = http.StatusNotFound })
+	r, err = New(Options{PAC: broken.URL + "/proxy.pac", URL: "fallback.example.com:8080"}, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := proxyFor(t, r, "https://api.example.com/"); got != "http://fallback.example.com:8080" {
+		t.Errorf("proxy with a missing PAC = %s, want the explicit proxy", got)
+	}
+	// Failed fetches aren't retried for every request
+	proxyFor(t, r, "https://api.example.com/")
+	if hits := broken.Hits(); hits != 1 {
+		t.Errorf("PAC fetched %d times, want 1 until the retry interval passes", hits)
+	}
+}
+
+func TestResolverKeepsPreviousPACWhenRefreshFails(t *testing.T) {
+	server := newPACServer(t, testPAC)
+	r, err := New(Options{PAC: server.URL + "/proxy.pac"}, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyFor(t, r, "https://api.example.com/")
+
+	server.set(func(s *pacServer) { s.status = http.StatusInternalServerError })
+	r.mu.Lock()
+	r.pacNext = time.Time{}
+	r.mu.Unlock()
+
+	if got := proxyFor(t, r, "https://api.example.com/"); got != "http://pac-proxy.example.com:3128" {
+		t.Errorf("proxy after a failed refresh = %s, want the previous PAC result", got)
+	}
+}
+
+func TestPACRefreshDoesNotBlockRequests(t *testing.T) {
+	server := newPACServer(t, testPAC)
+	r, err := New(Options{PAC: server.URL + "/proxy.pac"}, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyFor(t, r, "https://api.example.com/")
+
+	// The refresh hangs until released
+	hold := make(chan struct{})
+	server.set(func(s *pacServer) {
+		s.hold = hold
+		s.script = strings.Replace(testPAC, "pac-proxy", "new-proxy", 1)
+	})
+	r.mu.Lock()
+	r.pacNext = time.Time{}
+	r.mu.Unlock()
+
+	refreshed := make(chan string)
+	go func() { refreshed <- proxyFor(t, r, "https://api.example.com/") }()
+	for server.Hits() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan string)
+	go func() { done <- proxyFor(t, r, "https://other.example.com/") }()
+	select {
+	case got := <-done:
+		if got != "http://pac-proxy.example.com:3128" {
+			t.Errorf("proxy during refresh = %s, want the previous PAC result", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request blocked while the PAC script was being fetched")
+	}
+
+	close(hold)
+	if got := <-refreshed; got != "http://new-proxy.example.com:3128" {
+		t.Errorf("proxy after refresh = %s, want the new PAC result", got)
+	}
+}
+
+func TestResolverUsesCachedPACWhenFetchFails(t *testing.T) {
+	cache := filepath.Join(t.TempDir(), "proxy.pac")
+	server := newPACServer(t, testPAC)
+	r, err := New(Options{PAC: server.URL + "/proxy.pac", PACCache: cache, URL: "fallback.example.com:8080"}, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyFor(t, r, "https://api.example.com/")
+	if _, err := os.Stat(cache); err != nil {
+		t.Fatalf("fetched PAC script not cached: %v", err)
+	}
+
+	// The next start can't reach the PAC server
+	server.set(func(s *pacServer) { s.status = http.StatusServiceUnavailable })
+	r, err = New(Options{PAC: server.URL + "/proxy.pac", PACCache: cache, URL: "fallback.example.com:8080"}, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := proxyFor(t, r, "https://api.example.com/"); got != "http://pac-proxy.example.com:3128" {
+		t.Errorf("proxy with an unreachable PAC = %s, want the cached PAC result", got)
+	}
+
+	// Once the server is back its script replaces the cached one
+	server.set(func(s *pacServer) {
+		s.status = http.StatusOK
+		s.script = strings.Replace(testPAC, "pac-proxy", "new-proxy", 1)
+	})
+	r.mu.Lock()
+	r.pacNext = time.Time{}
+	r.mu.Unlock()
+	if got := proxyFor(t, r, "https://api.example.com/"); got != "http://new-proxy.example.com:3128" {
+		t.Errorf("proxy after the PAC server recovered = %s, want the new PAC result", got)
+	}
+	if data, err := os.ReadFile(cache); err != nil || !strings.Contains(string(data), "new-proxy") {
+		t.Errorf("cached PAC script = %q, %v; want the new script", data, err)
+	}
+}
+
+// standInProxy is a forward proxy that requires basic auth and answers for every host itself
+func standInProxy(t *testing.T, username, password string) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var seen []string
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.String())
+		mu.Unlock()
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="corp"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		fmt.Fprintf(w, "proxied %s", r.URL)
+	}))
+	t.Cleanup(server.Close)
+	return server, &seen
+}
+
+func TestRequestsGoThroughAuthenticatedProxy(t *testing.T) {
+	proxyServer, seen := standInProxy(t, "agent", "s3cret")
+	pac := newPACServer(t, fmt.Sprintf(`function FindProxyForURL(url, host) { return "PROXY %s"; }`, strings.TrimPrefix(proxyServer.URL, "http://")))
+
+	tests := []struct {
+		name     string
+		opts     Options
+		password string
+		status   int
+	}{
+		{"explicit", Options{URL: proxyServer.URL, Username: "agent", Password: "s3cret"}, "s3cret", http.StatusOK},
+		{"pac", Options{PAC: pac.URL, Username: "agent", Password: "s3cret"}, "s3cret", http.StatusOK},
+		{"wrong password", Options{URL: proxyServer.URL, Username: "agent", Password: "wrong"}, "wrong", http.StatusProxyAuthRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(tt.opts, testLogger{t})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(r.String(), tt.password) {
+				t.Errorf("String() = %q leaks the password", r.String())
+			}
+
+			client := &http.Client{Transport: &http.Transport{Proxy: r.Proxy}}
+			resp, err := client.Get("http://api.lunaris.test/agent/heartbeat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d (%s), want %d", resp.StatusCode, body, tt.status)
+			}
+		})
+	}
+	if len(*seen) != len(tests) {
+		t.Errorf("proxy saw %d requests, want %d", len(*seen), len(tests))
+	}
+	for _, u := range *seen {
+		if parsed, _ := url.Parse(u); parsed.Host != "api.lunaris.test" {
+			t.Errorf("proxy saw request for %s", u)
+		}
+	}
+}
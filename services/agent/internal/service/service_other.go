@@ -21,7 +21,7 @@ func IsWindowsService() bool {
 }
 
 // InstallService is not supported on non-Windows platforms
-func InstallService(exePath string) error {
+func InstallService(exePath string, args ...string) error {
 	return errors.New("service installation not supported on this platform")
 }
 
@@ -146,8 +146,9 @@ func IsWindowsService() bool {
 	return isService
 }
 
-// InstallService installs the Windows service to run as the current user
-func InstallService(exePath string) error {
+// InstallService installs the Windows service to run as the current user.
+// args are added to the service command line, e.g. directory overrides.
+func InstallService(exePath string, args ...string) error {
 	m, err := mgr.Connect()
 	if err != nil {
 		return fmt.Errorf("failed to connect to service manager: %w", err)
@@ -164,7 +165,7 @@ func InstallService(exePath string) error {
 		DisplayName: ServiceDisplayName,
 		Description: ServiceDescription,
 		StartType:   mgr.StartAutomatic,
-	})
+	}, args...)
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
 	}
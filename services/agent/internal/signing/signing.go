@@ -0,0 +1,344 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported algorithms
+const (
+	AlgorithmHMAC    = "hmac-sha256"
+	AlgorithmEd25519 = "ed25519"
+)
+
+// Headers carrying request and response signatures
+const (
+	HeaderTimestamp = "X-Lunaris-Timestamp"
+	HeaderNonce     = "X-Lunaris-Nonce"
+	HeaderSignature = "X-Lunaris-Signature"
+)
+
+// DefaultMaxSkew is the clock difference tolerated when verifying responses
+const DefaultMaxSkew = 5 * time.Minute
+
+// ErrBadSignature is returned when a response is unsigned or its signature doesn't verify
+var ErrBadSignature = errors.New("response signature verification failed")
+
+// Signer signs canonical messages
+type Signer interface {
+	Algorithm() string
+	Sign(message []byte) []byte
+}
+
+// Verifier checks signatures made by the server
+type Verifier interface {
+	Algorithm() string
+	Verify(message, signature []byte) bool
+}
+
+// HMAC signs and verifies with a shared key
+type HMAC struct {
+	key []byte
+}
+
+// NewHMAC returns an HMAC-SHA256 signer for a shared key
+func NewHMAC(key []byte) *HMAC {
+	return &HMAC{key: append([]byte(nil), key...)}
+}
+
+func (h *HMAC) Algorithm() string { return AlgorithmHMAC }
+
+func (h *HMAC) Sign(message []byte) []byte {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (h *HMAC) Verify(message, signature []byte) bool {
+	return hmac.Equal(h.Sign(message), signature)
+}
+
+// Ed25519Signer signs with the device's private key
+type Ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer returns a signer for a device private key
+func NewEd25519Signer(key ed25519.PrivateKey) *Ed25519Signer {
+	return &Ed25519Signer{key: key}
+}
+
+func (s *Ed25519Signer) Algorithm() string { return AlgorithmEd25519 }
+
+func (s *Ed25519Signer) Sign(message []byte) []byte {
+	return ed25519.Sign(s.key, message)
+}
+
+// PublicKey returns the base64 public key the server verifies requests with
+func (s *Ed25519Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Ed25519Verifier verifies signatures made with the server's key
+type Ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+// NewEd25519Verifier parses a base64 Ed25519 public key
+func NewEd25519Verifier(publicKey string) (*Ed25519Verifier, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key: expected %d base64 bytes", ed25519.PublicKeySize)
+	}
+	return &Ed25519Verifier{key: raw}, nil
+}
+
+func (v *Ed25519Verifier) Algorithm() string { return AlgorithmEd25519 }
+
+func (v *Ed25519Verifier) Verify(message, signature []byte) bool {
+	return ed25519.Verify(v.key, message, signature)
+}
+
+// Clock tracks the offset between the local clock and the server's.
+// Timestamps are generated and checked in server time, so a drifting device clock
+// doesn't make every request look like a replay.
+type Clock struct {
+	mu     sync.RWMutex
+	offset time.Duration
+	synced bool
+}
+
+// Now returns the current time as the server sees it
+func (c *Clock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Now().Add(c.offset)
+}
+
+// Offset returns how far the server clock is ahead of the local clock
+func (c *Clock) Offset() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.offset
+}
+
+// Observe records a server timestamp from a response to a request sent at sentAt.
+// The server time is compared against the midpoint of the round trip.
+func (c *Clock) Observe(serverTime, sentAt, receivedAt time.Time) {
+	midpoint := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset = serverTime.Sub(midpoint)
+	c.synced = true
+}
+
+// Synced reports whether a server time has been observed
+func (c *Clock) Synced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.synced
+}
+
+// observeDate takes a rough offset from a response Date header until a
+// server time has been observed, so the first signed requests aren't rejected for skew
+func (c *Clock) observeDate(resp *http.Response, sentAt time.Time) {
+	if c.Synced() {
+		return
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.synced {
+		c.offset = date.Sub(sentAt.Add(time.Since(sentAt) / 2)).Truncate(time.Second)
+	}
+}
+
+// Auth signs outgoing requests and verifies server responses
+type Auth struct {
+	signer   Signer
+	verifier Verifier
+	clock    *Clock
+	maxSkew  time.Duration
+	nonces   *nonceCache
+}
+
+// NewAuth returns request authentication with the given signer and optional response verifier
+func NewAuth(signer Signer, verifier Verifier, clock *Clock, maxSkew time.Duration) *Auth {
+	if clock == nil {
+		clock = &Clock{}
+	}
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &Auth{
+		signer:   signer,
+		verifier: verifier,
+		clock:    clock,
+		maxSkew:  maxSkew,
+		nonces:   newNonceCache(2 * maxSkew),
+	}
+}
+
+// ObserveResponse feeds the Date header of a response to the clock before it is synced
+func (a *Auth) ObserveResponse(resp *http.Response, sentAt time.Time) {
+	a.clock.observeDate(resp, sentAt)
+}
+
+// SignRequest adds timestamp, nonce and signature headers to req and returns the nonce.
+// The signature covers the method, request URI, timestamp, nonce and body hash.
+func (a *Auth) SignRequest(req *http.Request) (string, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(a.clock.Now().Unix(), 10)
+
+	message := canonical(req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, a.signer.Algorithm()+"="+base64.StdEncoding.EncodeToString(a.signer.Sign(message)))
+	return nonce, nil
+}
+
+// VerifyResponse checks the signature of a response to the request signed with requestNonce.
+// The signature covers the status, the request nonce, its own timestamp and nonce, and the body,
+// so a response can't be replayed for another request. The body is restored for the caller.
+func (a *Auth) VerifyResponse(resp *http.Response, requestNonce string) error {
+	if a.verifier == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	timestamp := resp.Header.Get(HeaderTimestamp)
+	nonce := resp.Header.Get(HeaderNonce)
+	algorithm, encoded, _ := strings.Cut(resp.Header.Get(HeaderSignature), "=")
+	if timestamp == "" || nonce == "" || encoded == "" {
+		return fmt.Errorf("%w: response is not signed", ErrBadSignature)
+	}
+	if algorithm != a.verifier.Algorithm() {
+		return fmt.Errorf("%w: unexpected algorithm %q", ErrBadSignature, algorithm)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrBadSignature)
+	}
+
+	message := canonical(strconv.Itoa(resp.StatusCode), requestNonce, timestamp, nonce, body)
+	if !a.verifier.Verify(message, signature) {
+		return fmt.Errorf("%w: signature mismatch", ErrBadSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrBadSignature)
+	}
+	// The request nonce already makes the response fresh; until the clock is synced
+	// from a heartbeat a timestamp check would only reject responses for local drift
+	skew := a.clock.Now().Sub(time.Unix(seconds, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if a.clock.Synced() && skew > a.maxSkew {
+		return fmt.Errorf("%w: timestamp is %v off the server clock", ErrBadSignature, skew.Round(time.Second))
+	}
+
+	if !a.nonces.add(nonce) {
+		return fmt.Errorf("%w: replayed nonce", ErrBadSignature)
+	}
+	return nil
+}
+
+// canonical joins the signed fields of a request or response
+func canonical(first, second, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(strings.Join([]string{first, second, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n"))
+}
+
+// requestBody returns a copy of the request body without consuming it
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("request body can't be read for signing")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// newNonce returns 16 random bytes as hex
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// nonceCache remembers response nonces for as long as their timestamps are acceptable
+type nonceCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// add records a nonce and reports whether it was new
+func (c *nonceCache) add(nonce string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.pruned) > c.ttl {
+		for n, at := range c.seen {
+			if now.Sub(at) > c.ttl {
+				delete(c.seen, n)
+			}
+		}
+		c.pruned = now
+	}
+
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = now
+	return true
+}
@@ -0,0 +1,286 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestCanonical(t *testing.T) {
+	got := string(canonical("POST", "/api/agent/heartbeat?x=1", "1700000000", "abc", []byte(`{"a":1}`)))
+	want := "POST\n/api/agent/heartbeat?x=1\n1700000000\nabc\n" +
+		"015abd7f5cc57a2dd94b7590f04ad8084273905ee33ec5cebeae62276a97f862"
+	if got != want {
+		t.Errorf("canonical() = %q, want %q", got, want)
+	}
+
+	// An empty body hashes like any other, so it can't be swapped for a non-empty one
+	empty := string(canonical("GET", "/", "1", "n", nil))
+	if !strings.HasSuffix(empty, "\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855") {
+		t.Errorf("canonical() of an empty body = %q, want the SHA-256 of nothing", empty)
+	}
+}
+
+// signedRequest returns a POST request signed by auth
+func signedRequest(t *testing.T, auth *Auth, body string) (*http.Request, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "https://api.example/api/agent/heartbeat?x=1", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := auth.SignRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req, nonce
+}
+
+// verifyRequest checks a signed request the way the server does
+func verifyRequest(req *http.Request, v Verifier) bool {
+	algorithm, encoded, _ := strings.Cut(req.Header.Get(HeaderSignature), "=")
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || algorithm != v.Algorithm() {
+		return false
+	}
+	body, _ := requestBody(req)
+	message := canonical(req.Method, req.URL.RequestURI(), req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderNonce), body)
+	return v.Verify(message, signature)
+}
+
+func TestSignRequest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewEd25519Signer(priv)
+	verifier, err := NewEd25519Verifier(signer.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(verifier.key, pub) {
+		t.Fatal("PublicKey() doesn't round-trip through NewEd25519Verifier")
+	}
+	h := NewHMAC(testKey)
+
+	for _, tt := range []struct {
+		name     string
+		signer   Signer
+		verifier Verifier
+	}{
+		{"hmac", h, h},
+		{"ed25519", signer, verifier},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := NewAuth(tt.signer, nil, nil, 0)
+			req, nonce := signedRequest(t, auth, `{"deviceId":"device-1"}`)
+
+			if req.Header.Get(HeaderNonce) != nonce || req.Header.Get(HeaderTimestamp) == "" {
+				t.Fatalf("headers = %v, want the nonce and a timestamp", req.Header)
+			}
+			if !verifyRequest(req, tt.verifier) {
+				t.Fatal("signed request doesn't verify")
+			}
+
+			// The body can still be sent after signing
+			if body, _ := io.ReadAll(req.Body); string(body) != `{"deviceId":"device-1"}` {
+				t.Errorf("body after signing = %q", body)
+			}
+
+			tampered := req.Clone(req.Context())
+			tampered.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(`{"deviceId":"device-2"}`)), nil
+			}
+			if verifyRequest(tampered, tt.verifier) {
+				t.Error("request with a tampered body verifies")
+			}
+		})
+	}
+
+	if _, err := NewEd25519Verifier("not a key"); err == nil {
+		t.Error("NewEd25519Verifier() accepted an invalid key")
+	}
+}
+
+// response is a server response signed for the request with requestNonce
+type response struct {
+	status       int
+	requestNonce string
+	timestamp    time.Time
+	nonce        string
+	body         string
+	signer       Signer
+}
+
+func (r response) http() *http.Response {
+	timestamp := strconv.FormatInt(r.timestamp.Unix(), 10)
+	header := make(http.Header)
+	header.Set(HeaderTimestamp, timestamp)
+	header.Set(HeaderNonce, r.nonce)
+	if r.signer != nil {
+		message := canonical(strconv.Itoa(r.status), r.requestNonce, timestamp, r.nonce, []byte(r.body))
+		header.Set(HeaderSignature, r.signer.Algorithm()+"="+base64.StdEncoding.EncodeToString(r.signer.Sign(message)))
+	}
+	return &http.Response{StatusCode: r.status, Header: header, Body: io.NopCloser(strings.NewReader(r.body))}
+}
+
+func TestVerifyResponse(t *testing.T) {
+	h := NewHMAC(testKey)
+	now := time.Now()
+	valid := response{status: 200, requestNonce: "req-1", timestamp: now, nonce: "resp-1", body: `{"status":"ok"}`, signer: h}
+
+	tests := []struct {
+		name   string
+		modify func(r *http.Response)
+		resp   response
+		ok     bool
+	}{
+		{name: "valid", resp: valid, ok: true},
+		{name: "error status", resp: response{status: 404, requestNonce: "req-1", timestamp: now, nonce: "resp-1", body: `{}`, signer: h}, ok: true},
+		{name: "unsigned", resp: response{status: 200, requestNonce: "req-1", timestamp: now, nonce: "resp-1", body: `{}`}},
+		{name: "other key", resp: response{status: 200, requestNonce: "req-1", timestamp: now, nonce: "resp-1", body: `{}`, signer: NewHMAC([]byte("another key of sixteen bytes"))}},
+		{name: "for another request", resp: response{status: 200, requestNonce: "req-2", timestamp: now, nonce: "resp-1", body: `{}`, signer: h}},
+		{
+			name: "tampered body", resp: valid,
+			modify: func(r *http.Response) { r.Body = io.NopCloser(strings.NewReader(`{"status":"evil"}`)) },
+		},
+		{
+			name: "tampered status", resp: valid,
+			modify: func(r *http.Response) { r.StatusCode = 401 },
+		},
+		{
+			name: "other algorithm", resp: valid,
+			modify: func(r *http.Response) {
+				r.Header.Set(HeaderSignature, strings.Replace(r.Header.Get(HeaderSignature), AlgorithmHMAC, AlgorithmEd25519, 1))
+			},
+		},
+		{
+			name: "malformed signature", resp: valid,
+			modify: func(r *http.Response) { r.Header.Set(HeaderSignature, AlgorithmHMAC+"=!!!") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := NewAuth(h, h, nil, 0)
+			resp := tt.resp.http()
+			if tt.modify != nil {
+				tt.modify(resp)
+			}
+			wantBody, _ := io.ReadAll(resp.Body)
+			resp.Body = io.NopCloser(bytes.NewReader(wantBody))
+
+			err := auth.VerifyResponse(resp, "req-1")
+			if tt.ok && err != nil {
+				t.Fatalf("VerifyResponse() = %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrBadSignature) {
+				t.Fatalf("VerifyResponse() = %v, want ErrBadSignature", err)
+			}
+			if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, wantBody) {
+				t.Errorf("body after verification = %q, want %q", body, wantBody)
+			}
+		})
+	}
+}
+
+func TestVerifyResponseWithoutVerifier(t *testing.T) {
+	auth := NewAuth(NewHMAC(testKey), nil, nil, 0)
+	resp := response{status: 200, requestNonce: "req-1", timestamp: time.Now(), nonce: "resp-1"}.http()
+	if err := auth.VerifyResponse(resp, "req-1"); err != nil {
+		t.Errorf("VerifyResponse() = %v, want nil without a verifier", err)
+	}
+}
+
+func TestVerifyResponseRejectsReplayedNonce(t *testing.T) {
+	h := NewHMAC(testKey)
+	auth := NewAuth(h, h, nil, 0)
+	signed := response{status: 200, requestNonce: "req-1", timestamp: time.Now(), nonce: "resp-1", body: "{}", signer: h}
+
+	if err := auth.VerifyResponse(signed.http(), "req-1"); err != nil {
+		t.Fatalf("first response: %v", err)
+	}
+	if err := auth.VerifyResponse(signed.http(), "req-1"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("replayed response = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestVerifyResponseClockSkew(t *testing.T) {
+	h := NewHMAC(testKey)
+	old := time.Now().Add(-10 * time.Minute)
+
+	// Until a server time is observed, drift alone doesn't reject responses
+	auth := NewAuth(h, h, &Clock{}, time.Minute)
+	if err := auth.VerifyResponse(response{status: 200, requestNonce: "req-1", timestamp: old, nonce: "a", signer: h}.http(), "req-1"); err != nil {
+		t.Fatalf("unsynced clock: VerifyResponse() = %v, want nil", err)
+	}
+
+	clock := &Clock{}
+	clock.Observe(time.Now(), time.Now(), time.Now())
+	auth = NewAuth(h, h, clock, time.Minute)
+	if err := auth.VerifyResponse(response{status: 200, requestNonce: "req-1", timestamp: old, nonce: "b", signer: h}.http(), "req-1"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("stale timestamp: VerifyResponse() = %v, want ErrBadSignature", err)
+	}
+	if err := auth.VerifyResponse(response{status: 200, requestNonce: "req-1", timestamp: time.Now().Add(30 * time.Second), nonce: "c", signer: h}.http(), "req-1"); err != nil {
+		t.Errorf("timestamp within skew: VerifyResponse() = %v, want nil", err)
+	}
+}
+
+func TestClockObserve(t *testing.T) {
+	var c Clock
+	if c.Synced() || c.Offset() != 0 {
+		t.Fatal("new clock is synced")
+	}
+
+	sentAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	receivedAt := sentAt.Add(2 * time.Second)
+	// The server answered at the midpoint of the round trip, by its clock 1h ahead
+	c.Observe(sentAt.Add(time.Hour+time.Second), sentAt, receivedAt)
+
+	if !c.Synced() {
+		t.Error("Synced() = false after Observe")
+	}
+	if got := c.Offset(); got != time.Hour {
+		t.Errorf("Offset() = %v, want 1h", got)
+	}
+	if d := c.Now().Sub(time.Now().Add(time.Hour)); d < -time.Second || d > time.Second {
+		t.Errorf("Now() is %v off local time + 1h", d)
+	}
+
+	// Signed requests carry server time
+	auth := NewAuth(NewHMAC(testKey), nil, &c, 0)
+	req, _ := signedRequest(t, auth, "{}")
+	seconds, _ := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if d := time.Unix(seconds, 0).Sub(time.Now().Add(time.Hour)); d < -2*time.Second || d > 2*time.Second {
+		t.Errorf("request timestamp is %v off server time", d)
+	}
+}
+
+func TestClockObserveDate(t *testing.T) {
+	var c Clock
+	sentAt := time.Now()
+	resp := &http.Response{Header: http.Header{"Date": {sentAt.Add(-time.Hour).UTC().Format(http.TimeFormat)}}}
+
+	c.observeDate(resp, sentAt)
+	if got := c.Offset(); got > -time.Hour+2*time.Second || got < -time.Hour-2*time.Second {
+		t.Errorf("Offset() after Date header = %v, want about -1h", got)
+	}
+	if c.Synced() {
+		t.Error("a Date header marked the clock as synced")
+	}
+
+	// Once synced, Date headers no longer override the observed offset
+	c.Observe(sentAt, sentAt, sentAt)
+	c.observeDate(resp, sentAt)
+	if got := c.Offset(); got != 0 {
+		t.Errorf("Offset() = %v, want the observed 0", got)
+	}
+}
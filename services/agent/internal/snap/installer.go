@@ -0,0 +1,103 @@
+package snap
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// InstallResult represents the result of an install operation
+type InstallResult = updates.InstallResult
+
+// Installer handles Snap refreshes
+type Installer struct{}
+
+// NewInstaller creates a new Snap installer
+func NewInstaller() *Installer {
+	return &Installer{}
+}
+
+// snapNameRe matches a snap name, optionally with a parallel install instance key
+var snapNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*(_[a-z0-9]{1,10})?$`)
+
+// ValidSnapName reports whether name is a snap name snap can't mistake for an option
+func ValidSnapName(name string) bool {
+	return snapNameRe.MatchString(name)
+}
+
+// Install refreshes a single installed snap
+func (i *Installer) Install(packageIdentifier string) *InstallResult {
+	result := &InstallResult{
+		PackageIdentifier: packageIdentifier,
+	}
+
+	if !ValidSnapName(packageIdentifier) {
+		result.Message = "Invalid snap name"
+		result.Error = fmt.Errorf("invalid snap name %q", packageIdentifier)
+		return result
+	}
+
+	stdout, stderr, err := runSnap("refresh", "--", packageIdentifier)
+	output := stdout
+	if stderr != "" {
+		output += "\n" + stderr
+	}
+
+	result.Message = strings.TrimSpace(output)
+
+	if err != nil {
+		result.Success = false
+		result.Error = fmt.Errorf("snap refresh failed: %w", err)
+
+		// Check for common error messages
+		if strings.Contains(output, "is not installed") {
+			result.Message = "Snap is not installed"
+		} else if strings.Contains(output, "has running apps") {
+			result.Message = "Snap has running apps, refresh postponed"
+		}
+	} else {
+		result.Success = true
+		if strings.Contains(output, "has no updates available") {
+			result.Success = false
+			result.Message = "Already up to date"
+		} else {
+			result.Message = "Successfully installed"
+		}
+	}
+
+	return result
+}
+
+// InstallMultiple refreshes multiple snaps
+func (i *Installer) InstallMultiple(packageIdentifiers []string) []*InstallResult {
+	results := make([]*InstallResult, 0, len(packageIdentifiers))
+
+	for _, pkgID := range packageIdentifiers {
+		results = append(results, i.Install(pkgID))
+	}
+
+	return results
+}
+
+// Uninstall removes a snap
+func (i *Installer) Uninstall(packageIdentifier string) error {
+	if !ValidSnapName(packageIdentifier) {
+		return fmt.Errorf("invalid snap name %q", packageIdentifier)
+	}
+	stdout, stderr, err := runSnap("remove", "--", packageIdentifier)
+	if err != nil {
+		return fmt.Errorf("snap remove failed: %w (output: %s)", err, strings.TrimSpace(stdout+"\n"+stderr))
+	}
+	return nil
+}
+
+// CanInstall checks if snap is available on the system
+func (i *Installer) CanInstall() error {
+	if _, err := exec.LookPath("snap"); err != nil {
+		return fmt.Errorf("snap is not available: %w", err)
+	}
+	return nil
+}
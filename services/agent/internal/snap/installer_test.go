@@ -0,0 +1,37 @@
+package snap
+
+import "testing"
+
+func TestValidSnapName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"firefox", true},
+		{"core22", true},
+		{"gnome-42-2204", true},
+		{"firefox_beta", true},
+		{"--classic", false},
+		{"-h", false},
+		{"--dangerous=/tmp/evil.snap", false},
+		{"firefox code", false},
+		{"Firefox", false},
+		{"firefox_", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidSnapName(tt.name); got != tt.want {
+			t.Errorf("ValidSnapName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInstallRejectsInvalidName(t *testing.T) {
+	result := NewInstaller().Install("--amend")
+	if result.Success || result.Error == nil {
+		t.Errorf("Install() = %+v, want an error without running snap", result)
+	}
+	if err := NewInstaller().Uninstall("--purge"); err == nil {
+		t.Error("Uninstall() accepted an option as snap name")
+	}
+}
@@ -0,0 +1,149 @@
+package snap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/lunaris/agent/internal/api"
+)
+
+// Scanner handles Snap update scanning
+type Scanner struct{}
+
+// NewScanner creates a new Snap scanner
+func NewScanner() *Scanner {
+	return &Scanner{}
+}
+
+// Update represents an available update from Snap
+type Update struct {
+	Name             string
+	InstalledVersion string
+	AvailableVersion string
+	Revision         string
+	Publisher        string
+}
+
+// ScanUpdates runs snap refresh --list and parses available updates
+func (s *Scanner) ScanUpdates() ([]Update, error) {
+	stdout, stderr, err := runSnap("refresh", "--list")
+	if err != nil {
+		return nil, fmt.Errorf("snap refresh --list failed: %w (stderr: %s)", err, stderr)
+	}
+
+	updates := parseSnapTable(stdout)
+	if len(updates) == 0 {
+		return updates, nil
+	}
+
+	// refresh --list only shows the new version, so look up what's installed
+	if listOut, _, err := runSnap("list"); err == nil {
+		installed := make(map[string]string)
+		for _, snap := range parseSnapTable(listOut) {
+			installed[snap.Name] = snap.AvailableVersion
+		}
+		for i := range updates {
+			updates[i].InstalledVersion = installed[updates[i].Name]
+		}
+	}
+
+	return updates, nil
+}
+
+// parseSnapTable parses the output of snap refresh --list or snap list
+// Format: Name  Version  Rev  [Tracking]  Publisher  Notes
+func parseSnapTable(output string) []Update {
+	var updates []Update
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	var columns map[string]int
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Map columns from the header, since snap list has an extra Tracking column
+		if columns == nil {
+			if fields[0] != "Name" {
+				// e.g. "All snaps up to date."
+				continue
+			}
+			columns = make(map[string]int, len(fields))
+			for i, name := range fields {
+				columns[name] = i
+			}
+			continue
+		}
+
+		update := parseSnapFields(fields, columns)
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+
+	return updates
+}
+
+// parseSnapFields parses a single snap table row using the header column positions
+func parseSnapFields(fields []string, columns map[string]int) *Update {
+	column := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(fields) {
+			return ""
+		}
+		return fields[i]
+	}
+
+	update := &Update{
+		Name:             column("Name"),
+		AvailableVersion: column("Version"),
+		Revision:         column("Rev"),
+		Publisher:        strings.TrimRight(column("Publisher"), "✓*"),
+	}
+
+	if update.Name == "" || update.AvailableVersion == "" {
+		return nil
+	}
+
+	return update
+}
+
+// ToAPIUpdates converts scanner updates to API update items
+func ToAPIUpdates(updates []Update) []api.UpdateItem {
+	items := make([]api.UpdateItem, len(updates))
+	for i, u := range updates {
+		var installed *string
+		if u.InstalledVersion != "" {
+			v := u.InstalledVersion
+			installed = &v
+		}
+
+		items[i] = api.UpdateItem{
+			PackageIdentifier: u.Name,
+			PackageName:       u.Name,
+			InstalledVersion:  installed,
+			AvailableVersion:  u.AvailableVersion,
+			Source:            SourceName,
+		}
+	}
+	return items
+}
+
+// runSnap runs snap and returns stdout and stderr
+func runSnap(args ...string) (string, string, error) {
+	cmd := exec.Command("snap", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
@@ -0,0 +1,41 @@
+package snap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseSnapTable(t *testing.T) {
+	got := parseSnapTable(readFixture(t, "refresh-list.txt"))
+	want := []Update{
+		{Name: "core22", AvailableVersion: "20240111", Revision: "1122", Publisher: "canonical"},
+		{Name: "firefox", AvailableVersion: "123.0-1", Revision: "3836", Publisher: "mozilla"},
+		{Name: "lxd", AvailableVersion: "5.20-f3dd836", Revision: "27049", Publisher: "canonical"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSnapTable(refresh --list) =\n%+v\nwant\n%+v", got, want)
+	}
+
+	// snap list has an extra Tracking column before Publisher
+	installed := parseSnapTable(readFixture(t, "list.txt"))
+	if len(installed) != 4 || installed[1].AvailableVersion != "122.0.1-1" || installed[1].Publisher != "mozilla" {
+		t.Errorf("parseSnapTable(list) = %+v", installed)
+	}
+}
+
+func TestParseSnapTableUpToDate(t *testing.T) {
+	if got := parseSnapTable("All snaps up to date.\n"); len(got) != 0 {
+		t.Errorf("parseSnapTable() = %+v, want none", got)
+	}
+}
@@ -0,0 +1,53 @@
+package snap
+
+import (
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// SourceName is the api.UpdateItem.Source value for Snap updates
+const SourceName = "snap"
+
+// Source adapts the Snap Scanner and Installer to updates.UpdateSource
+type Source struct {
+	scanner   *Scanner
+	installer *Installer
+}
+
+var _ updates.UpdateSource = (*Source)(nil)
+
+// NewSource creates a new Snap update source
+func NewSource() *Source {
+	return &Source{
+		scanner:   NewScanner(),
+		installer: NewInstaller(),
+	}
+}
+
+// Name returns the source name
+func (s *Source) Name() string {
+	return SourceName
+}
+
+// CheckAvailable checks if snap is available on the system
+func (s *Source) CheckAvailable() error {
+	return s.installer.CanInstall()
+}
+
+// ScanUpdates scans Snap for available updates
+func (s *Source) ScanUpdates() (*updates.ScanResult, error) {
+	found, err := s.scanner.ScanUpdates()
+	if err != nil {
+		return nil, err
+	}
+	return &updates.ScanResult{Items: ToAPIUpdates(found)}, nil
+}
+
+// Install refreshes a single snap
+func (s *Source) Install(packageIdentifier string) *InstallResult {
+	return s.installer.Install(packageIdentifier)
+}
+
+// Uninstall removes a snap
+func (s *Source) Uninstall(packageIdentifier string) error {
+	return s.installer.Uninstall(packageIdentifier)
+}
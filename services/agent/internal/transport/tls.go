@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions configures how the agent authenticates the server and itself
+type TLSOptions struct {
+	// CABundle is a PEM file of extra CAs trusted in addition to the system roots
+	CABundle string
+
+	// PinnedKeys are base64 SHA-256 hashes of server SubjectPublicKeyInfo.
+	// When set, a chain is only accepted if one of its certificates has a pinned key.
+	PinnedKeys []string
+
+	// GetClientCertificate supplies the device certificate for mutual TLS
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+}
+
+// NewTLSConfig builds the TLS configuration shared by the API client and the websocket
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: opts.GetClientCertificate,
+	}
+
+	if opts.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pemData, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", opts.CABundle)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(opts.PinnedKeys) > 0 {
+		pins := make(map[string]bool, len(opts.PinnedKeys))
+		for _, pin := range opts.PinnedKeys {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+			raw, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(raw) != sha256.Size {
+				return nil, fmt.Errorf("invalid pinned key %q: expected base64 SHA-256", pin)
+			}
+			pins[string(raw)] = true
+		}
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs, pins)
+		}
+	}
+
+	return cfg, nil
+}
+
+// verifyPins accepts a connection if any certificate in a verified chain has a pinned key.
+// Certificates the server merely presented don't count: anyone can send a pinned CA along.
+func verifyPins(cs tls.ConnectionState, pins map[string]bool) error {
+	if len(cs.VerifiedChains) == 0 {
+		return fmt.Errorf("server certificate for %s wasn't verified, so pinned keys can't be checked", cs.ServerName)
+	}
+
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if pins[string(sum[:])] {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("server certificate for %s doesn't match any pinned key", cs.ServerName)
+}
+
+// PinFor returns the pin of a certificate's public key, in the format of TLSOptions.PinnedKeys
+func PinFor(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
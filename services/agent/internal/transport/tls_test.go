@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed CA certificate
+func testCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// pinSet returns the pins of certs as verifyPins expects them
+func pinSet(certs ...*x509.Certificate) map[string]bool {
+	pins := make(map[string]bool)
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		pins[string(sum[:])] = true
+	}
+	return pins
+}
+
+func TestVerifyPins(t *testing.T) {
+	leaf, ca, other := testCertificate(t, "api.example.com"), testCertificate(t, "ca"), testCertificate(t, "other")
+
+	tests := []struct {
+		name    string
+		state   tls.ConnectionState
+		pins    map[string]bool
+		wantErr bool
+	}{
+		{"pinned CA in verified chain", tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}}, pinSet(ca), false},
+		{"pinned leaf in verified chain", tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}}, pinSet(leaf), false},
+		{"no pinned key", tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}}, pinSet(other), true},
+		{"pinned key only presented", tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, ca}}, pinSet(ca), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPins(tt.state, tt.pins)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyPins() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPinnedConnection(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	serverCert := server.Certificate()
+
+	for _, tt := range []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"pinned", PinFor(serverCert), false},
+		{"other key", PinFor(testCertificate(t, "other")), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewTLSConfig(TLSOptions{PinnedKeys: []string{tt.pin}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			cfg.RootCAs.AddCert(serverCert)
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
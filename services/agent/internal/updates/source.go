@@ -0,0 +1,44 @@
+package updates
+
+import (
+	"github.com/lunaris/agent/internal/api"
+)
+
+// InstallResult represents the result of an install operation
+type InstallResult struct {
+	PackageIdentifier string
+	Success           bool
+	Message           string
+	Error             error
+}
+
+// UnparsedLine is a line of package manager output a parser couldn't turn into an update
+type UnparsedLine struct {
+	LineNumber int
+	Text       string
+	Reason     string
+}
+
+// ScanResult holds the updates found by a source and any output it had to drop
+type ScanResult struct {
+	Items    []api.UpdateItem
+	Unparsed []UnparsedLine
+}
+
+// UpdateSource is a package manager the agent can scan and install updates from
+type UpdateSource interface {
+	// Name returns the source name reported in api.UpdateItem.Source
+	Name() string
+
+	// CheckAvailable returns an error if the package manager can't be used on this system
+	CheckAvailable() error
+
+	// ScanUpdates returns the updates currently available from this source
+	ScanUpdates() (*ScanResult, error)
+
+	// Install installs or upgrades a single package
+	Install(packageIdentifier string) *InstallResult
+
+	// Uninstall removes a single package
+	Uninstall(packageIdentifier string) error
+}
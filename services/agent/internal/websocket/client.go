@@ -1,19 +1,38 @@
 package websocket
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
-	socketio "github.com/zhouhui8915/go-socket.io-client"
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/lunaris/agent/internal/backoff"
 )
 
+// connectTimeout bounds a single connection attempt
+const connectTimeout = 5 * time.Second
+
+// DefaultNamespace is the Socket.IO namespace of the console gateway,
+// used when the server URL doesn't name one
+const DefaultNamespace = "/ws/console"
+
+// ErrClosed is returned when connecting a client that has been closed
+var ErrClosed = errors.New("websocket client closed")
+
 // InstallCommand represents an install command from the server
 type InstallCommand struct {
 	CommandID          string   `json:"commandId"`
 	DeviceID           string   `json:"deviceId"`
 	PackageIdentifiers []string `json:"packageIdentifiers"`
+	Signature          string   `json:"signature,omitempty"`
+	KeyID              string   `json:"keyId,omitempty"`
 }
 
 // EventPayload wraps the event data from server
@@ -23,141 +42,499 @@ type EventPayload struct {
 	Timestamp string          `json:"timestamp"`
 }
 
+// Logger is the logging interface used by the client
+type Logger interface {
+	Printf(format string, v ...interface{})
+	Println(v ...interface{})
+}
+
 // InstallHandler is called when an install command is received
 type InstallHandler func(cmd *InstallCommand) error
 
-// Client manages WebSocket connection to the server
+// Client manages WebSocket connection to the server.
+// Start runs a supervisor that keeps the connection up with jittered exponential backoff.
 type Client struct {
-	serverURL      string
-	deviceID       string
-	socket         *socketio.Client
+	serverURL string
+	deviceID  string
+	logger    Logger
+	backoff   *backoff.Backoff
+
+	mu             sync.Mutex
+	state          State
+	dialer         *gorilla.Dialer
+	socket         *socket
+	generation     uint64
+	lost           chan struct{}
 	installHandler InstallHandler
-	logger         *log.Logger
-	connected      bool
+	stateHandler   StateHandler
+	tokenSource    func() string
+	kick           chan struct{}
+	closing        chan struct{}
+	closeOnce      sync.Once
+	cancel         context.CancelFunc
+	done           chan struct{}
 }
 
-// NewClient creates a new WebSocket client
-func NewClient(serverURL, deviceID string, logger *log.Logger) *Client {
+// NewClient creates a new WebSocket client.
+// The path of serverURL selects the Socket.IO namespace; a bare origin uses DefaultNamespace.
+func NewClient(serverURL, deviceID string, logger Logger) *Client {
+	dialer := *gorilla.DefaultDialer
+	dialer.HandshakeTimeout = connectTimeout
+
 	return &Client{
-		serverURL: serverURL,
+		serverURL: withNamespace(serverURL),
+		dialer:    &dialer,
 		deviceID:  deviceID,
 		logger:    logger,
+		backoff:   DefaultBackoff(),
+		kick:      make(chan struct{}, 1),
+		closing:   make(chan struct{}),
 	}
 }
 
 // SetInstallHandler sets the handler for install commands
 func (c *Client) SetInstallHandler(handler InstallHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.installHandler = handler
 }
 
-// Connect establishes WebSocket connection
-func (c *Client) Connect() error {
-	opts := &socketio.Options{
-		Transport: "websocket",
-		Query:     make(map[string]string),
+// SetStateHandler sets the handler called on every connection state change
+func (c *Client) SetStateHandler(handler StateHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stateHandler = handler
+}
+
+// SetTLSConfig sets the TLS configuration used for wss connections
+func (c *Client) SetTLSConfig(cfg *tls.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialer.TLSClientConfig = cfg
+}
+
+// SetProxy sets the function choosing the proxy for connections; nil connects directly
+func (c *Client) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialer.Proxy = proxy
+}
+
+// SetTokenSource sets the function returning the device access token sent when connecting
+func (c *Client) SetTokenSource(source func() string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokenSource = source
+}
+
+// withNamespace adds DefaultNamespace to a server URL without a path
+func withNamespace(serverURL string) string {
+	if u, err := url.Parse(serverURL); err == nil && u.Host != "" && u.Path == "" {
+		u.Path = DefaultNamespace
+		return u.String()
 	}
+	return serverURL
+}
 
-	client, err := socketio.NewClient(c.serverURL, opts)
-	if err != nil {
-		return fmt.Errorf("failed to create socket.io client: %w", err)
+// SetServerURL changes the server to connect to, e.g. after an API failover.
+// An open connection is dropped so the supervisor reconnects to the new server.
+func (c *Client) SetServerURL(serverURL string) {
+	serverURL = withNamespace(serverURL)
+
+	c.mu.Lock()
+	if c.serverURL == serverURL {
+		c.mu.Unlock()
+		return
 	}
+	c.serverURL = serverURL
+	sock := c.socket
+	c.mu.Unlock()
 
-	c.socket = client
+	if sock != nil {
+		sock.Close()
+	}
+}
 
-	// Connection handlers
-	client.On("connection", func() {
-		c.logger.Println("[WebSocket] Connected to server")
-		c.connected = true
-	})
+// SetDeviceID changes the device room to join. An open connection is dropped
+// so the supervisor reconnects and joins the new room.
+func (c *Client) SetDeviceID(deviceID string) {
+	c.mu.Lock()
+	if c.deviceID == deviceID {
+		c.mu.Unlock()
+		return
+	}
+	c.deviceID = deviceID
+	sock := c.socket
+	c.mu.Unlock()
 
-	client.On("connect", func() {
-		c.logger.Println("[WebSocket] Connection established")
-		c.connected = true
+	if sock != nil {
+		sock.Close()
+	}
+}
 
-		// Join device-specific room to receive commands
-		c.logger.Printf("[WebSocket] Joining device room: %s", c.deviceID)
-		client.Emit("join_device", c.deviceID)
-	})
+// State returns the current connection state
+func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
 
-	client.On("disconnection", func() {
-		c.logger.Println("[WebSocket] Disconnected from server")
-		c.connected = false
-	})
+// IsConnected returns true if connected
+func (c *Client) IsConnected() bool {
+	return c.State() == StateConnected
+}
 
-	client.On("disconnect", func() {
-		c.logger.Println("[WebSocket] Connection closed")
-		c.connected = false
-	})
+// Start runs the connection supervisor until ctx is cancelled or Close is called
+func (c *Client) Start(ctx context.Context) {
+	c.mu.Lock()
+	if c.cancel != nil || c.state == StateClosed {
+		c.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	done := c.done
+	c.mu.Unlock()
 
-	client.On("error", func(err error) {
-		c.logger.Printf("[WebSocket] Error: %v", err)
-	})
+	go c.supervise(ctx, done)
+}
 
-	// Listen for install_updates command
-	client.On("install_updates", func(msg string) {
-		c.logger.Printf("[WebSocket] Received install_updates event: %s", msg)
+// supervise connects, waits for the connection to drop, backs off and repeats
+func (c *Client) supervise(ctx context.Context, done chan struct{}) {
+	defer close(done)
 
-		var payload EventPayload
-		if err := json.Unmarshal([]byte(msg), &payload); err != nil {
-			c.logger.Printf("[WebSocket] Failed to parse event payload: %v", err)
+	attempt := 0
+	for ctx.Err() == nil {
+		err := c.Connect()
+		if errors.Is(err, ErrClosed) {
 			return
 		}
 
-		var cmd InstallCommand
-		if err := json.Unmarshal(payload.Payload, &cmd); err != nil {
-			c.logger.Printf("[WebSocket] Failed to parse install command: %v", err)
-			return
+		if err == nil {
+			attempt = 0
+
+			c.mu.Lock()
+			lost := c.lost
+			c.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-lost:
+				err = errors.New("connection lost")
+			}
 		}
 
-		// Verify this command is for our device
-		if cmd.DeviceID != c.deviceID {
-			c.logger.Printf("[WebSocket] Ignoring command for different device: %s", cmd.DeviceID)
+		delay := c.backoff.Delay(attempt)
+		attempt++
+		c.setState(StateChange{To: StateBackingOff, Err: err, Attempt: attempt, RetryIn: delay})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
 			return
+		case <-c.kick:
+			timer.Stop()
+		case <-timer.C:
 		}
+	}
+}
+
+// Connect makes a single connection attempt and joins the device room
+func (c *Client) Connect() error {
+	c.mu.Lock()
+	if c.state == StateClosed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.generation++
+	gen := c.generation
+	lost := make(chan struct{})
+	c.lost = lost
+	deviceID, serverURL := c.deviceID, c.serverURL
+	c.mu.Unlock()
 
-		c.logger.Printf("[WebSocket] Processing install command %s for %d package(s)",
-			cmd.CommandID, len(cmd.PackageIdentifiers))
+	c.setState(StateChange{To: StateConnecting})
 
-		// Execute install handler
-		if c.installHandler != nil {
-			if err := c.installHandler(&cmd); err != nil {
-				c.logger.Printf("[WebSocket] Install handler failed: %v", err)
-			}
-		} else {
-			c.logger.Println("[WebSocket] No install handler set")
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	header, auth := c.credentials()
+	sock, err := dialSocket(ctx, c.dialer, serverURL, header, auth)
+	if err != nil {
+		c.detach(gen)
+		if c.isClosed() {
+			return ErrClosed
+		}
+		if proxy := c.proxyFor(serverURL); proxy != "" {
+			return fmt.Errorf("connect to %s via proxy %s: %w", serverURL, proxy, err)
+		}
+		return fmt.Errorf("connect to %s: %w", serverURL, err)
+	}
+
+	c.mu.Lock()
+	if gen != c.generation || c.state == StateClosed {
+		// Closed while dialing
+		c.mu.Unlock()
+		sock.Close()
+		return ErrClosed
+	}
+	c.socket = sock
+	c.mu.Unlock()
+
+	// Events from a socket that has since been replaced or closed are ignored
+	sock.On("install_updates", func(args []json.RawMessage) []interface{} {
+		if c.isCurrent(gen) && len(args) > 0 {
+			c.handleInstallEvent(args[0])
 		}
+		return nil
 	})
 
-	// Wait for connection (NewClient already initiates connection)
-	for i := 0; i < 10; i++ {
-		if c.connected {
-			c.logger.Printf("[WebSocket] Successfully connected to %s", c.serverURL)
-			return nil
+	c.logger.Println("[WebSocket] Connected to server")
+
+	// Join device-specific room to receive commands
+	c.logger.Printf("[WebSocket] Joining device room: %s", deviceID)
+	if _, err := sock.EmitWithAck(ctx, "join_device", deviceID); err != nil {
+		c.detach(gen)
+		sock.Close()
+		if c.isClosed() {
+			return ErrClosed
 		}
-		time.Sleep(500 * time.Millisecond)
+		return fmt.Errorf("join device room: %w", err)
 	}
 
-	return fmt.Errorf("connection timeout")
+	go c.watch(gen, sock, lost)
+
+	c.setState(StateChange{To: StateConnected})
+	c.logger.Printf("[WebSocket] Successfully connected to %s", serverURL)
+	return nil
 }
 
-// Disconnect closes the WebSocket connection
-func (c *Client) Disconnect() error {
-	if c.socket != nil {
-		// Socket.IO client doesn't have Close method, connection will be closed on cleanup
-		c.connected = false
-		c.logger.Println("[WebSocket] Disconnected")
+// watch marks the connection lost once its socket closes
+func (c *Client) watch(gen uint64, sock *socket, lost chan struct{}) {
+	<-sock.Done()
+	if !c.isCurrent(gen) {
+		return
+	}
+	c.logger.Printf("[WebSocket] Disconnected from server: %v", sock.Err())
+	close(lost)
+
+	// Without a supervisor nobody else moves the state off connected
+	c.mu.Lock()
+	supervised := c.cancel != nil
+	c.mu.Unlock()
+	if !supervised {
+		c.setState(StateChange{To: StateIdle, Err: sock.Err()})
 	}
+}
+
+// proxyFor names the proxy used to reach the server, or "" when connecting directly
+func (c *Client) proxyFor(serverURL string) string {
+	c.mu.Lock()
+	proxy := c.dialer.Proxy
+	c.mu.Unlock()
+	if proxy == nil {
+		return ""
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return ""
+	}
+	// The dialer asks for the proxy of the equivalent http(s) URL
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	proxyURL, err := proxy(&http.Request{URL: u, Header: http.Header{}})
+	if err != nil || proxyURL == nil {
+		return ""
+	}
+	return proxyURL.Redacted()
+}
+
+// credentials returns the handshake header and Socket.IO auth payload carrying the access token
+func (c *Client) credentials() (http.Header, interface{}) {
+	c.mu.Lock()
+	source := c.tokenSource
+	c.mu.Unlock()
+
+	if source == nil {
+		return nil, nil
+	}
+	token := source()
+	if token == "" {
+		return nil, nil
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	return header, map[string]string{"token": token}
+}
+
+// handleInstallEvent decodes an install_updates event and runs the install handler
+func (c *Client) handleInstallEvent(raw json.RawMessage) {
+	c.logger.Printf("[WebSocket] Received install_updates event: %s", raw)
+
+	// Older gateways send the event as a JSON-encoded string
+	var encoded string
+	if json.Unmarshal(raw, &encoded) == nil {
+		raw = json.RawMessage(encoded)
+	}
+
+	var payload EventPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		c.logger.Printf("[WebSocket] Failed to parse event payload: %v", err)
+		return
+	}
+
+	var cmd InstallCommand
+	if err := json.Unmarshal(payload.Payload, &cmd); err != nil {
+		c.logger.Printf("[WebSocket] Failed to parse install command: %v", err)
+		return
+	}
+
+	// Verify this command is for our device
+	c.mu.Lock()
+	deviceID := c.deviceID
+	c.mu.Unlock()
+	if cmd.DeviceID != deviceID {
+		c.logger.Printf("[WebSocket] Ignoring command for different device: %s", cmd.DeviceID)
+		return
+	}
+
+	c.logger.Printf("[WebSocket] Processing install command %s for %d package(s)",
+		cmd.CommandID, len(cmd.PackageIdentifiers))
+
+	c.mu.Lock()
+	handler := c.installHandler
+	c.mu.Unlock()
+
+	// Execute install handler
+	if handler != nil {
+		if err := handler(&cmd); err != nil {
+			c.logger.Printf("[WebSocket] Install handler failed: %v", err)
+		}
+	} else {
+		c.logger.Println("[WebSocket] No install handler set")
+	}
+}
+
+// isCurrent reports whether gen is the active connection attempt
+func (c *Client) isCurrent(gen uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return gen == c.generation && c.state != StateClosed
+}
+
+// isClosed reports whether Close has been called
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// detach drops the socket of a failed attempt so its late events are ignored
+func (c *Client) detach(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen == c.generation {
+		c.generation++
+		c.socket = nil
+	}
+}
+
+// setState records a transition and notifies the state handler.
+// Nothing leaves the closed state.
+func (c *Client) setState(change StateChange) {
+	c.mu.Lock()
+	if c.state == StateClosed || (c.state == change.To && change.To != StateBackingOff) {
+		c.mu.Unlock()
+		return
+	}
+	change.From = c.state
+	change.At = time.Now()
+	c.state = change.To
+	handler := c.stateHandler
+	c.mu.Unlock()
+
+	if handler != nil {
+		handler(change)
+	}
+}
+
+// Close stops the supervisor and tears down the connection.
+// It waits for the supervisor to exit until ctx is done.
+func (c *Client) Close(ctx context.Context) error {
+	c.setState(StateChange{To: StateClosed})
+	c.closeOnce.Do(func() { close(c.closing) })
+
+	c.mu.Lock()
+	cancel, done, sock := c.cancel, c.done, c.socket
+	c.generation++
+	c.socket = nil
+	c.mu.Unlock()
+
+	if sock != nil {
+		sock.Close()
+	}
+
+	if cancel != nil {
+		cancel()
+	}
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	c.logger.Println("[WebSocket] Disconnected")
 	return nil
 }
 
-// IsConnected returns true if connected
-func (c *Client) IsConnected() bool {
-	return c.connected
+// Disconnect closes the WebSocket connection
+func (c *Client) Disconnect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	return c.Close(ctx)
 }
 
-// Reconnect attempts to reconnect if disconnected
+// Reconnect attempts to reconnect if disconnected.
+// With the supervisor running it skips the remaining backoff instead of dialing directly.
 func (c *Client) Reconnect() error {
-	if c.connected {
+	c.mu.Lock()
+	state, supervised := c.state, c.cancel != nil
+	c.mu.Unlock()
+
+	if state == StateConnected {
+		return nil
+	}
+	if state == StateClosed {
+		return ErrClosed
+	}
+
+	if supervised {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
 		return nil
 	}
 
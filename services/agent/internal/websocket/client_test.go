@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lunaris/agent/internal/backoff"
+	"github.com/lunaris/agent/internal/websocket/wstest"
+)
+
+// recordStates delivers every state change of c
+func recordStates(c *Client) <-chan StateChange {
+	changes := make(chan StateChange, 64)
+	c.SetStateHandler(func(change StateChange) {
+		changes <- change
+	})
+	return changes
+}
+
+// waitState reads changes until one moves to want, failing on anything but the expected path
+func waitState(t *testing.T, changes <-chan StateChange, want ...State) StateChange {
+	t.Helper()
+	var change StateChange
+	for _, state := range want {
+		select {
+		case change = <-changes:
+			if change.To != state {
+				t.Fatalf("state changed %v -> %v, want -> %v", change.From, change.To, state)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no change to %v", state)
+		}
+	}
+	return change
+}
+
+func TestStateTransitions(t *testing.T) {
+	socket, url := newStandIn(t, nil)
+
+	c := NewClient(url, "device-1", testLogger{t})
+	changes := recordStates(c)
+	if c.State() != StateIdle {
+		t.Fatalf("new client state = %v, want idle", c.State())
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	change := waitState(t, changes, StateConnecting, StateConnected)
+	if change.From != StateConnecting || change.At.IsZero() {
+		t.Errorf("connected change = %+v", change)
+	}
+
+	// Without a supervisor a dropped connection goes back to idle
+	socket.CloseAll()
+	if change := waitState(t, changes, StateIdle); change.From != StateConnected || change.Err == nil {
+		t.Errorf("idle change = %+v, want from connected with the socket error", change)
+	}
+
+	if err := c.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() = %v", err)
+	}
+	waitState(t, changes, StateConnecting, StateConnected)
+
+	closeClient(t, c)
+	waitState(t, changes, StateClosed)
+
+	// Nothing leaves the closed state
+	if err := c.Connect(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Connect() after Close = %v, want ErrClosed", err)
+	}
+	if err := c.Reconnect(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Reconnect() after Close = %v, want ErrClosed", err)
+	}
+	c.Start(context.Background())
+	if c.State() != StateClosed {
+		t.Errorf("state after Close = %v, want closed", c.State())
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("state changed after Close: %+v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSupervisorReconnectsAfterDrop(t *testing.T) {
+	socket, url := newStandIn(t, nil)
+
+	c := NewClient(url, "device-1", testLogger{t})
+	c.backoff = &backoff.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	changes := recordStates(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+	defer closeClient(t, c)
+
+	waitState(t, changes, StateConnecting, StateConnected)
+	<-socket.Joined()
+
+	socket.CloseAll()
+	change := waitState(t, changes, StateBackingOff)
+	if change.Err == nil || change.Attempt != 1 {
+		t.Errorf("backing off change = %+v, want attempt 1 with the drop's error", change)
+	}
+
+	waitState(t, changes, StateConnecting, StateConnected)
+	select {
+	case id := <-socket.Joined():
+		if id != "device-1" {
+			t.Errorf("rejoined %q, want device-1", id)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("device room not rejoined after reconnecting")
+	}
+	if !c.IsConnected() {
+		t.Errorf("state = %v after reconnecting", c.State())
+	}
+}
+
+func TestCloseDuringBackoff(t *testing.T) {
+	_, url := newStandIn(t, func(s *wstest.Server) { s.RejectConnect = "invalid token" })
+
+	c := NewClient(url, "device-1", testLogger{t})
+	c.backoff = &backoff.Backoff{Min: time.Hour, Max: time.Hour}
+	changes := recordStates(c)
+
+	c.Start(context.Background())
+	waitState(t, changes, StateConnecting, StateBackingOff)
+
+	// Reconnect skips the rest of the backoff
+	if err := c.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() = %v", err)
+	}
+	if change := waitState(t, changes, StateConnecting, StateBackingOff); change.Attempt != 2 {
+		t.Errorf("second backoff attempt = %d, want 2", change.Attempt)
+	}
+
+	// Close doesn't wait out the backoff sleep
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close() took %v during backoff", elapsed)
+	}
+	waitState(t, changes, StateClosed)
+	if c.State() != StateClosed {
+		t.Errorf("state = %v after Close", c.State())
+	}
+}
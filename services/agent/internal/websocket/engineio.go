@@ -0,0 +1,263 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// Engine.IO v4 packet types
+const (
+	engineOpen    = '0'
+	engineClose   = '1'
+	enginePing    = '2'
+	enginePong    = '3'
+	engineMessage = '4'
+	engineUpgrade = '5'
+	engineNoop    = '6'
+)
+
+// engineProtocol is the Engine.IO protocol revision spoken by Socket.IO v4 servers
+const engineProtocol = "4"
+
+// defaultSocketIOPath is where Socket.IO servers mount the Engine.IO endpoint
+const defaultSocketIOPath = "/socket.io/"
+
+// errEngineClosed is returned when writing to a closed Engine.IO connection
+var errEngineClosed = errors.New("engine.io connection closed")
+
+// engineHandshake is the payload of the Engine.IO open packet
+type engineHandshake struct {
+	SID          string `json:"sid"`
+	PingInterval int    `json:"pingInterval"`
+	PingTimeout  int    `json:"pingTimeout"`
+	MaxPayload   int    `json:"maxPayload"`
+}
+
+// engineFrame is a message delivered by an Engine.IO connection
+type engineFrame struct {
+	text   string
+	binary []byte
+	isText bool
+}
+
+// engineConn is an Engine.IO v4 connection using only the websocket transport.
+// Liveness follows the v4 heartbeat: the server pings, the client answers with a pong,
+// and the connection is dropped if no ping arrives within pingInterval+pingTimeout.
+type engineConn struct {
+	ws        *gorilla.Conn
+	handshake engineHandshake
+	frames    chan engineFrame
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	closed    chan struct{}
+	errMu     sync.Mutex
+	err       error
+}
+
+// engineURL builds the Engine.IO websocket URL for a server URL
+func engineURL(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("parse server URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported server URL scheme %q", u.Scheme)
+	}
+
+	u.Path = defaultSocketIOPath
+	q := u.Query()
+	q.Set("EIO", engineProtocol)
+	q.Set("transport", "websocket")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// dialEngine opens an Engine.IO connection and waits for the open packet
+func dialEngine(ctx context.Context, dialer *gorilla.Dialer, serverURL string, header http.Header) (*engineConn, error) {
+	wsURL, err := engineURL(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ws, resp, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("websocket dial %s: %w (status %s)", wsURL, err, resp.Status)
+		}
+		return nil, fmt.Errorf("websocket dial %s: %w", wsURL, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		ws.SetReadDeadline(deadline)
+	}
+	msgType, data, err := ws.ReadMessage()
+	if err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("read engine.io open packet: %w", err)
+	}
+	if msgType != gorilla.TextMessage || len(data) == 0 || data[0] != engineOpen {
+		ws.Close()
+		return nil, fmt.Errorf("unexpected engine.io handshake %q", truncate(string(data), 64))
+	}
+
+	e := &engineConn{
+		ws:     ws,
+		frames: make(chan engineFrame, 16),
+		closed: make(chan struct{}),
+	}
+	if err := json.Unmarshal(data[1:], &e.handshake); err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("decode engine.io handshake: %w", err)
+	}
+	if e.handshake.PingInterval <= 0 {
+		e.handshake.PingInterval = 25000
+	}
+	if e.handshake.PingTimeout <= 0 {
+		e.handshake.PingTimeout = 20000
+	}
+
+	go e.readLoop()
+	return e, nil
+}
+
+// liveness returns how long the connection may go without a server ping
+func (e *engineConn) liveness() time.Duration {
+	return time.Duration(e.handshake.PingInterval+e.handshake.PingTimeout) * time.Millisecond
+}
+
+// readLoop answers pings and delivers message payloads until the connection fails
+func (e *engineConn) readLoop() {
+	defer close(e.frames)
+
+	for {
+		e.ws.SetReadDeadline(time.Now().Add(e.liveness()))
+
+		msgType, data, err := e.ws.ReadMessage()
+		if err != nil {
+			e.closeWithError(fmt.Errorf("read: %w", err))
+			return
+		}
+
+		if msgType == gorilla.BinaryMessage {
+			// v4 sends binary attachments as raw frames without a packet type
+			if !e.deliver(engineFrame{binary: data}) {
+				return
+			}
+			continue
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		switch data[0] {
+		case enginePing:
+			if err := e.writeText(string(enginePong) + string(data[1:])); err != nil {
+				e.closeWithError(fmt.Errorf("pong: %w", err))
+				return
+			}
+		case engineMessage:
+			if !e.deliver(engineFrame{text: string(data[1:]), isText: true}) {
+				return
+			}
+		case engineClose:
+			e.closeWithError(errors.New("server closed the connection"))
+			return
+		case enginePong, engineNoop, engineUpgrade, engineOpen:
+			// Nothing to do
+		}
+	}
+}
+
+// deliver hands a frame to the reader, giving up if the connection closes
+func (e *engineConn) deliver(frame engineFrame) bool {
+	select {
+	case e.frames <- frame:
+		return true
+	case <-e.closed:
+		return false
+	}
+}
+
+// writeText sends a raw Engine.IO text packet
+func (e *engineConn) writeText(packet string) error {
+	return e.write(gorilla.TextMessage, []byte(packet))
+}
+
+// writeMessage sends a Socket.IO packet inside an Engine.IO message packet
+func (e *engineConn) writeMessage(payload string) error {
+	return e.writeText(string(engineMessage) + payload)
+}
+
+// writeBinary sends a binary attachment frame
+func (e *engineConn) writeBinary(data []byte) error {
+	return e.write(gorilla.BinaryMessage, data)
+}
+
+func (e *engineConn) write(msgType int, data []byte) error {
+	select {
+	case <-e.closed:
+		return errEngineClosed
+	default:
+	}
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	e.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return e.ws.WriteMessage(msgType, data)
+}
+
+// Close sends an Engine.IO close packet and closes the websocket
+func (e *engineConn) Close() error {
+	e.writeMu.Lock()
+	e.ws.SetWriteDeadline(time.Now().Add(time.Second))
+	e.ws.WriteMessage(gorilla.TextMessage, []byte{engineClose})
+	e.ws.WriteMessage(gorilla.CloseMessage, gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, ""))
+	e.writeMu.Unlock()
+
+	e.closeWithError(errEngineClosed)
+	return nil
+}
+
+// closeWithError records why the connection ended and releases it
+func (e *engineConn) closeWithError(err error) {
+	e.closeOnce.Do(func() {
+		e.errMu.Lock()
+		e.err = err
+		e.errMu.Unlock()
+		close(e.closed)
+		e.ws.Close()
+	})
+}
+
+// Err returns why the connection closed
+func (e *engineConn) Err() error {
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	return e.err
+}
+
+// truncate shortens s for error messages
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "…"
+}
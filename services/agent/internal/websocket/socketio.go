@@ -0,0 +1,542 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// Socket.IO v5 packet types (used by Socket.IO v3 and v4 servers)
+const (
+	packetConnect      = 0
+	packetDisconnect   = 1
+	packetEvent        = 2
+	packetAck          = 3
+	packetConnectError = 4
+	packetBinaryEvent  = 5
+	packetBinaryAck    = 6
+)
+
+// noAck marks a packet that doesn't carry an ack id
+const noAck = -1
+
+// ErrSocketClosed is returned by Emit and EmitWithAck once the socket is closed
+var ErrSocketClosed = errors.New("socket.io socket closed")
+
+// EventHandler handles an event sent by the server.
+// Binary attachments arrive as base64 JSON strings, so they decode into []byte.
+// If the server asked for an ack, the returned values are sent back as its arguments.
+type EventHandler func(args []json.RawMessage) []interface{}
+
+// packet is a decoded Socket.IO packet
+type packet struct {
+	Type        int
+	Namespace   string
+	ID          int
+	Attachments int
+	Data        json.RawMessage
+}
+
+// partialPacket is a binary packet still waiting for its attachments
+type partialPacket struct {
+	packet      packet
+	attachments [][]byte
+}
+
+// socket is a Socket.IO v4 client socket connected to one namespace
+type socket struct {
+	engine    *engineConn
+	namespace string
+
+	mu        sync.Mutex
+	sid       string
+	handlers  map[string]EventHandler
+	acks      map[int]chan []json.RawMessage
+	nextAck   int
+	connected chan error
+	pending   *partialPacket
+
+	// Events wait here for the dispatcher so a slow handler never stalls the read loop,
+	// which has to keep answering pings
+	events      []packet
+	eventsReady chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// dialSocket opens an Engine.IO connection and connects to the namespace of serverURL.
+// The URL path selects the namespace, as in the JavaScript client: http://host/ws/console
+// connects to the /ws/console namespace through the default /socket.io/ endpoint.
+func dialSocket(ctx context.Context, dialer *gorilla.Dialer, serverURL string, header http.Header, auth interface{}) (*socket, error) {
+	base, namespace, err := splitNamespace(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := dialEngine(ctx, dialer, base, header)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &socket{
+		engine:      engine,
+		namespace:   namespace,
+		handlers:    make(map[string]EventHandler),
+		acks:        make(map[int]chan []json.RawMessage),
+		connected:   make(chan error, 1),
+		eventsReady: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.dispatchLoop()
+
+	var data json.RawMessage
+	if auth != nil {
+		if data, err = json.Marshal(auth); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("encode auth payload: %w", err)
+		}
+	}
+	if err := s.send(packet{Type: packetConnect, Namespace: namespace, ID: noAck, Data: data}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("connect namespace %s: %w", namespace, err)
+	}
+
+	select {
+	case err := <-s.connected:
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	case <-s.done:
+		return nil, fmt.Errorf("connect namespace %s: %w", namespace, s.Err())
+	case <-ctx.Done():
+		s.Close()
+		return nil, fmt.Errorf("connect namespace %s: %w", namespace, ctx.Err())
+	}
+
+	return s, nil
+}
+
+// splitNamespace separates the namespace from a server URL
+func splitNamespace(serverURL string) (string, string, error) {
+	schemeEnd := strings.Index(serverURL, "://")
+	if schemeEnd < 0 {
+		return "", "", fmt.Errorf("invalid server URL %q", serverURL)
+	}
+
+	rest := serverURL[schemeEnd+3:]
+	slash := strings.IndexByte(rest, '/')
+	if slash < 0 {
+		return serverURL, "/", nil
+	}
+
+	base := serverURL[:schemeEnd+3+slash]
+	namespace := rest[slash:]
+	if i := strings.IndexAny(namespace, "?#"); i >= 0 {
+		base += namespace[i:]
+		namespace = namespace[:i]
+	}
+	namespace = strings.TrimRight(namespace, "/")
+	if namespace == "" {
+		namespace = "/"
+	}
+	return base, namespace, nil
+}
+
+// On registers the handler for an event, replacing any previous one
+func (s *socket) On(event string, handler EventHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[event] = handler
+}
+
+// Emit sends an event without waiting for an ack.
+// []byte arguments are sent as binary attachments.
+func (s *socket) Emit(event string, args ...interface{}) error {
+	return s.emit(event, noAck, args)
+}
+
+// EmitWithAck sends an event and waits for the server to acknowledge it
+func (s *socket) EmitWithAck(ctx context.Context, event string, args ...interface{}) ([]json.RawMessage, error) {
+	reply := make(chan []json.RawMessage, 1)
+
+	s.mu.Lock()
+	id := s.nextAck
+	s.nextAck++
+	s.acks[id] = reply
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.acks, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.emit(event, id, args); err != nil {
+		return nil, err
+	}
+
+	select {
+	case data := <-reply:
+		return data, nil
+	case <-s.done:
+		return nil, ErrSocketClosed
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for %s ack: %w", event, ctx.Err())
+	}
+}
+
+func (s *socket) emit(event string, id int, args []interface{}) error {
+	return s.sendArgs(packetEvent, id, append([]interface{}{event}, args...))
+}
+
+// sendArgs encodes an argument list, moving []byte values into binary attachments
+func (s *socket) sendArgs(packetType, id int, args []interface{}) error {
+	var attachments [][]byte
+	for i, arg := range args {
+		if b, ok := arg.([]byte); ok {
+			args[i] = map[string]interface{}{"_placeholder": true, "num": len(attachments)}
+			attachments = append(attachments, b)
+		}
+	}
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("encode event arguments: %w", err)
+	}
+
+	p := packet{Type: packetType, Namespace: s.namespace, ID: id, Data: data}
+	if len(attachments) > 0 {
+		p.Attachments = len(attachments)
+		if packetType == packetEvent {
+			p.Type = packetBinaryEvent
+		} else {
+			p.Type = packetBinaryAck
+		}
+	}
+
+	if err := s.send(p); err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if err := s.engine.writeBinary(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send writes a packet to the engine connection
+func (s *socket) send(p packet) error {
+	select {
+	case <-s.done:
+		return ErrSocketClosed
+	default:
+	}
+	return s.engine.writeMessage(encodePacket(p))
+}
+
+// readLoop decodes packets from the engine connection until it closes
+func (s *socket) readLoop() {
+	for frame := range s.engine.frames {
+		if !frame.isText {
+			s.handleAttachment(frame.binary)
+			continue
+		}
+
+		p, err := decodePacket(frame.text)
+		if err != nil {
+			continue
+		}
+		if p.Namespace != s.namespace {
+			continue
+		}
+
+		if p.Attachments > 0 {
+			s.mu.Lock()
+			s.pending = &partialPacket{packet: p}
+			s.mu.Unlock()
+			continue
+		}
+		s.handlePacket(p)
+	}
+
+	s.shutdown(s.engine.Err())
+}
+
+// handleAttachment adds a binary frame to the pending packet and dispatches it once complete
+func (s *socket) handleAttachment(data []byte) {
+	s.mu.Lock()
+	pending := s.pending
+	if pending == nil {
+		s.mu.Unlock()
+		return
+	}
+	pending.attachments = append(pending.attachments, data)
+	if len(pending.attachments) < pending.packet.Attachments {
+		s.mu.Unlock()
+		return
+	}
+	s.pending = nil
+	s.mu.Unlock()
+
+	p := pending.packet
+	resolved, err := resolvePlaceholders(p.Data, pending.attachments)
+	if err != nil {
+		return
+	}
+	p.Data = resolved
+	s.handlePacket(p)
+}
+
+// handlePacket acts on a complete packet
+func (s *socket) handlePacket(p packet) {
+	switch p.Type {
+	case packetConnect:
+		var info struct {
+			SID string `json:"sid"`
+		}
+		json.Unmarshal(p.Data, &info)
+		s.mu.Lock()
+		s.sid = info.SID
+		s.mu.Unlock()
+		s.signalConnected(nil)
+
+	case packetConnectError:
+		var info struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(p.Data, &info) != nil || info.Message == "" {
+			info.Message = string(p.Data)
+		}
+		s.signalConnected(fmt.Errorf("namespace %s refused connection: %s", s.namespace, info.Message))
+
+	case packetDisconnect:
+		s.shutdown(errors.New("server disconnected the namespace"))
+		s.engine.Close()
+
+	case packetEvent, packetBinaryEvent:
+		s.queueEvent(p)
+
+	case packetAck, packetBinaryAck:
+		var args []json.RawMessage
+		json.Unmarshal(p.Data, &args)
+		s.mu.Lock()
+		reply, ok := s.acks[p.ID]
+		s.mu.Unlock()
+		if ok {
+			select {
+			case reply <- args:
+			default:
+			}
+		}
+	}
+}
+
+// queueEvent hands an event to the dispatcher without blocking
+func (s *socket) queueEvent(p packet) {
+	s.mu.Lock()
+	s.events = append(s.events, p)
+	s.mu.Unlock()
+
+	select {
+	case s.eventsReady <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchLoop runs event handlers in arrival order until the socket closes
+func (s *socket) dispatchLoop() {
+	for {
+		s.mu.Lock()
+		if len(s.events) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.eventsReady:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		p := s.events[0]
+		s.events[0] = packet{}
+		s.events = s.events[1:]
+		s.mu.Unlock()
+
+		s.handleEvent(p)
+	}
+}
+
+// handleEvent runs the handler for an event and acks it if requested
+func (s *socket) handleEvent(p packet) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(p.Data, &args); err != nil || len(args) == 0 {
+		return
+	}
+	var event string
+	if err := json.Unmarshal(args[0], &event); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	handler := s.handlers[event]
+	s.mu.Unlock()
+
+	var reply []interface{}
+	if handler != nil {
+		reply = handler(args[1:])
+	}
+
+	if p.ID != noAck {
+		if reply == nil {
+			reply = []interface{}{}
+		}
+		s.sendArgs(packetAck, p.ID, reply)
+	}
+}
+
+// signalConnected reports the outcome of the namespace handshake
+func (s *socket) signalConnected(err error) {
+	select {
+	case s.connected <- err:
+	default:
+	}
+}
+
+// Done is closed when the socket is no longer usable
+func (s *socket) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the socket closed
+func (s *socket) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close leaves the namespace and closes the underlying connection
+func (s *socket) Close() error {
+	s.send(packet{Type: packetDisconnect, Namespace: s.namespace, ID: noAck})
+	s.shutdown(ErrSocketClosed)
+	return s.engine.Close()
+}
+
+func (s *socket) shutdown(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		if err == nil {
+			err = ErrSocketClosed
+		}
+		s.err = err
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// encodePacket serializes a packet: <type>[<attachments>-][<namespace>,][<id>][<data>]
+func encodePacket(p packet) string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(p.Type))
+	if p.Type == packetBinaryEvent || p.Type == packetBinaryAck {
+		b.WriteString(strconv.Itoa(p.Attachments))
+		b.WriteByte('-')
+	}
+	if p.Namespace != "" && p.Namespace != "/" {
+		b.WriteString(p.Namespace)
+		b.WriteByte(',')
+	}
+	if p.ID != noAck {
+		b.WriteString(strconv.Itoa(p.ID))
+	}
+	b.Write(p.Data)
+	return b.String()
+}
+
+// decodePacket parses a packet serialized by encodePacket
+func decodePacket(s string) (packet, error) {
+	p := packet{Namespace: "/", ID: noAck}
+	if s == "" || s[0] < '0' || s[0] > '6' {
+		return p, fmt.Errorf("invalid socket.io packet %q", truncate(s, 32))
+	}
+	p.Type = int(s[0] - '0')
+	s = s[1:]
+
+	if p.Type == packetBinaryEvent || p.Type == packetBinaryAck {
+		count, rest, ok := strings.Cut(s, "-")
+		n, err := strconv.Atoi(count)
+		if !ok || err != nil || n < 0 {
+			return p, fmt.Errorf("invalid attachment count in socket.io packet")
+		}
+		p.Attachments = n
+		s = rest
+	}
+
+	if strings.HasPrefix(s, "/") {
+		namespace, rest, _ := strings.Cut(s, ",")
+		p.Namespace = namespace
+		s = rest
+	}
+
+	digits := 0
+	for digits < len(s) && s[digits] >= '0' && s[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 {
+		id, err := strconv.Atoi(s[:digits])
+		if err != nil {
+			return p, fmt.Errorf("invalid ack id in socket.io packet")
+		}
+		p.ID = id
+		s = s[digits:]
+	}
+
+	if s != "" {
+		p.Data = json.RawMessage(s)
+	}
+	return p, nil
+}
+
+// resolvePlaceholders replaces attachment placeholders with base64 strings
+func resolvePlaceholders(data json.RawMessage, attachments [][]byte) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(replacePlaceholders(v, attachments))
+}
+
+func replacePlaceholders(v interface{}, attachments [][]byte) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if isPlaceholder, _ := t["_placeholder"].(bool); isPlaceholder {
+			if num, ok := t["num"].(json.Number); ok {
+				if n, err := num.Int64(); err == nil && n >= 0 && int(n) < len(attachments) {
+					return base64.StdEncoding.EncodeToString(attachments[n])
+				}
+			}
+		}
+		for k, child := range t {
+			t[k] = replacePlaceholders(child, attachments)
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = replacePlaceholders(child, attachments)
+		}
+	}
+	return v
+}
@@ -0,0 +1,275 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/lunaris/agent/internal/backoff"
+	"github.com/lunaris/agent/internal/websocket/wstest"
+)
+
+// testLogger sends client logs to the test log
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Printf(format string, v ...interface{}) { l.t.Logf(format, v...) }
+func (l testLogger) Println(v ...interface{})               { l.t.Log(v...) }
+
+// newStandIn starts an in-process Socket.IO server
+func newStandIn(t *testing.T, configure func(s *wstest.Server)) (*wstest.Server, string) {
+	t.Helper()
+	socket := wstest.NewServer()
+	if configure != nil {
+		configure(socket)
+	}
+	server := httptest.NewServer(socket)
+	t.Cleanup(func() {
+		socket.CloseAll()
+		server.Close()
+	})
+	return socket, server.URL
+}
+
+func closeClient(t *testing.T, c *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Close(ctx); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	packets := []packet{
+		{Type: packetConnect, Namespace: "/ws/console", ID: noAck, Data: json.RawMessage(`{"token":"t"}`)},
+		{Type: packetEvent, Namespace: "/", ID: noAck, Data: json.RawMessage(`["hello",1]`)},
+		{Type: packetEvent, Namespace: "/ws/console", ID: 12, Data: json.RawMessage(`["join_device","d"]`)},
+		{Type: packetBinaryEvent, Namespace: "/ws/console", ID: 3, Attachments: 2, Data: json.RawMessage(`["bin",{"_placeholder":true,"num":0}]`)},
+		{Type: packetDisconnect, Namespace: "/ws/console", ID: noAck},
+	}
+	for _, want := range packets {
+		encoded := encodePacket(want)
+		got, err := decodePacket(encoded)
+		if err != nil {
+			t.Fatalf("decodePacket(%q) = %v", encoded, err)
+		}
+		if got.Type != want.Type || got.Namespace != want.Namespace || got.ID != want.ID ||
+			got.Attachments != want.Attachments || string(got.Data) != string(want.Data) {
+			t.Errorf("round trip of %q = %+v, want %+v", encoded, got, want)
+		}
+	}
+
+	if _, err := decodePacket("9oops"); err == nil {
+		t.Error("decodePacket accepted an invalid packet type")
+	}
+}
+
+func TestConnectAuthenticatesAndJoinsDeviceRoom(t *testing.T) {
+	socket, url := newStandIn(t, nil)
+
+	c := NewClient(url, "device-1", testLogger{t})
+	c.SetTokenSource(func() string { return "secret-token" })
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	defer closeClient(t, c)
+
+	if !c.IsConnected() {
+		t.Fatalf("state = %v after Connect", c.State())
+	}
+	select {
+	case id := <-socket.Joined():
+		if id != "device-1" {
+			t.Errorf("joined %q, want device-1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no join_device")
+	}
+
+	conn := socket.Conns()[0]
+	if got := conn.Header.Get("Authorization"); got != "Bearer secret-token" {
+		t.Errorf("Authorization header = %q", got)
+	}
+	var auth map[string]string
+	if err := json.Unmarshal(conn.Auth, &auth); err != nil || auth["token"] != "secret-token" {
+		t.Errorf("connect auth = %s", conn.Auth)
+	}
+}
+
+func TestConnectRefusedByNamespace(t *testing.T) {
+	_, url := newStandIn(t, func(s *wstest.Server) { s.RejectConnect = "invalid token" })
+
+	c := NewClient(url, "device-1", testLogger{t})
+	defer closeClient(t, c)
+
+	err := c.Connect()
+	if err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Fatalf("Connect() = %v, want refusal", err)
+	}
+	if c.IsConnected() {
+		t.Error("connected after refusal")
+	}
+}
+
+func TestPongsWhileEventHandlerBlocks(t *testing.T) {
+	socket, url := newStandIn(t, nil)
+
+	release := make(chan struct{})
+	received := make(chan string, 4)
+	c := NewClient(url, "device-1", testLogger{t})
+	c.SetInstallHandler(func(cmd *InstallCommand) error {
+		received <- cmd.CommandID
+		<-release
+		return nil
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	defer closeClient(t, c)
+	defer close(release)
+
+	event := func(id string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":    "install_updates",
+			"payload": map[string]interface{}{"commandId": id, "deviceId": "device-1", "packageIdentifiers": []string{"a"}},
+		}
+	}
+	socket.Emit("install_updates", event("cmd-1"))
+	socket.Emit("install_updates", event("cmd-2"))
+
+	select {
+	case id := <-received:
+		if id != "cmd-1" {
+			t.Fatalf("first command = %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("install handler not called")
+	}
+
+	// The handler is stuck, yet pings are still answered
+	conn := socket.Conns()[0]
+	for i := 0; i < 3; i++ {
+		if err := conn.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-socket.Pongs():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no pong %d while the event handler blocks", i+1)
+		}
+	}
+	if !c.IsConnected() {
+		t.Error("connection dropped while the handler blocks")
+	}
+
+	// Events queued behind the blocked handler run in order once it returns
+	release <- struct{}{}
+	select {
+	case id := <-received:
+		if id != "cmd-2" {
+			t.Errorf("second command = %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued event never dispatched")
+	}
+}
+
+func TestReconnectsAfterMissedPings(t *testing.T) {
+	socket, url := newStandIn(t, func(s *wstest.Server) {
+		s.PingInterval = 100 * time.Millisecond
+		s.PingTimeout = 100 * time.Millisecond
+	})
+
+	var mu sync.Mutex
+	var states []State
+	c := NewClient(url, "device-1", testLogger{t})
+	c.backoff = &backoff.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	c.SetStateHandler(func(change StateChange) {
+		mu.Lock()
+		states = append(states, change.To)
+		mu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+	defer closeClient(t, c)
+
+	// The server never pings, so the client gives up after pingInterval+pingTimeout and reconnects
+	for i := 0; i < 2; i++ {
+		select {
+		case <-socket.Joined():
+		case <-time.After(3 * time.Second):
+			t.Fatalf("join %d missing", i+1)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sawBackoff := false
+	for _, s := range states {
+		if s == StateBackingOff {
+			sawBackoff = true
+		}
+	}
+	if !sawBackoff {
+		t.Errorf("states %v never backed off", states)
+	}
+}
+
+func TestSocketAcksServerEventsAndResolvesAttachments(t *testing.T) {
+	socket, url := newStandIn(t, nil)
+
+	dialer := *gorilla.DefaultDialer
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := dialSocket(ctx, &dialer, url+"/ns", nil, nil)
+	if err != nil {
+		t.Fatalf("dialSocket() = %v", err)
+	}
+	defer s.Close()
+
+	binary := make(chan []byte, 1)
+	s.On("echo", func(args []json.RawMessage) []interface{} {
+		var v string
+		json.Unmarshal(args[0], &v)
+		return []interface{}{"echo:" + v}
+	})
+	s.On("blob", func(args []json.RawMessage) []interface{} {
+		var b []byte
+		json.Unmarshal(args[0], &b)
+		binary <- b
+		return nil
+	})
+
+	var conn *wstest.Conn
+	select {
+	case conn = <-socket.Connected():
+	case <-time.After(time.Second):
+		t.Fatal("no namespace connection")
+	}
+
+	reply, err := conn.EmitWithAck(2*time.Second, "echo", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 1 || string(reply[0]) != `"echo:hi"` {
+		t.Errorf("ack = %s", reply)
+	}
+
+	conn.WriteRaw(`451-/ns,["blob",{"_placeholder":true,"num":0}]`)
+	conn.WriteBinary([]byte{1, 2, 3})
+	select {
+	case b := <-binary:
+		if string(b) != "\x01\x02\x03" {
+			t.Errorf("attachment = %v", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("binary event not delivered")
+	}
+}
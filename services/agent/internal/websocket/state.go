@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/lunaris/agent/internal/backoff"
+)
+
+// State is the connection state of the client
+type State int
+
+const (
+	StateIdle State = iota
+	StateConnecting
+	StateConnected
+	StateBackingOff
+	StateClosed
+)
+
+// String returns the state name used in logs and heartbeats
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBackingOff:
+		return "backing_off"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// StateChange describes a transition of the connection state
+type StateChange struct {
+	From    State
+	To      State
+	Err     error
+	Attempt int
+	RetryIn time.Duration
+	At      time.Time
+}
+
+// StateHandler is called after every state transition
+type StateHandler func(change StateChange)
+
+// DefaultBackoff returns the backoff used for reconnects
+func DefaultBackoff() *backoff.Backoff {
+	return &backoff.Backoff{
+		Min: 2 * time.Second,
+		Max: 2 * time.Minute,
+	}
+}
@@ -0,0 +1,362 @@
+// Package wstest provides an in-process Socket.IO v4 server for tests.
+// It speaks the websocket transport of Engine.IO v4 and accepts every namespace.
+package wstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// Conn is a client connected to the server
+type Conn struct {
+	ws        *gorilla.Conn
+	namespace string
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextAck int
+	acks    map[int]chan []json.RawMessage
+	closed  chan struct{}
+	once    sync.Once
+
+	// Auth is the payload the client sent when connecting to the namespace
+	Auth json.RawMessage
+
+	// Header is the websocket handshake request header
+	Header http.Header
+}
+
+// Server is an http.Handler serving Socket.IO on /socket.io/
+type Server struct {
+	// PingInterval and PingTimeout are announced in the Engine.IO handshake
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	// RejectConnect, if set, refuses namespace connections with this message
+	RejectConnect string
+
+	// AutoPing makes the server ping every PingInterval, as real servers do
+	AutoPing bool
+
+	mu       sync.Mutex
+	conns    []*Conn
+	handlers map[string]func(c *Conn, args []json.RawMessage) []interface{}
+
+	connected chan *Conn
+	joined    chan string
+	pongs     chan struct{}
+	upgrader  gorilla.Upgrader
+}
+
+// NewServer returns a server that acks join_device and reports joins on Joined
+func NewServer() *Server {
+	s := &Server{
+		PingInterval: 25 * time.Second,
+		PingTimeout:  20 * time.Second,
+		handlers:     make(map[string]func(c *Conn, args []json.RawMessage) []interface{}),
+		connected:    make(chan *Conn, 16),
+		joined:       make(chan string, 16),
+		pongs:        make(chan struct{}, 64),
+	}
+	s.On("join_device", func(c *Conn, args []json.RawMessage) []interface{} {
+		var deviceID string
+		if len(args) > 0 {
+			json.Unmarshal(args[0], &deviceID)
+		}
+		select {
+		case s.joined <- deviceID:
+		default:
+		}
+		return []interface{}{map[string]bool{"ok": true}}
+	})
+	return s
+}
+
+// On sets the handler for an event sent by clients; its return values are the ack arguments
+func (s *Server) On(event string, handler func(c *Conn, args []json.RawMessage) []interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[event] = handler
+}
+
+// Connected delivers every client that connected to a namespace
+func (s *Server) Connected() <-chan *Conn {
+	return s.connected
+}
+
+// Joined delivers the device ID of every join_device event
+func (s *Server) Joined() <-chan string {
+	return s.joined
+}
+
+// Pongs receives a value for every pong a client sends
+func (s *Server) Pongs() <-chan struct{} {
+	return s.pongs
+}
+
+// Conns returns the clients connected to a namespace
+func (s *Server) Conns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Conn(nil), s.conns...)
+}
+
+// Emit sends an event to every connected client
+func (s *Server) Emit(event string, args ...interface{}) {
+	for _, c := range s.Conns() {
+		c.Emit(event, args...)
+	}
+}
+
+// CloseAll drops every client connection
+func (s *Server) CloseAll() {
+	for _, c := range s.Conns() {
+		c.Close()
+	}
+}
+
+// ServeHTTP upgrades Engine.IO websocket requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/socket.io/") || r.URL.Query().Get("EIO") != "4" {
+		http.NotFound(w, r)
+		return
+	}
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &Conn{
+		ws:     ws,
+		acks:   make(map[int]chan []json.RawMessage),
+		closed: make(chan struct{}),
+		Header: r.Header.Clone(),
+	}
+	handshake := fmt.Sprintf(`0{"sid":"eio-%d","upgrades":[],"pingInterval":%d,"pingTimeout":%d,"maxPayload":1000000}`,
+		time.Now().UnixNano(), s.PingInterval.Milliseconds(), s.PingTimeout.Milliseconds())
+	if err := c.write(handshake); err != nil {
+		ws.Close()
+		return
+	}
+
+	if s.AutoPing {
+		go s.autoPing(c)
+	}
+	s.serve(c)
+}
+
+// autoPing pings a client every PingInterval until it disconnects
+func (s *Server) autoPing(c *Conn) {
+	ticker := time.NewTicker(s.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			c.Ping()
+		}
+	}
+}
+
+// serve reads packets from a client until it disconnects
+func (s *Server) serve(c *Conn) {
+	defer s.remove(c)
+	defer c.Close()
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil || len(data) == 0 {
+			return
+		}
+
+		switch data[0] {
+		case '1':
+			return
+		case '3':
+			select {
+			case s.pongs <- struct{}{}:
+			default:
+			}
+		case '4':
+			s.handlePacket(c, string(data[1:]))
+		}
+	}
+}
+
+// handlePacket acts on a Socket.IO packet from a client
+func (s *Server) handlePacket(c *Conn, p string) {
+	if p == "" {
+		return
+	}
+	packetType, rest := p[0], p[1:]
+
+	namespace := "/"
+	if strings.HasPrefix(rest, "/") {
+		namespace, rest, _ = strings.Cut(rest, ",")
+	}
+	id := -1
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 {
+		id, _ = strconv.Atoi(rest[:digits])
+		rest = rest[digits:]
+	}
+
+	switch packetType {
+	case '0':
+		if s.RejectConnect != "" {
+			msg, _ := json.Marshal(map[string]string{"message": s.RejectConnect})
+			c.write("44" + prefix(namespace) + string(msg))
+			return
+		}
+		c.namespace = namespace
+		c.Auth = json.RawMessage(rest)
+		c.write("40" + prefix(namespace) + `{"sid":"sio-1"}`)
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.mu.Unlock()
+		select {
+		case s.connected <- c:
+		default:
+		}
+
+	case '1':
+		c.Close()
+
+	case '2':
+		var args []json.RawMessage
+		if json.Unmarshal([]byte(rest), &args) != nil || len(args) == 0 {
+			return
+		}
+		var event string
+		json.Unmarshal(args[0], &event)
+
+		s.mu.Lock()
+		handler := s.handlers[event]
+		s.mu.Unlock()
+
+		var reply []interface{}
+		if handler != nil {
+			reply = handler(c, args[1:])
+		}
+		if id >= 0 {
+			if reply == nil {
+				reply = []interface{}{}
+			}
+			data, _ := json.Marshal(reply)
+			c.write("43" + prefix(namespace) + strconv.Itoa(id) + string(data))
+		}
+
+	case '3':
+		var args []json.RawMessage
+		json.Unmarshal([]byte(rest), &args)
+		c.mu.Lock()
+		reply := c.acks[id]
+		delete(c.acks, id)
+		c.mu.Unlock()
+		if reply != nil {
+			reply <- args
+		}
+	}
+}
+
+func (s *Server) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, conn := range s.conns {
+		if conn == c {
+			s.conns = append(s.conns[:i], s.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// prefix returns the namespace part of a packet
+func prefix(namespace string) string {
+	if namespace == "/" {
+		return ""
+	}
+	return namespace + ","
+}
+
+// Emit sends an event to the client
+func (c *Conn) Emit(event string, args ...interface{}) error {
+	data, err := json.Marshal(append([]interface{}{event}, args...))
+	if err != nil {
+		return err
+	}
+	return c.write("42" + prefix(c.namespace) + string(data))
+}
+
+// EmitWithAck sends an event and waits up to timeout for the client's ack
+func (c *Conn) EmitWithAck(timeout time.Duration, event string, args ...interface{}) ([]json.RawMessage, error) {
+	data, err := json.Marshal(append([]interface{}{event}, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make(chan []json.RawMessage, 1)
+	c.mu.Lock()
+	id := c.nextAck
+	c.nextAck++
+	c.acks[id] = reply
+	c.mu.Unlock()
+
+	if err := c.write("42" + prefix(c.namespace) + strconv.Itoa(id) + string(data)); err != nil {
+		return nil, err
+	}
+	select {
+	case args := <-reply:
+		return args, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no ack for %s within %v", event, timeout)
+	}
+}
+
+// Ping sends an Engine.IO ping; the client must answer with a pong
+func (c *Conn) Ping() error {
+	return c.write("2")
+}
+
+// WriteRaw sends a raw Engine.IO packet
+func (c *Conn) WriteRaw(packet string) error {
+	return c.write(packet)
+}
+
+// WriteBinary sends a binary frame, such as a Socket.IO attachment
+func (c *Conn) WriteBinary(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return c.ws.WriteMessage(gorilla.BinaryMessage, data)
+}
+
+// Close drops the connection
+func (c *Conn) Close() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.ws.Close()
+	})
+}
+
+// Done is closed once the connection is dropped
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *Conn) write(packet string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return c.ws.WriteMessage(gorilla.TextMessage, []byte(packet))
+}
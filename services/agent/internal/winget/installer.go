@@ -5,15 +5,12 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+
+	"github.com/lunaris/agent/internal/updates"
 )
 
 // InstallResult represents the result of an install operation
-type InstallResult struct {
-	PackageIdentifier string
-	Success           bool
-	Message           string
-	Error             error
-}
+type InstallResult = updates.InstallResult
 
 // Installer handles winget package installations
 type Installer struct{}
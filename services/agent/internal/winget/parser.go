@@ -0,0 +1,310 @@
+package winget
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// tableColumns is the number of columns in the winget upgrade table:
+// Name, Id, Version, Available, Source
+const tableColumns = 5
+
+var (
+	separatorPattern = regexp.MustCompile(`^-{3,}$`)
+	ansiPattern      = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+	// Footer lines winget prints around the table; these aren't parse failures
+	footerPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^\d+ upgrades? available\.?$`),
+		regexp.MustCompile(`^\d+ package\(s\) have`),
+		regexp.MustCompile(`^No installed package found`),
+		regexp.MustCompile(`^No applicable upgrade`),
+	}
+	explicitTargetPattern = regexp.MustCompile(`require explicit targeting`)
+)
+
+// tableLayout holds the console column each table column starts at, taken from the header row.
+// Columns are measured in display cells, since wide characters take two.
+type tableLayout struct {
+	starts []int
+}
+
+// parseWingetOutput parses the output of winget upgrade.
+// Every line inside a table that doesn't produce an update is reported in ScanResult.Unparsed.
+func parseWingetOutput(output string) *ScanResult {
+	result := &ScanResult{}
+
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, cleanLine(scanner.Text()))
+	}
+
+	inData := false
+	explicitTarget := false
+	var layout *tableLayout
+	lastText := ""
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		// The separator starts a table; the row above it is the header
+		if separatorPattern.MatchString(trimmed) {
+			inData = true
+			layout = layoutFromHeader(lastText)
+			continue
+		}
+
+		if trimmed == "" || isSpinnerNoise(trimmed) {
+			continue
+		}
+		lastText = line
+
+		if explicitTargetPattern.MatchString(trimmed) {
+			// A second table follows for packages that must be upgraded by ID
+			inData = false
+			explicitTarget = true
+			continue
+		}
+
+		if !inData {
+			continue
+		}
+
+		if isFooter(trimmed) {
+			inData = false
+			continue
+		}
+
+		// A following line that is itself a header belongs to the next table
+		if i+1 < len(lines) && separatorPattern.MatchString(strings.TrimSpace(lines[i+1])) {
+			inData = false
+			continue
+		}
+
+		var update *Update
+		reason := ""
+		aligned := false
+		if layout != nil {
+			update, reason, aligned = layout.parse(line)
+		}
+
+		// A row that fits the header but holds invalid values is reported rather than guessed at
+		if update == nil && aligned {
+			result.Unparsed = append(result.Unparsed, UnparsedLine{
+				LineNumber: i + 1,
+				Text:       trimmed,
+				Reason:     reason,
+			})
+			continue
+		}
+
+		// Rows that don't fit the console width wrap onto the next line
+		if update == nil && i+1 < len(lines) && isContinuation(lines[i+1]) {
+			joined := trimmed + " " + strings.TrimSpace(lines[i+1])
+			if joinedUpdate, _ := parseUpdateLine(joined); joinedUpdate != nil {
+				update = joinedUpdate
+				i++
+			}
+		}
+
+		// Fall back to splitting from the right when the header positions don't apply
+		if update == nil {
+			fallback, fallbackReason := parseUpdateLine(line)
+			update = fallback
+			if reason == "" {
+				reason = fallbackReason
+			}
+		}
+
+		if update == nil {
+			result.Unparsed = append(result.Unparsed, UnparsedLine{
+				LineNumber: i + 1,
+				Text:       trimmed,
+				Reason:     reason,
+			})
+			continue
+		}
+
+		update.RequiresExplicitTarget = explicitTarget
+		result.Updates = append(result.Updates, *update)
+	}
+
+	return result
+}
+
+// layoutFromHeader derives column positions from the header row.
+// Header text is localized, so only the positions of its words are used.
+func layoutFromHeader(header string) *tableLayout {
+	runes := []rune(header)
+	offsets, _ := cellOffsets(runes)
+	var starts []int
+	for i, r := range runes {
+		if !unicode.IsSpace(r) && (i == 0 || unicode.IsSpace(runes[i-1])) {
+			starts = append(starts, offsets[i])
+		}
+	}
+	if len(starts) != tableColumns {
+		return nil
+	}
+	return &tableLayout{starts: starts}
+}
+
+// parse slices a row at the header's column positions.
+// aligned is false if the row doesn't fit the positions, so another way of parsing may apply.
+func (l *tableLayout) parse(line string) (update *Update, reason string, aligned bool) {
+	runes := []rune(strings.TrimRight(line, " \t"))
+	offsets, width := cellOffsets(runes)
+	if width <= l.starts[tableColumns-1] {
+		return nil, "row shorter than header", false
+	}
+
+	// bounds[c] is the index of the first rune at or after column c's start
+	bounds := make([]int, tableColumns+1)
+	for c := 0; c < tableColumns; c++ {
+		k := 0
+		for k < len(runes) && offsets[k] < l.starts[c] {
+			k++
+		}
+		// A value running into the next column means the positions don't apply
+		if c > 0 && (k == 0 || !unicode.IsSpace(runes[k-1])) {
+			return nil, fmt.Sprintf("column %d not aligned with header", c+1), false
+		}
+		bounds[c] = k
+	}
+	bounds[tableColumns] = len(runes)
+
+	cols := make([]string, tableColumns)
+	for c := range cols {
+		cols[c] = strings.TrimSpace(string(runes[bounds[c]:bounds[c+1]]))
+	}
+	update, reason = newUpdate(cols[0], cols[1], cols[2], cols[3], cols[4])
+	return update, reason, true
+}
+
+// parseUpdateLine parses a single row from right to left.
+// Format: Name    Id    Version    Available    Source
+// Package names can contain spaces, so the other columns are taken from the end.
+func parseUpdateLine(line string) (*Update, string) {
+	fields := joinVersionBounds(strings.Fields(line))
+	if len(fields) < tableColumns {
+		return nil, fmt.Sprintf("expected at least %d columns, found %d", tableColumns, len(fields))
+	}
+
+	n := len(fields)
+	name := strings.Join(fields[:n-4], " ")
+	return newUpdate(name, fields[n-4], fields[n-3], fields[n-2], fields[n-1])
+}
+
+// joinVersionBounds keeps versions winget prints as "< 1.2" or "> 1.2" in one field
+func joinVersionBounds(fields []string) []string {
+	joined := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		if (fields[i] == "<" || fields[i] == ">") && i+1 < len(fields) {
+			joined = append(joined, fields[i]+" "+fields[i+1])
+			i++
+			continue
+		}
+		joined = append(joined, fields[i])
+	}
+	return joined
+}
+
+// newUpdate validates the column values of a row
+func newUpdate(name, id, installed, available, source string) (*Update, string) {
+	switch {
+	case name == "":
+		return nil, "missing package name"
+	case id == "" || strings.ContainsAny(id, " \t") || strings.ContainsAny(id[:1], "<>"):
+		return nil, "invalid package identifier"
+	case !validVersion(installed):
+		return nil, "invalid installed version"
+	case !validVersion(available):
+		return nil, "invalid available version"
+	case source == "" || strings.ContainsAny(source, " \t"):
+		return nil, "invalid source"
+	}
+
+	return &Update{
+		PackageName:       name,
+		PackageIdentifier: id,
+		InstalledVersion:  installed,
+		AvailableVersion:  available,
+	}, ""
+}
+
+// validVersion reports whether a version column holds a single version, optionally
+// bounded as "< 1.2" when winget only knows the installed version is older
+func validVersion(v string) bool {
+	if bound, rest, ok := strings.Cut(v, " "); ok {
+		if bound != "<" && bound != ">" {
+			return false
+		}
+		v = rest
+	}
+	if v == "" || strings.ContainsAny(v, " \t<>") {
+		return false
+	}
+	// Versions carry digits; winget prints a word such as "Unknown" when it has none
+	return strings.ContainsAny(v, "0123456789") || strings.IndexFunc(v, func(r rune) bool { return !unicode.IsLetter(r) }) < 0
+}
+
+// cleanLine strips terminal control output from a line.
+// The progress spinner redraws with carriage returns, so only the text after the last one is kept.
+func cleanLine(line string) string {
+	if i := strings.LastIndex(line, "\r"); i >= 0 {
+		if rest := line[i+1:]; strings.TrimSpace(rest) != "" {
+			line = rest
+		} else {
+			line = line[:i]
+			if j := strings.LastIndex(line, "\r"); j >= 0 {
+				line = line[j+1:]
+			}
+		}
+	}
+	line = ansiPattern.ReplaceAllString(line, "")
+	return strings.TrimRightFunc(line, unicode.IsSpace)
+}
+
+// isSpinnerNoise reports whether a line is only spinner or progress bar output
+func isSpinnerNoise(trimmed string) bool {
+	if trimmed == "-" || trimmed == `\` || trimmed == "|" || trimmed == "/" {
+		return true
+	}
+	if !strings.ContainsAny(trimmed, "█▒") {
+		return false
+	}
+	// Progress bars: "██████▒▒▒▒  1.00 MB / 2.00 MB" or "███▒▒  40%"
+	for _, r := range trimmed {
+		if r == '█' || r == '▒' || unicode.IsSpace(r) || unicode.IsDigit(r) ||
+			strings.ContainsRune(".,/%KMGBkmgb", r) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// isFooter reports whether a line is one of winget's summary lines
+func isFooter(trimmed string) bool {
+	for _, p := range footerPatterns {
+		if p.MatchString(trimmed) {
+			return true
+		}
+	}
+	return false
+}
+
+// isContinuation reports whether a line looks like the wrapped tail of the previous row
+func isContinuation(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || separatorPattern.MatchString(trimmed) || isFooter(trimmed) {
+		return false
+	}
+	return len(strings.Fields(trimmed)) < tableColumns
+}
@@ -0,0 +1,117 @@
+package winget
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestParseWingetOutputGolden parses every testdata/*.txt capture and compares
+// the result with the matching .golden file. Run with -update to regenerate them.
+func TestParseWingetOutputGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no testdata")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".txt")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.MarshalIndent(parseWingetOutput(string(data)), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(input, ".txt") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("parseWingetOutput(%s) =\n%s\nwant\n%s", input, got, want)
+			}
+		})
+	}
+}
+
+func TestParseUpdateLineVersionBounds(t *testing.T) {
+	u, reason := parseUpdateLine("Foo   Foo.Foo  < 1.2   1.3   winget")
+	if u == nil {
+		t.Fatalf("parseUpdateLine() rejected row: %s", reason)
+	}
+	if u.PackageName != "Foo" || u.PackageIdentifier != "Foo.Foo" || u.InstalledVersion != "< 1.2" || u.AvailableVersion != "1.3" {
+		t.Errorf("parseUpdateLine() = %+v", u)
+	}
+}
+
+func FuzzParseWingetOutput(f *testing.F) {
+	inputs, _ := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	for _, input := range inputs {
+		if data, err := os.ReadFile(input); err == nil {
+			f.Add(string(data))
+		}
+	}
+	f.Add("Name Id Version Available Source\n---\nFoo Foo.Foo < 1.2 1.3 winget\n")
+
+	f.Fuzz(func(t *testing.T, output string) {
+		result := parseWingetOutput(output)
+		lines := strings.Count(output, "\n") + 1
+
+		for _, u := range result.Updates {
+			if u.PackageName == "" || u.PackageIdentifier == "" || u.InstalledVersion == "" || u.AvailableVersion == "" {
+				t.Fatalf("update with empty field: %+v", u)
+			}
+			if strings.IndexFunc(u.PackageIdentifier, unicode.IsSpace) >= 0 || strings.ContainsAny(u.PackageIdentifier[:1], "<>") {
+				t.Fatalf("invalid package identifier %q", u.PackageIdentifier)
+			}
+			if !validVersion(u.InstalledVersion) || !validVersion(u.AvailableVersion) {
+				t.Fatalf("invalid versions in %+v", u)
+			}
+		}
+		for _, line := range result.Unparsed {
+			if line.LineNumber < 1 || line.LineNumber > lines {
+				t.Fatalf("unparsed line number %d out of range 1-%d", line.LineNumber, lines)
+			}
+			if line.Reason == "" {
+				t.Fatalf("unparsed line %d without reason", line.LineNumber)
+			}
+		}
+	})
+}
+
+func TestTableLayoutDisplayWidth(t *testing.T) {
+	// Wide characters take two console cells, so columns line up by width rather than rune count
+	layout := layoutFromHeader("名称          ID                  版本      可用      源")
+	if layout == nil {
+		t.Fatal("layoutFromHeader() = nil")
+	}
+	u, reason, aligned := layout.parse("网易 云音乐   NetEase.CloudMusic  2.10.10   3.0.0     winget")
+	if !aligned || u == nil {
+		t.Fatalf("parse() aligned=%v reason=%q", aligned, reason)
+	}
+	if u.PackageName != "网易 云音乐" || u.PackageIdentifier != "NetEase.CloudMusic" {
+		t.Errorf("parse() = %+v", u)
+	}
+}
@@ -0,0 +1,56 @@
+package winget
+
+import (
+	"github.com/lunaris/agent/internal/updates"
+)
+
+// SourceName is the api.UpdateItem.Source value for winget updates
+const SourceName = "winget"
+
+// Source adapts the winget Scanner and Installer to updates.UpdateSource
+type Source struct {
+	scanner   *Scanner
+	installer *Installer
+}
+
+var _ updates.UpdateSource = (*Source)(nil)
+
+// NewSource creates a new winget update source
+func NewSource() *Source {
+	return &Source{
+		scanner:   NewScanner(),
+		installer: NewInstaller(),
+	}
+}
+
+// Name returns the source name
+func (s *Source) Name() string {
+	return SourceName
+}
+
+// CheckAvailable checks if winget is available on the system
+func (s *Source) CheckAvailable() error {
+	return s.installer.CanInstall()
+}
+
+// ScanUpdates scans winget for available updates
+func (s *Source) ScanUpdates() (*updates.ScanResult, error) {
+	result, err := s.scanner.ScanUpdates()
+	if err != nil {
+		return nil, err
+	}
+	return &updates.ScanResult{
+		Items:    ToAPIUpdates(result.Updates),
+		Unparsed: result.Unparsed,
+	}, nil
+}
+
+// Install installs a single package using winget
+func (s *Source) Install(packageIdentifier string) *InstallResult {
+	return s.installer.Install(packageIdentifier)
+}
+
+// Uninstall uninstalls a package using winget
+func (s *Source) Uninstall(packageIdentifier string) error {
+	return s.installer.Uninstall(packageIdentifier)
+}
@@ -0,0 +1,187 @@
+package winget
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// truncationMarker is appended by winget to column values that don't fit the console width
+const truncationMarker = "…"
+
+// powerShellScanScript lists upgradable packages through the Microsoft.WinGet.Client module.
+// -InputObject keeps single results as a JSON array.
+const powerShellScanScript = `$ErrorActionPreference = 'Stop'
+[Console]::OutputEncoding = [System.Text.Encoding]::UTF8
+Import-Module Microsoft.WinGet.Client
+$updates = @(Get-WinGetPackage | Where-Object { $_.IsUpdateAvailable } | ForEach-Object {
+  [pscustomobject]@{
+    Name             = $_.Name
+    Id               = $_.Id
+    InstalledVersion = $_.InstalledVersion
+    AvailableVersion = @($_.AvailableVersions)[0]
+  }
+})
+ConvertTo-Json -Compress -InputObject $updates`
+
+// powerShellPackage is a single package from powerShellScanScript
+type powerShellPackage struct {
+	Name             string `json:"Name"`
+	ID               string `json:"Id"`
+	InstalledVersion string `json:"InstalledVersion"`
+	AvailableVersion string `json:"AvailableVersion"`
+}
+
+// exportFile is the document written by winget export
+type exportFile struct {
+	Sources []struct {
+		Packages []struct {
+			PackageIdentifier string `json:"PackageIdentifier"`
+			Version           string `json:"Version"`
+		} `json:"Packages"`
+	} `json:"Sources"`
+}
+
+// scanViaPowerShell lists available updates as JSON through the WinGet PowerShell module.
+// It fails if PowerShell or the Microsoft.WinGet.Client module isn't installed.
+func scanViaPowerShell() ([]Update, error) {
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass",
+		"-Command", powerShellScanScript)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("powershell winget scan failed: %w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parsePowerShellOutput(stdout.Bytes())
+}
+
+// parsePowerShellOutput parses the JSON written by powerShellScanScript
+func parsePowerShellOutput(output []byte) ([]Update, error) {
+	output = bytes.TrimSpace(bytes.TrimPrefix(output, []byte("\xef\xbb\xbf")))
+	if len(output) == 0 {
+		return nil, fmt.Errorf("powershell winget scan returned no output")
+	}
+
+	var packages []powerShellPackage
+	if err := json.Unmarshal(output, &packages); err != nil {
+		return nil, fmt.Errorf("decode powershell winget output: %w", err)
+	}
+
+	updates := make([]Update, 0, len(packages))
+	for _, p := range packages {
+		if p.ID == "" || p.AvailableVersion == "" {
+			continue
+		}
+		name := p.Name
+		if name == "" {
+			name = p.ID
+		}
+		updates = append(updates, Update{
+			PackageIdentifier: p.ID,
+			PackageName:       name,
+			InstalledVersion:  p.InstalledVersion,
+			AvailableVersion:  p.AvailableVersion,
+		})
+	}
+
+	return updates, nil
+}
+
+// exportInstalledPackages runs winget export and returns installed package IDs mapped to versions
+func exportInstalledPackages(wingetCmd string) (map[string]string, error) {
+	tmp, err := os.CreateTemp("", "lunaris-winget-export-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("create export file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	cmd := exec.Command(wingetCmd, "export",
+		"--output", tmpPath,
+		"--include-versions",
+		"--accept-source-agreements",
+		"--disable-interactivity",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// winget export exits non-zero when some installed packages aren't available from a source,
+	// but still writes everything it could match, so only fail on an empty file
+	runErr := cmd.Run()
+
+	data, err := os.ReadFile(tmpPath)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		if runErr == nil {
+			runErr = fmt.Errorf("empty export file")
+		}
+		return nil, fmt.Errorf("winget export failed: %w (stderr: %s)", runErr, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseExportFile(data)
+}
+
+// parseExportFile parses a winget export document into package IDs mapped to versions
+func parseExportFile(data []byte) (map[string]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var export exportFile
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("decode winget export: %w", err)
+	}
+
+	packages := make(map[string]string)
+	for _, src := range export.Sources {
+		for _, p := range src.Packages {
+			if p.PackageIdentifier != "" {
+				packages[p.PackageIdentifier] = p.Version
+			}
+		}
+	}
+	return packages, nil
+}
+
+// hasTruncatedFields reports whether any update has a column winget cut short
+func hasTruncatedFields(updates []Update) bool {
+	for _, u := range updates {
+		if strings.HasSuffix(u.PackageIdentifier, truncationMarker) ||
+			strings.HasSuffix(u.InstalledVersion, truncationMarker) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveTruncatedFields replaces truncated IDs and installed versions with the full values
+// from the installed package list. IDs that match zero or several packages are left as they are.
+func resolveTruncatedFields(updates []Update, installed map[string]string) {
+	for i := range updates {
+		u := &updates[i]
+
+		if prefix, ok := strings.CutSuffix(u.PackageIdentifier, truncationMarker); ok {
+			match := ""
+			matches := 0
+			for id := range installed {
+				if strings.HasPrefix(id, prefix) {
+					match = id
+					matches++
+				}
+			}
+			if matches == 1 {
+				u.PackageIdentifier = match
+			}
+		}
+
+		if strings.HasSuffix(u.InstalledVersion, truncationMarker) {
+			if version, ok := installed[u.PackageIdentifier]; ok && version != "" {
+				u.InstalledVersion = version
+			}
+		}
+	}
+}
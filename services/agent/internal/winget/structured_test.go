@@ -0,0 +1,168 @@
+package winget
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParsePowerShellOutputGolden parses every testdata/powershell-*.json capture and
+// compares the result with the matching .golden file. Run with -update to regenerate them.
+func TestParsePowerShellOutputGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "powershell-*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no testdata")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updates, err := parsePowerShellOutput(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(updates, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(input, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("parsePowerShellOutput(%s) =\n%s\nwant\n%s", input, got, want)
+			}
+		})
+	}
+}
+
+func TestParsePowerShellOutputErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"empty", ""},
+		{"byte order mark only", "\xef\xbb\xbf\r\n"},
+		{"module error text", "Import-Module : The specified module 'Microsoft.WinGet.Client' was not loaded"},
+		{"single object", `{"Name":"Git","Id":"Git.Git","InstalledVersion":"2.43.0","AvailableVersion":"2.44.0"}`},
+		{"truncated", `[{"Name":"Git","Id":"Git.Git"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if updates, err := parsePowerShellOutput([]byte(tt.output)); err == nil {
+				t.Errorf("parsePowerShellOutput() = %+v, want an error", updates)
+			}
+		})
+	}
+}
+
+func TestParseExportFile(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "export.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// winget writes the export with a byte order mark
+	got, err := parseExportFile(append([]byte("\xef\xbb\xbf"), data...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Microsoft.VisualStudioCode":             "1.87.0",
+		"Microsoft.VisualStudio.2022.BuildTools": "17.9.2",
+		"Microsoft.VisualStudio.2022.Community":  "17.9.1",
+		"9NBLGGH4NNS1":                           "Unknown",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseExportFile() = %v, want %v", got, want)
+	}
+
+	if _, err := parseExportFile([]byte("not json")); err == nil {
+		t.Error("parseExportFile() accepted invalid JSON")
+	}
+}
+
+func TestResolveTruncatedFields(t *testing.T) {
+	installed := map[string]string{
+		"Microsoft.VisualStudioCode":             "1.87.0",
+		"Microsoft.VisualStudio.2022.BuildTools": "17.9.2",
+		"Microsoft.VisualStudio.2022.Community":  "17.9.1",
+		"Mozilla.Firefox":                        "",
+	}
+
+	tests := []struct {
+		name   string
+		update Update
+		want   Update
+	}{
+		{
+			name:   "unique ID prefix",
+			update: Update{PackageIdentifier: "Microsoft.VisualStudioC…", InstalledVersion: "1.87.0"},
+			want:   Update{PackageIdentifier: "Microsoft.VisualStudioCode", InstalledVersion: "1.87.0"},
+		},
+		{
+			name:   "ambiguous ID prefix",
+			update: Update{PackageIdentifier: "Microsoft.VisualStudio.2022…", InstalledVersion: "17.9…"},
+			want:   Update{PackageIdentifier: "Microsoft.VisualStudio.2022…", InstalledVersion: "17.9…"},
+		},
+		{
+			name:   "unknown ID prefix",
+			update: Update{PackageIdentifier: "Google.Chr…"},
+			want:   Update{PackageIdentifier: "Google.Chr…"},
+		},
+		{
+			name:   "truncated version",
+			update: Update{PackageIdentifier: "Microsoft.VisualStudio.2022.Community", InstalledVersion: "17.…"},
+			want:   Update{PackageIdentifier: "Microsoft.VisualStudio.2022.Community", InstalledVersion: "17.9.1"},
+		},
+		{
+			name:   "truncated ID and version",
+			update: Update{PackageIdentifier: "Microsoft.VisualStudio.2022.B…", InstalledVersion: "17.…"},
+			want:   Update{PackageIdentifier: "Microsoft.VisualStudio.2022.BuildTools", InstalledVersion: "17.9.2"},
+		},
+		{
+			name:   "no exported version",
+			update: Update{PackageIdentifier: "Mozilla.Firefox", InstalledVersion: "12…"},
+			want:   Update{PackageIdentifier: "Mozilla.Firefox", InstalledVersion: "12…"},
+		},
+		{
+			name:   "nothing truncated",
+			update: Update{PackageIdentifier: "Git.Git", InstalledVersion: "2.43.0"},
+			want:   Update{PackageIdentifier: "Git.Git", InstalledVersion: "2.43.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := []Update{tt.update}
+			truncated := strings.Contains(tt.update.PackageIdentifier+tt.update.InstalledVersion, truncationMarker)
+			if got := hasTruncatedFields(updates); got != truncated {
+				t.Errorf("hasTruncatedFields() = %v, want %v", got, truncated)
+			}
+			resolveTruncatedFields(updates, installed)
+			if updates[0] != tt.want {
+				t.Errorf("resolveTruncatedFields() = %+v, want %+v", updates[0], tt.want)
+			}
+		})
+	}
+}